@@ -55,10 +55,11 @@ type createSessionResponse struct {
 
 // ssePreviewEvent is a SSE event payload sent to the browser on completion.
 type ssePreviewEvent struct {
-	Type        string           `json:"type"`
-	Status      string           `json:"status"`
-	Result      []handoff.ResultItem `json:"result,omitempty"`
-	PreviewData []previewItem    `json:"preview_data,omitempty"`
+	Type          string                 `json:"type"`
+	Status        string                 `json:"status"`
+	Result        []handoff.ResultItem   `json:"result,omitempty"`
+	PreviewData   []previewItem          `json:"preview_data,omitempty"`
+	BarcodeResult *handoff.BarcodeResult `json:"barcode_result,omitempty"`
 }
 
 // previewItem holds base64-encoded file data for browser preview.
@@ -230,8 +231,16 @@ func (s *server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 		actionType = handoff.ActionTypeSignature
 	case "scan":
 		actionType = handoff.ActionTypeScan
+	case "video":
+		actionType = handoff.ActionTypeVideo
+	case "audio":
+		actionType = handoff.ActionTypeAudio
+	case "upload":
+		actionType = handoff.ActionTypeUpload
+	case "barcode":
+		actionType = handoff.ActionTypeBarcode
 	default:
-		jsonError(w, http.StatusBadRequest, "invalid action_type: must be 'photo', 'signature', or 'scan'")
+		jsonError(w, http.StatusBadRequest, "invalid action_type: must be 'photo', 'signature', 'scan', 'video', 'audio', 'upload', or 'barcode'")
 		return
 	}
 
@@ -261,8 +270,12 @@ func (s *server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 			scanFmt = handoff.ScanOutputFormatPDF
 		}
 		builder = builder.WithScanOutputFormat(scanFmt)
+	} else if actionType == handoff.ActionTypeUpload {
+		// Upload sessions keep the type of each file; use the server's defaults.
+	} else if actionType == handoff.ActionTypeBarcode {
+		// Barcode sessions return the decoded value; accept every symbology.
 	} else {
-		// Photo/signature sessions use output_format.
+		// Photo, signature, video and audio sessions use output_format.
 		var outputFormat handoff.OutputFormat
 		switch req.OutputFormat {
 		case "jpg":
@@ -273,8 +286,14 @@ func (s *server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 			outputFormat = handoff.OutputFormatPDF
 		case "svg":
 			outputFormat = handoff.OutputFormatSVG
+		case "mp4":
+			outputFormat = handoff.OutputFormatMP4
+		case "webm":
+			outputFormat = handoff.OutputFormatWebM
+		case "ogg":
+			outputFormat = handoff.OutputFormatOGG
 		default:
-			jsonError(w, http.StatusBadRequest, "invalid output_format: must be jpg, png, pdf, or svg")
+			jsonError(w, http.StatusBadRequest, "invalid output_format: must be jpg, png, pdf, svg, mp4, webm, or ogg")
 			return
 		}
 		builder = builder.WithOutputFormat(outputFormat)
@@ -460,7 +479,7 @@ func (s *server) handleSessionEvents(w http.ResponseWriter, r *http.Request, ses
 						}
 					}
 				} else {
-					// Photo/signature session: use ResultItem list.
+					// Photo, signature, video, audio or upload session: use ResultItem list.
 					for _, item := range evt.Result {
 						data, contentType, err := s.client.DownloadFile(downloadCtx, item.DownloadID)
 						if err != nil {
@@ -485,10 +504,11 @@ func (s *server) handleSessionEvents(w http.ResponseWriter, r *http.Request, ses
 				cancel()
 
 				sendSSE(ssePreviewEvent{
-					Type:        "completed",
-					Status:      string(evt.Status),
-					Result:      evt.Result,
-					PreviewData: previews,
+					Type:          "completed",
+					Status:        string(evt.Status),
+					Result:        evt.Result,
+					PreviewData:   previews,
+					BarcodeResult: evt.BarcodeResult,
 				})
 
 				// Clean up session after completion.
@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/mxcd/go-config/config"
+	"github.com/mxcd/handoff/internal/delivery"
 	"github.com/mxcd/handoff/internal/server"
 	"github.com/mxcd/handoff/internal/store"
 	"github.com/mxcd/handoff/internal/util"
+	"github.com/mxcd/handoff/internal/webhook"
+	"github.com/mxcd/handoff/internal/ws"
 	"github.com/rs/zerolog/log"
 )
 
@@ -24,12 +28,40 @@ func main() {
 		log.Panic().Err(err).Msg("error initializing logger")
 	}
 
-	sessionStore := store.NewStore()
+	sessionStore, err := initStore()
+	if err != nil {
+		log.Panic().Err(err).Msg("error initializing store")
+	}
+	restoreSnapshot(sessionStore)
+
+	webhooks, err := initWebhooks(sessionStore)
+	if err != nil {
+		log.Panic().Err(err).Msg("error initializing webhooks")
+	}
+
+	deliveries, err := initDeliveries(sessionStore)
+	if err != nil {
+		log.Panic().Err(err).Msg("error initializing deliveries")
+	}
+
+	bus, err := initBus()
+	if err != nil {
+		log.Panic().Err(err).Msg("error initializing event bus")
+	}
+
+	pingInterval, err := durationConfig("WS_PING_INTERVAL")
+	if err != nil {
+		log.Panic().Err(err).Msg("error initializing server")
+	}
 
 	s, err := server.NewServer(&server.ServerOptions{
-		DevMode: config.Get().Bool("DEV"),
-		Port:    config.Get().Int("PORT"),
-		Store:   sessionStore,
+		DevMode:        config.Get().Bool("DEV"),
+		Port:           config.Get().Int("PORT"),
+		Store:          sessionStore,
+		Webhooks:       webhooks,
+		Deliveries:     deliveries,
+		Bus:            bus,
+		WSPingInterval: pingInterval,
 	})
 	if err != nil {
 		log.Panic().Err(err).Msg("error initializing server")
@@ -39,6 +71,10 @@ func main() {
 		log.Panic().Err(err).Msg("error registering routes")
 	}
 
+	if webhooks != nil {
+		webhooks.Start()
+	}
+
 	// Start server in a goroutine so we can listen for shutdown signals
 	go func() {
 		if err := s.Run(); err != nil {
@@ -56,5 +92,206 @@ func main() {
 	defer cancel()
 
 	s.Shutdown(ctx)
+	if webhooks != nil {
+		webhooks.Stop()
+	}
+	deliveries.Stop()
+	if err := bus.Close(); err != nil {
+		log.Warn().Err(err).Msg("error closing event bus")
+	}
+	saveSnapshot(sessionStore)
 	log.Info().Msg("server shutdown complete")
 }
+
+// restoreSnapshot loads SNAPSHOT_PATH into the store when both are configured.
+// A corrupt or incompatible snapshot is skipped so the server still starts.
+func restoreSnapshot(st store.Store) {
+	path := config.Get().String("SNAPSHOT_PATH")
+	if path == "" {
+		return
+	}
+	snapshotter, ok := store.AsSnapshotter(st)
+	if !ok {
+		log.Warn().Msg("SNAPSHOT_PATH is set but the store backend does not support snapshots; ignoring")
+		return
+	}
+	if err := snapshotter.LoadSnapshot(path); err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("skipping unusable snapshot")
+	}
+}
+
+// saveSnapshot writes the store to SNAPSHOT_PATH after the HTTP server has
+// stopped accepting requests.
+func saveSnapshot(st store.Store) {
+	path := config.Get().String("SNAPSHOT_PATH")
+	if path == "" {
+		return
+	}
+	snapshotter, ok := store.AsSnapshotter(st)
+	if !ok {
+		return
+	}
+	if err := snapshotter.SaveSnapshot(path); err != nil {
+		log.Error().Err(err).Str("path", path).Msg("error saving snapshot")
+	}
+}
+
+// initWebhooks creates the webhook dispatcher when WEBHOOK_SECRET is set.
+// Returns nil when webhooks are disabled.
+func initWebhooks(st store.Store) (*webhook.Dispatcher, error) {
+	secret := config.Get().String("WEBHOOK_SECRET")
+	defaultURLs, err := webhook.ParseDefaultURLs(config.Get().StringArray("WEBHOOK_URLS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_URLS: %w", err)
+	}
+	if secret == "" {
+		if len(defaultURLs) > 0 {
+			return nil, fmt.Errorf("WEBHOOK_SECRET is required when WEBHOOK_URLS is set")
+		}
+		return nil, nil
+	}
+
+	timeout, err := durationConfig("WEBHOOK_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	backoff, err := durationConfig("WEBHOOK_RETRY_BACKOFF")
+	if err != nil {
+		return nil, err
+	}
+	deadLetterTTL, err := durationConfig("WEBHOOK_DEAD_LETTER_TTL")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Int("default_urls", len(defaultURLs)).Msg("webhook delivery enabled")
+	return webhook.NewDispatcher(st, webhook.Options{
+		Secret:        secret,
+		DefaultURLs:   defaultURLs,
+		MaxAttempts:   config.Get().Int("WEBHOOK_MAX_ATTEMPTS"),
+		Timeout:       timeout,
+		RetryBackoff:  backoff,
+		DeadLetterTTL: deadLetterTTL,
+	})
+}
+
+// initDeliveries creates the pusher that sends result files to the delivery
+// targets of sessions.
+func initDeliveries(st store.Store) (*delivery.Pusher, error) {
+	timeout, err := durationConfig("DELIVERY_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+	backoff, err := durationConfig("DELIVERY_RETRY_BACKOFF")
+	if err != nil {
+		return nil, err
+	}
+	return delivery.NewPusher(st, delivery.Options{
+		MaxAttempts:  config.Get().Int("DELIVERY_MAX_ATTEMPTS"),
+		Timeout:      timeout,
+		RetryBackoff: backoff,
+	})
+}
+
+// initBus creates the bus that carries real-time messages to the WebSocket
+// and event stream clients of every replica: Redis pub/sub with the redis
+// store, which replicas share, and in-process otherwise.
+func initBus() (ws.Bus, error) {
+	if config.Get().String("STORE_BACKEND") != "redis" {
+		return ws.NewLocalBus(), nil
+	}
+	bus, err := ws.NewRedisBusFromURL(config.Get().String("REDIS_URL"))
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Msg("using redis event bus")
+	return bus, nil
+}
+
+// durationConfig parses the duration config value name.
+func durationConfig(name string) (time.Duration, error) {
+	d, err := time.ParseDuration(config.Get().String(name))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// initStore creates the storage backend selected by STORE_BACKEND. Result files
+// and scan pages go to FILE_STORE_BACKEND instead when it is set, and are
+// encrypted at rest when ENCRYPTION_KEYS is configured.
+func initStore() (store.Store, error) {
+	st, err := initBackends()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := config.Get().StringArray("ENCRYPTION_KEYS")
+	if len(keys) == 0 {
+		return st, nil
+	}
+	keyring, err := store.ParseKeyring(keys, config.Get().String("ENCRYPTION_KEY_ID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ENCRYPTION_KEYS: %w", err)
+	}
+	log.Info().Int("keys", len(keys)).Msg("encrypting result files and scan pages at rest")
+	return store.Combine(st, store.NewEncryptedFileStore(st, keyring)), nil
+}
+
+// initBackends creates the session and file stores from configuration.
+func initBackends() (store.Store, error) {
+	var sessionStore store.Store
+	switch backend := config.Get().String("STORE_BACKEND"); backend {
+	case "memory":
+		log.Info().Msg("using in-memory store")
+		sessionStore = store.NewMemoryStore()
+	case "redis":
+		url := config.Get().String("REDIS_URL")
+		if url == "" {
+			return nil, fmt.Errorf("REDIS_URL is required when STORE_BACKEND is redis")
+		}
+		log.Info().Msg("using redis store")
+		redisStore, err := store.NewRedisStoreFromURL(url)
+		if err != nil {
+			return nil, err
+		}
+		sessionStore = redisStore
+	default:
+		return nil, fmt.Errorf("unknown STORE_BACKEND %q: must be 'memory' or 'redis'", backend)
+	}
+
+	switch backend := config.Get().String("FILE_STORE_BACKEND"); backend {
+	case "":
+		return sessionStore, nil
+	case "disk":
+		dir := config.Get().String("FILE_STORE_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("FILE_STORE_DIR is required when FILE_STORE_BACKEND is disk")
+		}
+		fileStore, err := store.NewDiskFileStore(dir)
+		if err != nil {
+			return nil, err
+		}
+		return store.Combine(sessionStore, fileStore), nil
+	case "s3":
+		endpoint := config.Get().String("S3_ENDPOINT")
+		if endpoint == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT is required when FILE_STORE_BACKEND is s3")
+		}
+		fileStore, err := store.NewS3FileStore(store.S3Options{
+			Endpoint:  endpoint,
+			Region:    config.Get().String("S3_REGION"),
+			Bucket:    config.Get().String("S3_BUCKET"),
+			AccessKey: config.Get().String("S3_ACCESS_KEY"),
+			SecretKey: config.Get().String("S3_SECRET_KEY"),
+			UseSSL:    config.Get().Bool("S3_USE_SSL"),
+			Prefix:    config.Get().String("S3_PREFIX"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return store.Combine(sessionStore, fileStore), nil
+	default:
+		return nil, fmt.Errorf("unknown FILE_STORE_BACKEND %q: must be empty, 'disk' or 's3'", backend)
+	}
+}
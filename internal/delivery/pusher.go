@@ -0,0 +1,267 @@
+// Package delivery pushes the result files of completed sessions to
+// caller-supplied upload targets, either as the body of a PUT request or as a
+// multipart/form-data POST, retrying failed pushes with exponential backoff.
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxBackoff caps the delay between two attempts.
+	maxBackoff = 5 * time.Minute
+	// maxResponseBody is how much of a target's response is read.
+	maxResponseBody = 64 << 10
+)
+
+// Options configures a Pusher.
+type Options struct {
+	// MaxAttempts is how often a file is tried before it is marked failed.
+	MaxAttempts int
+	// Timeout bounds a single attempt, including the upload.
+	Timeout time.Duration
+	// RetryBackoff is the delay after the first failed attempt; it doubles
+	// with every further failure.
+	RetryBackoff time.Duration
+}
+
+// Pusher pushes result files in the background and records the outcome of
+// every file on its session. Pushes run on the replica that completed the
+// session; a push interrupted by shutdown stays pending, and the files remain
+// available on the download endpoint.
+type Pusher struct {
+	store   store.Store
+	options Options
+	client  *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPusher creates a Pusher that reads files from and records deliveries in st.
+func NewPusher(st store.Store, options Options) (*Pusher, error) {
+	if options.MaxAttempts < 1 {
+		return nil, fmt.Errorf("delivery max attempts must be at least 1")
+	}
+	if options.Timeout <= 0 || options.RetryBackoff <= 0 {
+		return nil, fmt.Errorf("delivery timeout and retry backoff must be positive")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pusher{
+		store:   st,
+		options: options,
+		client: &http.Client{
+			Timeout: options.Timeout,
+			// A redirect would resend the file elsewhere; treat it as a failure instead.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// Push records files as pending on session's delivery target and pushes them
+// one after another in the background. session must have a delivery target.
+func (p *Pusher) Push(session *model.Session, files []model.FileDelivery) {
+	target := *session.Delivery
+	target.Files = append([]model.FileDelivery(nil), files...)
+	for i := range target.Files {
+		target.Files[i].State = model.DeliveryStatePending
+	}
+	p.record(session.ID, &target)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.run(session.ID, &target)
+	}()
+}
+
+// Stop abandons the pushes in flight and waits for them to return.
+func (p *Pusher) Stop() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// run pushes every file of target, recording each attempt, and purges the
+// files once all of them were delivered if the target asks for it.
+func (p *Pusher) run(sessionID string, target *model.DeliveryTarget) {
+	for i := range target.Files {
+		file := &target.Files[i]
+		for file.State == model.DeliveryStatePending {
+			if !p.attempt(sessionID, target, file) {
+				return
+			}
+			p.record(sessionID, target)
+		}
+	}
+
+	if !target.Purge {
+		return
+	}
+	for _, file := range target.Files {
+		if file.State != model.DeliveryStateDelivered {
+			return
+		}
+	}
+	for _, file := range target.Files {
+		if err := p.store.DeleteFile(file.DownloadID); err != nil {
+			log.Warn().Err(err).Str("session_id", sessionID).Str("download_id", file.DownloadID).Msg("delivery: failed to purge file")
+		}
+	}
+	log.Info().Str("session_id", sessionID).Int("files", len(target.Files)).Msg("delivery: purged delivered files")
+}
+
+// attempt waits out the backoff of earlier failures, makes one attempt and
+// updates file with its outcome. Returns false when shutting down.
+func (p *Pusher) attempt(sessionID string, target *model.DeliveryTarget, file *model.FileDelivery) bool {
+	if file.Attempts > 0 {
+		select {
+		case <-p.ctx.Done():
+			return false
+		case <-time.After(p.backoff(file.Attempts)):
+		}
+	}
+
+	now := time.Now()
+	status, err := p.push(target, file)
+	if p.ctx.Err() != nil {
+		// Shutting down; the attempt does not count.
+		return false
+	}
+
+	file.Attempts++
+	file.LastStatusCode = status
+	file.LastError = ""
+
+	logger := log.With().Str("session_id", sessionID).Str("download_id", file.DownloadID).Int("attempts", file.Attempts).Logger()
+	switch {
+	case err == nil:
+		file.State = model.DeliveryStateDelivered
+		file.DeliveredAt = &now
+		logger.Info().Int("status", status).Msg("delivery: file delivered")
+	case err == errFileGone || file.Attempts >= p.options.MaxAttempts:
+		file.State = model.DeliveryStateFailed
+		file.LastError = err.Error()
+		logger.Warn().Err(err).Msg("delivery: giving up")
+	default:
+		file.LastError = err.Error()
+		logger.Debug().Err(err).Msg("delivery: attempt failed")
+	}
+	return true
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (p *Pusher) backoff(attempts int) time.Duration {
+	delay := p.options.RetryBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// record saves the state of target's files on its session. Nothing is
+// recorded once the session has expired.
+func (p *Pusher) record(sessionID string, target *model.DeliveryTarget) {
+	if err := p.store.UpdateDeliveryStatus(sessionID, target.Files); err != nil {
+		log.Error().Err(err).Str("session_id", sessionID).Msg("delivery: failed to record delivery")
+	}
+}
+
+// errFileGone is returned by push when the file expired before it was delivered.
+var errFileGone = errors.New("file expired before it was delivered")
+
+// push streams the file to the target. Any response other than 2xx is an
+// error; the status code is returned alongside it when there was a response.
+func (p *Pusher) push(target *model.DeliveryTarget, file *model.FileDelivery) (int, error) {
+	stored, err := p.store.OpenFile(file.DownloadID)
+	if err != nil {
+		return 0, err
+	}
+	if stored == nil {
+		return 0, errFileGone
+	}
+	defer stored.Close()
+
+	var req *http.Request
+	switch target.Method {
+	case model.DeliveryMethodPost:
+		req, err = newMultipartRequest(p.ctx, target, file, stored)
+	default:
+		u := strings.ReplaceAll(target.URL, model.DeliveryFilenamePlaceholder, url.PathEscape(file.Filename))
+		req, err = http.NewRequestWithContext(p.ctx, http.MethodPut, u, stored.Content)
+		if err == nil {
+			req.ContentLength = stored.Size
+			req.Header.Set("Content-Type", file.ContentType)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range target.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("User-Agent", "handoff-delivery")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// newMultipartRequest returns a POST request whose multipart/form-data body
+// streams the file under target.Field, without buffering it.
+func newMultipartRequest(ctx context.Context, target *model.DeliveryTarget, file *model.FileDelivery, stored *store.StoredFile) (*http.Request, error) {
+	body, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	go func() {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", formFileDisposition(target.Field, file.Filename))
+		header.Set("Content-Type", file.ContentType)
+		part, err := form.CreatePart(header)
+		if err == nil {
+			_, err = io.Copy(part, stored.Content)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return req, nil
+}
+
+// formFileDisposition returns the Content-Disposition of a form file part.
+func formFileDisposition(field, filename string) string {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quote.Replace(field), quote.Replace(filename))
+}
@@ -0,0 +1,58 @@
+package model_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mxcd/handoff/internal/model"
+)
+
+func TestValidateBarcodeSymbology(t *testing.T) {
+	for _, symbology := range model.BarcodeSymbologies {
+		if got, err := model.ValidateBarcodeSymbology(string(symbology)); err != nil || got != symbology {
+			t.Errorf("ValidateBarcodeSymbology(%q): got (%q, %v)", symbology, got, err)
+		}
+	}
+	for _, s := range []string{"", "qr", "QR_CODE", "ean13", "pdf_417"} {
+		if _, err := model.ValidateBarcodeSymbology(s); err == nil {
+			t.Errorf("ValidateBarcodeSymbology(%q): got no error", s)
+		}
+	}
+}
+
+func TestCompileBarcodePattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern   string
+		matches   []string
+		rejects   []string
+		wantError bool
+	}{
+		{pattern: ""},
+		{pattern: `\d{3}`, matches: []string{"123"}, rejects: []string{"12", "1234", "a123", "123\n"}},
+		{pattern: `INV-\d+`, matches: []string{"INV-1", "INV-2024"}, rejects: []string{"INV-", "XINV-1", "INV-1x"}},
+		// The anchors apply to the whole alternation, not just its ends.
+		{pattern: `A|B`, matches: []string{"A", "B"}, rejects: []string{"AB", "AX", "XB"}},
+		{pattern: `(`, wantError: true},
+		{pattern: `\d{2,1}`, wantError: true},
+		{pattern: strings.Repeat("a", model.MaxBarcodePatternLength+1), wantError: true},
+	} {
+		re, err := model.CompileBarcodePattern(tc.pattern)
+		if (err != nil) != tc.wantError {
+			t.Errorf("CompileBarcodePattern(%q): got error %v, want error %v", tc.pattern, err, tc.wantError)
+			continue
+		}
+		if tc.pattern == "" && re != nil {
+			t.Errorf("CompileBarcodePattern(\"\"): got %v, want nil", re)
+		}
+		for _, s := range tc.matches {
+			if !re.MatchString(s) {
+				t.Errorf("pattern %q does not match %q", tc.pattern, s)
+			}
+		}
+		for _, s := range tc.rejects {
+			if re.MatchString(s) {
+				t.Errorf("pattern %q matches %q", tc.pattern, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DeliveryMethod is how result files are pushed to a delivery target.
+type DeliveryMethod string
+
+const (
+	// DeliveryMethodPut — each file is the body of its own PUT request.
+	DeliveryMethodPut DeliveryMethod = "put"
+	// DeliveryMethodPost — each file is POSTed as a multipart/form-data upload.
+	DeliveryMethodPost DeliveryMethod = "post"
+)
+
+// DeliveryState is the push state of one result file.
+type DeliveryState string
+
+const (
+	// DeliveryStatePending — waiting for its first or next attempt.
+	DeliveryStatePending DeliveryState = "pending"
+	// DeliveryStateDelivered — the target acknowledged it with a 2xx response.
+	DeliveryStateDelivered DeliveryState = "delivered"
+	// DeliveryStateFailed — every attempt failed; the file can still be downloaded.
+	DeliveryStateFailed DeliveryState = "failed"
+)
+
+// DeliveryFilenamePlaceholder in a PUT URL is replaced with the escaped name
+// of each file, so that several files do not overwrite each other.
+const DeliveryFilenamePlaceholder = "{filename}"
+
+// DefaultDeliveryField is the multipart form field of POSTed files.
+const DefaultDeliveryField = "file"
+
+// Limits for a caller-supplied delivery target.
+const (
+	MaxDeliveryURLLength   = 2048
+	MaxDeliveryHeaders     = 20
+	MaxDeliveryHeaderValue = 4096
+)
+
+// deliveryReservedHeaders are set by the push itself.
+var deliveryReservedHeaders = []string{"Content-Type", "Content-Length", "Host", "Transfer-Encoding"}
+
+// DeliveryTarget is a caller-supplied endpoint the result files of a session
+// are pushed to once it completes.
+type DeliveryTarget struct {
+	// Method is how each file is sent.
+	Method DeliveryMethod `json:"method"`
+	// URL receives the files; see DeliveryFilenamePlaceholder.
+	URL string `json:"url"`
+	// Headers are sent with every request, e.g. for authorisation. They are
+	// never returned by the API.
+	Headers map[string]string `json:"-"`
+	// Field is the multipart form field of POSTed files.
+	Field string `json:"field,omitempty"`
+	// Purge deletes the files from Handoff once every one was delivered.
+	Purge bool `json:"purge,omitempty"`
+	// Files records the push of each result file once the session completed.
+	Files []FileDelivery `json:"files,omitempty"`
+}
+
+// FileDelivery is the push of one result file to a session's DeliveryTarget.
+type FileDelivery struct {
+	// DownloadID is the file's ID on the download endpoint.
+	DownloadID string `json:"download_id"`
+	// Filename is the name the file is sent under.
+	Filename string `json:"filename"`
+	// ContentType is the MIME type of the file.
+	ContentType string `json:"content_type"`
+	// State is the push state.
+	State DeliveryState `json:"state"`
+	// Attempts counts the push attempts made so far.
+	Attempts int `json:"attempts"`
+	// DeliveredAt is when the target acknowledged the file.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// LastStatusCode is the HTTP status of the most recent attempt, if it got a response.
+	LastStatusCode int `json:"last_status_code,omitempty"`
+	// LastError describes why the most recent attempt failed.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ValidateDeliveryTarget returns an error unless target has a known method,
+// an absolute http or https URL and headers within the limits. An empty Field
+// is set to DefaultDeliveryField for POST targets.
+func ValidateDeliveryTarget(target *DeliveryTarget) error {
+	switch target.Method {
+	case DeliveryMethodPut:
+		if target.Field != "" {
+			return fmt.Errorf("delivery field is only used with method 'post'")
+		}
+	case DeliveryMethodPost:
+		if target.Field == "" {
+			target.Field = DefaultDeliveryField
+		}
+	default:
+		return fmt.Errorf("unknown delivery method %q: must be 'put' or 'post'", target.Method)
+	}
+
+	if len(target.URL) > MaxDeliveryURLLength {
+		return fmt.Errorf("delivery url must be at most %d bytes", MaxDeliveryURLLength)
+	}
+	u, err := url.Parse(strings.ReplaceAll(target.URL, DeliveryFilenamePlaceholder, "file"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("delivery url must be an absolute http or https URL")
+	}
+
+	if len(target.Headers) > MaxDeliveryHeaders {
+		return fmt.Errorf("delivery headers must have at most %d entries", MaxDeliveryHeaders)
+	}
+	for name, value := range target.Headers {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid delivery header name %q", name)
+		}
+		for _, reserved := range deliveryReservedHeaders {
+			if http.CanonicalHeaderKey(name) == reserved {
+				return fmt.Errorf("delivery header %q is set by Handoff", name)
+			}
+		}
+		if len(value) > MaxDeliveryHeaderValue || strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("invalid value for delivery header %q", name)
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is a non-empty HTTP token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r > 0x7e || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SessionEvent is an entry in a session's event log: one message that was
+// sent to the session's subscribers.
+type SessionEvent struct {
+	// Seq numbers the session's events from 1 upwards, in the order they occurred.
+	Seq uint64 `json:"seq"`
+	// Type is the message type, e.g. "status_update" or "completed".
+	Type string `json:"type"`
+	// Status is the session's status after the event.
+	Status SessionStatus `json:"status"`
+	// Data is the message's payload, if any.
+	Data json.RawMessage `json:"data,omitempty"`
+	// Timestamp is when the event occurred.
+	Timestamp time.Time `json:"timestamp"`
+}
@@ -2,6 +2,9 @@ package model
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,6 +17,10 @@ const (
 	ActionTypePhoto     ActionType = "photo"
 	ActionTypeSignature ActionType = "signature"
 	ActionTypeScan      ActionType = "scan"
+	ActionTypeVideo     ActionType = "video"
+	ActionTypeAudio     ActionType = "audio"
+	ActionTypeUpload    ActionType = "upload"
+	ActionTypeBarcode   ActionType = "barcode"
 )
 
 // ValidateActionType returns the typed ActionType value or an error for unknown types.
@@ -25,11 +32,26 @@ func ValidateActionType(s string) (ActionType, error) {
 		return ActionTypeSignature, nil
 	case ActionTypeScan:
 		return ActionTypeScan, nil
+	case ActionTypeVideo:
+		return ActionTypeVideo, nil
+	case ActionTypeAudio:
+		return ActionTypeAudio, nil
+	case ActionTypeUpload:
+		return ActionTypeUpload, nil
+	case ActionTypeBarcode:
+		return ActionTypeBarcode, nil
 	default:
-		return "", fmt.Errorf("unknown action type %q: must be 'photo', 'signature', or 'scan'", s)
+		return "", fmt.Errorf("unknown action type %q: must be 'photo', 'signature', 'scan', 'video', 'audio', 'upload', or 'barcode'", s)
 	}
 }
 
+// CollectsUploads reports whether the action collects uploads as scan pages
+// before they are finalized into its result: the pages of a scan, the chunks
+// of a video, or the files of an upload.
+func (t ActionType) CollectsUploads() bool {
+	return t == ActionTypeScan || t == ActionTypeVideo || t == ActionTypeUpload
+}
+
 // SessionStatus represents the lifecycle state of a session.
 type SessionStatus string
 
@@ -44,21 +66,51 @@ const (
 	SessionStatusCompleted SessionStatus = "completed"
 	// SessionStatusExpired — session TTL passed; session is no longer usable.
 	SessionStatusExpired SessionStatus = "expired"
+	// SessionStatusCancelled — session was cancelled by the API caller; the phone link is revoked.
+	SessionStatusCancelled SessionStatus = "cancelled"
 )
 
+// ValidateSessionStatus returns the typed SessionStatus or an error for unknown values.
+func ValidateSessionStatus(s string) (SessionStatus, error) {
+	switch status := SessionStatus(s); status {
+	case SessionStatusPending, SessionStatusOpened, SessionStatusActionStarted,
+		SessionStatusCompleted, SessionStatusExpired, SessionStatusCancelled:
+		return status, nil
+	default:
+		return "", fmt.Errorf("unknown session status %q", s)
+	}
+}
+
+// IsTerminal reports whether no further status changes are possible.
+func (s SessionStatus) IsTerminal() bool {
+	switch s {
+	case SessionStatusCompleted, SessionStatusExpired, SessionStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
 // OutputFormat represents the file format for the action result.
 type OutputFormat string
 
 const (
-	OutputFormatJPG OutputFormat = "jpg"
-	OutputFormatPNG OutputFormat = "png"
-	OutputFormatPDF OutputFormat = "pdf"
-	OutputFormatSVG OutputFormat = "svg"
+	OutputFormatJPG  OutputFormat = "jpg"
+	OutputFormatPNG  OutputFormat = "png"
+	OutputFormatPDF  OutputFormat = "pdf"
+	OutputFormatSVG  OutputFormat = "svg"
+	OutputFormatMP4  OutputFormat = "mp4"
+	OutputFormatWebM OutputFormat = "webm"
+	OutputFormatOGG  OutputFormat = "ogg"
 )
 
 // ValidateOutputFormat validates that format is a valid output format for the given action type.
 // For photo: accepts "jpg", "png", "pdf".
 // For signature: accepts "svg", "png", "pdf".
+// For video: accepts "mp4", "webm".
+// For audio: accepts "webm", "ogg".
+// For upload: output_format is not used (files keep their own type); returns empty string without error if empty.
+// For barcode: output_format is not used (the result is the decoded value); returns empty string without error if empty.
 // For scan: output_format is not used (scan uses ScanOutputFormat); returns empty string without error.
 func ValidateOutputFormat(actionType ActionType, format string) (OutputFormat, error) {
 	switch actionType {
@@ -79,6 +131,30 @@ func ValidateOutputFormat(actionType ActionType, format string) (OutputFormat, e
 	case ActionTypeScan:
 		// Scan sessions use ScanOutputFormat instead of OutputFormat; skip validation.
 		return OutputFormat(""), nil
+	case ActionTypeVideo:
+		switch OutputFormat(format) {
+		case OutputFormatMP4, OutputFormatWebM:
+			return OutputFormat(format), nil
+		default:
+			return "", fmt.Errorf("invalid output format %q for action type 'video': must be 'mp4' or 'webm'", format)
+		}
+	case ActionTypeAudio:
+		switch OutputFormat(format) {
+		case OutputFormatWebM, OutputFormatOGG:
+			return OutputFormat(format), nil
+		default:
+			return "", fmt.Errorf("invalid output format %q for action type 'audio': must be 'webm' or 'ogg'", format)
+		}
+	case ActionTypeUpload:
+		if format != "" {
+			return "", fmt.Errorf("output_format is not used by action type 'upload': files keep their own type")
+		}
+		return OutputFormat(""), nil
+	case ActionTypeBarcode:
+		if format != "" {
+			return "", fmt.Errorf("output_format is not used by action type 'barcode': the result is the decoded value")
+		}
+		return OutputFormat(""), nil
 	default:
 		return "", fmt.Errorf("unknown action type %q", actionType)
 	}
@@ -124,6 +200,167 @@ func ValidateScanOutputFormat(s string) (ScanOutputFormat, error) {
 	}
 }
 
+// VideoResolution is the resolution a video session records at.
+type VideoResolution string
+
+const (
+	VideoResolution480p  VideoResolution = "480p"
+	VideoResolution720p  VideoResolution = "720p"
+	VideoResolution1080p VideoResolution = "1080p"
+)
+
+// ValidateVideoResolution returns the typed VideoResolution or an error for unknown values.
+func ValidateVideoResolution(s string) (VideoResolution, error) {
+	switch resolution := VideoResolution(s); resolution {
+	case VideoResolution480p, VideoResolution720p, VideoResolution1080p:
+		return resolution, nil
+	default:
+		return "", fmt.Errorf("unknown video resolution %q: must be '480p', '720p', or '1080p'", s)
+	}
+}
+
+// VideoContentType returns the MIME type of a recorded video without codec
+// parameters, e.g. "video/webm" for "video/webm;codecs=vp9", and false if it
+// is neither MP4 nor WebM.
+func VideoContentType(contentType string) (string, bool) {
+	base, _, _ := strings.Cut(contentType, ";")
+	switch base = strings.ToLower(strings.TrimSpace(base)); base {
+	case "video/mp4", "video/webm":
+		return base, true
+	default:
+		return "", false
+	}
+}
+
+// uploadTypes maps the content types an upload session can allow, as
+// detected from the file content, to the file extension of their result files.
+var uploadTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/bmp":       ".bmp",
+	"image/heic":      ".heic",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"audio/mpeg":      ".mp3",
+	"audio/wave":      ".wav",
+	"text/plain":      ".txt",
+}
+
+// ValidateUploadType returns an error unless s is a content type an upload
+// session can allow: one of uploadTypes, or "image/*", "video/*" or "audio/*"
+// for all of them of that kind.
+func ValidateUploadType(s string) error {
+	if _, ok := uploadTypes[s]; ok {
+		return nil
+	}
+	switch s {
+	case "image/*", "video/*", "audio/*":
+		return nil
+	}
+	supported := make([]string, 0, len(uploadTypes))
+	for t := range uploadTypes {
+		supported = append(supported, t)
+	}
+	sort.Strings(supported)
+	return fmt.Errorf("unsupported upload type %q: must be image/*, video/*, audio/* or one of %s", s, strings.Join(supported, ", "))
+}
+
+// UploadTypeAllowed reports whether a file of contentType, as detected from
+// its content, matches one of the allowed upload types.
+func UploadTypeAllowed(allowed []string, contentType string) bool {
+	if _, ok := uploadTypes[contentType]; !ok {
+		return false
+	}
+	kind, _, _ := strings.Cut(contentType, "/")
+	for _, a := range allowed {
+		if a == contentType || a == kind+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadExtension returns the file extension of an uploaded file of
+// contentType, e.g. ".pdf", or "" for types no upload session allows.
+func UploadExtension(contentType string) string {
+	return uploadTypes[contentType]
+}
+
+// BarcodeSymbology is a barcode format a barcode session can read, named as
+// in the browser's Barcode Detection API.
+type BarcodeSymbology string
+
+const (
+	BarcodeSymbologyQRCode     BarcodeSymbology = "qr_code"
+	BarcodeSymbologyDataMatrix BarcodeSymbology = "data_matrix"
+	BarcodeSymbologyAztec      BarcodeSymbology = "aztec"
+	BarcodeSymbologyEAN13      BarcodeSymbology = "ean_13"
+	BarcodeSymbologyEAN8       BarcodeSymbology = "ean_8"
+	BarcodeSymbologyUPCA       BarcodeSymbology = "upc_a"
+	BarcodeSymbologyUPCE       BarcodeSymbology = "upc_e"
+	BarcodeSymbologyCode128    BarcodeSymbology = "code_128"
+	BarcodeSymbologyCode39     BarcodeSymbology = "code_39"
+	BarcodeSymbologyCode93     BarcodeSymbology = "code_93"
+	BarcodeSymbologyITF        BarcodeSymbology = "itf"
+	BarcodeSymbologyCodabar    BarcodeSymbology = "codabar"
+)
+
+// BarcodeSymbologies lists every symbology a barcode session can read, the
+// default of sessions that name none.
+var BarcodeSymbologies = []BarcodeSymbology{
+	BarcodeSymbologyQRCode, BarcodeSymbologyDataMatrix, BarcodeSymbologyAztec,
+	BarcodeSymbologyEAN13, BarcodeSymbologyEAN8, BarcodeSymbologyUPCA, BarcodeSymbologyUPCE,
+	BarcodeSymbologyCode128, BarcodeSymbologyCode39, BarcodeSymbologyCode93,
+	BarcodeSymbologyITF, BarcodeSymbologyCodabar,
+}
+
+// ValidateBarcodeSymbology returns the typed BarcodeSymbology or an error for unknown values.
+func ValidateBarcodeSymbology(s string) (BarcodeSymbology, error) {
+	for _, symbology := range BarcodeSymbologies {
+		if BarcodeSymbology(s) == symbology {
+			return symbology, nil
+		}
+	}
+	names := make([]string, len(BarcodeSymbologies))
+	for i, symbology := range BarcodeSymbologies {
+		names[i] = string(symbology)
+	}
+	return "", fmt.Errorf("unknown barcode symbology %q: must be one of %s", s, strings.Join(names, ", "))
+}
+
+// MaxBarcodePatternLength is the longest barcode_pattern a session can have.
+const MaxBarcodePatternLength = 256
+
+// CompileBarcodePattern compiles the pattern a barcode session's value must
+// match. The pattern uses Go's regexp syntax and must match the whole value,
+// as if it started with ^ and ended with $. An empty pattern accepts any value
+// and compiles to nil.
+func CompileBarcodePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if len(pattern) > MaxBarcodePatternLength {
+		return nil, fmt.Errorf("barcode_pattern must be at most %d bytes", MaxBarcodePatternLength)
+	}
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid barcode_pattern: %w", err)
+	}
+	return re, nil
+}
+
+// BarcodeResult is the value read by a completed barcode session.
+type BarcodeResult struct {
+	// Symbology is the format of the barcode that was read.
+	Symbology BarcodeSymbology `json:"symbology"`
+	// Text is the raw text encoded in the barcode.
+	Text string `json:"text"`
+}
+
 // ScanPage represents a single page of a scanned document in the result.
 type ScanPage struct {
 	URL         string `json:"url"`
@@ -149,6 +386,16 @@ type ResultItem struct {
 	ContentType string `json:"content_type"`
 	// Filename is the suggested file name for the result file.
 	Filename string `json:"filename"`
+	// Duration is the length of an audio recording.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// ActionResult is what completes an action: result items, or the scan or
+// barcode result, depending on the action type.
+type ActionResult struct {
+	Result        []ResultItem
+	ScanResult    *ScanResult
+	BarcodeResult *BarcodeResult
 }
 
 // Session represents a handoff session created by a backend application.
@@ -157,11 +404,13 @@ type Session struct {
 	ID string `json:"id"`
 	// ActionType is the action the phone user must complete.
 	ActionType ActionType `json:"action_type"`
-	// Status is the current lifecycle state of the session.
+	// Status is the current lifecycle state of the session. Change it with Transition.
 	Status SessionStatus `json:"status"`
+	// History records every status the session entered, oldest first.
+	History []StatusChange `json:"history,omitempty"`
 	// IntroText is optional Markdown displayed to the phone user on the session page.
 	IntroText string `json:"intro_text,omitempty"`
-	// OutputFormat specifies the file format for the result (photo/signature sessions).
+	// OutputFormat specifies the file format for the result (photo/signature/video/audio sessions).
 	OutputFormat OutputFormat `json:"output_format"`
 	// SessionTTL is how long the session remains active before expiring.
 	SessionTTL time.Duration `json:"session_ttl"`
@@ -171,9 +420,24 @@ type Session struct {
 	URL string `json:"url"`
 	// CreatedAt is the time the session was created.
 	CreatedAt time.Time `json:"created_at"`
+	// APIKeyID is a fingerprint of the API key that created the session, never the key itself.
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// ExternalRef is an opaque caller-supplied reference, e.g. an order or customer ID.
+	ExternalRef string `json:"external_ref,omitempty"`
+	// Metadata holds caller-supplied key/value pairs echoed in every response and event.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// WebhookURL receives a POST for each status change of the session.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Delivery is where the result files are pushed on completion, if anywhere.
+	Delivery *DeliveryTarget `json:"delivery,omitempty"`
+	// Steps lists the actions of a workflow session, in order; see ActionTypeWorkflow.
+	Steps []Step `json:"steps,omitempty"`
+	// CurrentStep is the index of the step the phone user is at. It equals
+	// len(Steps) once every step is complete.
+	CurrentStep int `json:"current_step,omitempty"`
 	// CompletedAt is set when the session reaches the "completed" status.
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
-	// Result holds the list of result files once the session is completed (photo/signature sessions).
+	// Result holds the list of result files once the session is completed (photo/signature/video/audio/upload sessions).
 	Result []ResultItem `json:"result,omitempty"`
 	// Opened is an internal flag used to track one-time-use session URL access.
 	Opened bool `json:"-"`
@@ -186,6 +450,72 @@ type Session struct {
 	ScanOutputFormat ScanOutputFormat `json:"scan_output_format,omitempty"`
 	// ScanResult holds the scan documents once a scan session is completed.
 	ScanResult *ScanResult `json:"scan_result,omitempty"`
+
+	// Video-specific fields (omitempty so they are absent on other sessions).
+
+	// VideoMaxDuration is the longest recording the phone user can make.
+	VideoMaxDuration time.Duration `json:"video_max_duration,omitempty"`
+	// VideoResolution is the resolution the phone records at.
+	VideoResolution VideoResolution `json:"video_resolution,omitempty"`
+
+	// Audio-specific fields (omitempty so they are absent on other sessions).
+
+	// AudioWAV requests a WAV copy of the recording next to the WebM or Ogg file.
+	AudioWAV bool `json:"audio_wav,omitempty"`
+
+	// Upload-specific fields (omitempty so they are absent on other sessions).
+
+	// UploadAllowedTypes lists the content types the phone user can upload, see ValidateUploadType.
+	UploadAllowedTypes []string `json:"upload_allowed_types,omitempty"`
+	// UploadMaxFiles is the most files the phone user can upload.
+	UploadMaxFiles int `json:"upload_max_files,omitempty"`
+	// UploadMaxFileBytes is the size limit of each uploaded file.
+	UploadMaxFileBytes int64 `json:"upload_max_file_bytes,omitempty"`
+
+	// Barcode-specific fields (omitempty so they are absent on other sessions).
+
+	// BarcodeSymbologies lists the barcode formats the phone accepts.
+	BarcodeSymbologies []BarcodeSymbology `json:"barcode_symbologies,omitempty"`
+	// BarcodePattern is a regular expression the whole value must match, if set.
+	BarcodePattern string `json:"barcode_pattern,omitempty"`
+	// BarcodeResult holds the value read once a barcode session is completed.
+	BarcodeResult *BarcodeResult `json:"barcode_result,omitempty"`
+}
+
+// Limits for caller-supplied correlation data on a session.
+const (
+	MaxExternalRefLength   = 256
+	MaxMetadataKeys        = 20
+	MaxMetadataKeyLength   = 64
+	MaxMetadataValueLength = 512
+)
+
+// ValidateExternalRef returns an error if ref exceeds MaxExternalRefLength.
+func ValidateExternalRef(ref string) error {
+	if len(ref) > MaxExternalRefLength {
+		return fmt.Errorf("external_ref must be at most %d bytes", MaxExternalRefLength)
+	}
+	return nil
+}
+
+// ValidateMetadata returns an error if metadata exceeds the key count or the
+// key and value length limits, or contains an empty key.
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > MaxMetadataKeys {
+		return fmt.Errorf("metadata must have at most %d keys", MaxMetadataKeys)
+	}
+	for k, v := range metadata {
+		if k == "" {
+			return fmt.Errorf("metadata keys must not be empty")
+		}
+		if len(k) > MaxMetadataKeyLength {
+			return fmt.Errorf("metadata key %q must be at most %d bytes", k, MaxMetadataKeyLength)
+		}
+		if len(v) > MaxMetadataValueLength {
+			return fmt.Errorf("metadata value for %q must be at most %d bytes", k, MaxMetadataValueLength)
+		}
+	}
+	return nil
 }
 
 // NewSessionID returns a new UUIDv4 string for use as a session ID.
@@ -0,0 +1,180 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// ActionTypeWorkflow is the action type of a session made of Steps. It is
+// assigned by the server and cannot be requested for a single action.
+const ActionTypeWorkflow ActionType = "workflow"
+
+// ValidateSessionActionType is ValidateActionType for the action type of an
+// existing session, e.g. in a filter, which may also be ActionTypeWorkflow.
+func ValidateSessionActionType(s string) (ActionType, error) {
+	if ActionType(s) == ActionTypeWorkflow {
+		return ActionTypeWorkflow, nil
+	}
+	return ValidateActionType(s)
+}
+
+// MaxSteps is the most steps a workflow session can have.
+const MaxSteps = 10
+
+// Step is one action of a workflow session, together with its result once the
+// phone user completed it.
+type Step struct {
+	// ActionType is the action the phone user must complete in this step.
+	ActionType ActionType `json:"action_type"`
+	// IntroText is optional Markdown displayed before the step.
+	IntroText string `json:"intro_text,omitempty"`
+	// OutputFormat specifies the file format of the result (photo/signature/video/audio steps).
+	OutputFormat OutputFormat `json:"output_format,omitempty"`
+	// ScanDocumentMode controls single vs. multi-document capture (scan steps).
+	ScanDocumentMode ScanDocumentMode `json:"document_mode,omitempty"`
+	// ScanOutputFormat controls the output format (scan steps).
+	ScanOutputFormat ScanOutputFormat `json:"scan_output_format,omitempty"`
+	// VideoMaxDuration is the longest recording the phone user can make (video steps).
+	VideoMaxDuration time.Duration `json:"video_max_duration,omitempty"`
+	// VideoResolution is the resolution the phone records at (video steps).
+	VideoResolution VideoResolution `json:"video_resolution,omitempty"`
+	// AudioWAV requests a WAV copy of the recording (audio steps).
+	AudioWAV bool `json:"audio_wav,omitempty"`
+	// UploadAllowedTypes lists the content types the phone user can upload (upload steps).
+	UploadAllowedTypes []string `json:"upload_allowed_types,omitempty"`
+	// UploadMaxFiles is the most files the phone user can upload (upload steps).
+	UploadMaxFiles int `json:"upload_max_files,omitempty"`
+	// UploadMaxFileBytes is the size limit of each uploaded file (upload steps).
+	UploadMaxFileBytes int64 `json:"upload_max_file_bytes,omitempty"`
+	// BarcodeSymbologies lists the barcode formats the phone accepts (barcode steps).
+	BarcodeSymbologies []BarcodeSymbology `json:"barcode_symbologies,omitempty"`
+	// BarcodePattern is a regular expression the whole value must match (barcode steps).
+	BarcodePattern string `json:"barcode_pattern,omitempty"`
+
+	// CompletedAt is set when the phone user completed the step.
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Result holds the result files of a completed photo, signature, video, audio or upload step.
+	Result []ResultItem `json:"result,omitempty"`
+	// ScanResult holds the documents of a completed scan step.
+	ScanResult *ScanResult `json:"scan_result,omitempty"`
+	// BarcodeResult holds the value read by a completed barcode step.
+	BarcodeResult *BarcodeResult `json:"barcode_result,omitempty"`
+}
+
+// ValidateStep checks the action type and output format of step, filling in
+// the scan defaults ("single" documents as "pdf") the way single-action
+// sessions do.
+func ValidateStep(step *Step) error {
+	actionType, err := ValidateActionType(string(step.ActionType))
+	if err != nil {
+		return err
+	}
+	step.ActionType = actionType
+
+	if actionType == ActionTypeUpload || actionType == ActionTypeBarcode {
+		if step.ScanDocumentMode != "" {
+			return fmt.Errorf("document_mode is only used by scan steps")
+		}
+		step.OutputFormat, err = ValidateOutputFormat(actionType, string(step.OutputFormat))
+		return err
+	}
+	if actionType != ActionTypeScan {
+		if step.OutputFormat == "" {
+			return fmt.Errorf("output_format is required")
+		}
+		if step.ScanDocumentMode != "" {
+			return fmt.Errorf("document_mode is only used by scan steps")
+		}
+		step.OutputFormat, err = ValidateOutputFormat(actionType, string(step.OutputFormat))
+		return err
+	}
+
+	// Scan steps carry their format in output_format on the wire, like scan sessions.
+	format := string(step.OutputFormat)
+	if format == "" {
+		format = string(ScanOutputFormatPDF)
+	}
+	if step.ScanOutputFormat, err = ValidateScanOutputFormat(format); err != nil {
+		return err
+	}
+	step.OutputFormat = ""
+
+	mode := string(step.ScanDocumentMode)
+	if mode == "" {
+		mode = string(ScanDocumentModeSingle)
+	}
+	step.ScanDocumentMode, err = ValidateScanDocumentMode(mode)
+	return err
+}
+
+// IsWorkflow reports whether the session is made of Steps.
+func (s *Session) IsWorkflow() bool {
+	return len(s.Steps) > 0
+}
+
+// CurrentAction returns the action the phone user is asked to complete: the
+// current step of a workflow session, or the session's own action otherwise.
+// Once every step is complete, the last step is returned.
+func (s *Session) CurrentAction() Step {
+	if !s.IsWorkflow() {
+		return Step{
+			ActionType:         s.ActionType,
+			IntroText:          s.IntroText,
+			OutputFormat:       s.OutputFormat,
+			ScanDocumentMode:   s.ScanDocumentMode,
+			ScanOutputFormat:   s.ScanOutputFormat,
+			VideoMaxDuration:   s.VideoMaxDuration,
+			VideoResolution:    s.VideoResolution,
+			AudioWAV:           s.AudioWAV,
+			UploadAllowedTypes: s.UploadAllowedTypes,
+			UploadMaxFiles:     s.UploadMaxFiles,
+			UploadMaxFileBytes: s.UploadMaxFileBytes,
+			BarcodeSymbologies: s.BarcodeSymbologies,
+			BarcodePattern:     s.BarcodePattern,
+		}
+	}
+	return s.Steps[min(s.CurrentStep, len(s.Steps)-1)]
+}
+
+// Complete records r as the result of step, which must be the current step,
+// and moves on to the next one. Completing the last step completes the
+// session; a session without steps has a single step 0. It returns an error
+// wrapping ErrInvalidTransition, leaving the session untouched, if the
+// session has already ended or is at another step.
+func (s *Session) Complete(step int, r ActionResult) error {
+	if !s.IsWorkflow() {
+		if step != 0 {
+			return fmt.Errorf("%w: session has no step %d", ErrInvalidTransition, step+1)
+		}
+		if err := s.Transition(SessionStatusCompleted, ActorPhone); err != nil {
+			return err
+		}
+		now := time.Now()
+		s.CompletedAt = &now
+		s.Result = r.Result
+		s.ScanResult = r.ScanResult
+		s.BarcodeResult = r.BarcodeResult
+		return nil
+	}
+	if s.Status.IsTerminal() || step != s.CurrentStep {
+		return fmt.Errorf("%w: cannot complete step %d of session at step %d (%s)", ErrInvalidTransition, step+1, s.CurrentStep+1, s.Status)
+	}
+
+	last := step == len(s.Steps)-1
+	if last {
+		if err := s.Transition(SessionStatusCompleted, ActorPhone); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	s.Steps[step].CompletedAt = &now
+	s.Steps[step].Result = r.Result
+	s.Steps[step].ScanResult = r.ScanResult
+	s.Steps[step].BarcodeResult = r.BarcodeResult
+	s.CurrentStep++
+	if last {
+		s.CompletedAt = &now
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Actors recorded in a session's status history.
+const (
+	// ActorAPI is the backend application, acting through the REST API.
+	ActorAPI = "api"
+	// ActorPhone is the end user, acting through the phone UI.
+	ActorPhone = "phone"
+	// ActorSystem is the server itself, e.g. when a session's TTL elapses.
+	ActorSystem = "system"
+)
+
+// ErrInvalidTransition is returned by Session.Transition for a status change
+// the session lifecycle does not allow.
+var ErrInvalidTransition = errors.New("invalid session status transition")
+
+// sessionTransitions lists, for each status, the statuses a session may move
+// to next. Terminal statuses have no entry. A session never moves backward, so
+// reloading the phone page cannot undo progress.
+var sessionTransitions = map[SessionStatus][]SessionStatus{
+	SessionStatusPending:       {SessionStatusOpened, SessionStatusActionStarted, SessionStatusCompleted, SessionStatusExpired, SessionStatusCancelled},
+	SessionStatusOpened:        {SessionStatusActionStarted, SessionStatusCompleted, SessionStatusExpired, SessionStatusCancelled},
+	SessionStatusActionStarted: {SessionStatusCompleted, SessionStatusExpired, SessionStatusCancelled},
+}
+
+// CanTransition reports whether a session in status from may move to status to.
+func CanTransition(from, to SessionStatus) bool {
+	for _, next := range sessionTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange is one entry in a session's status history.
+type StatusChange struct {
+	// Status is the status the session entered.
+	Status SessionStatus `json:"status"`
+	// At is when the session entered Status.
+	At time.Time `json:"at"`
+	// Actor is who caused the change: ActorAPI, ActorPhone or ActorSystem.
+	Actor string `json:"actor"`
+}
+
+// Transition moves the session to status to on behalf of actor and records the
+// change in its history. It returns an error wrapping ErrInvalidTransition,
+// leaving the session untouched, if the move is not allowed.
+func (s *Session) Transition(to SessionStatus, actor string) error {
+	if !CanTransition(s.Status, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, s.Status, to)
+	}
+	s.Status = to
+	s.History = append(s.History, StatusChange{Status: to, At: time.Now(), Actor: actor})
+	return nil
+}
@@ -0,0 +1,101 @@
+package model_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mxcd/handoff/internal/model"
+)
+
+var statuses = []model.SessionStatus{
+	model.SessionStatusPending,
+	model.SessionStatusOpened,
+	model.SessionStatusActionStarted,
+	model.SessionStatusCompleted,
+	model.SessionStatusExpired,
+	model.SessionStatusCancelled,
+}
+
+func TestCanTransition(t *testing.T) {
+	allowed := map[model.SessionStatus][]model.SessionStatus{
+		model.SessionStatusPending:       {model.SessionStatusOpened, model.SessionStatusActionStarted, model.SessionStatusCompleted, model.SessionStatusExpired, model.SessionStatusCancelled},
+		model.SessionStatusOpened:        {model.SessionStatusActionStarted, model.SessionStatusCompleted, model.SessionStatusExpired, model.SessionStatusCancelled},
+		model.SessionStatusActionStarted: {model.SessionStatusCompleted, model.SessionStatusExpired, model.SessionStatusCancelled},
+		// Completed, expired and cancelled are terminal.
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := false
+			for _, next := range allowed[from] {
+				want = want || next == to
+			}
+			if got := model.CanTransition(from, to); got != want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	s := &model.Session{Status: model.SessionStatusPending}
+	steps := []struct {
+		to    model.SessionStatus
+		actor string
+	}{
+		{model.SessionStatusOpened, model.ActorPhone},
+		{model.SessionStatusActionStarted, model.ActorPhone},
+		{model.SessionStatusCancelled, model.ActorAPI},
+	}
+	for _, step := range steps {
+		if err := s.Transition(step.to, step.actor); err != nil {
+			t.Fatalf("Transition(%s): %v", step.to, err)
+		}
+	}
+	if s.Status != model.SessionStatusCancelled || len(s.History) != len(steps) {
+		t.Fatalf("got status %s with %d history entries, want cancelled with %d", s.Status, len(s.History), len(steps))
+	}
+	for i, step := range steps {
+		if got := s.History[i]; got.Status != step.to || got.Actor != step.actor || got.At.IsZero() {
+			t.Errorf("history[%d] = %+v, want %s by %s", i, got, step.to, step.actor)
+		}
+	}
+
+	// A rejected move leaves the session untouched.
+	for _, to := range statuses {
+		err := s.Transition(to, model.ActorSystem)
+		if !errors.Is(err, model.ErrInvalidTransition) {
+			t.Errorf("Transition(cancelled to %s): got %v, want ErrInvalidTransition", to, err)
+		}
+	}
+	if s.Status != model.SessionStatusCancelled || len(s.History) != len(steps) {
+		t.Errorf("after rejected moves: got status %s with %d history entries", s.Status, len(s.History))
+	}
+}
+
+func TestCompleteWorkflow(t *testing.T) {
+	s := &model.Session{
+		Status: model.SessionStatusActionStarted,
+		Steps:  []model.Step{{ActionType: model.ActionTypePhoto}, {ActionType: model.ActionTypePhoto}},
+	}
+	if err := s.Complete(1, model.ActionResult{}); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Errorf("Complete(step 2 before step 1): got %v, want ErrInvalidTransition", err)
+	}
+	if err := s.Complete(0, model.ActionResult{}); err != nil {
+		t.Fatalf("Complete(step 1): %v", err)
+	}
+	if s.Status != model.SessionStatusActionStarted || s.CurrentStep != 1 || s.Steps[0].CompletedAt == nil {
+		t.Errorf("after step 1: got status %s at step %d", s.Status, s.CurrentStep)
+	}
+	if err := s.Complete(0, model.ActionResult{}); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Errorf("Complete(step 1 again): got %v, want ErrInvalidTransition", err)
+	}
+	if err := s.Complete(1, model.ActionResult{}); err != nil {
+		t.Fatalf("Complete(step 2): %v", err)
+	}
+	if s.Status != model.SessionStatusCompleted || s.CompletedAt == nil {
+		t.Errorf("after the last step: got status %s, completed at %v", s.Status, s.CompletedAt)
+	}
+	if err := s.Complete(2, model.ActionResult{}); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Errorf("Complete(after the last step): got %v, want ErrInvalidTransition", err)
+	}
+}
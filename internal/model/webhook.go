@@ -0,0 +1,65 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// WebhookState is the delivery state of a webhook.
+type WebhookState string
+
+const (
+	// WebhookStateQueued — waiting for its next delivery attempt.
+	WebhookStateQueued WebhookState = "queued"
+	// WebhookStateDelivered — the receiver acknowledged it with a 2xx response.
+	WebhookStateDelivered WebhookState = "delivered"
+	// WebhookStateDead — every attempt failed; kept in the dead-letter list.
+	WebhookStateDead WebhookState = "dead"
+)
+
+// WebhookDelivery is one session event on its way to a webhook URL.
+type WebhookDelivery struct {
+	// ID identifies the delivery; receivers use it to drop duplicates.
+	ID string `json:"id"`
+	// SessionID is the session the event belongs to.
+	SessionID string `json:"session_id"`
+	// APIKeyID is the fingerprint of the API key that created the session.
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// Event is the event name, e.g. "completed".
+	Event string `json:"event"`
+	// URL is where the event is POSTed.
+	URL string `json:"url"`
+	// Payload is the JSON request body.
+	Payload json.RawMessage `json:"payload"`
+	// State is the delivery state.
+	State WebhookState `json:"state"`
+	// Attempts counts the delivery attempts made so far.
+	Attempts int `json:"attempts"`
+	// CreatedAt is when the event was queued.
+	CreatedAt time.Time `json:"created_at"`
+	// NextAttemptAt is when a queued delivery is due.
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	// LastAttemptAt is when the most recent attempt was made.
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	// LastStatusCode is the HTTP status of the most recent attempt, if it got a response.
+	LastStatusCode int `json:"last_status_code,omitempty"`
+	// LastError describes why the most recent attempt failed.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// MaxWebhookURLLength limits the length of a session's webhook URL.
+const MaxWebhookURLLength = 2048
+
+// ValidateWebhookURL returns an error unless raw is an absolute http or https URL.
+func ValidateWebhookURL(raw string) error {
+	if len(raw) > MaxWebhookURLLength {
+		return fmt.Errorf("webhook_url must be at most %d bytes", MaxWebhookURLLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook_url must be an absolute http or https URL")
+	}
+	return nil
+}
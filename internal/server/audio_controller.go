@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// audioUploadHandler accepts the recording of an audio session, or a workflow
+// session at an audio step, and completes the session with it.
+// POST /s/:id/audio/upload (public — session UUID is the auth)
+//
+// Expects multipart/form-data with:
+//   - file: the recording, a WebM or Ogg file; its type is detected from its
+//     content, not the declared content type
+//   - wav: a WAV copy of the recording, required if the session asks for one.
+//     The phone converts the recording; the server only reads the WAV header.
+//   - duration_ms: integer (optional); the length of the recording as measured
+//     by the phone
+//
+// Each result item carries its own duration: the WAV copy's is read from its
+// header, the recording's is duration_ms, or the WAV copy's if not given.
+func (s *Server) audioUploadHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, action, ok := s.actionSession(c, model.ActionTypeAudio, "audio_upload")
+		if !ok {
+			return
+		}
+		id := session.ID
+
+		if !parseUploadForm(c) {
+			return
+		}
+		data, _, ok := readFormFile(c, id, "file", "audio_upload")
+		if !ok {
+			return
+		}
+		// Trust the content, not the type the browser declared.
+		audioType, ok := sniffAudioType(data)
+		if !ok {
+			jsonError(c, http.StatusBadRequest, "unsupported audio type "+util.SniffContentType(data)+": must be audio/webm or audio/ogg")
+			return
+		}
+
+		var duration time.Duration
+		if v := c.Request.FormValue("duration_ms"); v != "" {
+			ms, err := strconv.Atoi(v)
+			if err != nil || ms < 0 {
+				jsonError(c, http.StatusBadRequest, "invalid duration_ms")
+				return
+			}
+			duration = time.Duration(ms) * time.Millisecond
+		}
+
+		var wav []byte
+		var wavDuration time.Duration
+		if action.AudioWAV {
+			if wav, _, ok = readFormFile(c, id, "wav", "audio_upload"); !ok {
+				return
+			}
+			// The WAV header states the length exactly.
+			var err error
+			if wavDuration, err = util.WAVDuration(wav); err != nil {
+				jsonError(c, http.StatusBadRequest, "invalid wav file: "+err.Error())
+				return
+			}
+			if duration == 0 {
+				// Both are the same recording.
+				duration = wavDuration
+			}
+		}
+
+		// The recording, then the WAV copy if there is one.
+		resultItems := make([]model.ResultItem, 0, 2)
+		for _, f := range []struct {
+			data        []byte
+			contentType string
+			duration    time.Duration
+		}{{data, audioType, duration}, {wav, "audio/wav", wavDuration}} {
+			if f.data == nil {
+				continue
+			}
+			dlID := model.NewSessionID()
+			if err := s.Store.StoreFile(dlID, f.data, f.contentType, session.ResultTTL); err != nil {
+				log.Error().Err(err).Str("session_id", id).Str("download_id", dlID).Msg("audio_upload: failed to store recording")
+				jsonError(c, http.StatusInternalServerError, "failed to store recording")
+				return
+			}
+			resultItems = append(resultItems, model.ResultItem{
+				DownloadID:  dlID,
+				ContentType: f.contentType,
+				Filename:    "audio." + strings.TrimPrefix(f.contentType, "audio/"),
+				Duration:    f.duration,
+			})
+		}
+
+		if updated := s.completeAction(c, session, model.ActionResult{Result: resultItems}, "audio_upload"); updated != nil {
+			c.JSON(http.StatusOK, gin.H{"status": string(updated.Status), "items": resultItems})
+		}
+	}
+}
+
+// sniffAudioType returns the type of a recording detected from its content,
+// which must be a WebM or Ogg file.
+func sniffAudioType(data []byte) (string, bool) {
+	switch util.SniffContentType(data) {
+	case "video/webm":
+		// WebM audio and video share their header.
+		return "audio/webm", true
+	case "application/ogg":
+		return "audio/ogg", true
+	}
+	return "", false
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// maxBarcodeTextBytes bounds the value the phone can submit; the densest
+// symbologies hold a few thousand characters.
+const maxBarcodeTextBytes = 8 << 10
+
+// barcodeSettings validates the symbologies and pattern requested for a
+// barcode session or step, and returns the symbologies, defaulting to all of
+// model.BarcodeSymbologies.
+func barcodeSettings(symbologies []string, pattern string) ([]model.BarcodeSymbology, error) {
+	if _, err := model.CompileBarcodePattern(pattern); err != nil {
+		return nil, err
+	}
+	if len(symbologies) == 0 {
+		return model.BarcodeSymbologies, nil
+	}
+	settings := make([]model.BarcodeSymbology, 0, len(symbologies))
+	for _, s := range symbologies {
+		symbology, err := model.ValidateBarcodeSymbology(s)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, symbology)
+	}
+	return settings, nil
+}
+
+type submitBarcodeRequest struct {
+	Symbology string `json:"symbology" binding:"required"`
+	Text      string `json:"text" binding:"required"`
+}
+
+// barcodeSubmitHandler completes a barcode session, or a workflow session at a
+// barcode step, with a value the phone decoded from the camera feed.
+// POST /s/:id/barcode/submit (public — session UUID is the auth)
+//
+// Expects JSON {"symbology": "code_128", "text": "..."}. The symbology must be
+// one the session accepts and the text must match its pattern, if any.
+func (s *Server) barcodeSubmitHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, action, ok := s.actionSession(c, model.ActionTypeBarcode, "barcode_submit")
+		if !ok {
+			return
+		}
+
+		var req submitBarcodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			jsonError(c, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if len(req.Text) > maxBarcodeTextBytes {
+			jsonError(c, http.StatusBadRequest, "barcode value too long")
+			return
+		}
+
+		s.completeBarcode(c, session, action, &model.BarcodeResult{
+			Symbology: model.BarcodeSymbology(req.Symbology),
+			Text:      req.Text,
+		}, "barcode_submit")
+	}
+}
+
+// barcodeDecodeHandler decodes a still image taken by the phone, for browsers
+// that cannot decode barcodes themselves, and completes the session with the
+// value found like barcodeSubmitHandler.
+// POST /s/:id/barcode/decode (public — session UUID is the auth)
+//
+// Expects multipart/form-data with:
+//   - file: a JPEG or PNG image of the barcode
+func (s *Server) barcodeDecodeHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, action, ok := s.actionSession(c, model.ActionTypeBarcode, "barcode_decode")
+		if !ok {
+			return
+		}
+
+		if !parseUploadForm(c) {
+			return
+		}
+		data, _, ok := readFormFile(c, session.ID, "file", "barcode_decode")
+		if !ok {
+			return
+		}
+
+		symbologies := make([]string, len(action.BarcodeSymbologies))
+		for i, symbology := range action.BarcodeSymbologies {
+			symbologies[i] = string(symbology)
+		}
+		symbology, text, err := util.DecodeBarcode(data, symbologies)
+		if errors.Is(err, util.ErrNoBarcode) {
+			jsonError(c, http.StatusBadRequest, "no barcode found")
+			return
+		}
+		if err != nil {
+			jsonError(c, http.StatusBadRequest, "invalid image: "+err.Error())
+			return
+		}
+
+		s.completeBarcode(c, session, action, &model.BarcodeResult{
+			Symbology: model.BarcodeSymbology(symbology),
+			Text:      text,
+		}, "barcode_decode")
+	}
+}
+
+// completeBarcode checks result against the symbologies and pattern of the
+// current action and completes the session, or its current step, with it.
+func (s *Server) completeBarcode(c *gin.Context, session *model.Session, action model.Step, result *model.BarcodeResult, logPrefix string) {
+	allowed := false
+	for _, symbology := range action.BarcodeSymbologies {
+		if result.Symbology == symbology {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":               "symbology not allowed",
+			"symbology":           result.Symbology,
+			"allowed_symbologies": action.BarcodeSymbologies,
+		})
+		return
+	}
+	pattern, err := model.CompileBarcodePattern(action.BarcodePattern)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session.ID).Msg(logPrefix + ": invalid stored barcode pattern")
+		jsonError(c, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if pattern != nil && !pattern.MatchString(result.Text) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "value does not match the pattern",
+			"text":  result.Text,
+		})
+		return
+	}
+
+	// Barcode results have no files, so there is nothing to deliver.
+	if updated := s.completeAction(c, session, model.ActionResult{BarcodeResult: result}, logPrefix); updated != nil {
+		c.JSON(http.StatusOK, gin.H{"status": string(updated.Status), "barcode_result": result})
+	}
+}
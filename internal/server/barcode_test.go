@@ -0,0 +1,62 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mxcd/handoff/internal/store"
+)
+
+func TestCreateBarcodeSessionValidation(t *testing.T) {
+	ts := newTestServer(t, store.NewMemoryStore())
+	for name, req := range map[string]map[string]any{
+		"unknown symbology": {"action_type": "barcode", "barcode_symbologies": []string{"code_128", "pdf_417"}},
+		"invalid pattern":   {"action_type": "barcode", "barcode_pattern": "INV-("},
+		"invalid step": {"steps": []map[string]any{
+			{"action_type": "barcode", "barcode_symbologies": []string{"qr"}},
+		}},
+	} {
+		if resp, body := ts.request(http.MethodPost, "/api/v1/sessions", req, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got %d %s, want 400", name, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestBarcodeSubmit(t *testing.T) {
+	ts := newTestServer(t, store.NewMemoryStore())
+	id := ts.createSession(map[string]any{
+		"action_type":         "barcode",
+		"barcode_symbologies": []string{"code_128", "qr_code"},
+		"barcode_pattern":     `INV-\d{4}`,
+	})["id"].(string)
+	submit := func(symbology, text string) (*http.Response, []byte) {
+		return ts.request(http.MethodPost, "/s/"+id+"/barcode/submit", map[string]string{"symbology": symbology, "text": text}, nil)
+	}
+
+	for name, tc := range map[string][2]string{
+		"symbology not allowed": {"ean_13", "INV-1234"},
+		"unknown symbology":     {"pdf_417", "INV-1234"},
+		"pattern mismatch":      {"code_128", "INV-12"},
+		"partial match":         {"code_128", "XINV-1234"},
+		"empty text":            {"code_128", ""},
+	} {
+		if resp, body := submit(tc[0], tc[1]); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got %d %s, want 400", name, resp.StatusCode, body)
+		}
+	}
+	// Rejected values leave the session waiting for a valid one.
+	if session, err := ts.Store.GetSession(id); err != nil || session.Status.IsTerminal() || session.BarcodeResult != nil {
+		t.Fatalf("after rejected values: got (%+v, %v)", session, err)
+	}
+
+	if resp, body := submit("qr_code", "INV-1234"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("valid value: got %d %s, want 200", resp.StatusCode, body)
+	}
+	session, err := ts.Store.GetSession(id)
+	if err != nil || session.BarcodeResult == nil || session.BarcodeResult.Symbology != "qr_code" || session.BarcodeResult.Text != "INV-1234" {
+		t.Fatalf("after valid value: got (%+v, %v)", session, err)
+	}
+	if resp, body := submit("qr_code", "INV-5678"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second value: got %d %s, want 409", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,108 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+)
+
+func TestCancelSession(t *testing.T) {
+	ts := newTestServer(t, store.NewMemoryStore())
+	id := ts.createSession(map[string]any{"steps": []map[string]string{
+		{"action_type": "photo", "output_format": "jpg"},
+		{"action_type": "scan"},
+	}})["id"].(string)
+
+	// Complete the photo step and upload a page for the scan step.
+	if resp, body := ts.request(http.MethodGet, "/s/"+id, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("open session page: got %d %s", resp.StatusCode, body)
+	}
+	photo := map[string]any{"items": []map[string]string{
+		{"content_type": "image/jpeg", "filename": "photo.jpg", "data": base64.StdEncoding.EncodeToString([]byte("photo"))},
+	}}
+	if resp, body := ts.request(http.MethodPost, "/s/"+id+"/result", photo, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("submit photo step: got %d %s", resp.StatusCode, body)
+	}
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("file", "page.jpg")
+	fw.Write([]byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10})
+	mw.Close()
+	if resp, body := ts.request(http.MethodPost, "/s/"+id+"/scan/upload", form.Bytes(), map[string]string{"Content-Type": mw.FormDataContentType()}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload scan page: got %d %s", resp.StatusCode, body)
+	}
+	session, err := ts.Store.GetSession(id)
+	if err != nil || len(session.Steps[0].Result) != 1 {
+		t.Fatalf("after the photo step: got (%+v, %v)", session, err)
+	}
+	photoID := session.Steps[0].Result[0].DownloadID
+
+	resp, body := ts.request(http.MethodDelete, "/api/v1/sessions/"+id, nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("cancel: got %d %s, want 200", resp.StatusCode, body)
+	}
+	if session, err := ts.Store.GetSession(id); err != nil || session.Status != model.SessionStatusCancelled {
+		t.Errorf("after cancel: got (%+v, %v), want a cancelled session", session, err)
+	}
+	if f, err := ts.Store.OpenFile(photoID); err != nil || f != nil {
+		t.Errorf("photo step result after cancel: got (%v, %v), want deleted", f, err)
+	}
+	if pages, err := ts.Store.GetScanPages(id); err != nil || len(pages) != 0 {
+		t.Errorf("scan pages after cancel: got (%d, %v), want none", len(pages), err)
+	}
+	if resp, _ := ts.request(http.MethodGet, "/s/"+id, nil, nil); resp.StatusCode != http.StatusGone {
+		t.Errorf("session page after cancel: got %d, want 410", resp.StatusCode)
+	}
+
+	// Cancelling again is a no-op.
+	if resp, body := ts.request(http.MethodDelete, "/api/v1/sessions/"+id, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("second cancel: got %d %s, want 200", resp.StatusCode, body)
+	}
+}
+
+func TestCancelSessionRejected(t *testing.T) {
+	st := store.NewMemoryStore()
+	ts := newTestServer(t, st)
+
+	completed := ts.createSession(map[string]string{"action_type": "photo", "output_format": "jpg"})["id"].(string)
+	ts.request(http.MethodGet, "/s/"+completed, nil, nil)
+	photo := map[string]any{"items": []map[string]string{
+		{"content_type": "image/jpeg", "filename": "photo.jpg", "data": base64.StdEncoding.EncodeToString([]byte("photo"))},
+	}}
+	if resp, body := ts.request(http.MethodPost, "/s/"+completed+"/result", photo, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("submit photo: got %d %s", resp.StatusCode, body)
+	}
+
+	expired := model.NewSessionID()
+	if err := st.CreateSession(&model.Session{
+		ID:         expired,
+		ActionType: model.ActionTypePhoto,
+		Status:     model.SessionStatusExpired,
+		SessionTTL: time.Minute,
+		CreatedAt:  time.Now(),
+	}); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		id   string
+		want int
+	}{
+		"completed": {completed, http.StatusConflict},
+		"expired":   {expired, http.StatusGone},
+		"unknown":   {model.NewSessionID(), http.StatusNotFound},
+	} {
+		if resp, body := ts.request(http.MethodDelete, "/api/v1/sessions/"+tc.id, nil, nil); resp.StatusCode != tc.want {
+			t.Errorf("%s: got %d %s, want %d", name, resp.StatusCode, body, tc.want)
+		}
+	}
+	if session, err := st.GetSession(completed); err != nil || session.Status != model.SessionStatusCompleted {
+		t.Errorf("completed session after cancel: got (%+v, %v)", session, err)
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mxcd/handoff/internal/model"
+)
+
+// pushDelivery hands the result files of a just completed session to the
+// delivery pusher when the session has a delivery target.
+func (s *Server) pushDelivery(session *model.Session, files []model.FileDelivery) {
+	if session.Delivery == nil || s.Deliveries == nil || len(files) == 0 {
+		return
+	}
+	s.Deliveries.Push(session, files)
+}
+
+// scanFilename names a finalized scan document or page for delivery, e.g.
+// "document-1.pdf" or "document-2-page-3.jpg". Indexes are 1-based.
+func scanFilename(document, page int, contentType string) string {
+	if page == 0 {
+		return fmt.Sprintf("document-%d.pdf", document)
+	}
+	ext := "bin"
+	if sub, ok := strings.CutPrefix(contentType, "image/"); ok {
+		ext = strings.TrimSuffix(strings.ReplaceAll(sub, "jpeg", "jpg"), "+xml")
+	}
+	return fmt.Sprintf("document-%d-page-%d.%s", document, page, ext)
+}
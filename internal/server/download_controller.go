@@ -2,22 +2,41 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mxcd/go-config/config"
+	"github.com/mxcd/handoff/internal/store"
 	"github.com/rs/zerolog/log"
 )
 
+// downloadPathPrefix precedes the download ID in the URLs of scan documents and pages.
+const downloadPathPrefix = apiBasePath + "/downloads/"
+
 // downloadHandler returns the file download handler.
 // GET /api/v1/downloads/:download_id
 //
+// The file is streamed from the store via http.ServeContent, so Range,
+// If-None-Match and If-Modified-Since requests are honoured. With
+// S3_PRESIGNED_DOWNLOADS enabled and a file store that supports it, the
+// client is redirected to a short-lived presigned URL instead.
+//
 // Returns:
 //   - 200 with binary file data and correct Content-Type header
+//   - 206 for satisfiable Range requests
+//   - 302 to a presigned object URL when presigned downloads are enabled
+//   - 304 when the client's cached copy is still current
 //   - 404 when the file has expired or does not exist
 func (s *Server) downloadHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		downloadID := c.Param("download_id")
 
-		storedFile, err := s.Store.GetFile(downloadID)
+		if presigner, ok := store.AsPresigner(s.Store); ok && config.Get().Bool("S3_PRESIGNED_DOWNLOADS") {
+			s.redirectToPresignedURL(c, presigner, downloadID)
+			return
+		}
+
+		storedFile, err := s.Store.OpenFile(downloadID)
 		if err != nil {
 			log.Error().Err(err).Str("download_id", downloadID).Msg("download: failed to retrieve file")
 			jsonError(c, http.StatusInternalServerError, "internal error")
@@ -27,8 +46,37 @@ func (s *Server) downloadHandler() gin.HandlerFunc {
 			jsonError(c, http.StatusNotFound, "file not found or expired")
 			return
 		}
+		defer storedFile.Close()
 
 		c.Header("Content-Disposition", "attachment")
-		c.Data(http.StatusOK, storedFile.ContentType, storedFile.Data)
+		c.Header("Content-Type", storedFile.ContentType)
+		if storedFile.ETag != "" {
+			c.Header("ETag", storedFile.ETag)
+		}
+		http.ServeContent(c.Writer, c.Request, "", storedFile.ModTime, storedFile.Content)
 	}
 }
+
+// redirectToPresignedURL answers a download with a 302 to a presigned URL.
+func (s *Server) redirectToPresignedURL(c *gin.Context, presigner store.FilePresigner, downloadID string) {
+	expiry, err := time.ParseDuration(config.Get().String("S3_PRESIGN_TTL"))
+	if err != nil {
+		log.Error().Err(err).Msg("download: failed to parse S3_PRESIGN_TTL config")
+		jsonError(c, http.StatusInternalServerError, "internal configuration error")
+		return
+	}
+
+	u, err := presigner.PresignFile(downloadID, expiry)
+	if err != nil {
+		log.Error().Err(err).Str("download_id", downloadID).Msg("download: failed to presign file")
+		jsonError(c, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if u == nil {
+		jsonError(c, http.StatusNotFound, "file not found or expired")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, u.String())
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/webhook"
+	"github.com/mxcd/handoff/internal/ws"
+	"github.com/rs/zerolog/log"
+)
+
+// WebSocket event types sent to session subscribers.
+const (
+	eventStatusUpdate  = "status_update"
+	eventStepCompleted = "step_completed" // workflow sessions only
+	eventCompleted     = "completed"
+	eventCancelled     = "cancelled"
+	eventExpired       = "expired"
+	eventTTLUpdated    = "ttl_updated"
+)
+
+// ttlUpdate is the data of a "ttl_updated" event. Durations are encoded as
+// nanoseconds, like the session's own TTL fields.
+type ttlUpdate struct {
+	SessionTTL time.Duration `json:"session_ttl"`
+	ResultTTL  time.Duration `json:"result_ttl"`
+	ExpiresAt  time.Time     `json:"expires_at"`
+}
+
+// newTTLUpdate returns the "ttl_updated" event data for session.
+func newTTLUpdate(session *model.Session) ttlUpdate {
+	return ttlUpdate{
+		SessionTTL: session.SessionTTL,
+		ResultTTL:  session.ResultTTL,
+		ExpiresAt:  session.CreatedAt.Add(session.SessionTTL),
+	}
+}
+
+// emitEvent records a change in session's event log and tells every
+// subscriber of session, and every account subscriber of the API key that
+// created it, about it. The message
+// carries the session's current status and its correlation fields; data is
+// the result payload for "completed" and "step_completed" events and the new
+// TTLs for "ttl_updated".
+// Status changes are also queued for the session's webhook.
+func (s *Server) emitEvent(session *model.Session, eventType string, data interface{}) {
+	msg := newEventMessage(session, eventType, data)
+
+	lock := s.eventLock(session.ID)
+	lock.Lock()
+	if err := s.logEvent(session, &msg); err != nil {
+		// Live subscribers still get the message, without a sequence number.
+		log.Error().Err(err).Str("session_id", session.ID).Str("type", eventType).Msg("events: failed to log event")
+	}
+	s.Hub.Publish(session.APIKeyID, msg)
+	lock.Unlock()
+
+	if s.Webhooks == nil {
+		return
+	}
+	if event, ok := webhookEvent(eventType, session.Status); ok {
+		if err := s.Webhooks.Enqueue(session, event, data); err != nil {
+			log.Error().Err(err).Str("session_id", session.ID).Str("event", event).Msg("webhook: failed to queue event")
+		}
+	}
+}
+
+// eventLock returns the lock serialising the events of sessionID.
+func (s *Server) eventLock(sessionID string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(sessionID))
+	return &s.eventLocks[h.Sum32()%uint32(len(s.eventLocks))]
+}
+
+// logEvent appends msg to the session's event log and sets its sequence number.
+func (s *Server) logEvent(session *model.Session, msg *ws.WSMessage) error {
+	var data json.RawMessage
+	if msg.Data != nil {
+		var err error
+		if data, err = json.Marshal(msg.Data); err != nil {
+			return err
+		}
+	}
+	event := &model.SessionEvent{
+		Type:      msg.Type,
+		Status:    session.Status,
+		Data:      data,
+		Timestamp: msg.Timestamp,
+	}
+	if err := s.Store.AppendEvent(session.ID, event, session.CreatedAt.Add(session.SessionTTL)); err != nil {
+		return err
+	}
+	msg.Seq = event.Seq
+	return nil
+}
+
+// loggedEventMessage rebuilds the message of a logged event of session.
+func loggedEventMessage(session *model.Session, event *model.SessionEvent) ws.WSMessage {
+	msg := newEventMessage(session, event.Type, nil)
+	msg.Seq = event.Seq
+	msg.Status = string(event.Status)
+	msg.Timestamp = event.Timestamp
+	if len(event.Data) > 0 {
+		msg.Data = event.Data
+	}
+	return msg
+}
+
+// webhookEvent returns the webhook event for a WebSocket event type, or false
+// for events that are not sent to webhooks.
+func webhookEvent(eventType string, status model.SessionStatus) (string, bool) {
+	switch eventType {
+	case eventStatusUpdate:
+		switch status {
+		case model.SessionStatusOpened:
+			return webhook.EventOpened, true
+		case model.SessionStatusActionStarted:
+			return webhook.EventActionStarted, true
+		}
+	case eventCompleted:
+		return webhook.EventCompleted, true
+	case eventCancelled:
+		return webhook.EventCancelled, true
+	case eventExpired:
+		return webhook.EventExpired, true
+	}
+	return "", false
+}
+
+// newEventMessage builds the WebSocket message for an event on session.
+func newEventMessage(session *model.Session, eventType string, data interface{}) ws.WSMessage {
+	return ws.WSMessage{
+		Type:        eventType,
+		SessionID:   session.ID,
+		Status:      string(session.Status),
+		Data:        data,
+		Timestamp:   time.Now(),
+		ActionType:  string(session.ActionType),
+		ExternalRef: session.ExternalRef,
+		Metadata:    session.Metadata,
+	}
+}
+
+// withSessionRef adds the session's correlation fields to a JSON response body.
+func withSessionRef(resp gin.H, session *model.Session) gin.H {
+	if session.ExternalRef != "" {
+		resp["external_ref"] = session.ExternalRef
+	}
+	if len(session.Metadata) > 0 {
+		resp["metadata"] = session.Metadata
+	}
+	return resp
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/go-config/config"
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	// idempotencyPendingTTL bounds how long a key stays locked by a request
+	// that never finishes, e.g. because the replica handling it crashed.
+	idempotencyPendingTTL = time.Minute
+)
+
+// idempotentRequest is a request whose Idempotency-Key has been reserved.
+type idempotentRequest struct {
+	key  string // store key, scoped to the caller's API key
+	hash string // hex SHA-256 of the request body
+	ttl  time.Duration
+}
+
+// beginIdempotent reserves the request's Idempotency-Key, if it has one. The
+// request body must have been bound with ShouldBindBodyWith so its bytes are
+// still available.
+//
+// It returns (nil, true) when the request carries no key. When ok is false a
+// response has already been written:
+//   - the original response, replayed, if the key was used with the same body
+//   - 422 if the key was used with a different body
+//   - 409 if the original request is still in progress
+func (s *Server) beginIdempotent(c *gin.Context) (req *idempotentRequest, ok bool) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		return nil, true
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		jsonError(c, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+		return nil, false
+	}
+
+	ttl, err := time.ParseDuration(config.Get().String("IDEMPOTENCY_TTL"))
+	if err != nil {
+		log.Error().Err(err).Msg("idempotency: failed to parse IDEMPOTENCY_TTL config")
+		jsonError(c, http.StatusInternalServerError, "internal configuration error")
+		return nil, false
+	}
+
+	body, _ := c.Get(gin.BodyBytesKey)
+	raw, _ := body.([]byte)
+	sum := sha256.Sum256(raw)
+	req = &idempotentRequest{
+		key:  c.GetString(apiKeyIDContextKey) + ":" + key,
+		hash: hex.EncodeToString(sum[:]),
+		ttl:  ttl,
+	}
+
+	existing, err := s.Store.ReserveIdempotencyKey(req.key, &store.IdempotencyRecord{RequestHash: req.hash}, idempotencyPendingTTL)
+	if err != nil {
+		log.Error().Err(err).Msg("idempotency: failed to reserve key")
+		jsonError(c, http.StatusInternalServerError, "internal error")
+		return nil, false
+	}
+	if existing == nil {
+		return req, true
+	}
+
+	switch {
+	case existing.RequestHash != req.hash:
+		jsonError(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request body")
+	case existing.Pending():
+		c.Header("Retry-After", "1")
+		jsonError(c, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+	default:
+		log.Debug().Str("idempotency_key", key).Msg("idempotency: replaying response")
+		c.Header(idempotencyReplayedHeader, "true")
+		c.Data(existing.StatusCode, "application/json; charset=utf-8", existing.Body)
+	}
+	return nil, false
+}
+
+// finishIdempotent records the response to req so retries replay it.
+func (s *Server) finishIdempotent(req *idempotentRequest, status int, body []byte) {
+	if req == nil {
+		return
+	}
+	record := &store.IdempotencyRecord{RequestHash: req.hash, StatusCode: status, Body: body}
+	if err := s.Store.SaveIdempotencyRecord(req.key, record, req.ttl); err != nil {
+		log.Warn().Err(err).Msg("idempotency: failed to save response")
+	}
+}
+
+// abortIdempotent releases req's key after a failure so it can be retried.
+func (s *Server) abortIdempotent(req *idempotentRequest) {
+	if req == nil {
+		return
+	}
+	if err := s.Store.DeleteIdempotencyRecord(req.key); err != nil {
+		log.Warn().Err(err).Msg("idempotency: failed to release key")
+	}
+}
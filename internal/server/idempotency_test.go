@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+)
+
+const createBody = `{"action_type":"photo","output_format":"jpg"}`
+
+func TestIdempotencyReplay(t *testing.T) {
+	ts := newTestServer(t, store.NewMemoryStore())
+	key := map[string]string{"Idempotency-Key": "replay"}
+
+	first, firstBody := ts.request(http.MethodPost, "/api/v1/sessions", []byte(createBody), key)
+	if first.StatusCode != http.StatusCreated || first.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first request: got %d %s, replayed %q", first.StatusCode, firstBody, first.Header.Get("Idempotent-Replayed"))
+	}
+	retry, retryBody := ts.request(http.MethodPost, "/api/v1/sessions", []byte(createBody), key)
+	if retry.StatusCode != http.StatusCreated || string(retryBody) != string(firstBody) {
+		t.Errorf("retry: got %d %s, want the first response %s", retry.StatusCode, retryBody, firstBody)
+	}
+	if retry.Header.Get("Idempotent-Replayed") != "true" {
+		t.Error("retry: Idempotent-Replayed header not set")
+	}
+
+	list, err := ts.Store.ListSessions(store.SessionFilter{})
+	if err != nil || len(list.Sessions) != 1 {
+		t.Errorf("ListSessions: got (%v, %v), want one session", list, err)
+	}
+}
+
+func TestIdempotencyBodyMismatch(t *testing.T) {
+	ts := newTestServer(t, store.NewMemoryStore())
+	key := map[string]string{"Idempotency-Key": "mismatch"}
+
+	if resp, body := ts.request(http.MethodPost, "/api/v1/sessions", []byte(createBody), key); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("first request: got %d %s", resp.StatusCode, body)
+	}
+	resp, body := ts.request(http.MethodPost, "/api/v1/sessions", []byte(`{"action_type":"photo","output_format":"png"}`), key)
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("different body: got %d %s, want 422", resp.StatusCode, body)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	st := &blockingStore{Store: store.NewMemoryStore(), started: make(chan struct{}), release: make(chan struct{})}
+	ts := newTestServer(t, st)
+	key := map[string]string{"Idempotency-Key": "in-flight"}
+
+	done := make(chan int)
+	go func() {
+		resp, _ := ts.request(http.MethodPost, "/api/v1/sessions", []byte(createBody), key)
+		done <- resp.StatusCode
+	}()
+	select {
+	case <-st.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first request did not reach the store")
+	}
+
+	resp, body := ts.request(http.MethodPost, "/api/v1/sessions", []byte(createBody), key)
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("while in flight: got %d %s, Retry-After %q; want 409 with Retry-After", resp.StatusCode, body, resp.Header.Get("Retry-After"))
+	}
+
+	close(st.release)
+	if status := <-done; status != http.StatusCreated {
+		t.Fatalf("first request: got %d, want 201", status)
+	}
+	if resp, body := ts.request(http.MethodPost, "/api/v1/sessions", []byte(createBody), key); resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("after completion: got %d %s, want a replay", resp.StatusCode, body)
+	}
+}
+
+func TestIdempotencyInvalidRequest(t *testing.T) {
+	ts := newTestServer(t, store.NewMemoryStore())
+	key := map[string]string{"Idempotency-Key": "invalid"}
+
+	if resp, body := ts.request(http.MethodPost, "/api/v1/sessions", []byte(`{"action_type":"unknown"}`), key); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid request: got %d %s, want 400", resp.StatusCode, body)
+	}
+	// A rejected request does not lock the key for the corrected one.
+	if resp, body := ts.request(http.MethodPost, "/api/v1/sessions", []byte(createBody), key); resp.StatusCode != http.StatusCreated {
+		t.Errorf("corrected request: got %d %s, want 201", resp.StatusCode, body)
+	}
+}
+
+// blockingStore holds CreateSession until release is closed, so a request can
+// be kept in flight.
+type blockingStore struct {
+	store.Store
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) CreateSession(session *model.Session) error {
+	close(s.started)
+	<-s.release
+	return s.Store.CreateSession(session)
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getMetricsHandler reports the real-time subscriber counts in the
+// Prometheus text exposition format.
+// GET /api/v1/metrics
+//
+// Returns:
+//   - 200 with the metrics as text/plain
+func (s *Server) getMetricsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats := s.Hub.Stats()
+
+		var b strings.Builder
+		fmt.Fprintln(&b, "# HELP handoff_ws_subscribers Connected WebSocket and Server-Sent Events subscribers.")
+		fmt.Fprintln(&b, "# TYPE handoff_ws_subscribers gauge")
+		fmt.Fprintf(&b, "handoff_ws_subscribers{stream=\"session\"} %d\n", stats.SessionSubscribers)
+		fmt.Fprintf(&b, "handoff_ws_subscribers{stream=\"account\"} %d\n", stats.AccountSubscribers)
+		fmt.Fprintln(&b, "# HELP handoff_ws_sessions Sessions with at least one subscriber.")
+		fmt.Fprintln(&b, "# TYPE handoff_ws_sessions gauge")
+		fmt.Fprintf(&b, "handoff_ws_sessions %d\n", stats.Sessions)
+		fmt.Fprintln(&b, "# HELP handoff_ws_dropped_subscribers_total Subscribers dropped because they fell behind.")
+		fmt.Fprintln(&b, "# TYPE handoff_ws_dropped_subscribers_total counter")
+		fmt.Fprintf(&b, "handoff_ws_dropped_subscribers_total %d\n", stats.Dropped)
+
+		c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+	}
+}
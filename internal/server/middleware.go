@@ -1,14 +1,27 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mxcd/go-config/config"
 )
 
+// apiKeyIDContextKey holds the caller's API key fingerprint in the gin context.
+const apiKeyIDContextKey = "api_key_id"
+
+// apiKeyID returns a stable, non-reversible fingerprint of an API key that is
+// safe to store on sessions and return from the API.
+func apiKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
 // apiKeyAuth returns a Gin middleware that validates the X-API-Key header
-// against the configured API_KEYS list.
+// against the configured API_KEYS list. The key's fingerprint is stored in the
+// context under apiKeyIDContextKey.
 func apiKeyAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		key := c.GetHeader("X-API-Key")
@@ -18,15 +31,23 @@ func apiKeyAuth() gin.HandlerFunc {
 			return
 		}
 
-		validKeys := config.Get().StringArray("API_KEYS")
-		for _, valid := range validKeys {
-			if key == valid {
-				c.Next()
-				return
-			}
+		if !validAPIKey(key) {
+			jsonError(c, http.StatusUnauthorized, "invalid API key")
+			c.Abort()
+			return
 		}
 
-		jsonError(c, http.StatusUnauthorized, "invalid API key")
-		c.Abort()
+		c.Set(apiKeyIDContextKey, apiKeyID(key))
+		c.Next()
+	}
+}
+
+// validAPIKey reports whether key is one of the configured API_KEYS.
+func validAPIKey(key string) bool {
+	for _, valid := range config.Get().StringArray("API_KEYS") {
+		if key == valid {
+			return true
+		}
 	}
+	return false
 }
@@ -8,20 +8,31 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/mxcd/handoff/internal/model"
 	"github.com/mxcd/handoff/internal/util"
+	"github.com/mxcd/handoff/internal/ws"
 	"github.com/rs/zerolog/log"
 )
 
 // getResultHandler returns the result polling handler.
 // GET /api/v1/sessions/:id/result
 //
+// With ?since=<seq>, responses other than 410 also carry the logged events
+// after seq as "events", so polling clients see every transition.
+//
 // Returns:
-//   - 200 with result items when session is completed
+//   - 200 with result items when session is completed (grouped by step as "steps" for workflow sessions,
+//     and with the value read as "barcode_result" for barcode sessions)
+//   - 200 with {"status": "cancelled"} when session was cancelled
 //   - 202 with current status when session is pending/opened/action_started
+//   - 400 on a malformed since parameter
 //   - 404 when session does not exist
 //   - 410 when session has expired
 func (s *Server) getResultHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
+		since, ok := sinceQuery(c)
+		if !ok {
+			return
+		}
 
 		session, err := s.Store.GetSession(id)
 		if err != nil {
@@ -37,6 +48,15 @@ func (s *Server) getResultHandler() gin.HandlerFunc {
 			jsonError(c, http.StatusGone, "session expired")
 			return
 		}
+		if session.Status == model.SessionStatusCancelled {
+			resp := gin.H{
+				"status": string(model.SessionStatusCancelled),
+			}
+			if s.withEvents(c, resp, session, since) {
+				c.JSON(http.StatusOK, withSessionRef(resp, session))
+			}
+			return
+		}
 		if session.Status == model.SessionStatusCompleted {
 			resp := gin.H{
 				"status":       "completed",
@@ -46,15 +66,47 @@ func (s *Server) getResultHandler() gin.HandlerFunc {
 			if session.ActionType == model.ActionTypeScan && session.ScanResult != nil {
 				resp["scan_result"] = session.ScanResult
 			}
-			c.JSON(http.StatusOK, resp)
+			if session.ActionType == model.ActionTypeBarcode && session.BarcodeResult != nil {
+				resp["barcode_result"] = session.BarcodeResult
+			}
+			if session.IsWorkflow() {
+				resp["steps"] = session.Steps
+			}
+			if s.withEvents(c, resp, session, since) {
+				c.JSON(http.StatusOK, withSessionRef(resp, session))
+			}
 			return
 		}
 
 		// pending / opened / action_started
-		c.JSON(http.StatusAccepted, gin.H{
+		resp := gin.H{
 			"status": string(session.Status),
-		})
+		}
+		if s.withEvents(c, resp, session, since) {
+			c.JSON(http.StatusAccepted, withSessionRef(resp, session))
+		}
+	}
+}
+
+// withEvents adds the logged events of session after since to resp when
+// since is set. It writes a 500 response and returns false if they cannot be
+// listed.
+func (s *Server) withEvents(c *gin.Context, resp gin.H, session *model.Session, since *uint64) bool {
+	if since == nil {
+		return true
 	}
+	events, err := s.Store.ListEvents(session.ID, *since)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session.ID).Msg("result: failed to list events")
+		jsonError(c, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	messages := make([]ws.WSMessage, 0, len(events))
+	for _, event := range events {
+		messages = append(messages, loggedEventMessage(session, event))
+	}
+	resp["events"] = messages
+	return true
 }
 
 type submitResultItem struct {
@@ -70,38 +122,31 @@ type submitResultRequest struct {
 // submitResultHandler returns the result submission handler used by the phone UI.
 // POST /s/:id/result  (public — no API key required)
 //
+// For workflow sessions, the result completes the current step; the session
+// completes with the last one.
+//
 // Returns:
 //   - 200 with result items on success
-//   - 400 on invalid request body or bad base64 data
+//   - 400 on invalid request body or bad base64 data, or when a scan, video, audio recording, file upload or barcode is expected
 //   - 404 when session does not exist
 //   - 409 when session is already completed or has not yet been opened
-//   - 410 when session has expired
+//   - 410 when session has expired or was cancelled
 func (s *Server) submitResultHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
-
-		session, err := s.Store.GetSession(id)
-		if err != nil {
-			log.Error().Err(err).Str("session_id", id).Msg("submit: failed to get session")
-			jsonError(c, http.StatusInternalServerError, "internal error")
-			return
-		}
+		session := s.waitingSession(c, "submit")
 		if session == nil {
-			jsonError(c, http.StatusNotFound, "session not found")
-			return
-		}
-		if session.Status == model.SessionStatusExpired {
-			jsonError(c, http.StatusGone, "session expired")
-			return
-		}
-		if session.Status == model.SessionStatusCompleted {
-			jsonError(c, http.StatusConflict, "session already completed")
 			return
 		}
+		id := session.ID
 		if !session.Opened {
 			jsonError(c, http.StatusConflict, "session not yet opened")
 			return
 		}
+		action := session.CurrentAction()
+		if noun, ok := actionNouns[action.ActionType]; ok {
+			jsonError(c, http.StatusBadRequest, "session is waiting for "+noun)
+			return
+		}
 
 		var req submitResultRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -127,8 +172,8 @@ func (s *Server) submitResultHandler() gin.HandlerFunc {
 			fileContentType := item.ContentType
 			fileFilename := item.Filename
 
-			// Convert to PDF if the session's output format requires it
-			if session.OutputFormat == model.OutputFormatPDF {
+			// Convert to PDF if the output format of the current action requires it
+			if action.OutputFormat == model.OutputFormatPDF {
 				if strings.HasPrefix(item.ContentType, "image/svg") {
 					pdfBytes, pdfErr := util.SVGToPDF(decoded)
 					if pdfErr != nil {
@@ -170,16 +215,8 @@ func (s *Server) submitResultHandler() gin.HandlerFunc {
 			})
 		}
 
-		if err := s.Store.MarkSessionCompleted(id, resultItems); err != nil {
-			log.Error().Err(err).Str("session_id", id).Msg("submit: failed to mark session completed")
-			jsonError(c, http.StatusInternalServerError, "internal error")
-			return
+		if s.completeAction(c, session, model.ActionResult{Result: resultItems}, "submit") != nil {
+			c.JSON(http.StatusOK, gin.H{"items": resultItems})
 		}
-
-		// Notify all WebSocket subscribers that the session is complete.
-		s.Hub.BroadcastCompletion(id, string(model.SessionStatusCompleted), resultItems)
-
-		log.Info().Str("session_id", id).Int("items", len(resultItems)).Msg("submit: session completed")
-		c.JSON(http.StatusOK, gin.H{"items": resultItems})
 	}
 }
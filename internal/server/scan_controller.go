@@ -16,7 +16,8 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// scanUploadHandler accepts a single page upload for a scan session.
+// scanUploadHandler accepts a single page upload for a scan session, or a
+// workflow session at a scan step.
 // POST /s/:id/scan/upload (public — session UUID is the auth)
 //
 // Expects multipart/form-data with:
@@ -25,96 +26,29 @@ import (
 //   - page_index: integer (optional, defaults 0)
 func (s *Server) scanUploadHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
-
-		session, err := s.Store.GetSession(id)
-		if err != nil {
-			log.Error().Err(err).Str("session_id", id).Msg("scan_upload: failed to get session")
-			jsonError(c, http.StatusInternalServerError, "internal error")
-			return
-		}
-		if session == nil {
-			jsonError(c, http.StatusNotFound, "session not found")
-			return
-		}
-		if session.Status == model.SessionStatusExpired {
-			jsonError(c, http.StatusGone, "session expired")
-			return
-		}
-		if session.Status == model.SessionStatusCompleted {
-			jsonError(c, http.StatusConflict, "session already completed")
-			return
-		}
-		if session.ActionType != model.ActionTypeScan {
-			jsonError(c, http.StatusBadRequest, "session is not a scan session")
+		session, action, ok := s.actionSession(c, model.ActionTypeScan, "scan_upload")
+		if !ok {
 			return
 		}
+		id := session.ID
 
-		// Enforce body size limit before parsing multipart.
-		maxBytes := int64(config.Get().Int("SCAN_UPLOAD_MAX_BYTES"))
-		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
-
-		if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
-			if strings.Contains(err.Error(), "request body too large") ||
-				strings.Contains(err.Error(), "http: request body too large") {
-				jsonError(c, http.StatusRequestEntityTooLarge, "request body too large")
-				return
-			}
-			jsonError(c, http.StatusBadRequest, "failed to parse multipart form: "+err.Error())
+		if !parseUploadForm(c) {
 			return
 		}
-
-		file, fileHeader, err := c.Request.FormFile("file")
-		if err != nil {
-			jsonError(c, http.StatusBadRequest, "missing file field")
+		data, contentType, ok := s.readUploadedFile(c, id, "scan_upload")
+		if !ok {
 			return
 		}
-		defer file.Close()
 
 		// Parse document_index and page_index; default to 0 on missing/invalid.
-		documentIndex := 0
-		if v := c.Request.FormValue("document_index"); v != "" {
-			if n, parseErr := strconv.Atoi(v); parseErr == nil && n >= 0 {
-				documentIndex = n
-			}
-		}
-
-		pageIndex := 0
-		if v := c.Request.FormValue("page_index"); v != "" {
-			if n, parseErr := strconv.Atoi(v); parseErr == nil && n >= 0 {
-				pageIndex = n
-			}
-		}
+		documentIndex := formIndex(c, "document_index")
+		pageIndex := formIndex(c, "page_index")
 
 		// Single-document mode forces document_index to 0.
-		if session.ScanDocumentMode == model.ScanDocumentModeSingle {
+		if action.ScanDocumentMode == model.ScanDocumentModeSingle {
 			documentIndex = 0
 		}
 
-		// Check page count limit before reading data.
-		maxPages := config.Get().Int("SCAN_MAX_PAGES")
-		currentCount := s.Store.GetScanPageCount(id)
-		if currentCount >= maxPages {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":   "page limit exceeded",
-				"limit":   maxPages,
-				"current": currentCount,
-			})
-			return
-		}
-
-		data, err := io.ReadAll(file)
-		if err != nil {
-			log.Error().Err(err).Str("session_id", id).Msg("scan_upload: failed to read file data")
-			jsonError(c, http.StatusInternalServerError, "failed to read file data")
-			return
-		}
-
-		contentType := fileHeader.Header.Get("Content-Type")
-		if contentType == "" {
-			contentType = "application/octet-stream"
-		}
-
 		// Calculate remaining session TTL for the scan page cache entry.
 		remainingTTL := time.Until(session.CreatedAt.Add(session.SessionTTL))
 
@@ -144,37 +78,147 @@ func (s *Server) scanUploadHandler() gin.HandlerFunc {
 	}
 }
 
+// parseUploadForm parses a multipart upload from the phone UI, which scan
+// pages, video chunks and audio recordings share, enforcing
+// SCAN_UPLOAD_MAX_BYTES. It writes an error response and returns false if the
+// upload is rejected.
+func parseUploadForm(c *gin.Context) bool {
+	return parseUploadFormWithin(c, int64(config.Get().Int("SCAN_UPLOAD_MAX_BYTES")))
+}
+
+// parseUploadFormWithin is parseUploadForm with a request body limit of
+// maxBytes.
+func parseUploadFormWithin(c *gin.Context, maxBytes int64) bool {
+	// Enforce body size limit before parsing multipart.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		if strings.Contains(err.Error(), "request body too large") ||
+			strings.Contains(err.Error(), "http: request body too large") {
+			jsonError(c, http.StatusRequestEntityTooLarge, "request body too large")
+			return false
+		}
+		jsonError(c, http.StatusBadRequest, "failed to parse multipart form: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// readUploadedFile returns the data and content type of the "file" field of
+// an upload parsed with parseUploadForm, enforcing the SCAN_MAX_PAGES upload
+// count of the session. It writes an error response and returns false if the
+// upload is rejected. logPrefix names the calling handler in logs.
+func (s *Server) readUploadedFile(c *gin.Context, sessionID, logPrefix string) ([]byte, string, bool) {
+	if len(c.Request.MultipartForm.File["file"]) == 0 {
+		jsonError(c, http.StatusBadRequest, "missing file field")
+		return nil, "", false
+	}
+
+	// Check page count limit before reading data.
+	maxPages := config.Get().Int("SCAN_MAX_PAGES")
+	currentCount, err := s.Store.GetScanPageCount(sessionID)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", sessionID).Msg(logPrefix + ": failed to count scan pages")
+		jsonError(c, http.StatusInternalServerError, "internal error")
+		return nil, "", false
+	}
+	if currentCount >= maxPages {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "page limit exceeded",
+			"limit":   maxPages,
+			"current": currentCount,
+		})
+		return nil, "", false
+	}
+
+	return readFormFile(c, sessionID, "file", logPrefix)
+}
+
+// readFormFile returns the data and content type of the file field name of an
+// upload parsed with parseUploadForm. It writes an error response and returns
+// false if the field is missing or cannot be read.
+func readFormFile(c *gin.Context, sessionID, name, logPrefix string) ([]byte, string, bool) {
+	file, fileHeader, err := c.Request.FormFile(name)
+	if err != nil {
+		jsonError(c, http.StatusBadRequest, "missing "+name+" field")
+		return nil, "", false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", sessionID).Msg(logPrefix + ": failed to read file data")
+		jsonError(c, http.StatusInternalServerError, "failed to read file data")
+		return nil, "", false
+	}
+
+	contentType := fileHeader.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return data, contentType, true
+}
+
+// latestUploads returns the uploads of pages numbered by PageIndex in order,
+// keeping the last upload of each index, e.g. after a retried request. It
+// also returns the first index missing from 0 to n-1, or -1 if there is none.
+func latestUploads(pages []store.ScanPageData) ([]store.ScanPageData, int) {
+	byIndex := make(map[int]store.ScanPageData, len(pages))
+	for _, p := range pages {
+		byIndex[p.PageIndex] = p
+	}
+	uploads := make([]store.ScanPageData, len(byIndex))
+	for i := range uploads {
+		p, ok := byIndex[i]
+		if !ok {
+			return nil, i
+		}
+		uploads[i] = p
+	}
+	return uploads, -1
+}
+
+// formIndex returns the non-negative integer form value name of a parsed
+// multipart upload, defaulting to 0 on missing or invalid values.
+func formIndex(c *gin.Context, name string) int {
+	if v := c.Request.FormValue(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// strictFormIndex is formIndex for indexes where 0 restarts an upload: a
+// missing value is 0, but an invalid one writes a 400 response and returns
+// false instead of silently restarting.
+func strictFormIndex(c *gin.Context, name string) (int, bool) {
+	v := c.Request.FormValue(name)
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		jsonError(c, http.StatusBadRequest, "invalid "+name+": must be a non-negative integer")
+		return 0, false
+	}
+	return n, true
+}
+
 // scanFinalizeHandler assembles all uploaded pages into the final scan result.
 // POST /s/:id/scan/finalize (public — session UUID is the auth)
 //
 // For pdf output_format: each document group is assembled into a multi-page PDF.
 // For images output_format: each page is stored individually with its original content type.
+// For workflow sessions, the scan completes the current step; the session
+// completes with the last one.
 func (s *Server) scanFinalizeHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
-
-		session, err := s.Store.GetSession(id)
-		if err != nil {
-			log.Error().Err(err).Str("session_id", id).Msg("scan_finalize: failed to get session")
-			jsonError(c, http.StatusInternalServerError, "internal error")
-			return
-		}
-		if session == nil {
-			jsonError(c, http.StatusNotFound, "session not found")
-			return
-		}
-		if session.Status == model.SessionStatusExpired {
-			jsonError(c, http.StatusGone, "session expired")
-			return
-		}
-		if session.Status == model.SessionStatusCompleted {
-			jsonError(c, http.StatusConflict, "session already completed")
-			return
-		}
-		if session.ActionType != model.ActionTypeScan {
-			jsonError(c, http.StatusBadRequest, "session is not a scan session")
+		session, action, ok := s.actionSession(c, model.ActionTypeScan, "scan_finalize")
+		if !ok {
 			return
 		}
+		id := session.ID
 
 		pages, err := s.Store.GetScanPages(id)
 		if err != nil {
@@ -211,7 +255,7 @@ func (s *Server) scanFinalizeHandler() gin.HandlerFunc {
 				return docPages[i].PageIndex < docPages[j].PageIndex
 			})
 
-			if session.ScanOutputFormat == model.ScanOutputFormatPDF {
+			if action.ScanOutputFormat == model.ScanOutputFormatPDF {
 				// Assemble all pages of this document into a single PDF.
 				pageData := make([][]byte, len(docPages))
 				pageContentTypes := make([]string, len(docPages))
@@ -238,7 +282,7 @@ func (s *Server) scanFinalizeHandler() gin.HandlerFunc {
 				}
 
 				scanResult.Documents = append(scanResult.Documents, model.ScanDocument{
-					PDFURL: "/api/v1/downloads/" + dlID,
+					PDFURL: downloadPathPrefix + dlID,
 				})
 			} else {
 				// Store each page image individually.
@@ -251,7 +295,7 @@ func (s *Server) scanFinalizeHandler() gin.HandlerFunc {
 						return
 					}
 					docPageResults = append(docPageResults, model.ScanPage{
-						URL:         "/api/v1/downloads/" + dlID,
+						URL:         downloadPathPrefix + dlID,
 						ContentType: p.ContentType,
 					})
 				}
@@ -261,23 +305,14 @@ func (s *Server) scanFinalizeHandler() gin.HandlerFunc {
 			}
 		}
 
-		if err := s.Store.MarkScanSessionCompleted(id, &scanResult); err != nil {
-			log.Error().Err(err).Str("session_id", id).Msg("scan_finalize: failed to mark session completed")
-			jsonError(c, http.StatusInternalServerError, "internal error")
+		updated := s.completeAction(c, session, model.ActionResult{ScanResult: &scanResult}, "scan_finalize")
+		if updated == nil {
 			return
 		}
-
-		// Notify WebSocket subscribers that the session is complete.
-		s.Hub.BroadcastCompletion(id, string(model.SessionStatusCompleted), scanResult)
-
 		// Clear raw page data from the store — no longer needed after finalization.
-		s.Store.ClearScanPages(id)
-
-		log.Info().
-			Str("session_id", id).
-			Int("documents", len(scanResult.Documents)).
-			Msg("scan_finalize: session completed")
-
-		c.JSON(http.StatusOK, gin.H{"status": "completed"})
+		if err := s.Store.ClearScanPages(id); err != nil {
+			log.Warn().Err(err).Str("session_id", id).Msg("scan_finalize: failed to clear scan pages")
+		}
+		c.JSON(http.StatusOK, gin.H{"status": string(updated.Status)})
 	}
 }
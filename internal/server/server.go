@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mxcd/handoff/internal/delivery"
 	"github.com/mxcd/handoff/internal/store"
 	"github.com/mxcd/handoff/internal/web"
+	"github.com/mxcd/handoff/internal/webhook"
 	"github.com/mxcd/handoff/internal/ws"
 	"github.com/rs/zerolog/log"
 )
@@ -17,7 +21,18 @@ const apiBasePath = "/api/v1"
 type ServerOptions struct {
 	DevMode bool
 	Port    int
-	Store   *store.Store
+	Store   store.Store
+	// Webhooks delivers session events to webhook URLs; nil disables webhooks.
+	Webhooks *webhook.Dispatcher
+	// Deliveries pushes result files to the delivery targets of sessions; nil
+	// rejects sessions that ask for one.
+	Deliveries *delivery.Pusher
+	// Bus carries real-time messages between replicas; nil uses an in-process
+	// bus, which only reaches the clients connected to this server.
+	Bus ws.Bus
+	// WSPingInterval is how often WebSocket clients are pinged. A client that
+	// sends no pong for two intervals is disconnected. Zero uses 30 seconds.
+	WSPingInterval time.Duration
 }
 
 type Server struct {
@@ -25,8 +40,14 @@ type Server struct {
 	Engine       *gin.Engine
 	HttpServer   *http.Server
 	ProtectedAPI *gin.RouterGroup
-	Store        *store.Store
+	Store        store.Store
 	Hub          *ws.Hub
+	Webhooks     *webhook.Dispatcher
+	Deliveries   *delivery.Pusher
+
+	// eventLocks serialise logging and broadcasting the events of a session,
+	// striped by session ID, so subscribers see them in sequence order.
+	eventLocks [32]sync.Mutex
 }
 
 func NewServer(options *ServerOptions) (*Server, error) {
@@ -37,10 +58,21 @@ func NewServer(options *ServerOptions) (*Server, error) {
 		return nil, fmt.Errorf("server options Store cannot be nil")
 	}
 
+	hub, err := ws.NewHub(options.Bus)
+	if err != nil {
+		return nil, err
+	}
+
 	server := &Server{
-		Options: options,
-		Store:   options.Store,
-		Hub:     ws.NewHub(),
+		Options:    options,
+		Store:      options.Store,
+		Hub:        hub,
+		Webhooks:   options.Webhooks,
+		Deliveries: options.Deliveries,
+	}
+
+	if notifier, ok := store.AsExpiryNotifier(options.Store); ok {
+		notifier.OnSessionExpired(server.sessionExpired)
 	}
 
 	if !server.Options.DevMode {
@@ -74,15 +106,30 @@ func (s *Server) RegisterRoutes() error {
 
 	// Session management routes (protected)
 	s.ProtectedAPI.POST("/sessions", s.createSessionHandler())
+	s.ProtectedAPI.GET("/sessions", s.listSessionsHandler())
 	s.ProtectedAPI.GET("/sessions/:id", s.getSessionHandler())
+	s.ProtectedAPI.PATCH("/sessions/:id", s.updateSessionHandler())
+	s.ProtectedAPI.DELETE("/sessions/:id", s.cancelSessionHandler())
 
 	// Result polling and download routes (protected — caller uses API key)
 	s.ProtectedAPI.GET("/sessions/:id/result", s.getResultHandler())
 	s.ProtectedAPI.GET("/downloads/:download_id", s.downloadHandler())
 
+	// Webhook deliveries that could not be made (protected)
+	s.ProtectedAPI.GET("/webhooks/dead-letters", s.listDeadWebhooksHandler())
+
+	// Replica metrics (protected)
+	s.ProtectedAPI.GET("/metrics", s.getMetricsHandler())
+
 	// WebSocket endpoint for real-time session updates (auth handled in handler)
 	s.Engine.GET(apiBasePath+"/sessions/:id/ws", s.wsHandler())
 
+	// WebSocket endpoint for the events of all of an API key's sessions (auth handled in handler)
+	s.Engine.GET(apiBasePath+"/events/ws", s.accountWSHandler())
+
+	// Server-Sent Events alternative to the WebSocket endpoint (auth handled in handler)
+	s.Engine.GET(apiBasePath+"/sessions/:id/events", s.sseHandler())
+
 	// Phone-facing session pages (public — session UUID is the auth)
 	s.Engine.GET("/s/:id", s.sessionPageHandler())
 	s.Engine.GET("/s/:id/action", s.sessionActionHandler())
@@ -94,6 +141,21 @@ func (s *Server) RegisterRoutes() error {
 	s.Engine.POST("/s/:id/scan/upload", s.scanUploadHandler())
 	s.Engine.POST("/s/:id/scan/finalize", s.scanFinalizeHandler())
 
+	// Video session routes (public — session UUID is the auth)
+	s.Engine.POST("/s/:id/video/upload", s.videoUploadHandler())
+	s.Engine.POST("/s/:id/video/finalize", s.videoFinalizeHandler())
+
+	// Audio session routes (public — session UUID is the auth)
+	s.Engine.POST("/s/:id/audio/upload", s.audioUploadHandler())
+
+	// Upload session routes (public — session UUID is the auth)
+	s.Engine.POST("/s/:id/upload/file", s.uploadFileHandler())
+	s.Engine.POST("/s/:id/upload/finalize", s.uploadFinalizeHandler())
+
+	// Barcode session routes (public — session UUID is the auth)
+	s.Engine.POST("/s/:id/barcode/submit", s.barcodeSubmitHandler())
+	s.Engine.POST("/s/:id/barcode/decode", s.barcodeDecodeHandler())
+
 	// Static files are public (no middleware)
 	web.RegisterStaticFiles(s.Engine)
 	return nil
@@ -107,5 +169,7 @@ func (s *Server) Run() error {
 }
 
 func (s *Server) Shutdown(ctx context.Context) {
+	// Event streams run until their subscription ends, so end them all.
+	s.HttpServer.RegisterOnShutdown(s.Hub.CloseAll)
 	s.HttpServer.Shutdown(ctx)
 }
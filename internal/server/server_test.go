@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/mxcd/handoff/internal/server"
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/mxcd/handoff/internal/util"
+	"github.com/rs/zerolog"
+)
+
+// testAPIKey is the API key the test client authenticates with.
+const testAPIKey = "test-key"
+
+func TestMain(m *testing.M) {
+	os.Setenv("API_KEYS", testAPIKey)
+	os.Setenv("BASE_URL", "http://localhost")
+	if err := util.InitConfig(); err != nil {
+		panic(err)
+	}
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// testServer is a handoff server listening on a local test address.
+type testServer struct {
+	t *testing.T
+	*server.Server
+	URL string
+}
+
+func newTestServer(t *testing.T, st store.Store) *testServer {
+	t.Helper()
+	s, err := server.NewServer(&server.ServerOptions{Store: st})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	if err := s.RegisterRoutes(); err != nil {
+		t.Fatalf("RegisterRoutes: %v", err)
+	}
+	ts := httptest.NewServer(s.Engine)
+	t.Cleanup(ts.Close)
+	return &testServer{t: t, Server: s, URL: ts.URL}
+}
+
+// request sends an authenticated request and returns the response with its
+// body read. body is sent as is if it is a []byte and as JSON otherwise.
+func (ts *testServer) request(method, path string, body any, header map[string]string) (*http.Response, []byte) {
+	ts.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			ts.t.Fatalf("marshal request body: %v", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		ts.t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("X-API-Key", testAPIKey)
+	if r != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatalf("%s %s: read body: %v", method, path, err)
+	}
+	return resp, data
+}
+
+// createSession creates a session from req and returns its decoded response.
+func (ts *testServer) createSession(req any) map[string]any {
+	ts.t.Helper()
+	resp, body := ts.request(http.MethodPost, "/api/v1/sessions", req, nil)
+	if resp.StatusCode != http.StatusCreated {
+		ts.t.Fatalf("create session: got %d %s", resp.StatusCode, body)
+	}
+	var session map[string]any
+	if err := json.Unmarshal(body, &session); err != nil {
+		ts.t.Fatalf("create session: decode %s: %v", body, err)
+	}
+	return session
+}
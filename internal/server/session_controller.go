@@ -1,41 +1,179 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mxcd/go-config/config"
 	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
 	"github.com/rs/zerolog/log"
 )
 
 // createSessionRequest is the JSON body for POST /api/v1/sessions.
 type createSessionRequest struct {
-	ActionType   string `json:"action_type" binding:"required"`
+	ActionType   string `json:"action_type"` // required unless steps are given
 	IntroText    string `json:"intro_text"`
-	OutputFormat string `json:"output_format"` // required for photo/signature; optional for scan (defaults to "pdf")
+	OutputFormat string `json:"output_format"` // required for photo/signature/video/audio; optional for scan (defaults to "pdf")
 	DocumentMode string `json:"document_mode"` // scan only: "single" (default) or "multi"
 	SessionTTL   string `json:"session_ttl"`   // optional, e.g. "30m", "1h"
 	ResultTTL    string `json:"result_ttl"`    // optional, e.g. "5m", "10m"
+
+	VideoMaxDuration string `json:"video_max_duration"` // video only, e.g. "30s"; defaults to VIDEO_MAX_DURATION, which caps it
+	VideoResolution  string `json:"video_resolution"`   // video only: "480p", "720p" or "1080p"; defaults to VIDEO_RESOLUTION
+	AudioWAV         bool   `json:"audio_wav"`          // audio only: also return a WAV copy of the recording
+
+	UploadAllowedTypes []string `json:"upload_allowed_types"`  // upload only, e.g. ["application/pdf", "image/*"]; defaults to UPLOAD_ALLOWED_TYPES
+	UploadMaxFiles     int      `json:"upload_max_files"`      // upload only; defaults to UPLOAD_MAX_FILES, which caps it
+	UploadMaxFileBytes int64    `json:"upload_max_file_bytes"` // upload only; defaults to UPLOAD_MAX_FILE_BYTES, which caps it
+
+	BarcodeSymbologies []string `json:"barcode_symbologies"` // barcode only, e.g. ["code_128", "qr_code"]; defaults to all supported
+	BarcodePattern     string   `json:"barcode_pattern"`     // barcode only: regular expression the whole value must match
+
+	ExternalRef string            `json:"external_ref"` // optional caller reference, echoed back everywhere
+	Metadata    map[string]string `json:"metadata"`     // optional caller key/value pairs, echoed back everywhere
+	WebhookURL  string            `json:"webhook_url"`  // optional, overrides the API key's default webhook URL
+
+	Delivery *deliveryRequest `json:"delivery"` // optional, pushes the result files here on completion
+
+	Steps []createStepRequest `json:"steps"` // optional, makes a workflow session of several actions
+}
+
+// createStepRequest is one step of a workflow session in a createSessionRequest.
+// The fields mean the same as those of a single-action session.
+type createStepRequest struct {
+	ActionType       string `json:"action_type"`
+	IntroText        string `json:"intro_text"`
+	OutputFormat     string `json:"output_format"`
+	DocumentMode     string `json:"document_mode"`
+	VideoMaxDuration string `json:"video_max_duration"`
+	VideoResolution  string `json:"video_resolution"`
+	AudioWAV         bool   `json:"audio_wav"`
+
+	UploadAllowedTypes []string `json:"upload_allowed_types"`
+	UploadMaxFiles     int      `json:"upload_max_files"`
+	UploadMaxFileBytes int64    `json:"upload_max_file_bytes"`
+
+	BarcodeSymbologies []string `json:"barcode_symbologies"`
+	BarcodePattern     string   `json:"barcode_pattern"`
+}
+
+// deliveryRequest is the delivery target of a createSessionRequest. Unlike
+// model.DeliveryTarget, it accepts headers.
+type deliveryRequest struct {
+	Method  string            `json:"method"` // "put" or "post"
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"` // sent with every push, never returned
+	Field   string            `json:"field"`   // post only, defaults to "file"
+	Purge   bool              `json:"purge"`   // delete the files from Handoff once all were delivered
 }
 
 // createSessionHandler returns a gin.HandlerFunc that creates a new session.
 // POST /api/v1/sessions
+//
+// Requests carrying an Idempotency-Key header are deduplicated per API key;
+// see beginIdempotent.
 func (s *Server) createSessionHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req createSessionRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if err := c.ShouldBindBodyWithJSON(&req); err != nil {
 			jsonError(c, http.StatusBadRequest, "invalid request body: "+err.Error())
 			return
 		}
 
-		actionType, err := model.ValidateActionType(req.ActionType)
-		if err != nil {
+		var video videoSettings
+		if wantsVideo(&req) {
+			var err error
+			if video, err = videoDefaults(); err != nil {
+				log.Error().Err(err).Msg("session_controller: failed to parse video config")
+				jsonError(c, http.StatusInternalServerError, "internal configuration error")
+				return
+			}
+		}
+		var upload uploadSettings
+		if wantsUpload(&req) {
+			var err error
+			if upload, err = uploadDefaults(); err != nil {
+				log.Error().Err(err).Msg("session_controller: failed to parse upload config")
+				jsonError(c, http.StatusInternalServerError, "internal configuration error")
+				return
+			}
+		}
+
+		var actionType model.ActionType
+		var steps []model.Step
+		var err error
+		if len(req.Steps) > 0 {
+			steps, err = newSteps(&req, video, upload)
+			if err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			actionType = model.ActionTypeWorkflow
+		} else {
+			if req.ActionType == "" {
+				jsonError(c, http.StatusBadRequest, "action_type is required")
+				return
+			}
+			if req.ActionType == string(model.ActionTypeWorkflow) {
+				jsonError(c, http.StatusBadRequest, "steps are required for workflow sessions")
+				return
+			}
+			actionType, err = model.ValidateActionType(req.ActionType)
+			if err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		if err := model.ValidateExternalRef(req.ExternalRef); err != nil {
+			jsonError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := model.ValidateMetadata(req.Metadata); err != nil {
 			jsonError(c, http.StatusBadRequest, err.Error())
 			return
 		}
 
+		webhookURL := req.WebhookURL
+		if webhookURL != "" {
+			if s.Webhooks == nil {
+				jsonError(c, http.StatusBadRequest, "webhook_url requires webhooks to be enabled on the server")
+				return
+			}
+			if err := model.ValidateWebhookURL(webhookURL); err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		} else if s.Webhooks != nil {
+			webhookURL = s.Webhooks.DefaultURL(c.GetString(apiKeyIDContextKey))
+		}
+
+		var delivery *model.DeliveryTarget
+		if req.Delivery != nil {
+			if s.Deliveries == nil {
+				jsonError(c, http.StatusBadRequest, "delivery requires deliveries to be enabled on the server")
+				return
+			}
+			delivery = &model.DeliveryTarget{
+				Method:  model.DeliveryMethod(req.Delivery.Method),
+				URL:     req.Delivery.URL,
+				Headers: req.Delivery.Headers,
+				Field:   req.Delivery.Field,
+				Purge:   req.Delivery.Purge,
+			}
+			if err := model.ValidateDeliveryTarget(delivery); err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
 		// Parse SessionTTL — use request value if provided, else fall back to config default.
 		var sessionTTL time.Duration
 		if req.SessionTTL != "" {
@@ -75,7 +213,24 @@ func (s *Server) createSessionHandler() gin.HandlerFunc {
 
 		var session model.Session
 
-		if actionType == model.ActionTypeScan {
+		if actionType == model.ActionTypeWorkflow {
+			// Workflow sessions carry the action settings on their steps.
+			session = model.Session{
+				ID:          sessionID,
+				ActionType:  actionType,
+				Status:      model.SessionStatusPending,
+				Steps:       steps,
+				SessionTTL:  sessionTTL,
+				ResultTTL:   resultTTL,
+				URL:         sessionURL,
+				CreatedAt:   time.Now(),
+				APIKeyID:    c.GetString(apiKeyIDContextKey),
+				ExternalRef: req.ExternalRef,
+				Metadata:    req.Metadata,
+				WebhookURL:  webhookURL,
+				Delivery:    delivery,
+			}
+		} else if actionType == model.ActionTypeScan {
 			// Scan sessions use their own document_mode and scan output format fields.
 
 			docModeStr := req.DocumentMode
@@ -109,6 +264,73 @@ func (s *Server) createSessionHandler() gin.HandlerFunc {
 				ResultTTL:        resultTTL,
 				URL:              sessionURL,
 				CreatedAt:        time.Now(),
+				APIKeyID:         c.GetString(apiKeyIDContextKey),
+				ExternalRef:      req.ExternalRef,
+				Metadata:         req.Metadata,
+				WebhookURL:       webhookURL,
+				Delivery:         delivery,
+			}
+		} else if actionType == model.ActionTypeUpload {
+			// Upload sessions keep the type of each file instead of an output_format.
+			if _, err := model.ValidateOutputFormat(actionType, req.OutputFormat); err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			if upload, err = upload.with(req.UploadAllowedTypes, req.UploadMaxFiles, req.UploadMaxFileBytes); err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			session = model.Session{
+				ID:                 sessionID,
+				ActionType:         actionType,
+				Status:             model.SessionStatusPending,
+				IntroText:          req.IntroText,
+				UploadAllowedTypes: upload.allowedTypes,
+				UploadMaxFiles:     upload.maxFiles,
+				UploadMaxFileBytes: upload.maxFileBytes,
+				SessionTTL:         sessionTTL,
+				ResultTTL:          resultTTL,
+				URL:                sessionURL,
+				CreatedAt:          time.Now(),
+				APIKeyID:           c.GetString(apiKeyIDContextKey),
+				ExternalRef:        req.ExternalRef,
+				Metadata:           req.Metadata,
+				WebhookURL:         webhookURL,
+				Delivery:           delivery,
+			}
+		} else if actionType == model.ActionTypeBarcode {
+			// Barcode sessions return the value read instead of a file.
+			if _, err := model.ValidateOutputFormat(actionType, req.OutputFormat); err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			if delivery != nil {
+				jsonError(c, http.StatusBadRequest, "delivery is not used by barcode sessions: they have no result files")
+				return
+			}
+			symbologies, err := barcodeSettings(req.BarcodeSymbologies, req.BarcodePattern)
+			if err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			session = model.Session{
+				ID:                 sessionID,
+				ActionType:         actionType,
+				Status:             model.SessionStatusPending,
+				IntroText:          req.IntroText,
+				BarcodeSymbologies: symbologies,
+				BarcodePattern:     req.BarcodePattern,
+				SessionTTL:         sessionTTL,
+				ResultTTL:          resultTTL,
+				URL:                sessionURL,
+				CreatedAt:          time.Now(),
+				APIKeyID:           c.GetString(apiKeyIDContextKey),
+				ExternalRef:        req.ExternalRef,
+				Metadata:           req.Metadata,
+				WebhookURL:         webhookURL,
+				Delivery:           delivery,
 			}
 		} else {
 			// Photo and signature sessions require a valid output_format.
@@ -132,18 +354,258 @@ func (s *Server) createSessionHandler() gin.HandlerFunc {
 				ResultTTL:    resultTTL,
 				URL:          sessionURL,
 				CreatedAt:    time.Now(),
+				APIKeyID:     c.GetString(apiKeyIDContextKey),
+				ExternalRef:  req.ExternalRef,
+				Metadata:     req.Metadata,
+				WebhookURL:   webhookURL,
+				Delivery:     delivery,
+			}
+
+			// Video sessions also take a max duration and resolution.
+			if actionType == model.ActionTypeVideo {
+				if video, err = video.with(req.VideoMaxDuration, req.VideoResolution); err != nil {
+					jsonError(c, http.StatusBadRequest, err.Error())
+					return
+				}
+				session.VideoMaxDuration = video.maxDuration
+				session.VideoResolution = video.resolution
 			}
+			if actionType == model.ActionTypeAudio {
+				session.AudioWAV = req.AudioWAV
+			}
+		}
+
+		session.History = []model.StatusChange{{Status: session.Status, At: session.CreatedAt, Actor: model.ActorAPI}}
+
+		// A retried request with the same Idempotency-Key gets the original
+		// session instead of a second one.
+		idem, ok := s.beginIdempotent(c)
+		if !ok {
+			return
 		}
 
 		if err := s.Store.CreateSession(&session); err != nil {
 			log.Error().Err(err).Str("session_id", sessionID).Msg("session_controller: failed to create session")
+			s.abortIdempotent(idem)
+			jsonError(c, http.StatusInternalServerError, "failed to create session")
+			return
+		}
+
+		body, err := json.Marshal(session)
+		if err != nil {
+			log.Error().Err(err).Str("session_id", sessionID).Msg("session_controller: failed to encode session")
+			s.abortIdempotent(idem)
 			jsonError(c, http.StatusInternalServerError, "failed to create session")
 			return
 		}
+		s.finishIdempotent(idem, http.StatusCreated, body)
 
 		log.Info().Str("session_id", sessionID).Str("action_type", string(actionType)).Msg("session_controller: session created")
-		c.JSON(http.StatusCreated, session)
+		c.Data(http.StatusCreated, "application/json; charset=utf-8", body)
+	}
+}
+
+// newSteps validates the steps of a workflow session request. The action
+// settings of a workflow session go on its steps, not on the session. video
+// and upload hold the defaults of video and upload steps.
+func newSteps(req *createSessionRequest, video videoSettings, upload uploadSettings) ([]model.Step, error) {
+	if req.ActionType != "" && req.ActionType != string(model.ActionTypeWorkflow) {
+		return nil, fmt.Errorf("action_type must be 'workflow' or omitted when steps are given")
+	}
+	if req.IntroText != "" || req.OutputFormat != "" || req.DocumentMode != "" ||
+		req.VideoMaxDuration != "" || req.VideoResolution != "" || req.AudioWAV ||
+		len(req.UploadAllowedTypes) > 0 || req.UploadMaxFiles != 0 || req.UploadMaxFileBytes != 0 ||
+		len(req.BarcodeSymbologies) > 0 || req.BarcodePattern != "" {
+		return nil, fmt.Errorf("intro_text, output_format, document_mode and the video, audio, upload and barcode settings go on the steps of a workflow session")
+	}
+	if len(req.Steps) > model.MaxSteps {
+		return nil, fmt.Errorf("a session can have at most %d steps", model.MaxSteps)
+	}
+
+	steps := make([]model.Step, 0, len(req.Steps))
+	for i, r := range req.Steps {
+		step := model.Step{
+			ActionType:       model.ActionType(r.ActionType),
+			IntroText:        r.IntroText,
+			OutputFormat:     model.OutputFormat(r.OutputFormat),
+			ScanDocumentMode: model.ScanDocumentMode(r.DocumentMode),
+		}
+		if err := model.ValidateStep(&step); err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		if step.ActionType == model.ActionTypeVideo {
+			settings, err := video.with(r.VideoMaxDuration, r.VideoResolution)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			step.VideoMaxDuration = settings.maxDuration
+			step.VideoResolution = settings.resolution
+		} else if r.VideoMaxDuration != "" || r.VideoResolution != "" {
+			return nil, fmt.Errorf("step %d: video_max_duration and video_resolution are only used by video steps", i+1)
+		}
+		if step.ActionType == model.ActionTypeAudio {
+			step.AudioWAV = r.AudioWAV
+		} else if r.AudioWAV {
+			return nil, fmt.Errorf("step %d: audio_wav is only used by audio steps", i+1)
+		}
+		if step.ActionType == model.ActionTypeUpload {
+			settings, err := upload.with(r.UploadAllowedTypes, r.UploadMaxFiles, r.UploadMaxFileBytes)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			step.UploadAllowedTypes = settings.allowedTypes
+			step.UploadMaxFiles = settings.maxFiles
+			step.UploadMaxFileBytes = settings.maxFileBytes
+		} else if len(r.UploadAllowedTypes) > 0 || r.UploadMaxFiles != 0 || r.UploadMaxFileBytes != 0 {
+			return nil, fmt.Errorf("step %d: upload_allowed_types, upload_max_files and upload_max_file_bytes are only used by upload steps", i+1)
+		}
+		if step.ActionType == model.ActionTypeBarcode {
+			symbologies, err := barcodeSettings(r.BarcodeSymbologies, r.BarcodePattern)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			step.BarcodeSymbologies = symbologies
+			step.BarcodePattern = r.BarcodePattern
+		} else if len(r.BarcodeSymbologies) > 0 || r.BarcodePattern != "" {
+			return nil, fmt.Errorf("step %d: barcode_symbologies and barcode_pattern are only used by barcode steps", i+1)
+		}
+		steps = append(steps, step)
 	}
+	return steps, nil
+}
+
+// listSessionsHandler returns a gin.HandlerFunc that lists live sessions.
+// GET /api/v1/sessions
+//
+// Query parameters (all optional):
+//   - status, action_type: comma-separated values to match
+//   - created_after, created_before: RFC 3339 timestamps (exclusive)
+//   - mine: "true" to only list sessions created with the caller's API key
+//   - external_ref: exact external reference to match
+//   - metadata.<key>: metadata value to match; repeat for several keys
+//   - order: "desc" (default, newest first) or "asc"
+//   - limit: page size, default 50, max 200
+//   - cursor: next_cursor from a previous page
+//
+// Returns:
+//   - 200 with {"sessions": [...], "next_cursor": "..."}; next_cursor is omitted on the last page
+//   - 400 on invalid parameters or cursor
+func (s *Server) listSessionsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := store.SessionFilter{Cursor: c.Query("cursor")}
+
+		for _, v := range splitQuery(c.Query("status")) {
+			status, err := model.ValidateSessionStatus(v)
+			if err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			filter.Status = append(filter.Status, status)
+		}
+		for _, v := range splitQuery(c.Query("action_type")) {
+			actionType, err := model.ValidateSessionActionType(v)
+			if err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			filter.ActionType = append(filter.ActionType, actionType)
+		}
+
+		var ok bool
+		if filter.CreatedAfter, ok = timeQuery(c, "created_after"); !ok {
+			return
+		}
+		if filter.CreatedBefore, ok = timeQuery(c, "created_before"); !ok {
+			return
+		}
+
+		filter.ExternalRef = c.Query("external_ref")
+		filter.Metadata = metadataQuery(c)
+
+		if c.Query("mine") == "true" {
+			filter.APIKeyID = c.GetString(apiKeyIDContextKey)
+		}
+
+		switch order := store.SortOrder(c.DefaultQuery("order", string(store.SortOrderDesc))); order {
+		case store.SortOrderAsc, store.SortOrderDesc:
+			filter.Order = order
+		default:
+			jsonError(c, http.StatusBadRequest, "invalid order: must be 'asc' or 'desc'")
+			return
+		}
+
+		if v := c.Query("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit < 1 || limit > store.MaxListLimit {
+				jsonError(c, http.StatusBadRequest, fmt.Sprintf("invalid limit: must be between 1 and %d", store.MaxListLimit))
+				return
+			}
+			filter.Limit = limit
+		}
+
+		list, err := s.Store.ListSessions(filter)
+		if errors.Is(err, store.ErrInvalidCursor) {
+			jsonError(c, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("session_controller: failed to list sessions")
+			jsonError(c, http.StatusInternalServerError, "failed to list sessions")
+			return
+		}
+
+		resp := gin.H{"sessions": list.Sessions}
+		if list.Sessions == nil {
+			resp["sessions"] = []*model.Session{}
+		}
+		if list.NextCursor != "" {
+			resp["next_cursor"] = list.NextCursor
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// splitQuery splits a comma-separated query value, dropping empty entries.
+func splitQuery(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// metadataQuery collects the metadata.<key> query parameters into a map, or
+// returns nil if there are none.
+func metadataQuery(c *gin.Context) map[string]string {
+	var metadata map[string]string
+	for param, values := range c.Request.URL.Query() {
+		key, ok := strings.CutPrefix(param, "metadata.")
+		if !ok || key == "" {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[key] = values[0]
+	}
+	return metadata
+}
+
+// timeQuery parses an optional RFC 3339 query parameter. On a malformed value
+// it writes a 400 response and returns ok == false.
+func timeQuery(c *gin.Context, param string) (t time.Time, ok bool) {
+	v := c.Query(param)
+	if v == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		jsonError(c, http.StatusBadRequest, "invalid "+param+": must be an RFC 3339 timestamp")
+		return time.Time{}, false
+	}
+	return t, true
 }
 
 // getSessionHandler returns a gin.HandlerFunc that retrieves a session by ID.
@@ -166,13 +628,198 @@ func (s *Server) getSessionHandler() gin.HandlerFunc {
 
 		// Return minimal tombstone payload for expired sessions.
 		if session.Status == model.SessionStatusExpired {
-			c.JSON(http.StatusOK, gin.H{
+			resp := withSessionRef(gin.H{
 				"id":     session.ID,
 				"status": "expired",
-			})
+			}, session)
+			if len(session.History) > 0 {
+				resp["history"] = session.History
+			}
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// updateSessionRequest is the JSON body for PATCH /api/v1/sessions/:id.
+// session_ttl and extend_by are mutually exclusive.
+type updateSessionRequest struct {
+	SessionTTL string `json:"session_ttl"` // new lifetime measured from created_at, e.g. "45m"
+	ExtendBy   string `json:"extend_by"`   // added to the current session_ttl; negative shortens
+	ResultTTL  string `json:"result_ttl"`  // new lifetime of the result files, e.g. "10m"
+}
+
+// updateSessionHandler returns a gin.HandlerFunc that changes the TTLs of a
+// live session. The new session TTL is applied to the stored session and to
+// any uploaded scan pages, and subscribers receive a "ttl_updated" event.
+// PATCH /api/v1/sessions/:id
+//
+// Returns:
+//   - 200 with the updated session
+//   - 400 on invalid durations or a session_ttl that would end the session
+//   - 404 when session does not exist
+//   - 409 when session is already completed or cancelled
+//   - 410 when session has expired
+func (s *Server) updateSessionHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var req updateSessionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			jsonError(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if req.SessionTTL == "" && req.ExtendBy == "" && req.ResultTTL == "" {
+			jsonError(c, http.StatusBadRequest, "one of session_ttl, extend_by or result_ttl is required")
+			return
+		}
+		if req.SessionTTL != "" && req.ExtendBy != "" {
+			jsonError(c, http.StatusBadRequest, "session_ttl and extend_by are mutually exclusive")
+			return
+		}
+
+		session, err := s.Store.GetSession(id)
+		if err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("session_controller: failed to retrieve session")
+			jsonError(c, http.StatusInternalServerError, "failed to retrieve session")
+			return
+		}
+		if session == nil {
+			jsonError(c, http.StatusNotFound, "session not found")
+			return
+		}
+		if session.Status == model.SessionStatusExpired {
+			jsonError(c, http.StatusGone, "session expired")
+			return
+		}
+		if session.Status.IsTerminal() {
+			jsonError(c, http.StatusConflict, "session already "+string(session.Status))
+			return
+		}
+
+		// The change is applied to the stored session, so concurrent
+		// extensions add up instead of overwriting each other.
+		var change store.TTLChange
+		if req.SessionTTL != "" {
+			change.SessionTTL, err = time.ParseDuration(req.SessionTTL)
+			if err != nil || change.SessionTTL <= 0 {
+				jsonError(c, http.StatusBadRequest, "invalid session_ttl: must be a positive duration")
+				return
+			}
+		}
+		if req.ExtendBy != "" {
+			change.ExtendBy, err = time.ParseDuration(req.ExtendBy)
+			if err != nil {
+				jsonError(c, http.StatusBadRequest, "invalid extend_by: "+err.Error())
+				return
+			}
+		}
+		if req.ResultTTL != "" {
+			change.ResultTTL, err = time.ParseDuration(req.ResultTTL)
+			if err != nil || change.ResultTTL <= 0 {
+				jsonError(c, http.StatusBadRequest, "invalid result_ttl: must be a positive duration")
+				return
+			}
+		}
+
+		session, err = s.Store.UpdateSessionTTL(id, change)
+		if errors.Is(err, model.ErrInvalidTransition) {
+			// Completed or cancelled concurrently.
+			jsonError(c, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, store.ErrTTLElapsed) {
+			jsonError(c, http.StatusBadRequest, "new session TTL would expire the session; cancel it instead")
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("session_controller: failed to update session TTL")
+			jsonError(c, http.StatusInternalServerError, "failed to update session")
+			return
+		}
+
+		// Uploaded scan pages must live as long as the session that finalizes them.
+		if session.CurrentAction().ActionType.CollectsUploads() {
+			remaining := time.Until(session.CreatedAt.Add(session.SessionTTL))
+			if err := s.Store.TouchScanPages(id, remaining); err != nil {
+				log.Warn().Err(err).Str("session_id", id).Msg("session_controller: failed to update scan page TTL")
+			}
+		}
+
+		s.emitEvent(session, eventTTLUpdated, newTTLUpdate(session))
+
+		log.Info().Str("session_id", id).Dur("session_ttl", session.SessionTTL).Dur("result_ttl", session.ResultTTL).Msg("session_controller: session TTL updated")
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// cancelSessionHandler returns a gin.HandlerFunc that cancels a session.
+// DELETE /api/v1/sessions/:id
+//
+// The phone link is revoked, the result files of completed workflow steps and
+// pending scan pages are purged, and WebSocket subscribers receive a final
+// "cancelled" message before being disconnected.
+// Cancelling an already cancelled session is a no-op.
+//
+// Returns:
+//   - 200 with the cancelled session
+//   - 404 when session does not exist
+//   - 409 when session is already completed
+//   - 410 when session has expired
+func (s *Server) cancelSessionHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		session, err := s.Store.GetSession(id)
+		if err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("session_controller: failed to retrieve session")
+			jsonError(c, http.StatusInternalServerError, "failed to retrieve session")
+			return
+		}
+		if session == nil {
+			jsonError(c, http.StatusNotFound, "session not found")
+			return
+		}
+		if session.Status == model.SessionStatusExpired {
+			jsonError(c, http.StatusGone, "session expired")
+			return
+		}
+		if session.Status == model.SessionStatusCompleted {
+			jsonError(c, http.StatusConflict, "session already completed")
+			return
+		}
+		if session.Status == model.SessionStatusCancelled {
+			c.JSON(http.StatusOK, session)
+			return
+		}
+
+		session, err = s.Store.MarkSessionCancelled(id)
+		if errors.Is(err, model.ErrInvalidTransition) {
+			// Completed or cancelled concurrently.
+			jsonError(c, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("session_controller: failed to cancel session")
+			jsonError(c, http.StatusInternalServerError, "failed to cancel session")
 			return
 		}
 
+		// Nobody will collect the results of the workflow steps completed so
+		// far, or the uploads of the current action.
+		s.deleteResultFiles(id, workflowDeliveries(session.Steps), "session_controller")
+		if session.CurrentAction().ActionType.CollectsUploads() {
+			if err := s.Store.ClearScanPages(id); err != nil {
+				log.Warn().Err(err).Str("session_id", id).Msg("session_controller: failed to purge scan pages")
+			}
+		}
+
+		s.emitEvent(session, eventCancelled, nil)
+		s.Hub.CloseSession(id)
+
+		log.Info().Str("session_id", id).Msg("session_controller: session cancelled")
 		c.JSON(http.StatusOK, session)
 	}
 }
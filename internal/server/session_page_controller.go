@@ -1,10 +1,14 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mxcd/go-config/config"
 	"github.com/mxcd/handoff/internal/model"
 	"github.com/mxcd/handoff/internal/web"
 	"github.com/rs/zerolog/log"
@@ -52,6 +56,16 @@ func (s *Server) sessionPageHandler() gin.HandlerFunc {
 			return
 		}
 
+		// Cancelled by the API caller
+		if session.Status == model.SessionStatusCancelled {
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Status(http.StatusGone)
+			if err := web.RenderPage(c.Writer, "cancelled.html", nil); err != nil {
+				log.Error().Err(err).Str("session_id", id).Msg("session_page: cancelled template render error")
+			}
+			return
+		}
+
 		// Already completed
 		if session.Status == model.SessionStatusCompleted {
 			c.Header("Content-Type", "text/html; charset=utf-8")
@@ -65,23 +79,31 @@ func (s *Server) sessionPageHandler() gin.HandlerFunc {
 		// Mark session as opened if this is the first visit
 		if session.Status == model.SessionStatusPending {
 			if err := s.Store.MarkSessionOpened(id); err != nil {
-				log.Error().Err(err).Str("session_id", id).Msg("session_page: failed to mark opened")
-				// Non-fatal — continue rendering the page
+				// Non-fatal — continue rendering the page. A concurrent visit
+				// may already have moved the session on.
+				if !errors.Is(err, model.ErrInvalidTransition) {
+					log.Error().Err(err).Str("session_id", id).Msg("session_page: failed to mark opened")
+				}
+			} else {
+				// Broadcast status update via WebSocket
+				session.Status = model.SessionStatusOpened
+				s.emitEvent(session, eventStatusUpdate, nil)
 			}
-			// Broadcast status update via WebSocket
-			s.Hub.BroadcastStatusUpdate(id, string(model.SessionStatusOpened))
 		}
 
 		// Determine what to show: intro page or action page
 		// If intro text is configured, show intro page with Continue button
-		if session.IntroText != "" {
+		// (for workflow sessions, the intro text of the current step). Audio
+		// actions show it as the script to read while recording instead.
+		action := session.CurrentAction()
+		if introText := action.IntroText; introText != "" && action.ActionType != model.ActionTypeAudio {
 			actionURL := fmt.Sprintf("/s/%s/action", id)
 			c.Header("Content-Type", "text/html; charset=utf-8")
 			c.Status(http.StatusOK)
-			if err := web.RenderPage(c.Writer, "intro.html", map[string]interface{}{
-				"IntroText": session.IntroText,
+			if err := web.RenderPage(c.Writer, "intro.html", withStepProgress(map[string]interface{}{
+				"IntroText": introText,
 				"ActionURL": actionURL,
-			}); err != nil {
+			}, session)); err != nil {
 				log.Error().Err(err).Str("session_id", id).Msg("session_page: intro template render error")
 			}
 			return
@@ -92,36 +114,82 @@ func (s *Server) sessionPageHandler() gin.HandlerFunc {
 	}
 }
 
-// renderActionPage renders the correct action-specific template based on session.ActionType.
-// It also advances the session status to action_started if it is currently opened.
+// renderActionPage renders the correct action-specific template for the
+// session's current action, see model.Session.CurrentAction.
+// It also advances the session status to action_started if it is pending or opened.
 func (s *Server) renderActionPage(c *gin.Context, session *model.Session) {
 	// Advance to action_started once the user reaches the action UI
-	if session.Status == model.SessionStatusOpened || session.Status == model.SessionStatusPending {
-		session.Status = model.SessionStatusActionStarted
-		if err := s.Store.UpdateSession(session); err != nil {
+	// (reloading the page once the action has started leaves the status alone).
+	if model.CanTransition(session.Status, model.SessionStatusActionStarted) {
+		started, err := s.Store.MarkActionStarted(session.ID)
+		switch {
+		case err == nil:
+			session = started
+			s.emitEvent(session, eventStatusUpdate, nil)
+		case errors.Is(err, model.ErrInvalidTransition):
+			// A concurrent request moved the session on first.
+		default:
 			log.Error().Err(err).Str("session_id", session.ID).Msg("session_page: failed to update action_started")
 		}
-		s.Hub.BroadcastStatusUpdate(session.ID, string(model.SessionStatusActionStarted))
 	}
 
-	data := map[string]interface{}{
+	action := session.CurrentAction()
+	data := withStepProgress(map[string]interface{}{
 		"SessionID":    session.ID,
-		"ActionType":   string(session.ActionType),
-		"OutputFormat": string(session.OutputFormat),
+		"ActionType":   string(action.ActionType),
+		"OutputFormat": string(action.OutputFormat),
 		"SubmitURL":    fmt.Sprintf("/s/%s/result", session.ID),
-	}
+	}, session)
 
 	var templateName string
-	switch session.ActionType {
+	switch action.ActionType {
 	case model.ActionTypePhoto:
 		templateName = "action_photo.html"
 	case model.ActionTypeSignature:
 		templateName = "action_signature.html"
 	case model.ActionTypeScan:
-		data["ScanDocumentMode"] = string(session.ScanDocumentMode)
+		data["ScanDocumentMode"] = string(action.ScanDocumentMode)
 		data["ScanUploadURL"] = fmt.Sprintf("/s/%s/scan/upload", session.ID)
 		data["ScanFinalizeURL"] = fmt.Sprintf("/s/%s/scan/finalize", session.ID)
 		templateName = "action_scan.html"
+	case model.ActionTypeVideo:
+		data["VideoMaxSeconds"] = int(action.VideoMaxDuration.Seconds())
+		data["VideoResolution"] = string(action.VideoResolution)
+		data["VideoMaxBytes"] = config.Get().Int("VIDEO_MAX_BYTES")
+		data["VideoChunkBytes"] = min(videoChunkBytes, config.Get().Int("SCAN_UPLOAD_MAX_BYTES")-videoChunkOverhead)
+		data["VideoUploadURL"] = fmt.Sprintf("/s/%s/video/upload", session.ID)
+		data["VideoFinalizeURL"] = fmt.Sprintf("/s/%s/video/finalize", session.ID)
+		templateName = "action_video.html"
+	case model.ActionTypeAudio:
+		maxDuration, err := time.ParseDuration(config.Get().String("AUDIO_MAX_DURATION"))
+		if err != nil {
+			log.Error().Err(err).Str("session_id", session.ID).Msg("session_page: invalid AUDIO_MAX_DURATION")
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Status(http.StatusInternalServerError)
+			if err := web.RenderPage(c.Writer, "error.html", map[string]interface{}{
+				"Message": "Something went wrong. Please try again.",
+			}); err != nil {
+				log.Error().Err(err).Str("session_id", session.ID).Msg("session_page: error template render error")
+			}
+			return
+		}
+		data["Script"] = action.IntroText
+		data["AudioMaxSeconds"] = int(maxDuration.Seconds())
+		data["AudioWAV"] = action.AudioWAV
+		data["AudioUploadURL"] = fmt.Sprintf("/s/%s/audio/upload", session.ID)
+		templateName = "action_audio.html"
+	case model.ActionTypeUpload:
+		data["UploadAccept"] = uploadAccept(action.UploadAllowedTypes)
+		data["UploadMaxFiles"] = action.UploadMaxFiles
+		data["UploadMaxFileBytes"] = action.UploadMaxFileBytes
+		data["UploadFileURL"] = fmt.Sprintf("/s/%s/upload/file", session.ID)
+		data["UploadFinalizeURL"] = fmt.Sprintf("/s/%s/upload/finalize", session.ID)
+		templateName = "action_upload.html"
+	case model.ActionTypeBarcode:
+		data["BarcodeSymbologies"] = action.BarcodeSymbologies
+		data["BarcodeSubmitURL"] = fmt.Sprintf("/s/%s/barcode/submit", session.ID)
+		data["BarcodeDecodeURL"] = fmt.Sprintf("/s/%s/barcode/decode", session.ID)
+		templateName = "action_barcode.html"
 	default:
 		c.Header("Content-Type", "text/html; charset=utf-8")
 		c.Status(http.StatusInternalServerError)
@@ -179,6 +247,16 @@ func (s *Server) sessionActionHandler() gin.HandlerFunc {
 			return
 		}
 
+		// Cancelled by the API caller
+		if session.Status == model.SessionStatusCancelled {
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Status(http.StatusGone)
+			if err := web.RenderPage(c.Writer, "cancelled.html", nil); err != nil {
+				log.Error().Err(err).Str("session_id", id).Msg("session_action: cancelled template render error")
+			}
+			return
+		}
+
 		if session.Status == model.SessionStatusCompleted {
 			c.Header("Content-Type", "text/html; charset=utf-8")
 			c.Status(http.StatusOK)
@@ -191,3 +269,27 @@ func (s *Server) sessionActionHandler() gin.HandlerFunc {
 		s.renderActionPage(c, session)
 	}
 }
+
+// uploadAccept returns the accept attribute of the file input of an upload
+// session. Browsers may not know the MIME type of HEIC photos, so they are
+// also accepted by extension.
+func uploadAccept(allowedTypes []string) string {
+	accept := make([]string, 0, len(allowedTypes)+1)
+	for _, t := range allowedTypes {
+		accept = append(accept, t)
+		if t == "image/heic" || t == "image/*" {
+			accept = append(accept, ".heic")
+		}
+	}
+	return strings.Join(accept, ",")
+}
+
+// withStepProgress adds the 1-based number of the current step and the step
+// count of a workflow session to the template data, for the step indicator.
+func withStepProgress(data map[string]interface{}, session *model.Session) map[string]interface{} {
+	if session.IsWorkflow() {
+		data["StepNumber"] = session.CurrentStep + 1
+		data["StepCount"] = len(session.Steps)
+	}
+	return data
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/handoff/internal/ws"
+	"github.com/rs/zerolog/log"
+)
+
+// sseKeepAlive is how often a comment is sent on an idle event stream so
+// that proxies do not time the connection out.
+const sseKeepAlive = 15 * time.Second
+
+// sseHandler streams per-session real-time notifications as Server-Sent Events,
+// for clients behind proxies that do not pass WebSocket upgrades.
+// GET /api/v1/sessions/:id/events
+//
+// Every event carries the same JSON message as the WebSocket endpoint in its
+// data field and, except for the initial status, its sequence number as id.
+// A client reconnecting with the Last-Event-ID header (or ?since=<seq>)
+// receives the logged events after it instead of the initial status. The key
+// may be supplied via the X-API-Key header or the api_key query parameter.
+//
+// Returns:
+//   - 200 with a text/event-stream until the session ends or the client disconnects
+//   - 400 on a malformed since parameter
+//   - 401 if the API key is missing or invalid
+//   - 404 if the session does not exist
+//   - 410 if the session has expired or was cancelled
+func (s *Server) sseHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		session := s.streamSession(c, id, "sse")
+		if session == nil {
+			return
+		}
+		since, ok := sinceQuery(c)
+		if !ok {
+			return
+		}
+		if lastEventID, err := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64); err == nil {
+			since = &lastEventID
+		}
+
+		sub := ws.NewStreamSubscriber(streamBuffer)
+		replay, lastSeq, err := s.subscribeSession(session, sub, since)
+		if err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("sse: failed to list events")
+			jsonError(c, http.StatusInternalServerError, "internal error")
+			return
+		}
+		log.Info().Str("session_id", id).Msg("sse: client connected")
+		defer func() {
+			s.Hub.Unsubscribe(id, sub)
+			log.Info().Str("session_id", id).Msg("sse: client disconnected")
+		}()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no") // disable nginx response buffering
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		err = forwardEvents(sub, replay, lastSeq, c.Request.Context().Done(), func(msg ws.WSMessage) error {
+			return writeSSE(c, msg)
+		}, sseKeepAlive, func() error {
+			if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		})
+		if err != nil {
+			log.Debug().Err(err).Str("session_id", id).Msg("sse: write failed")
+		}
+	}
+}
+
+// writeSSE writes msg as one event, with its sequence number as id, and
+// flushes it. Status snapshots have no sequence number and get no id.
+func writeSSE(c *gin.Context, msg ws.WSMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if msg.Seq > 0 {
+		if _, err := fmt.Fprintf(c.Writer, "id: %d\n", msg.Seq); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
@@ -0,0 +1,133 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mxcd/handoff/internal/store"
+)
+
+// sseEvent is one event read from an event stream.
+type sseEvent struct {
+	id   string
+	data map[string]any
+}
+
+// openEvents opens the event stream of session id with the given since query
+// and Last-Event-ID header, either of which may be empty. The stream is
+// closed when the test ends.
+func (ts *testServer) openEvents(id, since, lastEventID string) *bufio.Reader {
+	ts.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ts.t.Cleanup(cancel)
+	url := ts.URL + "/api/v1/sessions/" + id + "/events?api_key=" + testAPIKey
+	if since != "" {
+		url += "&since=" + since
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		ts.t.Fatalf("NewRequest: %v", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatalf("GET events: %v", err)
+	}
+	ts.t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		ts.t.Fatalf("GET events: got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// readEvent reads the next event from stream, skipping keep-alive comments.
+func readEvent(t *testing.T, stream *bufio.Reader) sseEvent {
+	t.Helper()
+	var evt sseEvent
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && evt.data != nil:
+			return evt
+		case strings.HasPrefix(line, "id: "):
+			evt.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &evt.data); err != nil {
+				t.Fatalf("decode event data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+func TestSSEResume(t *testing.T) {
+	ts := newTestServer(t, store.NewMemoryStore())
+	id := ts.createSession(map[string]string{"action_type": "photo", "output_format": "jpg"})["id"].(string)
+
+	// Log events 1 (opened), 2 (action_started), 3 and 4 (TTL updates).
+	if resp, body := ts.request(http.MethodGet, "/s/"+id, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("open session page: got %d %s", resp.StatusCode, body)
+	}
+	for range 2 {
+		if resp, body := ts.request(http.MethodPatch, "/api/v1/sessions/"+id, map[string]string{"extend_by": "1m"}, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("extend session: got %d %s", resp.StatusCode, body)
+		}
+	}
+
+	t.Run("initial status", func(t *testing.T) {
+		evt := readEvent(t, ts.openEvents(id, "", ""))
+		if evt.id != "" || evt.data["type"] != "status_update" || evt.data["status"] != "action_started" {
+			t.Errorf("got %+v, want the current status without an id", evt)
+		}
+	})
+
+	for name, tc := range map[string]struct {
+		since, lastEventID string
+		want               []string
+	}{
+		"Last-Event-ID":              {lastEventID: "2", want: []string{"3", "4"}},
+		"since":                      {since: "0", want: []string{"1", "2", "3", "4"}},
+		"Last-Event-ID beats since":  {since: "0", lastEventID: "3", want: []string{"4"}},
+		"Last-Event-ID before since": {since: "4", lastEventID: "2", want: []string{"3", "4"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stream := ts.openEvents(id, tc.since, tc.lastEventID)
+			for _, want := range tc.want {
+				if evt := readEvent(t, stream); evt.id != want || fmt.Sprint(evt.data["seq"]) != want {
+					t.Fatalf("got event %+v, want event %s", evt, want)
+				}
+			}
+		})
+	}
+
+	// Live events follow the replayed ones, and cancelling ends the stream.
+	stream := ts.openEvents(id, "", "4")
+	if resp, body := ts.request(http.MethodDelete, "/api/v1/sessions/"+id, nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("cancel session: got %d %s", resp.StatusCode, body)
+	}
+	if evt := readEvent(t, stream); evt.id != "5" || evt.data["status"] != "cancelled" {
+		t.Errorf("live event: got %+v, want 5 cancelled", evt)
+	}
+	if _, err := stream.ReadString('\n'); err == nil {
+		t.Error("stream still open after the session was cancelled")
+	}
+}
+
+func TestSSEBadSince(t *testing.T) {
+	ts := newTestServer(t, store.NewMemoryStore())
+	id := ts.createSession(map[string]string{"action_type": "photo", "output_format": "jpg"})["id"].(string)
+	if resp, body := ts.request(http.MethodGet, "/api/v1/sessions/"+id+"/events?since=x", nil, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed since: got %d %s, want 400", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/rs/zerolog/log"
+)
+
+// stepCompleted is the data of a "step_completed" event: the completed step
+// with its result, and its index in the session's steps.
+type stepCompleted struct {
+	Index int `json:"index"`
+	model.Step
+}
+
+// workflowResult is the data of the "completed" event of a workflow session,
+// its result grouped by step.
+type workflowResult struct {
+	Steps []model.Step `json:"steps"`
+}
+
+// actionNouns names the actions that have their own phone endpoints, for
+// error messages such as "session is not waiting for a scan".
+var actionNouns = map[model.ActionType]string{
+	model.ActionTypeScan:    "a scan",
+	model.ActionTypeVideo:   "a video",
+	model.ActionTypeAudio:   "an audio recording",
+	model.ActionTypeUpload:  "a file upload",
+	model.ActionTypeBarcode: "a barcode",
+}
+
+// waitingSession looks up the session of a request from the phone UI and
+// checks that it still waits for a result: it exists and has neither expired
+// nor been cancelled or completed. It writes an error response and returns
+// nil if not. logPrefix names the calling handler in logs.
+func (s *Server) waitingSession(c *gin.Context, logPrefix string) *model.Session {
+	id := c.Param("id")
+
+	session, err := s.Store.GetSession(id)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", id).Msg(logPrefix + ": failed to get session")
+		jsonError(c, http.StatusInternalServerError, "internal error")
+		return nil
+	}
+	if session == nil {
+		jsonError(c, http.StatusNotFound, "session not found")
+		return nil
+	}
+	if session.Status == model.SessionStatusExpired {
+		jsonError(c, http.StatusGone, "session expired")
+		return nil
+	}
+	if session.Status == model.SessionStatusCancelled {
+		jsonError(c, http.StatusGone, "session cancelled")
+		return nil
+	}
+	if session.Status == model.SessionStatusCompleted {
+		jsonError(c, http.StatusConflict, "session already completed")
+		return nil
+	}
+	return session
+}
+
+// actionSession is waitingSession for the endpoints of one action type: it
+// also checks that the current action of the session is of type want, and
+// returns that action.
+func (s *Server) actionSession(c *gin.Context, want model.ActionType, logPrefix string) (*model.Session, model.Step, bool) {
+	session := s.waitingSession(c, logPrefix)
+	if session == nil {
+		return nil, model.Step{}, false
+	}
+	action := session.CurrentAction()
+	if action.ActionType != want {
+		jsonError(c, http.StatusBadRequest, "session is not waiting for "+actionNouns[want])
+		return nil, model.Step{}, false
+	}
+	return session, action, true
+}
+
+// completeAction records r as the result of the current action of session:
+// its current step for workflow sessions, the session itself otherwise. It
+// reports the result to subscribers, delivers the result files once the
+// session is complete and returns the session as stored. If the result cannot
+// be recorded, its already stored files are deleted, an error response is
+// written and nil is returned. logPrefix names the calling handler in logs.
+func (s *Server) completeAction(c *gin.Context, session *model.Session, r model.ActionResult, logPrefix string) *model.Session {
+	var updated *model.Session
+	if session.IsWorkflow() {
+		updated = s.completeStep(c, session, r, logPrefix)
+	} else {
+		updated = s.completeSession(c, session, r, logPrefix)
+	}
+	if updated == nil {
+		// Nothing refers to the result files any more.
+		s.deleteResultFiles(session.ID, actionDeliveries("", r), logPrefix)
+	}
+	return updated
+}
+
+// deleteResultFiles deletes the stored result files of session sessionID,
+// logging failures. logPrefix names the calling handler in logs.
+func (s *Server) deleteResultFiles(sessionID string, files []model.FileDelivery, logPrefix string) {
+	for _, f := range files {
+		if err := s.Store.DeleteFile(f.DownloadID); err != nil {
+			log.Warn().Err(err).Str("session_id", sessionID).Str("download_id", f.DownloadID).Msg(logPrefix + ": failed to delete result file")
+		}
+	}
+}
+
+// completeSession completes a session that is not a workflow with r. It
+// returns the completed session, or writes an error response and returns nil
+// if the session cannot be completed.
+func (s *Server) completeSession(c *gin.Context, session *model.Session, r model.ActionResult, logPrefix string) *model.Session {
+	updated, err := s.Store.MarkCompleted(session.ID, 0, r)
+	if errors.Is(err, model.ErrInvalidTransition) {
+		// Cancelled or completed concurrently.
+		jsonError(c, http.StatusConflict, err.Error())
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session.ID).Msg(logPrefix + ": failed to mark session completed")
+		jsonError(c, http.StatusInternalServerError, "internal error")
+		return nil
+	}
+
+	s.emitEvent(updated, eventCompleted, completedData(r))
+	s.pushDelivery(updated, actionDeliveries("", r))
+	log.Info().Str("session_id", session.ID).Msg(logPrefix + ": session completed")
+	return updated
+}
+
+// completedData is the data of the "completed" event of a session that is not
+// a workflow: its scan or barcode result, or its result items.
+func completedData(r model.ActionResult) interface{} {
+	if r.ScanResult != nil {
+		return r.ScanResult
+	}
+	if r.BarcodeResult != nil {
+		return r.BarcodeResult
+	}
+	return r.Result
+}
+
+// completeStep records the result of the current step of a workflow session,
+// reports it to subscribers and, after the last step, completes the session.
+// It returns the updated session, or writes an error response and returns
+// nil if the step cannot be recorded. logPrefix names the calling handler in logs.
+func (s *Server) completeStep(c *gin.Context, session *model.Session, r model.ActionResult, logPrefix string) *model.Session {
+	step := session.CurrentStep
+	updated, err := s.Store.MarkCompleted(session.ID, step, r)
+	if errors.Is(err, model.ErrInvalidTransition) {
+		// Cancelled, or the step completed concurrently.
+		jsonError(c, http.StatusConflict, err.Error())
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session.ID).Int("step", step).Msg(logPrefix + ": failed to mark step completed")
+		jsonError(c, http.StatusInternalServerError, "internal error")
+		return nil
+	}
+
+	s.emitEvent(updated, eventStepCompleted, stepCompleted{Index: step, Step: updated.Steps[step]})
+	log.Info().Str("session_id", session.ID).Int("step", step+1).Int("steps", len(updated.Steps)).Msg(logPrefix + ": step completed")
+
+	if updated.Status == model.SessionStatusCompleted {
+		s.emitEvent(updated, eventCompleted, workflowResult{Steps: updated.Steps})
+		s.pushDelivery(updated, workflowDeliveries(updated.Steps))
+		log.Info().Str("session_id", session.ID).Msg(logPrefix + ": session completed")
+	}
+	return updated
+}
+
+// workflowDeliveries lists the result files of every step for delivery,
+// prefixing their names with the 1-based step number, e.g. "step-2-photo.jpg".
+func workflowDeliveries(steps []model.Step) []model.FileDelivery {
+	var files []model.FileDelivery
+	for i, step := range steps {
+		files = append(files, actionDeliveries(fmt.Sprintf("step-%d-", i+1), model.ActionResult{
+			Result:     step.Result,
+			ScanResult: step.ScanResult,
+		})...)
+	}
+	return files
+}
+
+// actionDeliveries lists the result files of r for delivery, prefixing their
+// names with prefix. Scan documents and pages are named by scanFilename.
+func actionDeliveries(prefix string, r model.ActionResult) []model.FileDelivery {
+	var files []model.FileDelivery
+	for _, item := range r.Result {
+		files = append(files, model.FileDelivery{DownloadID: item.DownloadID, Filename: prefix + item.Filename, ContentType: item.ContentType})
+	}
+	if r.ScanResult == nil {
+		return files
+	}
+	for d, doc := range r.ScanResult.Documents {
+		if doc.PDFURL != "" {
+			files = append(files, model.FileDelivery{
+				DownloadID:  strings.TrimPrefix(doc.PDFURL, downloadPathPrefix),
+				Filename:    prefix + scanFilename(d+1, 0, "application/pdf"),
+				ContentType: "application/pdf",
+			})
+		}
+		for p, page := range doc.Pages {
+			files = append(files, model.FileDelivery{
+				DownloadID:  strings.TrimPrefix(page.URL, downloadPathPrefix),
+				Filename:    prefix + scanFilename(d+1, p+1, page.ContentType),
+				ContentType: page.ContentType,
+			})
+		}
+	}
+	return files
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/ws"
+	"github.com/rs/zerolog/log"
+)
+
+// streamBuffer is how many live messages may queue for a WebSocket or event
+// stream client before it is dropped, e.g. while missed events are replayed.
+const streamBuffer = 64
+
+// streamAPIKeyID authenticates a WebSocket or event stream request and
+// returns the fingerprint of its API key. The key may be supplied via the
+// X-API-Key header or the api_key query parameter, since browsers cannot set
+// headers on these requests. It writes an error response and returns false
+// when the request is not authorised.
+func streamAPIKeyID(c *gin.Context) (string, bool) {
+	key := c.GetHeader("X-API-Key")
+	if key == "" {
+		key = c.Query("api_key")
+	}
+	if key == "" {
+		jsonError(c, http.StatusUnauthorized, "missing API key")
+		return "", false
+	}
+	if !validAPIKey(key) {
+		jsonError(c, http.StatusUnauthorized, "invalid API key")
+		return "", false
+	}
+	return apiKeyID(key), true
+}
+
+// streamSession authenticates a WebSocket or event stream request and loads
+// the session it subscribes to. It writes an error response and returns nil
+// when the request is not authorised or the session is unknown or no longer
+// live.
+func (s *Server) streamSession(c *gin.Context, id string, logPrefix string) *model.Session {
+	if _, ok := streamAPIKeyID(c); !ok {
+		return nil
+	}
+
+	// Verify session exists and is not expired.
+	session, err := s.Store.GetSession(id)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", id).Msg(logPrefix + ": failed to get session")
+		jsonError(c, http.StatusInternalServerError, "internal error")
+		return nil
+	}
+	if session == nil {
+		jsonError(c, http.StatusNotFound, "session not found")
+		return nil
+	}
+	if session.Status == model.SessionStatusExpired {
+		jsonError(c, http.StatusGone, "session expired")
+		return nil
+	}
+	if session.Status == model.SessionStatusCancelled {
+		jsonError(c, http.StatusGone, "session cancelled")
+		return nil
+	}
+	return session
+}
+
+// sinceQuery parses the optional since query parameter, the sequence number
+// of the last event a reconnecting client saw. It writes a 400 response and
+// returns false on a malformed value.
+func sinceQuery(c *gin.Context) (*uint64, bool) {
+	v, ok := c.GetQuery("since")
+	if !ok {
+		return nil, true
+	}
+	since, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		jsonError(c, http.StatusBadRequest, "invalid since: must be an event sequence number")
+		return nil, false
+	}
+	return &since, true
+}
+
+// subscribeSession subscribes sub to the live messages of session and returns
+// the messages to send before them: the logged events after since, or the
+// current status if since is nil. Subscribing first means nothing is missed
+// in between; live messages numbered up to the returned sequence number
+// repeat replayed ones.
+func (s *Server) subscribeSession(session *model.Session, sub *ws.StreamSubscriber, since *uint64) ([]ws.WSMessage, uint64, error) {
+	s.Hub.Subscribe(session.ID, sub)
+	if since == nil {
+		return []ws.WSMessage{newEventMessage(session, eventStatusUpdate, nil)}, 0, nil
+	}
+
+	events, err := s.Store.ListEvents(session.ID, *since)
+	if err != nil {
+		s.Hub.Unsubscribe(session.ID, sub)
+		return nil, 0, err
+	}
+	replay := make([]ws.WSMessage, 0, len(events))
+	lastSeq := *since
+	for _, event := range events {
+		replay = append(replay, loggedEventMessage(session, event))
+		lastSeq = event.Seq
+	}
+	return replay, lastSeq, nil
+}
+
+// forwardEvents passes replay and then the live messages of sub to send, until
+// the subscription ends, done is closed or send fails. Live messages numbered
+// up to lastSeq were already replayed and are skipped. keepAlive is called
+// every keepAliveInterval.
+func forwardEvents(sub *ws.StreamSubscriber, replay []ws.WSMessage, lastSeq uint64, done <-chan struct{}, send func(ws.WSMessage) error, keepAliveInterval time.Duration, keepAlive func() error) error {
+	for _, msg := range replay {
+		if err := send(msg); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case msg, ok := <-sub.Events():
+			if !ok {
+				// Dropped by the hub, or the session ended.
+				return nil
+			}
+			if msg.Seq != 0 && msg.Seq <= lastSeq {
+				continue
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := keepAlive(); err != nil {
+				return err
+			}
+		}
+	}
+}
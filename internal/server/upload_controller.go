@@ -0,0 +1,250 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/go-config/config"
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/mxcd/handoff/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// uploadFormOverhead is the room left for the multipart framing of an uploaded
+// file on top of its size limit.
+const uploadFormOverhead = 16 << 10
+
+// uploadSettings is the allowed types, file count and file size of an upload
+// session or step.
+type uploadSettings struct {
+	allowedTypes []string
+	maxFiles     int
+	maxFileBytes int64
+}
+
+// uploadDefaults returns the configured upload settings: UPLOAD_ALLOWED_TYPES,
+// and UPLOAD_MAX_FILES and UPLOAD_MAX_FILE_BYTES, which also cap the limits
+// requested for a session.
+func uploadDefaults() (uploadSettings, error) {
+	allowedTypes := config.Get().StringArray("UPLOAD_ALLOWED_TYPES")
+	if len(allowedTypes) == 0 {
+		return uploadSettings{}, fmt.Errorf("UPLOAD_ALLOWED_TYPES is empty")
+	}
+	for _, t := range allowedTypes {
+		if err := model.ValidateUploadType(t); err != nil {
+			return uploadSettings{}, fmt.Errorf("invalid UPLOAD_ALLOWED_TYPES: %w", err)
+		}
+	}
+	maxFiles := config.Get().Int("UPLOAD_MAX_FILES")
+	if maxFiles <= 0 {
+		return uploadSettings{}, fmt.Errorf("UPLOAD_MAX_FILES must be positive")
+	}
+	if maxFiles > config.Get().Int("SCAN_MAX_PAGES") {
+		return uploadSettings{}, fmt.Errorf("UPLOAD_MAX_FILES must be at most SCAN_MAX_PAGES")
+	}
+	maxFileBytes := int64(config.Get().Int("UPLOAD_MAX_FILE_BYTES"))
+	if maxFileBytes <= 0 {
+		return uploadSettings{}, fmt.Errorf("UPLOAD_MAX_FILE_BYTES must be positive")
+	}
+	return uploadSettings{allowedTypes: allowedTypes, maxFiles: maxFiles, maxFileBytes: maxFileBytes}, nil
+}
+
+// with returns the settings requested for an upload session or step, falling
+// back to u for those not given. The limits cannot exceed u's.
+func (u uploadSettings) with(allowedTypes []string, maxFiles int, maxFileBytes int64) (uploadSettings, error) {
+	settings := u
+	if len(allowedTypes) > 0 {
+		for _, t := range allowedTypes {
+			if err := model.ValidateUploadType(t); err != nil {
+				return uploadSettings{}, err
+			}
+		}
+		settings.allowedTypes = allowedTypes
+	}
+	if maxFiles != 0 {
+		if maxFiles < 0 || maxFiles > u.maxFiles {
+			return uploadSettings{}, fmt.Errorf("upload_max_files must be positive and at most %d", u.maxFiles)
+		}
+		settings.maxFiles = maxFiles
+	}
+	if maxFileBytes != 0 {
+		if maxFileBytes < 0 || maxFileBytes > u.maxFileBytes {
+			return uploadSettings{}, fmt.Errorf("upload_max_file_bytes must be positive and at most %d", u.maxFileBytes)
+		}
+		settings.maxFileBytes = maxFileBytes
+	}
+	return settings, nil
+}
+
+// wantsUpload reports whether req creates an upload session or a workflow
+// with an upload step, which need the configured upload settings.
+func wantsUpload(req *createSessionRequest) bool {
+	if req.ActionType == string(model.ActionTypeUpload) {
+		return true
+	}
+	for _, step := range req.Steps {
+		if step.ActionType == string(model.ActionTypeUpload) {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadFileHandler accepts one file for an upload session, or a workflow
+// session at an upload step.
+// POST /s/:id/upload/file (public — session UUID is the auth)
+//
+// Expects multipart/form-data with:
+//   - file: the file; its type is detected from its content, not the declared
+//     content type, and must be one of the session's allowed types
+//   - file_index: non-negative integer (optional, defaults 0); the files keep
+//     this order, and an accepted file 0 starts a new upload, discarding any
+//     earlier files
+func (s *Server) uploadFileHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, action, ok := s.actionSession(c, model.ActionTypeUpload, "upload_file")
+		if !ok {
+			return
+		}
+		id := session.ID
+
+		if !parseUploadFormWithin(c, action.UploadMaxFileBytes+uploadFormOverhead) {
+			return
+		}
+		fileIndex, ok := strictFormIndex(c, "file_index")
+		if !ok {
+			return
+		}
+		if fileIndex >= action.UploadMaxFiles {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "file limit exceeded",
+				"limit": action.UploadMaxFiles,
+			})
+			return
+		}
+		// Every stored file, retries included, counts against SCAN_MAX_PAGES,
+		// except file 0, which replaces the earlier files.
+		var data []byte
+		if fileIndex == 0 {
+			data, _, ok = readFormFile(c, id, "file", "upload_file")
+		} else {
+			data, _, ok = s.readUploadedFile(c, id, "upload_file")
+		}
+		if !ok {
+			return
+		}
+		if int64(len(data)) > action.UploadMaxFileBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "file too large",
+				"limit": action.UploadMaxFileBytes,
+			})
+			return
+		}
+		// Trust the content, not the type the browser declared.
+		contentType := util.SniffContentType(data)
+		if !model.UploadTypeAllowed(action.UploadAllowedTypes, contentType) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":         "file type not allowed",
+				"content_type":  contentType,
+				"allowed_types": action.UploadAllowedTypes,
+			})
+			return
+		}
+
+		if fileIndex == 0 {
+			// A new upload: drop the files of an earlier, abandoned one, now
+			// that this file was accepted.
+			if err := s.Store.ClearScanPages(id); err != nil {
+				log.Error().Err(err).Str("session_id", id).Msg("upload_file: failed to clear earlier files")
+				jsonError(c, http.StatusInternalServerError, "internal error")
+				return
+			}
+		}
+		// Files are kept with the scan pages until the upload is finalized.
+		remainingTTL := time.Until(session.CreatedAt.Add(session.SessionTTL))
+		if err := s.Store.AddScanPage(id, store.ScanPageData{
+			PageIndex:   fileIndex,
+			Data:        data,
+			ContentType: contentType,
+		}, remainingTTL); err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("upload_file: failed to store file")
+			jsonError(c, http.StatusInternalServerError, "failed to store file")
+			return
+		}
+
+		log.Info().
+			Str("session_id", id).
+			Int("file_index", fileIndex).
+			Str("content_type", contentType).
+			Int("bytes", len(data)).
+			Msg("upload_file: file accepted")
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":       "file accepted",
+			"file_index":   fileIndex,
+			"content_type": contentType,
+		})
+	}
+}
+
+// uploadFinalizeHandler completes the session with the uploaded files, each
+// as a result item of its own.
+// POST /s/:id/upload/finalize (public — session UUID is the auth)
+//
+// The files must be numbered 0 to n-1; a file uploaded twice counts once. They
+// are named file-1.pdf, file-2.jpg and so on, after their detected type. For
+// workflow sessions, the files complete the current step; the session
+// completes with the last one.
+func (s *Server) uploadFinalizeHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, _, ok := s.actionSession(c, model.ActionTypeUpload, "upload_finalize")
+		if !ok {
+			return
+		}
+		id := session.ID
+
+		pages, err := s.Store.GetScanPages(id)
+		if err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("upload_finalize: failed to get files")
+			jsonError(c, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if len(pages) == 0 {
+			jsonError(c, http.StatusBadRequest, "no files uploaded")
+			return
+		}
+		files, missing := latestUploads(pages)
+		if missing >= 0 {
+			jsonError(c, http.StatusBadRequest, fmt.Sprintf("missing file %d", missing))
+			return
+		}
+
+		resultItems := make([]model.ResultItem, 0, len(files))
+		for i, f := range files {
+			dlID := model.NewSessionID()
+			if err := s.Store.StoreFile(dlID, f.Data, f.ContentType, session.ResultTTL); err != nil {
+				log.Error().Err(err).Str("session_id", id).Str("download_id", dlID).Msg("upload_finalize: failed to store file")
+				jsonError(c, http.StatusInternalServerError, "failed to store file")
+				return
+			}
+			resultItems = append(resultItems, model.ResultItem{
+				DownloadID:  dlID,
+				ContentType: f.ContentType,
+				Filename:    fmt.Sprintf("file-%d%s", i+1, model.UploadExtension(f.ContentType)),
+			})
+		}
+
+		updated := s.completeAction(c, session, model.ActionResult{Result: resultItems}, "upload_finalize")
+		if updated == nil {
+			return
+		}
+		// The staged files are no longer needed once stored as results.
+		if err := s.Store.ClearScanPages(id); err != nil {
+			log.Warn().Err(err).Str("session_id", id).Msg("upload_finalize: failed to clear files")
+		}
+		c.JSON(http.StatusOK, gin.H{"status": string(updated.Status), "items": resultItems})
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/go-config/config"
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// The phone UI uploads recordings in chunks of videoChunkBytes, or smaller if
+// a chunk and its multipart framing would exceed SCAN_UPLOAD_MAX_BYTES.
+const (
+	videoChunkBytes    = 5 << 20
+	videoChunkOverhead = 16 << 10
+)
+
+// videoSettings is the max duration and resolution of a video session or step.
+type videoSettings struct {
+	maxDuration time.Duration
+	resolution  model.VideoResolution
+}
+
+// videoDefaults returns the configured video settings: VIDEO_MAX_DURATION,
+// which also caps the max duration requested for a session, and
+// VIDEO_RESOLUTION.
+func videoDefaults() (videoSettings, error) {
+	maxDuration, err := time.ParseDuration(config.Get().String("VIDEO_MAX_DURATION"))
+	if err != nil {
+		return videoSettings{}, fmt.Errorf("invalid VIDEO_MAX_DURATION: %w", err)
+	}
+	resolution, err := model.ValidateVideoResolution(config.Get().String("VIDEO_RESOLUTION"))
+	if err != nil {
+		return videoSettings{}, fmt.Errorf("invalid VIDEO_RESOLUTION: %w", err)
+	}
+	return videoSettings{maxDuration: maxDuration, resolution: resolution}, nil
+}
+
+// with returns the settings requested for a video session or step, falling
+// back to v for those not given. The max duration cannot exceed v's.
+func (v videoSettings) with(maxDuration, resolution string) (videoSettings, error) {
+	settings := v
+	if maxDuration != "" {
+		d, err := time.ParseDuration(maxDuration)
+		if err != nil {
+			return videoSettings{}, fmt.Errorf("invalid video_max_duration: %w", err)
+		}
+		if d <= 0 || d > v.maxDuration {
+			return videoSettings{}, fmt.Errorf("video_max_duration must be positive and at most %s", v.maxDuration)
+		}
+		settings.maxDuration = d
+	}
+	if resolution != "" {
+		r, err := model.ValidateVideoResolution(resolution)
+		if err != nil {
+			return videoSettings{}, err
+		}
+		settings.resolution = r
+	}
+	return settings, nil
+}
+
+// wantsVideo reports whether req creates a video session or a workflow with a
+// video step, which need the configured video settings.
+func wantsVideo(req *createSessionRequest) bool {
+	if req.ActionType == string(model.ActionTypeVideo) {
+		return true
+	}
+	for _, step := range req.Steps {
+		if step.ActionType == string(model.ActionTypeVideo) {
+			return true
+		}
+	}
+	return false
+}
+
+// videoUploadHandler accepts one chunk of a recording for a video session, or
+// a workflow session at a video step.
+// POST /s/:id/video/upload (public — session UUID is the auth)
+//
+// Expects multipart/form-data with:
+//   - file: the chunk, with the recording's content type (video/mp4 or video/webm)
+//   - chunk_index: non-negative integer (optional, defaults 0); the chunks are
+//     joined in this order, and an accepted chunk 0 starts a new recording,
+//     discarding any earlier chunks
+func (s *Server) videoUploadHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, _, ok := s.actionSession(c, model.ActionTypeVideo, "video_upload")
+		if !ok {
+			return
+		}
+		id := session.ID
+
+		if !parseUploadForm(c) {
+			return
+		}
+		chunkIndex, ok := strictFormIndex(c, "chunk_index")
+		if !ok {
+			return
+		}
+		var data []byte
+		var contentType string
+		if chunkIndex == 0 {
+			// Chunk 0 replaces the earlier chunks, so it does not count
+			// against the SCAN_MAX_PAGES limit they may have reached.
+			data, contentType, ok = readFormFile(c, id, "file", "video_upload")
+		} else {
+			data, contentType, ok = s.readUploadedFile(c, id, "video_upload")
+		}
+		if !ok {
+			return
+		}
+		videoType, ok := model.VideoContentType(contentType)
+		if !ok {
+			jsonError(c, http.StatusBadRequest, "unsupported video content type "+contentType+": must be video/mp4 or video/webm")
+			return
+		}
+		if chunkIndex == 0 {
+			// A new recording: drop the chunks of an earlier, abandoned
+			// upload, now that this chunk was accepted.
+			if err := s.Store.ClearScanPages(id); err != nil {
+				log.Error().Err(err).Str("session_id", id).Msg("video_upload: failed to clear earlier chunks")
+				jsonError(c, http.StatusInternalServerError, "internal error")
+				return
+			}
+		}
+
+		// Chunks are kept with the scan pages until the recording is finalized.
+		remainingTTL := time.Until(session.CreatedAt.Add(session.SessionTTL))
+		if err := s.Store.AddScanPage(id, store.ScanPageData{
+			PageIndex:   chunkIndex,
+			Data:        data,
+			ContentType: videoType,
+		}, remainingTTL); err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("video_upload: failed to store chunk")
+			jsonError(c, http.StatusInternalServerError, "failed to store chunk")
+			return
+		}
+
+		log.Info().
+			Str("session_id", id).
+			Int("chunk_index", chunkIndex).
+			Int("bytes", len(data)).
+			Msg("video_upload: chunk accepted")
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":      "chunk accepted",
+			"chunk_index": chunkIndex,
+		})
+	}
+}
+
+// videoFinalizeHandler joins the uploaded chunks into the recording and
+// completes the session with it as its only result item.
+// POST /s/:id/video/finalize (public — session UUID is the auth)
+//
+// The chunks must be numbered 0 to n-1 and share their content type; a chunk
+// uploaded twice counts once. For workflow sessions, the recording completes
+// the current step; the session completes with the last one.
+func (s *Server) videoFinalizeHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, _, ok := s.actionSession(c, model.ActionTypeVideo, "video_finalize")
+		if !ok {
+			return
+		}
+		id := session.ID
+
+		pages, err := s.Store.GetScanPages(id)
+		if err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("video_finalize: failed to get chunks")
+			jsonError(c, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if len(pages) == 0 {
+			jsonError(c, http.StatusBadRequest, "no video uploaded")
+			return
+		}
+
+		chunks, missing := latestUploads(pages)
+		if missing >= 0 {
+			jsonError(c, http.StatusBadRequest, fmt.Sprintf("missing video chunk %d", missing))
+			return
+		}
+
+		contentType := chunks[0].ContentType
+		maxBytes := config.Get().Int("VIDEO_MAX_BYTES")
+		var video bytes.Buffer
+		for _, chunk := range chunks {
+			if chunk.ContentType != contentType {
+				jsonError(c, http.StatusBadRequest, "video chunks differ in content type")
+				return
+			}
+			if video.Len()+len(chunk.Data) > maxBytes {
+				// The recording cannot be finalized; let the phone start over.
+				if err := s.Store.ClearScanPages(id); err != nil {
+					log.Warn().Err(err).Str("session_id", id).Msg("video_finalize: failed to clear chunks")
+				}
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+					"error": "video too large",
+					"limit": maxBytes,
+				})
+				return
+			}
+			video.Write(chunk.Data)
+		}
+
+		dlID := model.NewSessionID()
+		if err := s.Store.StoreFile(dlID, video.Bytes(), contentType, session.ResultTTL); err != nil {
+			log.Error().Err(err).Str("session_id", id).Msg("video_finalize: failed to store video")
+			jsonError(c, http.StatusInternalServerError, "failed to store video")
+			return
+		}
+		resultItems := []model.ResultItem{{
+			DownloadID:  dlID,
+			ContentType: contentType,
+			Filename:    "video." + strings.TrimPrefix(contentType, "video/"),
+		}}
+
+		updated := s.completeAction(c, session, model.ActionResult{Result: resultItems}, "video_finalize")
+		if updated == nil {
+			return
+		}
+		// The chunks are no longer needed once joined.
+		if err := s.Store.ClearScanPages(id); err != nil {
+			log.Warn().Err(err).Str("session_id", id).Msg("video_finalize: failed to clear chunks")
+		}
+		c.JSON(http.StatusOK, gin.H{"status": string(updated.Status), "items": resultItems})
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/rs/zerolog/log"
+)
+
+// listDeadWebhooksHandler returns the webhook deliveries that were given up on.
+// GET /api/v1/webhooks/dead-letters
+//
+// Only deliveries for sessions created with the caller's API key are listed,
+// most recently failed first. Dead letters are kept for WEBHOOK_DEAD_LETTER_TTL.
+//
+// Returns:
+//   - 200 with {"dead_letters": [...]}
+func (s *Server) listDeadWebhooksHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deliveries, err := s.Store.ListDeadWebhooks(c.GetString(apiKeyIDContextKey))
+		if err != nil {
+			log.Error().Err(err).Msg("webhook_controller: failed to list dead letters")
+			jsonError(c, http.StatusInternalServerError, "failed to list dead letters")
+			return
+		}
+		if deliveries == nil {
+			deliveries = []*model.WebhookDelivery{}
+		}
+		c.JSON(http.StatusOK, gin.H{"dead_letters": deliveries})
+	}
+}
@@ -6,9 +6,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/mxcd/go-config/config"
-	handoffws "github.com/mxcd/handoff/internal/ws"
 	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/ws"
 	"github.com/rs/zerolog/log"
 )
 
@@ -16,52 +15,43 @@ var wsUpgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true }, // Allow all origins
 }
 
+const (
+	// wsWriteDeadline bounds writing one message to a WebSocket client.
+	wsWriteDeadline = 10 * time.Second
+	// wsDefaultPingInterval is used when ServerOptions.WSPingInterval is zero.
+	wsDefaultPingInterval = 30 * time.Second
+	// wsMaxMessageSize bounds messages read from clients, which only send
+	// control frames.
+	wsMaxMessageSize = 4096
+)
+
 // wsHandler returns the WebSocket upgrade handler for per-session real-time notifications.
 // GET /api/v1/sessions/:id/ws
 //
 // API key authentication is performed before the WebSocket upgrade.
 // The key may be supplied via the X-API-Key header or the api_key query parameter.
+// With ?since=<seq> the logged events after seq are replayed first; otherwise
+// the current status is sent first.
 func (s *Server) wsHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-
-		// Validate API key before upgrade — supports header and query param.
-		key := c.GetHeader("X-API-Key")
-		if key == "" {
-			key = c.Query("api_key")
-		}
-		if key == "" {
-			jsonError(c, http.StatusUnauthorized, "missing API key")
+		session := s.streamSession(c, id, "ws")
+		if session == nil {
 			return
 		}
-		validKeys := config.Get().StringArray("API_KEYS")
-		valid := false
-		for _, k := range validKeys {
-			if key == k {
-				valid = true
-				break
-			}
-		}
-		if !valid {
-			jsonError(c, http.StatusUnauthorized, "invalid API key")
+		since, ok := sinceQuery(c)
+		if !ok {
 			return
 		}
 
-		// Verify session exists and is not expired.
-		session, err := s.Store.GetSession(id)
+		sub := ws.NewStreamSubscriber(streamBuffer)
+		replay, lastSeq, err := s.subscribeSession(session, sub, since)
 		if err != nil {
-			log.Error().Err(err).Str("session_id", id).Msg("ws: failed to get session")
+			log.Error().Err(err).Str("session_id", id).Msg("ws: failed to list events")
 			jsonError(c, http.StatusInternalServerError, "internal error")
 			return
 		}
-		if session == nil {
-			jsonError(c, http.StatusNotFound, "session not found")
-			return
-		}
-		if session.Status == model.SessionStatusExpired {
-			jsonError(c, http.StatusGone, "session expired")
-			return
-		}
+		defer s.Hub.Unsubscribe(id, sub)
 
 		// Upgrade the HTTP connection to WebSocket.
 		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
@@ -70,35 +60,133 @@ func (s *Server) wsHandler() gin.HandlerFunc {
 			log.Debug().Err(err).Str("session_id", id).Msg("ws: upgrade failed")
 			return
 		}
-
-		s.Hub.Subscribe(id, conn)
 		log.Info().Str("session_id", id).Msg("ws: client connected")
+		if err := s.serveWS(conn, sub, replay, lastSeq); err != nil {
+			log.Debug().Err(err).Str("session_id", id).Msg("ws: connection closed")
+		}
+		log.Info().Str("session_id", id).Msg("ws: client disconnected")
+	}
+}
+
+// accountWSHandler returns the WebSocket upgrade handler that streams the
+// events of every session created with the caller's API key, including
+// sessions created after connecting.
+// GET /api/v1/events/ws
+//
+// Query parameters (all optional):
+//   - action_type: comma-separated action types to match
+//   - metadata.<key>: metadata value to match; repeat for several keys
+//
+// Messages are the same as on the per-session endpoint. No initial status is
+// sent, and events that occur while the client is disconnected are not replayed.
+//
+// Returns:
+//   - 101 on a successful upgrade
+//   - 400 on an unknown action type
+//   - 401 if the API key is missing or invalid
+func (s *Server) accountWSHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKeyID, ok := streamAPIKeyID(c)
+		if !ok {
+			return
+		}
 
-		// Send the current status immediately so the client knows where things stand.
-		initialMsg := handoffws.WSMessage{
-			Type:      "status_update",
-			SessionID: id,
-			Status:    string(session.Status),
-			Timestamp: time.Now(),
+		filter := ws.AccountFilter{Metadata: metadataQuery(c)}
+		for _, v := range splitQuery(c.Query("action_type")) {
+			actionType, err := model.ValidateSessionActionType(v)
+			if err != nil {
+				jsonError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			filter.ActionTypes = append(filter.ActionTypes, string(actionType))
 		}
-		if writeErr := conn.WriteJSON(initialMsg); writeErr != nil {
-			log.Debug().Err(writeErr).Str("session_id", id).Msg("ws: failed to send initial status")
-			s.Hub.Unsubscribe(id, conn)
-			conn.Close()
+
+		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// Upgrader already wrote an error response.
+			log.Debug().Err(err).Str("api_key_id", apiKeyID).Msg("ws: account upgrade failed")
 			return
 		}
 
-		// Read loop — block until the client disconnects.
+		sub := ws.NewStreamSubscriber(streamBuffer)
+		s.Hub.SubscribeAccount(apiKeyID, sub, filter)
+		log.Info().Str("api_key_id", apiKeyID).Msg("ws: account client connected")
 		defer func() {
-			s.Hub.Unsubscribe(id, conn)
-			conn.Close()
-			log.Info().Str("session_id", id).Msg("ws: client disconnected")
+			s.Hub.UnsubscribeAccount(apiKeyID, sub)
+			log.Info().Str("api_key_id", apiKeyID).Msg("ws: account client disconnected")
 		}()
+
+		if err := s.serveWS(conn, sub, nil, 0); err != nil {
+			log.Debug().Err(err).Str("api_key_id", apiKeyID).Msg("ws: account connection closed")
+		}
+	}
+}
+
+// serveWS writes replay and then the live messages of sub to conn until the
+// subscription ends or the client goes away, and closes conn. The client is
+// pinged every ping interval; one that sends nothing, not even a pong, for two
+// intervals is treated as gone, so half-open connections do not linger.
+func (s *Server) serveWS(conn *websocket.Conn, sub *ws.StreamSubscriber, replay []ws.WSMessage, lastSeq uint64) error {
+	defer conn.Close()
+
+	pingInterval := s.Options.WSPingInterval
+	if pingInterval <= 0 {
+		pingInterval = wsDefaultPingInterval
+	}
+	pongWait := 2 * pingInterval
+
+	// Read loop — closes disconnected when the client goes away or misses
+	// its pongs. Reading also processes the pongs.
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	disconnected := make(chan struct{})
+	var readErr error
+	go func() {
+		defer close(disconnected)
 		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				break // client disconnected or error
+			if _, _, err := conn.ReadMessage(); err != nil {
+				readErr = err
+				return // client disconnected, timed out or error
 			}
+			_ = conn.SetReadDeadline(time.Now().Add(pongWait))
 		}
+	}()
+
+	err := forwardEvents(sub, replay, lastSeq, disconnected, func(msg ws.WSMessage) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(wsWriteDeadline)); err != nil {
+			return err
+		}
+		return conn.WriteJSON(msg)
+	}, pingInterval, func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteDeadline))
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case <-disconnected:
+		return readErr
+	default:
+		// The subscription ended first.
+		return nil
 	}
 }
+
+// sessionExpired is registered with stores that report session expiry. It
+// tells WebSocket and event stream subscribers the session is gone and disconnects them.
+func (s *Server) sessionExpired(sessionID string) {
+	go func() {
+		log.Info().Str("session_id", sessionID).Msg("ws: session expired")
+		// The tombstone still carries the caller's correlation fields.
+		session, err := s.Store.GetSession(sessionID)
+		if err != nil || session == nil {
+			session = &model.Session{ID: sessionID}
+		}
+		session.Status = model.SessionStatusExpired
+		s.emitEvent(session, eventExpired, nil)
+		s.Hub.CloseSession(sessionID)
+	}()
+}
@@ -0,0 +1,529 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	diskFilesDir     = "files"
+	diskScanPagesDir = "scanpages"
+	diskDataExt      = ".bin"
+	diskMetaExt      = ".json"
+	diskPagesMeta    = "pages.json"
+)
+
+// diskIDPattern restricts IDs used as path components; anything else cannot
+// have been issued by the server and is treated as "not found".
+var diskIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// DiskFileStore keeps result files and scan pages on the local filesystem so
+// large payloads do not sit in the heap. Each file is a payload plus a JSON
+// sidecar holding its content type, ETag, expiry and the name of the payload;
+// a janitor goroutine deletes expired entries every minute.
+//
+// Layout below the root directory:
+//
+//	files/{downloadID}.{hash}.bin, files/{downloadID}.json
+//	scanpages/{sessionID}/pages.json, scanpages/{sessionID}/{seq}.bin
+//
+// Payloads are named after their content, so overwriting a file writes a new
+// payload and then switches the sidecar to it in a single rename: a reader
+// sees either the old file or the new one, never one's payload with the
+// other's metadata.
+type DiskFileStore struct {
+	root    string
+	pagesMu sync.Mutex // serialises scan page appends
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+var _ FileStore = (*DiskFileStore)(nil)
+
+// diskFileMeta is the JSON sidecar stored next to each result file.
+type diskFileMeta struct {
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	ETag        string    `json:"etag"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Payload is the name of the payload file in the files directory. Files
+	// stored before payloads were named after their content leave it empty
+	// and use {downloadID}.bin.
+	Payload string `json:"payload,omitempty"`
+}
+
+// payloadPath returns the path of the payload described by meta.
+func (s *DiskFileStore) payloadPath(downloadID string, meta *diskFileMeta) string {
+	if meta.Payload == "" {
+		data, _ := s.filePaths(downloadID)
+		return data
+	}
+	return filepath.Join(s.root, diskFilesDir, filepath.Base(meta.Payload))
+}
+
+// diskPagesMetaFile is the JSON index of a session's scan pages, in upload order.
+type diskPagesMetaFile struct {
+	ExpiresAt time.Time          `json:"expires_at"`
+	Pages     []diskScanPageMeta `json:"pages"`
+}
+
+// diskScanPageMeta describes one scan page; its payload lives in {Seq}.bin.
+type diskScanPageMeta struct {
+	Seq           int    `json:"seq"`
+	DocumentIndex int    `json:"document_index"`
+	PageIndex     int    `json:"page_index"`
+	ContentType   string `json:"content_type"`
+}
+
+// NewDiskFileStore creates the directory layout under root and starts the
+// expiry janitor. Call Close to stop the janitor.
+func NewDiskFileStore(root string) (*DiskFileStore, error) {
+	for _, dir := range []string{filepath.Join(root, diskFilesDir), filepath.Join(root, diskScanPagesDir)} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("create file store directory %q: %w", dir, err)
+		}
+	}
+
+	s := &DiskFileStore{
+		root: root,
+		stop: make(chan struct{}),
+	}
+	go s.janitor()
+
+	log.Info().Str("dir", root).Msg("store: using disk file store")
+	return s, nil
+}
+
+// Close stops the expiry janitor. Stored files are left on disk.
+func (s *DiskFileStore) Close() error {
+	s.stopped.Do(func() { close(s.stop) })
+	return nil
+}
+
+// filePaths returns the legacy payload path and the sidecar path for a download ID.
+func (s *DiskFileStore) filePaths(downloadID string) (data, meta string) {
+	base := filepath.Join(s.root, diskFilesDir, downloadID)
+	return base + diskDataExt, base + diskMetaExt
+}
+
+// pagesDir returns the directory holding a session's scan pages.
+func (s *DiskFileStore) pagesDir(sessionID string) string {
+	return filepath.Join(s.root, diskScanPagesDir, sessionID)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place so
+// readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readJSON decodes the JSON file at path into v.
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// StoreFile writes the payload and its sidecar to disk. The file expires after ttl.
+func (s *DiskFileStore) StoreFile(downloadID string, data []byte, contentType string, ttl time.Duration) error {
+	if !diskIDPattern.MatchString(downloadID) {
+		return fmt.Errorf("invalid download ID %q", downloadID)
+	}
+	log.Debug().Str("download_id", downloadID).Dur("ttl", ttl).Int("bytes", len(data)).Str("content_type", contentType).Msg("store: storing file")
+
+	now := time.Now()
+	etag := fileETag(data)
+	meta := diskFileMeta{
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		ETag:        etag,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+		Payload:     downloadID + "." + strings.Trim(etag, `"`) + diskDataExt,
+	}
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	_, metaPath := s.filePaths(downloadID)
+	var previous diskFileMeta
+	hadPrevious := readJSON(metaPath, &previous) == nil
+
+	// Payload first: a sidecar without its payload would be served as a broken
+	// file. The sidecar rename then switches readers over to the new payload.
+	dataPath := s.payloadPath(downloadID, &meta)
+	if err := writeFileAtomic(dataPath, data); err != nil {
+		return fmt.Errorf("write file %q: %w", downloadID, err)
+	}
+	if err := writeFileAtomic(metaPath, encoded); err != nil {
+		if !hadPrevious || s.payloadPath(downloadID, &previous) != dataPath {
+			os.Remove(dataPath)
+		}
+		return fmt.Errorf("write file metadata %q: %w", downloadID, err)
+	}
+	if hadPrevious {
+		if old := s.payloadPath(downloadID, &previous); old != dataPath {
+			os.Remove(old)
+		}
+	}
+	return nil
+}
+
+// OpenFile opens the payload for streaming. Returns nil if the file has
+// expired or was never stored; expired files are removed on access.
+func (s *DiskFileStore) OpenFile(downloadID string) (*StoredFile, error) {
+	if !diskIDPattern.MatchString(downloadID) {
+		return nil, nil
+	}
+	_, metaPath := s.filePaths(downloadID)
+
+	var meta diskFileMeta
+	var f *os.File
+	// A second try covers a payload removed by an overwrite between reading
+	// the sidecar and opening the payload it named.
+	for attempt := 0; f == nil; attempt++ {
+		meta = diskFileMeta{}
+		if err := readJSON(metaPath, &meta); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Debug().Str("download_id", downloadID).Msg("store: file not found or expired")
+				return nil, nil
+			}
+			return nil, fmt.Errorf("read file metadata %q: %w", downloadID, err)
+		}
+		if time.Now().After(meta.ExpiresAt) {
+			s.removeFile(downloadID)
+			log.Debug().Str("download_id", downloadID).Msg("store: file not found or expired")
+			return nil, nil
+		}
+
+		var err error
+		f, err = os.Open(s.payloadPath(downloadID, &meta))
+		if errors.Is(err, fs.ErrNotExist) && attempt == 0 {
+			continue
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("open file %q: %w", downloadID, err)
+		}
+	}
+
+	log.Debug().Str("download_id", downloadID).Int64("bytes", meta.Size).Msg("store: file retrieved")
+	return &StoredFile{
+		Content:     f,
+		ContentType: meta.ContentType,
+		Size:        meta.Size,
+		ModTime:     meta.CreatedAt,
+		ETag:        meta.ETag,
+	}, nil
+}
+
+// removeFile deletes a file's sidecar and payload. The sidecar goes first so a
+// concurrent OpenFile sees "not found" rather than a missing payload.
+func (s *DiskFileStore) removeFile(downloadID string) error {
+	_, metaPath := s.filePaths(downloadID)
+	var meta diskFileMeta
+	readJSON(metaPath, &meta)
+	if err := os.Remove(metaPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(s.payloadPath(downloadID, &meta)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// DeleteFile removes the payload and sidecar of the file stored under downloadID.
+func (s *DiskFileStore) DeleteFile(downloadID string) error {
+	if !diskIDPattern.MatchString(downloadID) {
+		return nil
+	}
+	if err := s.removeFile(downloadID); err != nil {
+		return fmt.Errorf("delete file %q: %w", downloadID, err)
+	}
+	log.Debug().Str("download_id", downloadID).Msg("store: file deleted")
+	return nil
+}
+
+// readPagesMeta loads a session's scan page index. A missing or expired index
+// yields an empty one.
+func (s *DiskFileStore) readPagesMeta(sessionID string) (*diskPagesMetaFile, error) {
+	var meta diskPagesMetaFile
+	err := readJSON(filepath.Join(s.pagesDir(sessionID), diskPagesMeta), &meta)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &diskPagesMetaFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read scan page index for session %q: %w", sessionID, err)
+	}
+	if time.Now().After(meta.ExpiresAt) {
+		return &diskPagesMetaFile{}, nil
+	}
+	return &meta, nil
+}
+
+// AddScanPage writes the page payload and appends it to the session's page
+// index. The index TTL is reset to ttl, matching the session's remaining TTL.
+func (s *DiskFileStore) AddScanPage(sessionID string, page ScanPageData, ttl time.Duration) error {
+	if !diskIDPattern.MatchString(sessionID) {
+		return fmt.Errorf("invalid session ID %q", sessionID)
+	}
+
+	s.pagesMu.Lock()
+	defer s.pagesMu.Unlock()
+
+	dir := s.pagesDir(sessionID)
+	meta, err := s.readPagesMeta(sessionID)
+	if err != nil {
+		return err
+	}
+	if len(meta.Pages) == 0 {
+		// Start from a clean directory in case an expired session left pages behind.
+		os.RemoveAll(dir)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create scan page directory: %w", err)
+	}
+
+	seq := len(meta.Pages)
+	if err := writeFileAtomic(filepath.Join(dir, fmt.Sprintf("%06d%s", seq, diskDataExt)), page.Data); err != nil {
+		return fmt.Errorf("write scan page for session %q: %w", sessionID, err)
+	}
+
+	meta.ExpiresAt = time.Now().Add(ttl)
+	meta.Pages = append(meta.Pages, diskScanPageMeta{
+		Seq:           seq,
+		DocumentIndex: page.DocumentIndex,
+		PageIndex:     page.PageIndex,
+		ContentType:   page.ContentType,
+	})
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, diskPagesMeta), data); err != nil {
+		return fmt.Errorf("write scan page index for session %q: %w", sessionID, err)
+	}
+
+	log.Debug().Str("session_id", sessionID).Int("document_index", page.DocumentIndex).Int("page_index", page.PageIndex).Int("total_pages", len(meta.Pages)).Msg("store: scan page added")
+	return nil
+}
+
+// TouchScanPages moves the expiry in the session's page index.
+func (s *DiskFileStore) TouchScanPages(sessionID string, ttl time.Duration) error {
+	if !diskIDPattern.MatchString(sessionID) {
+		return nil
+	}
+	s.pagesMu.Lock()
+	defer s.pagesMu.Unlock()
+
+	meta, err := s.readPagesMeta(sessionID)
+	if err != nil {
+		return err
+	}
+	if len(meta.Pages) == 0 {
+		return nil
+	}
+	meta.ExpiresAt = time.Now().Add(ttl)
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.pagesDir(sessionID), diskPagesMeta), data); err != nil {
+		return fmt.Errorf("write scan page index for session %q: %w", sessionID, err)
+	}
+	log.Debug().Str("session_id", sessionID).Dur("ttl", ttl).Msg("store: scan pages touched")
+	return nil
+}
+
+// GetScanPages reads all accumulated scan pages for a session into memory.
+func (s *DiskFileStore) GetScanPages(sessionID string) ([]ScanPageData, error) {
+	if !diskIDPattern.MatchString(sessionID) {
+		return nil, nil
+	}
+	meta, err := s.readPagesMeta(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta.Pages) == 0 {
+		return nil, nil
+	}
+
+	dir := s.pagesDir(sessionID)
+	pages := make([]ScanPageData, 0, len(meta.Pages))
+	for _, p := range meta.Pages {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%06d%s", p.Seq, diskDataExt)))
+		if err != nil {
+			return nil, fmt.Errorf("read scan page %d for session %q: %w", p.Seq, sessionID, err)
+		}
+		pages = append(pages, ScanPageData{
+			DocumentIndex: p.DocumentIndex,
+			PageIndex:     p.PageIndex,
+			Data:          data,
+			ContentType:   p.ContentType,
+		})
+	}
+	return pages, nil
+}
+
+// GetScanPageCount returns the number of accumulated scan pages for a session.
+func (s *DiskFileStore) GetScanPageCount(sessionID string) (int, error) {
+	if !diskIDPattern.MatchString(sessionID) {
+		return 0, nil
+	}
+	meta, err := s.readPagesMeta(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	return len(meta.Pages), nil
+}
+
+// ClearScanPages removes all accumulated scan pages for a session.
+func (s *DiskFileStore) ClearScanPages(sessionID string) error {
+	if !diskIDPattern.MatchString(sessionID) {
+		return nil
+	}
+	s.pagesMu.Lock()
+	defer s.pagesMu.Unlock()
+
+	if err := os.RemoveAll(s.pagesDir(sessionID)); err != nil {
+		return fmt.Errorf("clear scan pages for session %q: %w", sessionID, err)
+	}
+	log.Debug().Str("session_id", sessionID).Msg("store: scan pages cleared")
+	return nil
+}
+
+// janitor periodically removes expired files and scan pages until Close is called.
+func (s *DiskFileStore) janitor() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
+
+// sweep deletes every expired file and scan page directory.
+func (s *DiskFileStore) sweep() {
+	now := time.Now()
+	removedFiles, removedPages := 0, 0
+
+	entries, err := os.ReadDir(filepath.Join(s.root, diskFilesDir))
+	if err != nil {
+		log.Warn().Err(err).Msg("store: disk sweep failed to list files")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, diskMetaExt) {
+			continue
+		}
+		downloadID := strings.TrimSuffix(name, diskMetaExt)
+		var meta diskFileMeta
+		if err := readJSON(filepath.Join(s.root, diskFilesDir, name), &meta); err != nil || now.After(meta.ExpiresAt) {
+			s.removeFile(downloadID)
+			removedFiles++
+		}
+	}
+
+	sessions, err := os.ReadDir(filepath.Join(s.root, diskScanPagesDir))
+	if err != nil {
+		log.Warn().Err(err).Msg("store: disk sweep failed to list scan pages")
+	}
+	s.pagesMu.Lock()
+	for _, entry := range sessions {
+		if !entry.IsDir() {
+			continue
+		}
+		var meta diskPagesMetaFile
+		path := filepath.Join(s.root, diskScanPagesDir, entry.Name(), diskPagesMeta)
+		if err := readJSON(path, &meta); err != nil || now.After(meta.ExpiresAt) {
+			os.RemoveAll(filepath.Join(s.root, diskScanPagesDir, entry.Name()))
+			removedPages++
+		}
+	}
+	s.pagesMu.Unlock()
+
+	removeOrphanPayloads(filepath.Join(s.root, diskFilesDir), now)
+
+	if removedFiles > 0 || removedPages > 0 {
+		log.Debug().Int("files", removedFiles).Int("scan_sessions", removedPages).Msg("store: disk sweep removed expired entries")
+	}
+}
+
+// removeOrphanPayloads deletes payloads and temp files no sidecar names, left
+// behind by a crash between the two writes in StoreFile or by concurrent
+// overwrites of the same file. Only entries older than a cleanup interval are
+// touched so in-flight writes are not disturbed.
+func removeOrphanPayloads(dir string, now time.Time) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	referenced := make(map[string]bool)
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, diskMetaExt) {
+			continue
+		}
+		var meta diskFileMeta
+		if readJSON(filepath.Join(dir, name), &meta) != nil {
+			continue
+		}
+		if meta.Payload == "" {
+			referenced[strings.TrimSuffix(name, diskMetaExt)+diskDataExt] = true
+		} else {
+			referenced[filepath.Base(meta.Payload)] = true
+		}
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		orphan := false
+		switch {
+		case strings.HasPrefix(name, ".tmp-"):
+			orphan = true
+		case strings.HasSuffix(name, diskDataExt):
+			orphan = !referenced[name]
+		}
+		if !orphan {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err == nil && now.Sub(info.ModTime()) > cleanupInterval {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
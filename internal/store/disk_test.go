@@ -0,0 +1,162 @@
+package store_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/mxcd/handoff/internal/store/storetest"
+)
+
+func newDiskFileStore(t *testing.T) (*store.DiskFileStore, string) {
+	t.Helper()
+	dir := t.TempDir()
+	st, err := store.NewDiskFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewDiskFileStore: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st, dir
+}
+
+func TestDiskFileStore(t *testing.T) {
+	storetest.TestFileStore(t, func(t *testing.T) store.FileStore {
+		st, _ := newDiskFileStore(t)
+		return st
+	})
+}
+
+func TestDiskFileStoreOverwriteDoesNotTear(t *testing.T) {
+	st, _ := newDiskFileStore(t)
+	id := model.NewSessionID()
+	versions := map[string][]byte{
+		"text/plain":       bytes.Repeat([]byte("a"), 64<<10),
+		"application/json": []byte(`{"b":true}`),
+	}
+	if err := st.StoreFile(id, versions["text/plain"], "text/plain", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			contentType := "text/plain"
+			if i%2 == 0 {
+				contentType = "application/json"
+			}
+			if err := st.StoreFile(id, versions[contentType], contentType, time.Minute); err != nil {
+				t.Errorf("StoreFile: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 500; i++ {
+		f, err := st.OpenFile(id)
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		if f == nil {
+			continue
+		}
+		data, err := f.ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if !bytes.Equal(data, versions[f.ContentType]) || f.Size != int64(len(data)) {
+			t.Fatalf("read %d bytes with content type %s and size %d: payload and metadata of different versions", len(data), f.ContentType, f.Size)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestDiskFileStoreReadsLegacyLayout(t *testing.T) {
+	st, dir := newDiskFileStore(t)
+	id := model.NewSessionID()
+	base := filepath.Join(dir, "files", id)
+	meta := `{"content_type":"text/plain","size":6,"etag":"\"x\"","created_at":"2026-01-01T00:00:00Z","expires_at":"2999-01-01T00:00:00Z"}`
+	if err := os.WriteFile(base+".bin", []byte("legacy"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(base+".json", []byte(meta), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := st.OpenFile(id)
+	if err != nil || f == nil {
+		t.Fatalf("OpenFile: got (%v, %v), want the legacy file", f, err)
+	}
+	data, err := f.ReadAll()
+	if err != nil || string(data) != "legacy" {
+		t.Fatalf("ReadAll: got (%q, %v), want %q", data, err, "legacy")
+	}
+
+	if err := st.DeleteFile(id); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+	if _, err := os.Stat(base + ".bin"); !os.IsNotExist(err) {
+		t.Errorf("legacy payload left behind after DeleteFile: %v", err)
+	}
+}
+
+func TestDiskFileStoreSweep(t *testing.T) {
+	st, dir := newDiskFileStore(t)
+	expired, live := model.NewSessionID(), model.NewSessionID()
+	if err := st.StoreFile(expired, []byte("expired"), "text/plain", time.Millisecond); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+	if err := st.StoreFile(live, []byte("live"), "text/plain", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+	if err := st.AddScanPage(expired, store.ScanPageData{Data: []byte("page"), ContentType: "image/jpeg"}, time.Millisecond); err != nil {
+		t.Fatalf("AddScanPage: %v", err)
+	}
+
+	// A payload without a sidecar, as left by a crash, is removed once it is
+	// older than the janitor interval.
+	orphan := filepath.Join(dir, "files", model.NewSessionID()+".bin")
+	if err := os.WriteFile(orphan, []byte("orphan"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	store.DiskSweep(st)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("files after sweep = %v, want the live file's payload and sidecar", names)
+	}
+	if f, err := st.OpenFile(live); err != nil || f == nil {
+		t.Errorf("OpenFile(live) after sweep: got (%v, %v)", f, err)
+	} else {
+		f.Close()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "scanpages", expired)); !os.IsNotExist(err) {
+		t.Errorf("expired scan pages left behind after sweep: %v", err)
+	}
+}
@@ -0,0 +1,418 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// envelopeMagic prefixes every encrypted payload and versions the layout.
+	envelopeMagic = "HOE1"
+	// dataKeySize is the length of per-payload AES-256 data keys and of master keys.
+	dataKeySize = 32
+	// encChunkSize is the plaintext length of each separately sealed chunk.
+	encChunkSize = 64 << 10
+	// noncePrefixSize is the random part of each chunk nonce; the remaining
+	// five bytes hold the chunk index and the final-chunk flag.
+	noncePrefixSize = 7
+)
+
+// Keyring holds the master keys used to wrap per-payload data keys. New
+// payloads are sealed with the active key; any key in the ring can open
+// payloads written earlier, so master keys can be rotated by adding a new key,
+// making it active and removing the old one once its payloads have expired.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewKeyring creates a Keyring from 32-byte master keys indexed by key ID.
+func NewKeyring(activeID string, keys map[string][]byte) (*Keyring, error) {
+	kr := &Keyring{active: activeID, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("invalid encryption key ID %q", id)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes, got %d", id, dataKeySize, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[id] = aead
+	}
+	if _, ok := kr.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not in the keyring", activeID)
+	}
+	return kr, nil
+}
+
+// ParseKeyring builds a Keyring from "id:base64key" entries as found in the
+// ENCRYPTION_KEYS config. An empty activeID selects the first entry.
+func ParseKeyring(entries []string, activeID string) (*Keyring, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("no encryption keys configured")
+	}
+	keys := make(map[string][]byte, len(entries))
+	for i, entry := range entries {
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("encryption key entry %d: expected 'id:base64key'", i)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("duplicate encryption key ID %q", id)
+		}
+		keys[id] = key
+		if activeID == "" && i == 0 {
+			activeID = id
+		}
+	}
+	return NewKeyring(activeID, keys)
+}
+
+// newGCM returns an AES-GCM AEAD for key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under a fresh data key wrapped by the active master
+// key. aad binds the payload to where it is stored so ciphertexts cannot be
+// swapped between download IDs, sessions or pages.
+//
+// The plaintext is sealed in chunks of encChunkSize so it can be decrypted as
+// a stream. Each chunk's nonce is the payload's nonce prefix, the chunk index
+// and a final-chunk flag, so chunks cannot be reordered, dropped or appended.
+//
+// Layout: magic | len(keyID) | keyID | wrapNonce | wrappedKey | noncePrefix | chunk...
+func (kr *Keyring) seal(plaintext []byte, aad string) ([]byte, error) {
+	master := kr.keys[kr.active]
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(envelopeMagic)+1+len(kr.active))
+	header = append(header, envelopeMagic...)
+	header = append(header, byte(len(kr.active)))
+	header = append(header, kr.active...)
+
+	wrapNonce := make([]byte, master.NonceSize())
+	if _, err := rand.Read(wrapNonce); err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	chunks := (len(plaintext) + encChunkSize - 1) / encChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	out := make([]byte, 0, len(header)+len(wrapNonce)+dataKeySize+master.Overhead()+len(prefix)+len(plaintext)+chunks*data.Overhead())
+	out = append(out, header...)
+	out = append(out, wrapNonce...)
+	out = master.Seal(out, wrapNonce, dataKey, header)
+	out = append(out, prefix...)
+	for i := 0; i < chunks; i++ {
+		chunk := plaintext[i*encChunkSize : min((i+1)*encChunkSize, len(plaintext))]
+		out = data.Seal(out, chunkNonce(prefix, int64(i), i == chunks-1), chunk, []byte(aad))
+	}
+	return out, nil
+}
+
+// open reverses seal using whichever master key the payload names.
+func (kr *Keyring) open(envelope []byte, aad string) ([]byte, error) {
+	r, err := kr.newDecryptReader(nopReadSeekCloser{bytes.NewReader(envelope)}, int64(len(envelope)), aad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// chunkNonce returns the GCM nonce for chunk index of a payload.
+func chunkNonce(prefix []byte, index int64, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], uint32(index))
+	if last {
+		nonce[noncePrefixSize+4] = 1
+	}
+	return nonce
+}
+
+// decryptReader decrypts a sealed payload one chunk at a time. It seeks in
+// plaintext offsets, so downloads keep supporting Range requests without the
+// payload being held in memory.
+type decryptReader struct {
+	src    io.ReadSeekCloser
+	data   cipher.AEAD
+	prefix []byte
+	aad    []byte
+
+	bodyOffset int64 // ciphertext offset of the first chunk
+	bodySize   int64 // ciphertext length of all chunks
+	chunks     int64
+	size       int64 // plaintext length
+
+	pos      int64 // plaintext read position
+	srcPos   int64 // ciphertext position of src
+	chunk    []byte
+	chunkIdx int64 // index of the chunk held in chunk, -1 if none
+}
+
+// newDecryptReader reads the envelope header from src, which holds size bytes
+// of sealed payload positioned at the start, and unwraps the data key. Chunks
+// are decrypted as they are read.
+func (kr *Keyring) newDecryptReader(src io.ReadSeekCloser, size int64, aad string) (*decryptReader, error) {
+	prefix := make([]byte, len(envelopeMagic)+1)
+	if _, err := io.ReadFull(src, prefix); err != nil || string(prefix[:len(envelopeMagic)]) != envelopeMagic {
+		return nil, errors.New("payload is not encrypted")
+	}
+	header := make([]byte, len(prefix)+int(prefix[len(envelopeMagic)]))
+	copy(header, prefix)
+	if _, err := io.ReadFull(src, header[len(prefix):]); err != nil {
+		return nil, errors.New("truncated encryption header")
+	}
+	keyID := string(header[len(prefix):])
+
+	master, ok := kr.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+
+	wrapped := make([]byte, master.NonceSize()+dataKeySize+master.Overhead()+noncePrefixSize)
+	if _, err := io.ReadFull(src, wrapped); err != nil {
+		return nil, errors.New("truncated wrapped data key")
+	}
+	wrappedEnd := len(wrapped) - noncePrefixSize
+	dataKey, err := master.Open(nil, wrapped[:master.NonceSize()], wrapped[master.NonceSize():wrappedEnd], header)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &decryptReader{
+		src:        src,
+		data:       data,
+		prefix:     wrapped[wrappedEnd:],
+		aad:        []byte(aad),
+		bodyOffset: int64(len(header) + len(wrapped)),
+		chunkIdx:   -1,
+	}
+	r.srcPos = r.bodyOffset
+	r.bodySize = size - r.bodyOffset
+
+	sealedChunk := int64(encChunkSize + data.Overhead())
+	r.chunks = (r.bodySize + sealedChunk - 1) / sealedChunk
+	if r.chunks == 0 || r.bodySize-(r.chunks-1)*sealedChunk < int64(data.Overhead()) {
+		return nil, errors.New("truncated payload")
+	}
+	r.size = r.bodySize - r.chunks*int64(data.Overhead())
+
+	// Every chunk is bound to aad, so decrypting the first one up front
+	// reports a payload stored under the wrong ID before any byte is served.
+	if err := r.load(0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Read decrypts from the current position, loading the chunk it falls in.
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	idx := r.pos / encChunkSize
+	if idx != r.chunkIdx {
+		if err := r.load(idx); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk[r.pos-idx*encChunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+// load reads and decrypts chunk idx.
+func (r *decryptReader) load(idx int64) error {
+	sealedChunk := int64(encChunkSize + r.data.Overhead())
+	offset := r.bodyOffset + idx*sealedChunk
+	if offset != r.srcPos {
+		if _, err := r.src.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		r.srcPos = offset
+	}
+	sealed := make([]byte, min(sealedChunk, r.bodyOffset+r.bodySize-offset))
+	n, err := io.ReadFull(r.src, sealed)
+	r.srcPos += int64(n)
+	if err != nil {
+		return fmt.Errorf("read encrypted chunk %d: %w", idx, err)
+	}
+	chunk, err := r.data.Open(r.chunk[:0], chunkNonce(r.prefix, idx, idx == r.chunks-1), sealed, r.aad)
+	if err != nil {
+		r.chunkIdx = -1
+		return fmt.Errorf("decrypt payload: %w", err)
+	}
+	r.chunk, r.chunkIdx = chunk, idx
+	return nil
+}
+
+// Seek moves the plaintext read position.
+func (r *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close closes the sealed payload reader.
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
+
+// EncryptedFileStore encrypts result files and scan pages before handing them
+// to another FileStore, and decrypts them again on the way out. Content types
+// and page indices stay in plaintext; only payload bytes are encrypted.
+//
+// Payloads must be decrypted by the server, so an EncryptedFileStore never
+// exposes the wrapped store's presigned URLs.
+type EncryptedFileStore struct {
+	next FileStore
+	keys *Keyring
+}
+
+var _ FileStore = (*EncryptedFileStore)(nil)
+
+// NewEncryptedFileStore wraps next so every payload is sealed with keys.
+func NewEncryptedFileStore(next FileStore, keys *Keyring) *EncryptedFileStore {
+	return &EncryptedFileStore{next: next, keys: keys}
+}
+
+// fileAAD and scanPageAAD scope ciphertexts to their storage location.
+func fileAAD(downloadID string) string { return "file:" + downloadID }
+func scanPageAAD(sessionID string, page ScanPageData) string {
+	return fmt.Sprintf("scanpage:%s:%d:%d", sessionID, page.DocumentIndex, page.PageIndex)
+}
+
+// StoreFile encrypts data and stores it in the wrapped store.
+func (s *EncryptedFileStore) StoreFile(downloadID string, data []byte, contentType string, ttl time.Duration) error {
+	sealed, err := s.keys.seal(data, fileAAD(downloadID))
+	if err != nil {
+		return fmt.Errorf("encrypt file %q: %w", downloadID, err)
+	}
+	return s.next.StoreFile(downloadID, sealed, contentType, ttl)
+}
+
+// OpenFile opens the file stored under downloadID for streaming. The payload
+// is decrypted chunk by chunk as it is read; a later chunk that fails to
+// authenticate surfaces as a read error.
+func (s *EncryptedFileStore) OpenFile(downloadID string) (*StoredFile, error) {
+	f, err := s.next.OpenFile(downloadID)
+	if err != nil || f == nil {
+		return f, err
+	}
+	r, err := s.keys.newDecryptReader(f.Content, f.Size, fileAAD(downloadID))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("decrypt file %q: %w", downloadID, err)
+	}
+	log.Debug().Str("download_id", downloadID).Int64("bytes", r.size).Msg("store: file opened for decryption")
+	return &StoredFile{
+		Content:     r,
+		ContentType: f.ContentType,
+		Size:        r.size,
+		ModTime:     f.ModTime,
+		ETag:        f.ETag,
+	}, nil
+}
+
+// DeleteFile delegates to the wrapped store.
+func (s *EncryptedFileStore) DeleteFile(downloadID string) error {
+	return s.next.DeleteFile(downloadID)
+}
+
+// AddScanPage encrypts the page payload and appends it in the wrapped store.
+func (s *EncryptedFileStore) AddScanPage(sessionID string, page ScanPageData, ttl time.Duration) error {
+	sealed, err := s.keys.seal(page.Data, scanPageAAD(sessionID, page))
+	if err != nil {
+		return fmt.Errorf("encrypt scan page: %w", err)
+	}
+	page.Data = sealed
+	return s.next.AddScanPage(sessionID, page, ttl)
+}
+
+// GetScanPages returns the session's scan pages with their payloads decrypted.
+func (s *EncryptedFileStore) GetScanPages(sessionID string) ([]ScanPageData, error) {
+	pages, err := s.next.GetScanPages(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ScanPageData, len(pages))
+	for i, page := range pages {
+		data, err := s.keys.open(page.Data, scanPageAAD(sessionID, page))
+		if err != nil {
+			return nil, fmt.Errorf("decrypt scan page %d: %w", i, err)
+		}
+		page.Data = data
+		out[i] = page
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// GetScanPageCount delegates to the wrapped store.
+func (s *EncryptedFileStore) GetScanPageCount(sessionID string) (int, error) {
+	return s.next.GetScanPageCount(sessionID)
+}
+
+// ClearScanPages delegates to the wrapped store.
+func (s *EncryptedFileStore) ClearScanPages(sessionID string) error {
+	return s.next.ClearScanPages(sessionID)
+}
+
+// TouchScanPages delegates to the wrapped store.
+func (s *EncryptedFileStore) TouchScanPages(sessionID string, ttl time.Duration) error {
+	return s.next.TouchScanPages(sessionID, ttl)
+}
@@ -0,0 +1,291 @@
+package store_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/mxcd/handoff/internal/store/storetest"
+)
+
+// chunk matches the encrypted store's plaintext chunk size.
+const chunk = 64 << 10
+
+func TestEncryptedFileStore(t *testing.T) {
+	kr := newKeyring(t, "k1", map[string][]byte{"k1": newKey(t)})
+	storetest.TestFileStore(t, func(t *testing.T) store.FileStore {
+		return store.NewEncryptedFileStore(store.NewMemoryStore(), kr)
+	})
+}
+
+func TestEncryptedFileStoreRoundTrip(t *testing.T) {
+	kr := newKeyring(t, "k1", map[string][]byte{"k1": newKey(t)})
+	for name, size := range map[string]int{
+		"empty":           0,
+		"one chunk":       chunk,
+		"partial chunk":   chunk + 1,
+		"several chunks":  3*chunk + 100,
+		"less than chunk": 10,
+	} {
+		t.Run(name, func(t *testing.T) {
+			raw := store.NewMemoryStore()
+			enc := store.NewEncryptedFileStore(raw, kr)
+			data := randomBytes(t, size)
+			if err := enc.StoreFile("f1", data, "application/pdf", time.Minute); err != nil {
+				t.Fatalf("StoreFile: %v", err)
+			}
+
+			if size > 0 {
+				sealed := readAll(t, raw, "f1")
+				if bytes.Contains(sealed, data[:min(size, 64)]) {
+					t.Error("wrapped store holds the plaintext")
+				}
+			}
+
+			f := openFile(t, enc, "f1")
+			if f.Size != int64(size) || f.ContentType != "application/pdf" {
+				t.Errorf("OpenFile: got size %d, type %q", f.Size, f.ContentType)
+			}
+			got, err := f.ReadAll()
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("ReadAll: got %d bytes, %v; want %d bytes", len(got), err, size)
+			}
+		})
+	}
+}
+
+func TestEncryptedFileStoreSeek(t *testing.T) {
+	kr := newKeyring(t, "k1", map[string][]byte{"k1": newKey(t)})
+	enc := store.NewEncryptedFileStore(store.NewMemoryStore(), kr)
+	data := randomBytes(t, 3*chunk+100)
+	if err := enc.StoreFile("f1", data, "application/pdf", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+
+	f := openFile(t, enc, "f1")
+	defer f.Close()
+	for _, r := range []struct{ offset, length int }{
+		{2*chunk - 10, 20},        // across a chunk boundary
+		{10, 5},                   // back to the first chunk
+		{3 * chunk, 100},          // the short final chunk
+		{chunk, chunk},            // one whole chunk
+		{len(data) - 1, 1},        // the last byte
+		{chunk / 2, 2*chunk + 10}, // several chunks
+	} {
+		if _, err := f.Content.Seek(int64(r.offset), io.SeekStart); err != nil {
+			t.Fatalf("Seek(%d): %v", r.offset, err)
+		}
+		got := make([]byte, r.length)
+		if _, err := io.ReadFull(f.Content, got); err != nil {
+			t.Fatalf("read %d bytes at %d: %v", r.length, r.offset, err)
+		}
+		if !bytes.Equal(got, data[r.offset:r.offset+r.length]) {
+			t.Errorf("read %d bytes at %d: content differs", r.length, r.offset)
+		}
+	}
+	if end, err := f.Content.Seek(0, io.SeekEnd); err != nil || end != int64(len(data)) {
+		t.Errorf("Seek to end: got (%d, %v), want %d", end, err, len(data))
+	}
+}
+
+func TestEncryptedFileStoreKeyRotation(t *testing.T) {
+	oldKey, newKey := newKey(t), newKey(t)
+	raw := store.NewMemoryStore()
+
+	before := store.NewEncryptedFileStore(raw, newKeyring(t, "old", map[string][]byte{"old": oldKey}))
+	if err := before.StoreFile("f1", []byte("sealed with old"), "text/plain", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+	if err := before.AddScanPage("s1", store.ScanPageData{PageIndex: 0, Data: []byte("page"), ContentType: "image/jpeg"}, time.Minute); err != nil {
+		t.Fatalf("AddScanPage: %v", err)
+	}
+
+	rotated := store.NewEncryptedFileStore(raw, newKeyring(t, "new", map[string][]byte{"new": newKey, "old": oldKey}))
+	if got := readAll(t, rotated, "f1"); string(got) != "sealed with old" {
+		t.Errorf("old file after rotation: got %q", got)
+	}
+	pages, err := rotated.GetScanPages("s1")
+	if err != nil || len(pages) != 1 || string(pages[0].Data) != "page" {
+		t.Errorf("old scan page after rotation: got (%v, %v)", pages, err)
+	}
+	if err := rotated.StoreFile("f2", []byte("sealed with new"), "text/plain", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+
+	// Once the old key is removed, only payloads sealed with the new key open.
+	retired := store.NewEncryptedFileStore(raw, newKeyring(t, "new", map[string][]byte{"new": newKey}))
+	if got := readAll(t, retired, "f2"); string(got) != "sealed with new" {
+		t.Errorf("new file without the old key: got %q", got)
+	}
+	if f, err := retired.OpenFile("f1"); err == nil {
+		f.Close()
+		t.Error("OpenFile: old file opened without the old key")
+	}
+}
+
+func TestEncryptedFileStoreWrongKey(t *testing.T) {
+	raw := store.NewMemoryStore()
+	enc := store.NewEncryptedFileStore(raw, newKeyring(t, "k1", map[string][]byte{"k1": newKey(t)}))
+	if err := enc.StoreFile("f1", []byte("secret"), "text/plain", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+	if err := enc.AddScanPage("s1", store.ScanPageData{Data: []byte("page")}, time.Minute); err != nil {
+		t.Fatalf("AddScanPage: %v", err)
+	}
+
+	// Same key ID, different key material.
+	wrong := store.NewEncryptedFileStore(raw, newKeyring(t, "k1", map[string][]byte{"k1": newKey(t)}))
+	if f, err := wrong.OpenFile("f1"); err == nil {
+		f.Close()
+		t.Error("OpenFile: opened with the wrong key")
+	}
+	if _, err := wrong.GetScanPages("s1"); err == nil {
+		t.Error("GetScanPages: opened with the wrong key")
+	}
+}
+
+func TestEncryptedFileStoreTamper(t *testing.T) {
+	kr := newKeyring(t, "k1", map[string][]byte{"k1": newKey(t)})
+	data := randomBytes(t, 2*chunk+100)
+	sealed := func(t *testing.T) []byte {
+		raw := store.NewMemoryStore()
+		if err := store.NewEncryptedFileStore(raw, kr).StoreFile("f1", data, "application/pdf", time.Minute); err != nil {
+			t.Fatalf("StoreFile: %v", err)
+		}
+		return readAll(t, raw, "f1")
+	}
+	sealedChunk := chunk + 16
+	body := len(sealed(t)) - len(data) - 3*16 // offset of the first chunk
+
+	for name, tamper := range map[string]func([]byte) []byte{
+		"header":       func(b []byte) []byte { b[5] ^= 1; return b },
+		"wrapped key":  func(b []byte) []byte { b[body-20] ^= 1; return b },
+		"first chunk":  func(b []byte) []byte { b[body+10] ^= 1; return b },
+		"last chunk":   func(b []byte) []byte { b[len(b)-1] ^= 1; return b },
+		"truncated":    func(b []byte) []byte { return b[:len(b)-1] },
+		"last dropped": func(b []byte) []byte { return b[:body+2*sealedChunk] },
+		"reordered": func(b []byte) []byte {
+			first := append([]byte(nil), b[body:body+sealedChunk]...)
+			copy(b[body:], b[body+sealedChunk:body+2*sealedChunk])
+			copy(b[body+sealedChunk:], first)
+			return b
+		},
+		"appended": func(b []byte) []byte { return append(b, b[body:body+sealedChunk]...) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			raw := store.NewMemoryStore()
+			if err := raw.StoreFile("f1", tamper(sealed(t)), "application/pdf", time.Minute); err != nil {
+				t.Fatalf("StoreFile: %v", err)
+			}
+			f, err := store.NewEncryptedFileStore(raw, kr).OpenFile("f1")
+			if err != nil {
+				return
+			}
+			if _, err := f.ReadAll(); err == nil {
+				t.Error("tampered payload decrypted without error")
+			}
+		})
+	}
+
+	t.Run("moved to another download ID", func(t *testing.T) {
+		raw := store.NewMemoryStore()
+		if err := raw.StoreFile("f2", sealed(t), "application/pdf", time.Minute); err != nil {
+			t.Fatalf("StoreFile: %v", err)
+		}
+		if f, err := store.NewEncryptedFileStore(raw, kr).OpenFile("f2"); err == nil {
+			f.Close()
+			t.Error("OpenFile: payload moved to another download ID opened without error")
+		}
+	})
+
+	t.Run("scan page moved to another index", func(t *testing.T) {
+		raw := store.NewMemoryStore()
+		enc := store.NewEncryptedFileStore(raw, kr)
+		for i := range 2 {
+			if err := enc.AddScanPage("s1", store.ScanPageData{PageIndex: i, Data: []byte{byte(i)}}, time.Minute); err != nil {
+				t.Fatalf("AddScanPage: %v", err)
+			}
+		}
+		pages, err := raw.GetScanPages("s1")
+		if err != nil || len(pages) != 2 {
+			t.Fatalf("GetScanPages (raw): got (%v, %v)", pages, err)
+		}
+		pages[0].Data, pages[1].Data = pages[1].Data, pages[0].Data
+		if err := raw.ClearScanPages("s1"); err != nil {
+			t.Fatalf("ClearScanPages: %v", err)
+		}
+		for _, page := range pages {
+			if err := raw.AddScanPage("s1", page, time.Minute); err != nil {
+				t.Fatalf("AddScanPage (raw): %v", err)
+			}
+		}
+		if _, err := enc.GetScanPages("s1"); err == nil {
+			t.Error("swapped scan pages decrypted without error")
+		}
+	})
+}
+
+func TestParseKeyring(t *testing.T) {
+	valid := "k1:MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE="
+	for name, tc := range map[string]struct {
+		entries []string
+		active  string
+		ok      bool
+	}{
+		"first key active": {entries: []string{valid}, ok: true},
+		"named active":     {entries: []string{valid}, active: "k1", ok: true},
+		"unknown active":   {entries: []string{valid}, active: "k2"},
+		"none":             {},
+		"no separator":     {entries: []string{"MDEy"}},
+		"bad base64":       {entries: []string{"k1:!!"}},
+		"short key":        {entries: []string{"k1:AAAA"}},
+		"duplicate":        {entries: []string{valid, valid}},
+	} {
+		if _, err := store.ParseKeyring(tc.entries, tc.active); (err == nil) != tc.ok {
+			t.Errorf("%s: got err %v, want ok %v", name, err, tc.ok)
+		}
+	}
+}
+
+func newKey(t *testing.T) []byte {
+	return randomBytes(t, 32)
+}
+
+func newKeyring(t *testing.T, active string, keys map[string][]byte) *store.Keyring {
+	t.Helper()
+	kr, err := store.NewKeyring(active, keys)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return kr
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	return b
+}
+
+func openFile(t *testing.T, s store.FileStore, downloadID string) *store.StoredFile {
+	t.Helper()
+	f, err := s.OpenFile(downloadID)
+	if err != nil || f == nil {
+		t.Fatalf("OpenFile(%q): got (%v, %v), want a file", downloadID, f, err)
+	}
+	return f
+}
+
+func readAll(t *testing.T, s store.FileStore, downloadID string) []byte {
+	t.Helper()
+	data, err := openFile(t, s, downloadID).ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll(%q): %v", downloadID, err)
+	}
+	return data
+}
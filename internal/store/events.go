@@ -0,0 +1,34 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+)
+
+const eventsKeyFmt = "events:%s"
+
+// EventLog keeps the ordered events of each session so that subscribers who
+// lost their connection can catch up on what they missed.
+type EventLog interface {
+	// AppendEvent assigns event the next sequence number of the session and
+	// appends it to the session's log. The log is kept until the tombstone of
+	// a session ending at sessionEnd would lapse, and at least as long as a
+	// tombstone from now.
+	AppendEvent(sessionID string, event *model.SessionEvent, sessionEnd time.Time) error
+	// ListEvents returns the session's events with a sequence number greater
+	// than since, oldest first.
+	ListEvents(sessionID string, since uint64) ([]*model.SessionEvent, error)
+}
+
+// eventsKey returns the store key for the event log of a session.
+func eventsKey(sessionID string) string {
+	return fmt.Sprintf(eventsKeyFmt, sessionID)
+}
+
+// eventLogTTL returns how long the event log of a session ending at
+// sessionEnd is kept after an append.
+func eventLogTTL(sessionEnd time.Time) time.Duration {
+	return max(time.Until(sessionEnd), 0) + tombstoneTTL
+}
@@ -0,0 +1,4 @@
+package store
+
+// DiskSweep runs one pass of a DiskFileStore's expiry janitor.
+var DiskSweep = (*DiskFileStore).sweep
@@ -0,0 +1,27 @@
+package store
+
+import "fmt"
+
+const idempotencyKeyFmt = "idempotency:%s"
+
+// IdempotencyRecord remembers a request made with an Idempotency-Key so that
+// retries of it can be answered with the original response.
+type IdempotencyRecord struct {
+	// RequestHash fingerprints the original request body.
+	RequestHash string `json:"request_hash"`
+	// StatusCode is the original response status; zero while the original
+	// request is still being processed.
+	StatusCode int `json:"status_code,omitempty"`
+	// Body is the original response body.
+	Body []byte `json:"body,omitempty"`
+}
+
+// Pending reports whether the original request has not finished yet.
+func (r *IdempotencyRecord) Pending() bool {
+	return r.StatusCode == 0
+}
+
+// idempotencyKey returns the store key for the given idempotency key.
+func idempotencyKey(key string) string {
+	return fmt.Sprintf(idempotencyKeyFmt, key)
+}
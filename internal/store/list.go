@@ -0,0 +1,198 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+)
+
+const (
+	// DefaultListLimit is used when SessionFilter.Limit is not set.
+	DefaultListLimit = 50
+	// MaxListLimit caps SessionFilter.Limit.
+	MaxListLimit = 200
+)
+
+// ErrInvalidCursor is returned by ListSessions for a cursor it did not issue.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortOrder orders listed sessions by creation time.
+type SortOrder string
+
+const (
+	SortOrderAsc  SortOrder = "asc"
+	SortOrderDesc SortOrder = "desc"
+)
+
+// SessionFilter selects live sessions for ListSessions. Zero-valued fields
+// match everything. Expired sessions are never listed.
+type SessionFilter struct {
+	// Status matches sessions in any of the given statuses.
+	Status []model.SessionStatus
+	// ActionType matches sessions of any of the given action types.
+	ActionType []model.ActionType
+	// CreatedAfter and CreatedBefore bound CreatedAt (exclusive).
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// APIKeyID matches sessions created with the given API key.
+	APIKeyID string
+	// ExternalRef matches sessions with exactly this external reference.
+	ExternalRef string
+	// Metadata matches sessions carrying every given key/value pair.
+	Metadata map[string]string
+	// Order sorts by CreatedAt, ties broken by ID. Defaults to SortOrderDesc.
+	Order SortOrder
+	// Limit caps the page size; see DefaultListLimit and MaxListLimit.
+	Limit int
+	// Cursor resumes after the last session of a previous page.
+	Cursor string
+}
+
+// SessionList is one page of ListSessions results.
+type SessionList struct {
+	Sessions []*model.Session
+	// NextCursor is set when more sessions match; pass it as SessionFilter.Cursor.
+	NextCursor string
+}
+
+// matches reports whether sess passes every filter except the cursor.
+func (f *SessionFilter) matches(sess *model.Session) bool {
+	if len(f.Status) > 0 && !containsStatus(f.Status, sess.Status) {
+		return false
+	}
+	if len(f.ActionType) > 0 && !containsActionType(f.ActionType, sess.ActionType) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && !sess.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !sess.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	if f.APIKeyID != "" && sess.APIKeyID != f.APIKeyID {
+		return false
+	}
+	if f.ExternalRef != "" && sess.ExternalRef != f.ExternalRef {
+		return false
+	}
+	for k, v := range f.Metadata {
+		if got, ok := sess.Metadata[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func containsStatus(list []model.SessionStatus, v model.SessionStatus) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsActionType(list []model.ActionType, v model.ActionType) bool {
+	for _, a := range list {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize applies defaults to the order and limit.
+func (f *SessionFilter) normalize() {
+	if f.Order != SortOrderAsc {
+		f.Order = SortOrderDesc
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultListLimit
+	}
+	if f.Limit > MaxListLimit {
+		f.Limit = MaxListLimit
+	}
+}
+
+// listPosition is a session's place in the listing order. CreatedAt is kept
+// at microsecond precision so it fits a Redis sorted set score exactly.
+type listPosition struct {
+	micros int64
+	id     string
+}
+
+func positionOf(sess *model.Session) listPosition {
+	return listPosition{micros: sess.CreatedAt.UnixMicro(), id: sess.ID}
+}
+
+// less orders positions ascending.
+func (p listPosition) less(o listPosition) bool {
+	if p.micros != o.micros {
+		return p.micros < o.micros
+	}
+	return p.id < o.id
+}
+
+// after reports whether p comes after cursor in the given order.
+func (p listPosition) after(cursor listPosition, order SortOrder) bool {
+	if order == SortOrderAsc {
+		return cursor.less(p)
+	}
+	return p.less(cursor)
+}
+
+// encodeCursor returns the opaque cursor pointing past p.
+func encodeCursor(p listPosition) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(p.micros, 10) + ":" + p.id))
+}
+
+// decodeCursor parses a cursor produced by encodeCursor.
+func decodeCursor(cursor string) (listPosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return listPosition{}, ErrInvalidCursor
+	}
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return listPosition{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return listPosition{}, ErrInvalidCursor
+	}
+	return listPosition{micros: n, id: id}, nil
+}
+
+// paginate skips sessions, already filtered and sorted in f.Order, up to and
+// including f.Cursor and returns the next page.
+func paginate(sessions []*model.Session, f *SessionFilter) (*SessionList, error) {
+	if f.Cursor != "" {
+		cursor, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		start := len(sessions)
+		for i, sess := range sessions {
+			if positionOf(sess).after(cursor, f.Order) {
+				start = i
+				break
+			}
+		}
+		sessions = sessions[start:]
+	}
+	return trimPage(sessions, f.Limit), nil
+}
+
+// trimPage cuts sessions to limit and sets NextCursor when more remain.
+func trimPage(sessions []*model.Session, limit int) *SessionList {
+	list := &SessionList{Sessions: sessions}
+	if len(sessions) > limit {
+		list.Sessions = sessions[:limit]
+		list.NextCursor = encodeCursor(positionOf(list.Sessions[limit-1]))
+	}
+	return list
+}
@@ -0,0 +1,583 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	cache "github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	tombstoneTTL    = 24 * time.Hour
+	sessionKeyFmt   = "session:%s"
+	tombstoneKeyFmt = "tombstone:%s"
+	fileKeyFmt      = "file:%s"
+	scanPagesKeyFmt = "scanpages:%s"
+	defaultExpiry   = cache.NoExpiration
+	cleanupInterval = time.Minute
+	// sessionCleanupInterval bounds how late expiry notifications fire.
+	sessionCleanupInterval = time.Second
+	// sessionLockStripes is the number of locks sessions are spread over.
+	sessionLockStripes = 64
+)
+
+// MemoryStore is an in-memory session and file store backed by patrickmn/go-cache.
+// Sessions expire according to their SessionTTL. Tombstones linger for 24 hours
+// after session expiry so callers can distinguish "expired" from "never existed".
+// Result files expire independently according to the session's ResultTTL.
+// Scan pages accumulate until finalization or expiry.
+//
+// Cached sessions are never modified in place: GetSession hands out copies and
+// every change stores a new copy under the session's lock.
+type MemoryStore struct {
+	sessions  *cache.Cache // keyed by "session:{id}"
+	files     *cache.Cache // keyed by "file:{downloadID}"
+	scanPages *cache.Cache // keyed by "scanpages:{sessionID}", stores []ScanPageData
+	pagesMu   sync.Mutex   // serialises read-modify-write of scan page slices
+	webhooks  *cache.Cache // keyed by "webhook:{id}", stores *model.WebhookDelivery
+	hooksMu   sync.Mutex   // serialises claims and updates of webhook deliveries
+	events    *cache.Cache // keyed by "events:{sessionID}", stores []*model.SessionEvent
+	eventsMu  sync.Mutex   // serialises appends to event logs
+
+	// sessionLocks serialise read-modify-write of sessions; a session always
+	// maps to the same lock.
+	sessionLocks [sessionLockStripes]sync.Mutex
+
+	expiryMu  sync.RWMutex
+	expiryFns []func(sessionID string)
+	// expiredOnLoad lists the sessions LoadSnapshot found expired, which
+	// functions registered later are told about as well.
+	expiredOnLoad []string
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates a new MemoryStore with separate caches for sessions, files, and scan pages.
+// The session cache retains tombstone entries for up to 24 hours.
+// The file cache uses a 5-minute default expiry.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		// 24-hour default expiry covers the tombstone lifetime; cleanup runs every
+		// second so expiry notifications are timely.
+		sessions: cache.New(tombstoneTTL, sessionCleanupInterval),
+		// 5-minute default expiry for files; cleanup every minute.
+		files: cache.New(5*time.Minute, cleanupInterval),
+		// scan pages live as long as the session; cleanup every minute.
+		scanPages: cache.New(tombstoneTTL, cleanupInterval),
+		// queued deliveries never expire; finished ones carry their own retention.
+		webhooks: cache.New(defaultExpiry, cleanupInterval),
+		// event logs outlive their session like tombstones.
+		events: cache.New(tombstoneTTL, cleanupInterval),
+	}
+	s.sessions.OnEvicted(s.sessionEvicted)
+	return s
+}
+
+// OnSessionExpired registers fn to be called when a session's TTL elapses
+// before it reached a terminal status. fn is also called for the sessions that
+// expired while the server was down, as found by LoadSnapshot.
+func (s *MemoryStore) OnSessionExpired(fn func(sessionID string)) {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	s.expiryFns = append(s.expiryFns, fn)
+	if expired := s.expiredOnLoad; len(expired) > 0 {
+		go func() {
+			for _, id := range expired {
+				fn(id)
+			}
+		}()
+	}
+}
+
+// sessionEvicted is the go-cache eviction hook for the session cache. It fires
+// for explicit deletes as well as expiry, so only live session entries whose
+// TTL has actually run out are reported.
+func (s *MemoryStore) sessionEvicted(key string, v interface{}) {
+	if !strings.HasPrefix(key, sessionKey("")) {
+		return
+	}
+	sess := v.(*model.Session)
+	if time.Now().Before(sess.CreatedAt.Add(sess.SessionTTL)) || sess.Status.IsTerminal() {
+		return
+	}
+
+	log.Debug().Str("session_id", sess.ID).Msg("store: session expired")
+	s.expiryMu.RLock()
+	defer s.expiryMu.RUnlock()
+	for _, fn := range s.expiryFns {
+		fn(sess.ID)
+	}
+}
+
+// sessionKey returns the cache key for the given session ID.
+func sessionKey(id string) string {
+	return fmt.Sprintf(sessionKeyFmt, id)
+}
+
+// tombstoneKey returns the cache key for the expired-session tombstone of id.
+func tombstoneKey(id string) string {
+	return fmt.Sprintf(tombstoneKeyFmt, id)
+}
+
+// fileKey returns the cache key for the given download ID.
+func fileKey(downloadID string) string {
+	return fmt.Sprintf(fileKeyFmt, downloadID)
+}
+
+// scanPagesKey returns the cache key for the accumulated scan pages of a session.
+func scanPagesKey(sessionID string) string {
+	return fmt.Sprintf(scanPagesKeyFmt, sessionID)
+}
+
+// sessionLock returns the lock guarding changes to session id.
+func (s *MemoryStore) sessionLock(id string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &s.sessionLocks[h.Sum32()%sessionLockStripes]
+}
+
+// cloneSession returns a copy of sess that shares nothing a caller or a
+// store update could modify in place.
+func cloneSession(sess *model.Session) *model.Session {
+	c := *sess
+	c.History = append([]model.StatusChange(nil), sess.History...)
+	c.Metadata = maps.Clone(sess.Metadata)
+	c.Steps = append([]model.Step(nil), sess.Steps...)
+	if sess.Delivery != nil {
+		delivery := *sess.Delivery
+		delivery.Headers = maps.Clone(sess.Delivery.Headers)
+		delivery.Files = append([]model.FileDelivery(nil), sess.Delivery.Files...)
+		c.Delivery = &delivery
+	}
+	return &c
+}
+
+// CreateSession stores a new session in the cache with its SessionTTL.
+// It also stores a tombstone entry that persists for 24 hours past the session's
+// expiry so expired sessions can be distinguished from sessions that never existed.
+func (s *MemoryStore) CreateSession(session *model.Session) error {
+	key := sessionKey(session.ID)
+	tombstone := tombstoneKey(session.ID)
+
+	log.Debug().Str("session_id", session.ID).Dur("ttl", session.SessionTTL).Msg("store: creating session")
+
+	// Store the live session entry with the session's own TTL.
+	s.sessions.Set(key, cloneSession(session), session.SessionTTL)
+
+	// Pre-store a tombstone that outlives the session.
+	// The tombstone is a minimal Session snapshot indicating expiry.
+	s.sessions.Set(tombstone, newTombstone(session), session.SessionTTL+tombstoneTTL)
+
+	return nil
+}
+
+// GetSession retrieves a session by ID.
+//
+// Return semantics:
+//   - (session, nil)  — live session found
+//   - (expiredSession, nil)  — session expired but tombstone present; Status == expired
+//   - (nil, nil)  — never existed or tombstone also gone; caller should 404
+func (s *MemoryStore) GetSession(id string) (*model.Session, error) {
+	// Try the live session entry first.
+	if v, found := s.sessions.Get(sessionKey(id)); found {
+		sess := v.(*model.Session)
+		log.Debug().Str("session_id", id).Str("status", string(sess.Status)).Msg("store: session found")
+		return cloneSession(sess), nil
+	}
+
+	// Not found — check for a tombstone (expired session).
+	if v, found := s.sessions.Get(tombstoneKey(id)); found {
+		expired := v.(*model.Session)
+		log.Debug().Str("session_id", id).Msg("store: tombstone found — session expired")
+		return cloneSession(expired), nil
+	}
+
+	log.Debug().Str("session_id", id).Msg("store: session not found")
+	return nil, nil
+}
+
+// UpdateSession replaces a session in the cache, preserving a proportional TTL
+// calculated from CreatedAt + SessionTTL - now. The tombstone is moved along so
+// it still outlives the session after a SessionTTL change.
+func (s *MemoryStore) UpdateSession(session *model.Session) error {
+	mu := s.sessionLock(session.ID)
+	mu.Lock()
+	defer mu.Unlock()
+	return s.putSession(cloneSession(session))
+}
+
+// putSession stores session, which the caller no longer modifies, with the
+// remaining TTL. The caller holds the session's lock.
+func (s *MemoryStore) putSession(session *model.Session) error {
+	remaining := time.Until(session.CreatedAt.Add(session.SessionTTL))
+	if remaining <= 0 {
+		// Session has already expired; nothing to update.
+		log.Debug().Str("session_id", session.ID).Msg("store: update skipped — session already expired")
+		return nil
+	}
+
+	log.Debug().Str("session_id", session.ID).Dur("remaining_ttl", remaining).Msg("store: updating session")
+	s.sessions.Set(sessionKey(session.ID), session, remaining)
+	s.sessions.Set(tombstoneKey(session.ID), newTombstone(session), remaining+tombstoneTTL)
+	return nil
+}
+
+// mutateSession applies fn to a copy of the live session while holding its
+// lock and stores the result, so that concurrent changes cannot overwrite each
+// other. It returns the updated session.
+func (s *MemoryStore) mutateSession(id string, fn func(sess *model.Session) error) (*model.Session, error) {
+	mu := s.sessionLock(id)
+	mu.Lock()
+	defer mu.Unlock()
+
+	v, found := s.sessions.Get(sessionKey(id))
+	if !found {
+		return nil, fmt.Errorf("%w: %s", errSessionNotFound, id)
+	}
+	sess := cloneSession(v.(*model.Session))
+	if err := fn(sess); err != nil {
+		return nil, err
+	}
+	if err := s.putSession(sess); err != nil {
+		return nil, err
+	}
+	return cloneSession(sess), nil
+}
+
+// DeleteSession removes a session (and its tombstone and event log) from the store.
+func (s *MemoryStore) DeleteSession(id string) error {
+	log.Debug().Str("session_id", id).Msg("store: deleting session")
+	s.sessions.Delete(sessionKey(id))
+	s.sessions.Delete(tombstoneKey(id))
+	s.events.Delete(eventsKey(id))
+	return nil
+}
+
+// MarkSessionOpened sets the session status to "opened" and marks the Opened flag.
+func (s *MemoryStore) MarkSessionOpened(id string) error {
+	log.Debug().Str("session_id", id).Msg("store: marking session opened")
+	_, err := s.mutateSession(id, func(sess *model.Session) error {
+		if err := sess.Transition(model.SessionStatusOpened, model.ActorPhone); err != nil {
+			return err
+		}
+		sess.Opened = true
+		return nil
+	})
+	return err
+}
+
+// MarkActionStarted sets the session status to "action_started". See SessionStore.
+func (s *MemoryStore) MarkActionStarted(id string) (*model.Session, error) {
+	log.Debug().Str("session_id", id).Msg("store: marking action started")
+	return s.mutateSession(id, func(sess *model.Session) error {
+		return sess.Transition(model.SessionStatusActionStarted, model.ActorPhone)
+	})
+}
+
+// MarkSessionCancelled sets the session status to "cancelled". See SessionStore.
+func (s *MemoryStore) MarkSessionCancelled(id string) (*model.Session, error) {
+	log.Debug().Str("session_id", id).Msg("store: marking session cancelled")
+	return s.mutateSession(id, func(sess *model.Session) error {
+		return sess.Transition(model.SessionStatusCancelled, model.ActorAPI)
+	})
+}
+
+// UpdateSessionTTL changes the TTLs of a live session. See SessionStore.
+func (s *MemoryStore) UpdateSessionTTL(id string, change TTLChange) (*model.Session, error) {
+	log.Debug().Str("session_id", id).Dur("session_ttl", change.SessionTTL).Dur("extend_by", change.ExtendBy).Dur("result_ttl", change.ResultTTL).Msg("store: updating session TTL")
+	return s.mutateSession(id, func(sess *model.Session) error {
+		return setSessionTTLs(sess, change)
+	})
+}
+
+// UpdateDeliveryStatus records the push state of a completed session's result
+// files. See SessionStore.
+func (s *MemoryStore) UpdateDeliveryStatus(id string, files []model.FileDelivery) error {
+	_, err := s.mutateSession(id, func(sess *model.Session) error {
+		return setDeliveryStatus(sess, files)
+	})
+	if errors.Is(err, errSessionNotFound) || errors.Is(err, errDeliveryNotRecorded) {
+		return nil
+	}
+	return err
+}
+
+// MarkCompleted records the result of step and completes the session with
+// its last step. See SessionStore.
+func (s *MemoryStore) MarkCompleted(id string, step int, r model.ActionResult) (*model.Session, error) {
+	log.Debug().Str("session_id", id).Int("step", step).Int("result_items", len(r.Result)).Msg("store: marking step completed")
+	return s.mutateSession(id, func(sess *model.Session) error {
+		return sess.Complete(step, r)
+	})
+}
+
+// ListSessions scans the live sessions in the cache. See SessionStore.
+func (s *MemoryStore) ListSessions(filter SessionFilter) (*SessionList, error) {
+	filter.normalize()
+
+	var sessions []*model.Session
+	for key, item := range s.sessions.Items() {
+		if !strings.HasPrefix(key, sessionKey("")) {
+			continue
+		}
+		sess := item.Object.(*model.Session)
+		if filter.matches(sess) {
+			sessions = append(sessions, sess)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := positionOf(sessions[i]), positionOf(sessions[j])
+		if filter.Order == SortOrderAsc {
+			return a.less(b)
+		}
+		return b.less(a)
+	})
+
+	log.Debug().Int("matched", len(sessions)).Msg("store: listing sessions")
+	list, err := paginate(sessions, &filter)
+	if err != nil {
+		return nil, err
+	}
+	for i, sess := range list.Sessions {
+		list.Sessions[i] = cloneSession(sess)
+	}
+	return list, nil
+}
+
+// ReserveIdempotencyKey stores record unless key is taken. See SessionStore.
+func (s *MemoryStore) ReserveIdempotencyKey(key string, record *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, error) {
+	rec := *record
+	if err := s.sessions.Add(idempotencyKey(key), &rec, ttl); err == nil {
+		log.Debug().Str("idempotency_key", key).Msg("store: idempotency key reserved")
+		return nil, nil
+	}
+	if v, found := s.sessions.Get(idempotencyKey(key)); found {
+		existing := *v.(*IdempotencyRecord)
+		return &existing, nil
+	}
+	// The holder expired between Add and Get; the key is free again.
+	return s.ReserveIdempotencyKey(key, record, ttl)
+}
+
+// SaveIdempotencyRecord replaces the record under key.
+func (s *MemoryStore) SaveIdempotencyRecord(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	rec := *record
+	s.sessions.Set(idempotencyKey(key), &rec, ttl)
+	return nil
+}
+
+// DeleteIdempotencyRecord releases key.
+func (s *MemoryStore) DeleteIdempotencyRecord(key string) error {
+	s.sessions.Delete(idempotencyKey(key))
+	return nil
+}
+
+// EnqueueWebhook queues delivery unless its ID is known. See WebhookQueue.
+func (s *MemoryStore) EnqueueWebhook(delivery *model.WebhookDelivery) (bool, error) {
+	d := *delivery
+	if err := s.webhooks.Add(webhookKey(d.ID), &d, defaultExpiry); err != nil {
+		log.Debug().Str("delivery_id", d.ID).Msg("store: webhook already queued")
+		return false, nil
+	}
+	log.Debug().Str("delivery_id", d.ID).Str("session_id", d.SessionID).Str("event", d.Event).Msg("store: webhook queued")
+	return true, nil
+}
+
+// ClaimWebhooks returns the queued deliveries that are due. See WebhookQueue.
+func (s *MemoryStore) ClaimWebhooks(now time.Time, limit int, lease time.Duration) ([]*model.WebhookDelivery, error) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+
+	var due []*model.WebhookDelivery
+	for _, item := range s.webhooks.Items() {
+		d := item.Object.(*model.WebhookDelivery)
+		if d.State == model.WebhookStateQueued && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]*model.WebhookDelivery, 0, len(due))
+	for _, d := range due {
+		d.NextAttemptAt = now.Add(lease)
+		c := *d
+		claimed = append(claimed, &c)
+	}
+	return claimed, nil
+}
+
+// UpdateWebhook saves delivery after an attempt. See WebhookQueue.
+func (s *MemoryStore) UpdateWebhook(delivery *model.WebhookDelivery, retention time.Duration) error {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+
+	d := *delivery
+	ttl := defaultExpiry
+	if d.State != model.WebhookStateQueued {
+		ttl = retention
+	}
+	s.webhooks.Set(webhookKey(d.ID), &d, ttl)
+	log.Debug().Str("delivery_id", d.ID).Str("state", string(d.State)).Int("attempts", d.Attempts).Msg("store: webhook updated")
+	return nil
+}
+
+// ListDeadWebhooks returns the dead deliveries of apiKeyID. See WebhookQueue.
+func (s *MemoryStore) ListDeadWebhooks(apiKeyID string) ([]*model.WebhookDelivery, error) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+
+	var dead []*model.WebhookDelivery
+	for _, item := range s.webhooks.Items() {
+		d := item.Object.(*model.WebhookDelivery)
+		if d.State == model.WebhookStateDead && d.APIKeyID == apiKeyID {
+			c := *d
+			dead = append(dead, &c)
+		}
+	}
+	sort.Slice(dead, func(i, j int) bool { return lastAttempt(dead[i]).After(lastAttempt(dead[j])) })
+	return dead, nil
+}
+
+// AppendEvent numbers event and appends it to the session's log. See EventLog.
+func (s *MemoryStore) AppendEvent(sessionID string, event *model.SessionEvent, sessionEnd time.Time) error {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	var events []*model.SessionEvent
+	if v, found := s.events.Get(eventsKey(sessionID)); found {
+		events = v.([]*model.SessionEvent)
+	}
+	event.Seq = uint64(len(events)) + 1
+	e := *event
+	// Copy on append so that slices handed out by ListEvents stay unchanged.
+	events = append(events[:len(events):len(events)], &e)
+	s.events.Set(eventsKey(sessionID), events, eventLogTTL(sessionEnd))
+	log.Debug().Str("session_id", sessionID).Uint64("seq", e.Seq).Str("type", e.Type).Msg("store: event appended")
+	return nil
+}
+
+// ListEvents returns the session's events after since. See EventLog.
+func (s *MemoryStore) ListEvents(sessionID string, since uint64) ([]*model.SessionEvent, error) {
+	v, found := s.events.Get(eventsKey(sessionID))
+	if !found {
+		return nil, nil
+	}
+	events := v.([]*model.SessionEvent)
+	if since >= uint64(len(events)) {
+		return nil, nil
+	}
+	out := make([]*model.SessionEvent, 0, uint64(len(events))-since)
+	for _, e := range events[since:] {
+		c := *e
+		out = append(out, &c)
+	}
+	return out, nil
+}
+
+// memoryFile is the cached representation of a stored result file.
+type memoryFile struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
+// StoreFile stores binary file data and its content type under the given downloadID with a specific TTL.
+func (s *MemoryStore) StoreFile(downloadID string, data []byte, contentType string, ttl time.Duration) error {
+	log.Debug().Str("download_id", downloadID).Dur("ttl", ttl).Int("bytes", len(data)).Str("content_type", contentType).Msg("store: storing file")
+	s.files.Set(fileKey(downloadID), &memoryFile{
+		Data:        data,
+		ContentType: contentType,
+		ModTime:     time.Now(),
+		ETag:        fileETag(data),
+	}, ttl)
+	return nil
+}
+
+// OpenFile returns a reader over the file stored under downloadID. Returns nil
+// if the file has expired or was never stored.
+func (s *MemoryStore) OpenFile(downloadID string) (*StoredFile, error) {
+	v, found := s.files.Get(fileKey(downloadID))
+	if !found {
+		log.Debug().Str("download_id", downloadID).Msg("store: file not found or expired")
+		return nil, nil
+	}
+
+	mf := v.(*memoryFile)
+	log.Debug().Str("download_id", downloadID).Int("bytes", len(mf.Data)).Msg("store: file retrieved")
+	return newBytesFile(mf.Data, mf.ContentType, mf.ModTime, mf.ETag), nil
+}
+
+// DeleteFile removes the file stored under downloadID.
+func (s *MemoryStore) DeleteFile(downloadID string) error {
+	s.files.Delete(fileKey(downloadID))
+	log.Debug().Str("download_id", downloadID).Msg("store: file deleted")
+	return nil
+}
+
+// AddScanPage appends a page to the accumulated scan pages for a session.
+// The TTL is set to match the session's remaining TTL.
+func (s *MemoryStore) AddScanPage(sessionID string, page ScanPageData, ttl time.Duration) error {
+	s.pagesMu.Lock()
+	defer s.pagesMu.Unlock()
+
+	key := scanPagesKey(sessionID)
+	var pages []ScanPageData
+	if v, found := s.scanPages.Get(key); found {
+		pages = v.([]ScanPageData)
+	}
+	pages = append(pages, page)
+	s.scanPages.Set(key, pages, ttl)
+	log.Debug().Str("session_id", sessionID).Int("document_index", page.DocumentIndex).Int("page_index", page.PageIndex).Int("total_pages", len(pages)).Msg("store: scan page added")
+	return nil
+}
+
+// TouchScanPages resets the expiry of a session's scan pages.
+func (s *MemoryStore) TouchScanPages(sessionID string, ttl time.Duration) error {
+	s.pagesMu.Lock()
+	defer s.pagesMu.Unlock()
+
+	key := scanPagesKey(sessionID)
+	if v, found := s.scanPages.Get(key); found {
+		s.scanPages.Set(key, v, ttl)
+		log.Debug().Str("session_id", sessionID).Dur("ttl", ttl).Msg("store: scan pages touched")
+	}
+	return nil
+}
+
+// GetScanPages returns all accumulated scan pages for a session.
+func (s *MemoryStore) GetScanPages(sessionID string) ([]ScanPageData, error) {
+	v, found := s.scanPages.Get(scanPagesKey(sessionID))
+	if !found {
+		return nil, nil
+	}
+	return v.([]ScanPageData), nil
+}
+
+// GetScanPageCount returns the number of accumulated scan pages for a session.
+func (s *MemoryStore) GetScanPageCount(sessionID string) (int, error) {
+	v, found := s.scanPages.Get(scanPagesKey(sessionID))
+	if !found {
+		return 0, nil
+	}
+	return len(v.([]ScanPageData)), nil
+}
+
+// ClearScanPages removes all accumulated scan pages for a session.
+func (s *MemoryStore) ClearScanPages(sessionID string) error {
+	s.scanPages.Delete(scanPagesKey(sessionID))
+	log.Debug().Str("session_id", sessionID).Msg("store: scan pages cleared")
+	return nil
+}
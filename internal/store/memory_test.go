@@ -0,0 +1,14 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/mxcd/handoff/internal/store/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) store.Store {
+		return store.NewMemoryStore()
+	})
+}
@@ -0,0 +1,1083 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRedisKeyPrefix = "handoff:"
+	redisOpTimeout        = 5 * time.Second
+	redisMaxTxRetries     = 10
+	// redisExpiriesKey is a sorted set of live session IDs scored by expiry
+	// time in Unix milliseconds, used to report expired sessions.
+	redisExpiriesKey = "expiries"
+	// redisExpiryGrace delays expiry checks to absorb clock skew between
+	// replicas and Redis.
+	redisExpiryGrace = time.Second
+	// redisExpiryRetention is how long expiry entries that no replica
+	// claimed, e.g. of sessions still live when swept, are kept.
+	redisExpiryRetention = time.Minute
+	// redisSessionIndexKey is a sorted set of session IDs scored by CreatedAt
+	// in Unix microseconds, backing ListSessions.
+	redisSessionIndexKey = "sessions"
+	// redisListBatch is how many index entries ListSessions fetches per round trip.
+	redisListBatch = 100
+	// redisWebhookQueueKey is a sorted set of queued webhook delivery IDs
+	// scored by their next attempt time in Unix milliseconds.
+	redisWebhookQueueKey = "webhooks"
+	// redisDeadWebhooksKeyFmt is a sorted set per API key of dead webhook
+	// delivery IDs scored by their last attempt time in Unix milliseconds.
+	redisDeadWebhooksKeyFmt = "webhooks:dead:%s"
+	// redisEventSeqKeyFmt is the counter that numbers a session's events; the
+	// events themselves are a sorted set under eventsKey scored by number.
+	redisEventSeqKeyFmt = "events:%s:seq"
+)
+
+// RedisStore is a session and file store backed by Redis, shared by every
+// replica that points at the same server. Sessions and tombstones are separate
+// keys with native TTLs, result files are hashes holding the payload and its
+// content type, and scan pages are appended to a list per session.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+
+	expiryMu  sync.Mutex
+	expiryFns []func(sessionID string)
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+var _ Store = (*RedisStore)(nil)
+
+// redisSession is the JSON shape persisted for a session. model.Session hides
+// the Opened flag and the delivery headers from API responses, so they are
+// carried alongside explicitly.
+type redisSession struct {
+	*model.Session
+	Opened          bool              `json:"opened"`
+	DeliveryHeaders map[string]string `json:"delivery_headers,omitempty"`
+}
+
+// redisScanPageHeader precedes the raw page bytes in each scan page list entry.
+type redisScanPageHeader struct {
+	DocumentIndex int    `json:"document_index"`
+	PageIndex     int    `json:"page_index"`
+	ContentType   string `json:"content_type"`
+}
+
+// NewRedisStore creates a RedisStore on top of an existing client. Every key is
+// namespaced with prefix; an empty prefix defaults to "handoff:". Accepting a
+// client lets tests run against an in-process fake such as miniredis.
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = defaultRedisKeyPrefix
+	}
+	return &RedisStore{client: client, prefix: prefix, stop: make(chan struct{})}
+}
+
+// NewRedisStoreFromURL connects to the Redis server at url (redis:// or rediss://)
+// and verifies the connection with a PING before returning the store.
+func NewRedisStoreFromURL(url string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("ping redis: %w", err)
+	}
+
+	log.Info().Str("addr", opts.Addr).Int("db", opts.DB).Msg("store: connected to redis")
+	return NewRedisStore(client, ""), nil
+}
+
+// Close stops the expiry watcher and releases the underlying Redis client.
+func (s *RedisStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return s.client.Close()
+}
+
+// key returns the namespaced Redis key for a bare store key.
+func (s *RedisStore) key(k string) string {
+	return s.prefix + k
+}
+
+// opContext returns a context bounded by the per-operation timeout.
+func opContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), redisOpTimeout)
+}
+
+// encodeSession serialises a session including the internal Opened flag and
+// delivery headers.
+func encodeSession(session *model.Session) ([]byte, error) {
+	rs := redisSession{Session: session, Opened: session.Opened}
+	if session.Delivery != nil {
+		rs.DeliveryHeaders = session.Delivery.Headers
+	}
+	return json.Marshal(rs)
+}
+
+// decodeSession is the inverse of encodeSession.
+func decodeSession(data []byte) (*model.Session, error) {
+	rs := redisSession{Session: &model.Session{}}
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("decode session: %w", err)
+	}
+	rs.Session.Opened = rs.Opened
+	if rs.Session.Delivery != nil {
+		rs.Session.Delivery.Headers = rs.DeliveryHeaders
+	}
+	return rs.Session, nil
+}
+
+// CreateSession stores the session with its SessionTTL and a tombstone that
+// lingers for 24 hours, in a single MULTI/EXEC transaction.
+func (s *RedisStore) CreateSession(session *model.Session) error {
+	data, err := encodeSession(session)
+	if err != nil {
+		return err
+	}
+	tombstone, err := encodeSession(newTombstone(session))
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Str("session_id", session.ID).Dur("ttl", session.SessionTTL).Msg("store: creating session")
+
+	ctx, cancel := opContext()
+	defer cancel()
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.key(sessionKey(session.ID)), data, session.SessionTTL)
+		pipe.Set(ctx, s.key(tombstoneKey(session.ID)), tombstone, session.SessionTTL+tombstoneTTL)
+		pipe.ZAdd(ctx, s.key(redisExpiriesKey), redis.Z{
+			Score:  float64(session.CreatedAt.Add(session.SessionTTL).UnixMilli()),
+			Member: session.ID,
+		})
+		pipe.ZAdd(ctx, s.key(redisSessionIndexKey), redis.Z{
+			Score:  float64(positionOf(session).micros),
+			Member: session.ID,
+		})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("create session %q: %w", session.ID, err)
+	}
+	return nil
+}
+
+// GetSession retrieves a session by ID, falling back to its tombstone once the
+// live key has expired. See SessionStore for the return semantics.
+func (s *RedisStore) GetSession(id string) (*model.Session, error) {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	for _, k := range []string{sessionKey(id), tombstoneKey(id)} {
+		data, err := s.client.Get(ctx, s.key(k)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get session %q: %w", id, err)
+		}
+		sess, err := decodeSession(data)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug().Str("session_id", id).Str("status", string(sess.Status)).Msg("store: session found")
+		return sess, nil
+	}
+
+	log.Debug().Str("session_id", id).Msg("store: session not found")
+	return nil, nil
+}
+
+// UpdateSession replaces a session, preserving a proportional TTL calculated
+// from CreatedAt + SessionTTL - now. Expired sessions are left untouched. The
+// tombstone and expiry index follow a changed SessionTTL.
+func (s *RedisStore) UpdateSession(session *model.Session) error {
+	remaining := time.Until(session.CreatedAt.Add(session.SessionTTL))
+	if remaining <= 0 {
+		log.Debug().Str("session_id", session.ID).Msg("store: update skipped — session already expired")
+		return nil
+	}
+
+	data, err := encodeSession(session)
+	if err != nil {
+		return err
+	}
+	tombstone, err := encodeSession(newTombstone(session))
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Str("session_id", session.ID).Dur("remaining_ttl", remaining).Msg("store: updating session")
+
+	ctx, cancel := opContext()
+	defer cancel()
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.key(sessionKey(session.ID)), data, remaining)
+		pipe.Set(ctx, s.key(tombstoneKey(session.ID)), tombstone, remaining+tombstoneTTL)
+		if session.Status.IsTerminal() {
+			pipe.ZRem(ctx, s.key(redisExpiriesKey), session.ID)
+		} else {
+			pipe.ZAdd(ctx, s.key(redisExpiriesKey), redis.Z{
+				Score:  float64(session.CreatedAt.Add(session.SessionTTL).UnixMilli()),
+				Member: session.ID,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("update session %q: %w", session.ID, err)
+	}
+	return nil
+}
+
+// DeleteSession removes a session and its tombstone.
+func (s *RedisStore) DeleteSession(id string) error {
+	log.Debug().Str("session_id", id).Msg("store: deleting session")
+
+	ctx, cancel := opContext()
+	defer cancel()
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.key(sessionKey(id)), s.key(tombstoneKey(id)))
+		pipe.Del(ctx, s.key(eventsKey(id)), s.key(fmt.Sprintf(redisEventSeqKeyFmt, id)))
+		pipe.ZRem(ctx, s.key(redisExpiriesKey), id)
+		pipe.ZRem(ctx, s.key(redisSessionIndexKey), id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete session %q: %w", id, err)
+	}
+	return nil
+}
+
+// mutateSession applies fn to the live session under WATCH so that concurrent
+// replicas cannot overwrite each other's status changes. The write keeps the
+// remaining TTL of the live key, unless fn changed the SessionTTL, in which
+// case the live key, the tombstone and the expiry index move along. It returns
+// the updated session.
+func (s *RedisStore) mutateSession(id string, fn func(sess *model.Session) error) (*model.Session, error) {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	key := s.key(sessionKey(id))
+	var updatedSession *model.Session
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return fmt.Errorf("%w: %s", errSessionNotFound, id)
+		}
+		if err != nil {
+			return err
+		}
+		sess, err := decodeSession(data)
+		if err != nil {
+			return err
+		}
+		sessionTTL := sess.SessionTTL
+		if err := fn(sess); err != nil {
+			return err
+		}
+		updated, err := encodeSession(sess)
+		if err != nil {
+			return err
+		}
+		tombstone, err := encodeSession(newTombstone(sess))
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(ctx, key, updated, redis.SetArgs{KeepTTL: true})
+			// Keep the tombstone's copy of the history current.
+			pipe.SetArgs(ctx, s.key(tombstoneKey(id)), tombstone, redis.SetArgs{KeepTTL: true, Mode: "XX"})
+			if sess.SessionTTL != sessionTTL {
+				expiresAt := sess.CreatedAt.Add(sess.SessionTTL)
+				pipe.PExpireAt(ctx, key, expiresAt)
+				pipe.PExpireAt(ctx, s.key(tombstoneKey(id)), expiresAt.Add(tombstoneTTL))
+				if !sess.Status.IsTerminal() {
+					pipe.ZAdd(ctx, s.key(redisExpiriesKey), redis.Z{
+						Score:  float64(expiresAt.UnixMilli()),
+						Member: id,
+					})
+				}
+			}
+			if sess.Status.IsTerminal() {
+				pipe.ZRem(ctx, s.key(redisExpiriesKey), id)
+			}
+			return nil
+		})
+		updatedSession = sess
+		return err
+	}
+
+	for i := 0; i < redisMaxTxRetries; i++ {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue // key changed underneath us — retry
+		}
+		if err != nil {
+			return nil, err
+		}
+		return updatedSession, nil
+	}
+	return nil, fmt.Errorf("update session %q: too much contention", id)
+}
+
+// OnSessionExpired registers fn to be called when a session's TTL elapses
+// before it reached a terminal status. The first registration starts a
+// watcher that scans the expiry index once per second. Every replica runs its
+// own watcher, but each expiry is reported by only one of them: the first to
+// claim it from the index. Telling the clients connected to other replicas is
+// up to the listener.
+func (s *RedisStore) OnSessionExpired(fn func(sessionID string)) {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	s.expiryFns = append(s.expiryFns, fn)
+	if len(s.expiryFns) == 1 {
+		go s.watchExpiries()
+	}
+}
+
+// watchExpiries reports sessions whose expiry score falls into each new
+// window. Entries are pruned once they are older than redisExpiryRetention.
+func (s *RedisStore) watchExpiries() {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+
+	since := time.Now().Add(-redisExpiryGrace)
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			until := time.Now().Add(-redisExpiryGrace)
+			if err := s.sweepExpiries(since, until); err != nil {
+				log.Warn().Err(err).Msg("store: failed to check session expiries")
+				continue
+			}
+			since = until
+		}
+	}
+}
+
+// sweepExpiries notifies listeners of sessions that expired in (since, until]
+// and that this replica claimed by removing them from the expiry index.
+// Sessions whose live key still exists were extended and are skipped.
+func (s *RedisStore) sweepExpiries(since, until time.Time) error {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	index := s.key(redisExpiriesKey)
+	ids, err := s.client.ZRangeByScore(ctx, index, &redis.ZRangeBy{
+		Min: fmt.Sprintf("(%d", since.UnixMilli()),
+		Max: fmt.Sprintf("%d", until.UnixMilli()),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		n, err := s.client.Exists(ctx, s.key(sessionKey(id))).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		claimed, err := s.client.ZRem(ctx, index, id).Result()
+		if err != nil {
+			return err
+		}
+		if claimed == 0 {
+			continue // another replica reports this expiry
+		}
+		if err := s.client.ZRem(ctx, s.key(redisSessionIndexKey), id).Err(); err != nil {
+			return err
+		}
+		log.Debug().Str("session_id", id).Msg("store: session expired")
+		s.expiryMu.Lock()
+		fns := s.expiryFns
+		s.expiryMu.Unlock()
+		for _, fn := range fns {
+			fn(id)
+		}
+	}
+
+	cutoff := until.Add(-redisExpiryRetention).UnixMilli()
+	if err := s.client.ZRemRangeByScore(ctx, index, "-inf", fmt.Sprintf("%d", cutoff)).Err(); err != nil {
+		log.Warn().Err(err).Msg("store: failed to prune expiry index")
+	}
+	return nil
+}
+
+// ListSessions walks the session index in filter.Order, fetching sessions in
+// batches and filtering them client-side. Index entries whose session has
+// expired are pruned on the way. See SessionStore.
+func (s *RedisStore) ListSessions(filter SessionFilter) (*SessionList, error) {
+	filter.normalize()
+
+	var cursor *listPosition
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &c
+	}
+
+	// Narrow the score range; exact bounds are applied by filter.matches.
+	rng := &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: redisListBatch}
+	if !filter.CreatedAfter.IsZero() {
+		rng.Min = strconv.FormatInt(filter.CreatedAfter.UnixMicro(), 10)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		rng.Max = strconv.FormatInt(filter.CreatedBefore.UnixMicro(), 10)
+	}
+	if cursor != nil {
+		bound := strconv.FormatInt(cursor.micros, 10)
+		if filter.Order == SortOrderAsc && (filter.CreatedAfter.IsZero() || cursor.micros > filter.CreatedAfter.UnixMicro()) {
+			rng.Min = bound
+		}
+		if filter.Order == SortOrderDesc && (filter.CreatedBefore.IsZero() || cursor.micros < filter.CreatedBefore.UnixMicro()) {
+			rng.Max = bound
+		}
+	}
+
+	ctx, cancel := opContext()
+	defer cancel()
+
+	index := s.key(redisSessionIndexKey)
+	var sessions []*model.Session
+	var stale []interface{}
+	for len(sessions) <= filter.Limit {
+		var entries []redis.Z
+		var err error
+		if filter.Order == SortOrderAsc {
+			entries, err = s.client.ZRangeByScoreWithScores(ctx, index, rng).Result()
+		} else {
+			entries, err = s.client.ZRevRangeByScoreWithScores(ctx, index, rng).Result()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list sessions: %w", err)
+		}
+		rng.Offset += int64(len(entries))
+
+		var ids []string
+		for _, z := range entries {
+			pos := listPosition{micros: int64(z.Score), id: z.Member.(string)}
+			if cursor == nil || pos.after(*cursor, filter.Order) {
+				ids = append(ids, pos.id)
+			}
+		}
+		if len(ids) > 0 {
+			keys := make([]string, len(ids))
+			for i, id := range ids {
+				keys[i] = s.key(sessionKey(id))
+			}
+			values, err := s.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return nil, fmt.Errorf("list sessions: %w", err)
+			}
+			for i, v := range values {
+				data, ok := v.(string)
+				if !ok {
+					stale = append(stale, ids[i])
+					continue
+				}
+				sess, err := decodeSession([]byte(data))
+				if err != nil {
+					return nil, err
+				}
+				if filter.matches(sess) {
+					sessions = append(sessions, sess)
+				}
+			}
+		}
+
+		if len(entries) < redisListBatch {
+			break
+		}
+	}
+
+	if len(stale) > 0 {
+		if err := s.client.ZRem(ctx, index, stale...).Err(); err != nil {
+			log.Warn().Err(err).Msg("store: failed to prune session index")
+		}
+	}
+
+	log.Debug().Int("matched", len(sessions)).Int("pruned", len(stale)).Msg("store: listing sessions")
+	return trimPage(sessions, filter.Limit), nil
+}
+
+// MarkSessionOpened sets the session status to "opened" and marks the Opened flag.
+func (s *RedisStore) MarkSessionOpened(id string) error {
+	log.Debug().Str("session_id", id).Msg("store: marking session opened")
+	_, err := s.mutateSession(id, func(sess *model.Session) error {
+		if err := sess.Transition(model.SessionStatusOpened, model.ActorPhone); err != nil {
+			return err
+		}
+		sess.Opened = true
+		return nil
+	})
+	return err
+}
+
+// MarkActionStarted sets the session status to "action_started". See SessionStore.
+func (s *RedisStore) MarkActionStarted(id string) (*model.Session, error) {
+	log.Debug().Str("session_id", id).Msg("store: marking action started")
+	return s.mutateSession(id, func(sess *model.Session) error {
+		return sess.Transition(model.SessionStatusActionStarted, model.ActorPhone)
+	})
+}
+
+// MarkSessionCancelled sets the session status to "cancelled". See SessionStore.
+func (s *RedisStore) MarkSessionCancelled(id string) (*model.Session, error) {
+	log.Debug().Str("session_id", id).Msg("store: marking session cancelled")
+	return s.mutateSession(id, func(sess *model.Session) error {
+		return sess.Transition(model.SessionStatusCancelled, model.ActorAPI)
+	})
+}
+
+// UpdateSessionTTL changes the TTLs of a live session. See SessionStore.
+func (s *RedisStore) UpdateSessionTTL(id string, change TTLChange) (*model.Session, error) {
+	log.Debug().Str("session_id", id).Dur("session_ttl", change.SessionTTL).Dur("extend_by", change.ExtendBy).Dur("result_ttl", change.ResultTTL).Msg("store: updating session TTL")
+	return s.mutateSession(id, func(sess *model.Session) error {
+		return setSessionTTLs(sess, change)
+	})
+}
+
+// UpdateDeliveryStatus records the push state of a completed session's result
+// files. See SessionStore.
+func (s *RedisStore) UpdateDeliveryStatus(id string, files []model.FileDelivery) error {
+	_, err := s.mutateSession(id, func(sess *model.Session) error {
+		return setDeliveryStatus(sess, files)
+	})
+	if errors.Is(err, errSessionNotFound) || errors.Is(err, errDeliveryNotRecorded) {
+		return nil
+	}
+	return err
+}
+
+// MarkCompleted records the result of step and completes the session with
+// its last step. See SessionStore.
+func (s *RedisStore) MarkCompleted(id string, step int, r model.ActionResult) (*model.Session, error) {
+	log.Debug().Str("session_id", id).Int("step", step).Int("result_items", len(r.Result)).Msg("store: marking step completed")
+	return s.mutateSession(id, func(sess *model.Session) error {
+		return sess.Complete(step, r)
+	})
+}
+
+// ReserveIdempotencyKey stores record with SET NX. See SessionStore.
+func (s *RedisStore) ReserveIdempotencyKey(key string, record *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := opContext()
+	defer cancel()
+	k := s.key(idempotencyKey(key))
+	for i := 0; i < redisMaxTxRetries; i++ {
+		ok, err := s.client.SetNX(ctx, k, data, ttl).Result()
+		if err != nil {
+			return nil, fmt.Errorf("reserve idempotency key: %w", err)
+		}
+		if ok {
+			log.Debug().Str("idempotency_key", key).Msg("store: idempotency key reserved")
+			return nil, nil
+		}
+
+		existing, err := s.client.Get(ctx, k).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue // expired in between; try to reserve again
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get idempotency record: %w", err)
+		}
+		var rec IdempotencyRecord
+		if err := json.Unmarshal(existing, &rec); err != nil {
+			return nil, fmt.Errorf("decode idempotency record: %w", err)
+		}
+		return &rec, nil
+	}
+	return nil, fmt.Errorf("reserve idempotency key: too much contention")
+}
+
+// SaveIdempotencyRecord replaces the record under key.
+func (s *RedisStore) SaveIdempotencyRecord(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := opContext()
+	defer cancel()
+	if err := s.client.Set(ctx, s.key(idempotencyKey(key)), data, ttl).Err(); err != nil {
+		return fmt.Errorf("save idempotency record: %w", err)
+	}
+	return nil
+}
+
+// DeleteIdempotencyRecord releases key.
+func (s *RedisStore) DeleteIdempotencyRecord(key string) error {
+	ctx, cancel := opContext()
+	defer cancel()
+	if err := s.client.Del(ctx, s.key(idempotencyKey(key))).Err(); err != nil {
+		return fmt.Errorf("delete idempotency record: %w", err)
+	}
+	return nil
+}
+
+// EnqueueWebhook stores delivery and adds it to the queue unless its ID is
+// already known, in a single WATCH transaction. See WebhookQueue.
+func (s *RedisStore) EnqueueWebhook(delivery *model.WebhookDelivery) (bool, error) {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := opContext()
+	defer cancel()
+
+	key := s.key(webhookKey(delivery.ID))
+	queued := false
+	txf := func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, key).Result()
+		if err != nil || n > 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, 0)
+			pipe.ZAdd(ctx, s.key(redisWebhookQueueKey), redis.Z{
+				Score:  float64(delivery.NextAttemptAt.UnixMilli()),
+				Member: delivery.ID,
+			})
+			return nil
+		})
+		queued = err == nil
+		return err
+	}
+
+	for i := 0; i < redisMaxTxRetries; i++ {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("enqueue webhook %q: %w", delivery.ID, err)
+		}
+		if queued {
+			log.Debug().Str("delivery_id", delivery.ID).Str("session_id", delivery.SessionID).Str("event", delivery.Event).Msg("store: webhook queued")
+		} else {
+			log.Debug().Str("delivery_id", delivery.ID).Msg("store: webhook already queued")
+		}
+		return queued, nil
+	}
+	return false, fmt.Errorf("enqueue webhook %q: too much contention", delivery.ID)
+}
+
+// ClaimWebhooks moves the due entries of the queue forward by lease under
+// WATCH, so concurrent replicas never claim the same delivery, and then loads
+// them. See WebhookQueue.
+func (s *RedisStore) ClaimWebhooks(now time.Time, limit int, lease time.Duration) ([]*model.WebhookDelivery, error) {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	queue := s.key(redisWebhookQueueKey)
+	var ids []string
+	txf := func(tx *redis.Tx) error {
+		var err error
+		ids, err = tx.ZRangeByScore(ctx, queue, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(now.UnixMilli(), 10),
+			Count: int64(limit),
+		}).Result()
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			score := float64(now.Add(lease).UnixMilli())
+			for _, id := range ids {
+				pipe.ZAdd(ctx, queue, redis.Z{Score: score, Member: id})
+			}
+			return nil
+		})
+		return err
+	}
+
+	claimed := false
+	for i := 0; i < redisMaxTxRetries; i++ {
+		err := s.client.Watch(ctx, txf, queue)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("claim webhooks: %w", err)
+		}
+		claimed = true
+		break
+	}
+	if !claimed {
+		// Another replica is claiming right now; try again next round.
+		return nil, nil
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	deliveries, stale, err := s.loadWebhooks(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("claim webhooks: %w", err)
+	}
+	if len(stale) > 0 {
+		if err := s.client.ZRem(ctx, queue, stale...).Err(); err != nil {
+			log.Warn().Err(err).Msg("store: failed to prune webhook queue")
+		}
+	}
+	for _, d := range deliveries {
+		d.NextAttemptAt = now.Add(lease)
+	}
+	return deliveries, nil
+}
+
+// loadWebhooks fetches the deliveries with the given IDs in order. IDs whose
+// delivery no longer exists are returned as stale.
+func (s *RedisStore) loadWebhooks(ctx context.Context, ids []string) ([]*model.WebhookDelivery, []interface{}, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.key(webhookKey(id))
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var deliveries []*model.WebhookDelivery
+	var stale []interface{}
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			stale = append(stale, ids[i])
+			continue
+		}
+		var d model.WebhookDelivery
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			return nil, nil, fmt.Errorf("decode webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, stale, nil
+}
+
+// UpdateWebhook saves delivery and moves it between the queue and the
+// dead-letter index according to its state. See WebhookQueue.
+func (s *RedisStore) UpdateWebhook(delivery *model.WebhookDelivery, retention time.Duration) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := opContext()
+	defer cancel()
+	queue := s.key(redisWebhookQueueKey)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		switch delivery.State {
+		case model.WebhookStateQueued:
+			pipe.Set(ctx, s.key(webhookKey(delivery.ID)), data, 0)
+			pipe.ZAdd(ctx, queue, redis.Z{
+				Score:  float64(delivery.NextAttemptAt.UnixMilli()),
+				Member: delivery.ID,
+			})
+		case model.WebhookStateDead:
+			pipe.Set(ctx, s.key(webhookKey(delivery.ID)), data, retention)
+			pipe.ZRem(ctx, queue, delivery.ID)
+			pipe.ZAdd(ctx, s.key(fmt.Sprintf(redisDeadWebhooksKeyFmt, delivery.APIKeyID)), redis.Z{
+				Score:  float64(lastAttempt(delivery).UnixMilli()),
+				Member: delivery.ID,
+			})
+		default:
+			pipe.Set(ctx, s.key(webhookKey(delivery.ID)), data, retention)
+			pipe.ZRem(ctx, queue, delivery.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("update webhook %q: %w", delivery.ID, err)
+	}
+	log.Debug().Str("delivery_id", delivery.ID).Str("state", string(delivery.State)).Int("attempts", delivery.Attempts).Msg("store: webhook updated")
+	return nil
+}
+
+// ListDeadWebhooks walks the dead-letter index of apiKeyID, pruning entries
+// whose delivery has outlived its retention. See WebhookQueue.
+func (s *RedisStore) ListDeadWebhooks(apiKeyID string) ([]*model.WebhookDelivery, error) {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	index := s.key(fmt.Sprintf(redisDeadWebhooksKeyFmt, apiKeyID))
+	ids, err := s.client.ZRevRange(ctx, index, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list dead webhooks: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	deliveries, stale, err := s.loadWebhooks(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("list dead webhooks: %w", err)
+	}
+	if len(stale) > 0 {
+		if err := s.client.ZRem(ctx, index, stale...).Err(); err != nil {
+			log.Warn().Err(err).Msg("store: failed to prune dead webhook index")
+		}
+	}
+	return deliveries, nil
+}
+
+// AppendEvent numbers event with INCR on the session's counter and adds it to
+// the session's sorted set. See EventLog.
+func (s *RedisStore) AppendEvent(sessionID string, event *model.SessionEvent, sessionEnd time.Time) error {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	seqKey := s.key(fmt.Sprintf(redisEventSeqKeyFmt, sessionID))
+	seq, err := s.client.Incr(ctx, seqKey).Result()
+	if err != nil {
+		return fmt.Errorf("number event of session %q: %w", sessionID, err)
+	}
+	event.Seq = uint64(seq)
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	key := s.key(eventsKey(sessionID))
+	ttl := eventLogTTL(sessionEnd)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(seq), Member: data})
+		pipe.PExpire(ctx, key, ttl)
+		pipe.PExpire(ctx, seqKey, ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("append event to session %q: %w", sessionID, err)
+	}
+	log.Debug().Str("session_id", sessionID).Uint64("seq", event.Seq).Str("type", event.Type).Msg("store: event appended")
+	return nil
+}
+
+// ListEvents returns the session's events after since. See EventLog.
+func (s *RedisStore) ListEvents(sessionID string, since uint64) ([]*model.SessionEvent, error) {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	entries, err := s.client.ZRangeByScore(ctx, s.key(eventsKey(sessionID)), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatUint(since, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list events of session %q: %w", sessionID, err)
+	}
+
+	events := make([]*model.SessionEvent, 0, len(entries))
+	for _, entry := range entries {
+		var event model.SessionEvent
+		if err := json.Unmarshal([]byte(entry), &event); err != nil {
+			return nil, fmt.Errorf("decode event of session %q: %w", sessionID, err)
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+// StoreFile stores file data and its content type as a hash that expires after ttl.
+func (s *RedisStore) StoreFile(downloadID string, data []byte, contentType string, ttl time.Duration) error {
+	log.Debug().Str("download_id", downloadID).Dur("ttl", ttl).Int("bytes", len(data)).Str("content_type", contentType).Msg("store: storing file")
+
+	ctx, cancel := opContext()
+	defer cancel()
+	key := s.key(fileKey(downloadID))
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"content_type", contentType,
+			"mod_time", time.Now().UTC().Format(time.RFC3339Nano),
+			"etag", fileETag(data),
+			"data", data,
+		)
+		pipe.PExpire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("store file %q: %w", downloadID, err)
+	}
+	return nil
+}
+
+// OpenFile fetches the file stored under downloadID and returns a reader over
+// it. Returns nil if the file has expired or was never stored.
+func (s *RedisStore) OpenFile(downloadID string) (*StoredFile, error) {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	fields, err := s.client.HGetAll(ctx, s.key(fileKey(downloadID))).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get file %q: %w", downloadID, err)
+	}
+	if len(fields) == 0 {
+		log.Debug().Str("download_id", downloadID).Msg("store: file not found or expired")
+		return nil, nil
+	}
+
+	modTime, _ := time.Parse(time.RFC3339Nano, fields["mod_time"])
+	data := []byte(fields["data"])
+	log.Debug().Str("download_id", downloadID).Int("bytes", len(data)).Msg("store: file retrieved")
+	return newBytesFile(data, fields["content_type"], modTime, fields["etag"]), nil
+}
+
+// DeleteFile removes the hash holding the file stored under downloadID.
+func (s *RedisStore) DeleteFile(downloadID string) error {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.key(fileKey(downloadID))).Err(); err != nil {
+		return fmt.Errorf("delete file %q: %w", downloadID, err)
+	}
+	log.Debug().Str("download_id", downloadID).Msg("store: file deleted")
+	return nil
+}
+
+// encodeScanPage packs a page as a JSON header line followed by the raw bytes,
+// avoiding the base64 overhead of embedding the data in JSON.
+func encodeScanPage(page ScanPageData) ([]byte, error) {
+	header, err := json.Marshal(redisScanPageHeader{
+		DocumentIndex: page.DocumentIndex,
+		PageIndex:     page.PageIndex,
+		ContentType:   page.ContentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(header)+1+len(page.Data))
+	buf = append(buf, header...)
+	buf = append(buf, '\n')
+	return append(buf, page.Data...), nil
+}
+
+// decodeScanPage is the inverse of encodeScanPage.
+func decodeScanPage(entry []byte) (ScanPageData, error) {
+	idx := bytes.IndexByte(entry, '\n')
+	if idx < 0 {
+		return ScanPageData{}, fmt.Errorf("decode scan page: missing header")
+	}
+	var header redisScanPageHeader
+	if err := json.Unmarshal(entry[:idx], &header); err != nil {
+		return ScanPageData{}, fmt.Errorf("decode scan page header: %w", err)
+	}
+	return ScanPageData{
+		DocumentIndex: header.DocumentIndex,
+		PageIndex:     header.PageIndex,
+		Data:          entry[idx+1:],
+		ContentType:   header.ContentType,
+	}, nil
+}
+
+// AddScanPage appends a page to the session's scan page list and resets the
+// list TTL to match the session's remaining TTL.
+func (s *RedisStore) AddScanPage(sessionID string, page ScanPageData, ttl time.Duration) error {
+	entry, err := encodeScanPage(page)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := opContext()
+	defer cancel()
+	key := s.key(scanPagesKey(sessionID))
+	var length *redis.IntCmd
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		length = pipe.RPush(ctx, key, entry)
+		pipe.PExpire(ctx, key, ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("add scan page for session %q: %w", sessionID, err)
+	}
+
+	log.Debug().Str("session_id", sessionID).Int("document_index", page.DocumentIndex).Int("page_index", page.PageIndex).Int64("total_pages", length.Val()).Msg("store: scan page added")
+	return nil
+}
+
+// TouchScanPages resets the expiry of a session's scan page list.
+func (s *RedisStore) TouchScanPages(sessionID string, ttl time.Duration) error {
+	ctx, cancel := opContext()
+	defer cancel()
+	if err := s.client.PExpire(ctx, s.key(scanPagesKey(sessionID)), ttl).Err(); err != nil {
+		return fmt.Errorf("touch scan pages for session %q: %w", sessionID, err)
+	}
+	log.Debug().Str("session_id", sessionID).Dur("ttl", ttl).Msg("store: scan pages touched")
+	return nil
+}
+
+// GetScanPages returns all accumulated scan pages for a session.
+func (s *RedisStore) GetScanPages(sessionID string) ([]ScanPageData, error) {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	entries, err := s.client.LRange(ctx, s.key(scanPagesKey(sessionID)), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get scan pages for session %q: %w", sessionID, err)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	pages := make([]ScanPageData, 0, len(entries))
+	for _, entry := range entries {
+		page, err := decodeScanPage([]byte(entry))
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// GetScanPageCount returns the number of accumulated scan pages for a session.
+func (s *RedisStore) GetScanPageCount(sessionID string) (int, error) {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	n, err := s.client.LLen(ctx, s.key(scanPagesKey(sessionID))).Result()
+	if err != nil {
+		return 0, fmt.Errorf("count scan pages for session %q: %w", sessionID, err)
+	}
+	return int(n), nil
+}
+
+// ClearScanPages removes all accumulated scan pages for a session.
+func (s *RedisStore) ClearScanPages(sessionID string) error {
+	ctx, cancel := opContext()
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.key(scanPagesKey(sessionID))).Err(); err != nil {
+		return fmt.Errorf("clear scan pages for session %q: %w", sessionID, err)
+	}
+	log.Debug().Str("session_id", sessionID).Msg("store: scan pages cleared")
+	return nil
+}
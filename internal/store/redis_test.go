@@ -0,0 +1,22 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/mxcd/handoff/internal/store/storetest"
+)
+
+func TestRedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	storetest.TestStore(t, func(t *testing.T) store.Store {
+		mr.FlushAll()
+		st, err := store.NewRedisStoreFromURL("redis://" + mr.Addr())
+		if err != nil {
+			t.Fatalf("NewRedisStoreFromURL: %v", err)
+		}
+		t.Cleanup(func() { st.Close() })
+		return st
+	}, storetest.WithFastForward(mr.FastForward))
+}
@@ -0,0 +1,527 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	s3FilesPrefix       = "files/"
+	s3ScanPagesPrefix   = "scanpages/"
+	s3MetaExpiresAt     = "Handoff-Expires-At"
+	s3MetaDocumentIndex = "Handoff-Document-Index"
+	s3MetaPageIndex     = "Handoff-Page-Index"
+	s3OpTimeout         = 30 * time.Second
+)
+
+// S3Options configures the connection to an S3-compatible bucket.
+type S3Options struct {
+	// Endpoint is the host[:port] of the S3 API, e.g. "s3.amazonaws.com" or "minio:9000".
+	Endpoint string
+	// Region is the bucket region; may be empty for MinIO.
+	Region string
+	// Bucket is created on startup if it does not exist.
+	Bucket string
+	// AccessKey and SecretKey are static credentials.
+	AccessKey string
+	SecretKey string
+	// UseSSL selects https for the S3 API.
+	UseSSL bool
+	// Prefix is prepended to every object key, e.g. "handoff/".
+	Prefix string
+}
+
+// S3FileStore keeps result files and scan pages in an S3-compatible bucket.
+// Each object carries its expiry in user metadata; reads treat expired
+// objects as missing and a cleanup loop deletes them every minute.
+//
+// Object layout below the configured prefix:
+//
+//	files/{downloadID}
+//	scanpages/{sessionID}/{unixNano}-{random}
+type S3FileStore struct {
+	client  *minio.Client
+	bucket  string
+	prefix  string
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+var (
+	_ FileStore     = (*S3FileStore)(nil)
+	_ FilePresigner = (*S3FileStore)(nil)
+)
+
+// NewS3FileStore connects to the bucket, creating it if needed, and starts
+// the expiry cleanup loop. Call Close to stop the loop.
+func NewS3FileStore(opts S3Options) (*S3FileStore, error) {
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: opts.UseSSL,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3OpTimeout)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("check s3 bucket %q: %w", opts.Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, opts.Bucket, minio.MakeBucketOptions{Region: opts.Region}); err != nil {
+			return nil, fmt.Errorf("create s3 bucket %q: %w", opts.Bucket, err)
+		}
+		log.Info().Str("bucket", opts.Bucket).Msg("store: created s3 bucket")
+	}
+
+	s := &S3FileStore{
+		client: client,
+		bucket: opts.Bucket,
+		prefix: opts.Prefix,
+		stop:   make(chan struct{}),
+	}
+	go s.cleanupLoop()
+
+	log.Info().Str("endpoint", opts.Endpoint).Str("bucket", opts.Bucket).Msg("store: using s3 file store")
+	return s, nil
+}
+
+// Close stops the cleanup loop.
+func (s *S3FileStore) Close() error {
+	s.stopped.Do(func() { close(s.stop) })
+	return nil
+}
+
+// fileObject returns the object key for a result file.
+func (s *S3FileStore) fileObject(downloadID string) string {
+	return s.prefix + s3FilesPrefix + downloadID
+}
+
+// pagesPrefix returns the key prefix under which a session's scan pages live.
+func (s *S3FileStore) pagesPrefix(sessionID string) string {
+	return s.prefix + s3ScanPagesPrefix + sessionID + "/"
+}
+
+// validS3ID rejects IDs that would escape their key prefix.
+func validS3ID(id string) bool {
+	return diskIDPattern.MatchString(id)
+}
+
+// metaValue looks up a user metadata value regardless of key casing or the
+// "X-Amz-Meta-" prefix, which differ between StatObject and ListObjects.
+func metaValue(m minio.StringMap, key string) string {
+	for k, v := range m {
+		k = strings.TrimPrefix(strings.ToLower(k), "x-amz-meta-")
+		if k == strings.ToLower(key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// objectExpired reports whether the object's expiry metadata lies in the past.
+// Objects without readable expiry metadata are never considered expired.
+func objectExpired(info minio.ObjectInfo, now time.Time) bool {
+	expiresAt, err := time.Parse(time.RFC3339Nano, metaValue(info.UserMetadata, s3MetaExpiresAt))
+	return err == nil && now.After(expiresAt)
+}
+
+// isNoSuchKey reports whether err is S3's "object does not exist".
+func isNoSuchKey(err error) bool {
+	return minio.ToErrorResponse(err).Code == minio.NoSuchKey
+}
+
+// putObject uploads data with the given content type and expiry metadata.
+func (s *S3FileStore) putObject(key string, data []byte, contentType string, ttl time.Duration, extra map[string]string) error {
+	meta := map[string]string{s3MetaExpiresAt: time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)}
+	for k, v := range extra {
+		meta[k] = v
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3OpTimeout)
+	defer cancel()
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  contentType,
+		UserMetadata: meta,
+	})
+	return err
+}
+
+// statLive returns the object's info, or nil if it is missing or expired.
+func (s *S3FileStore) statLive(key string) (*minio.ObjectInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3OpTimeout)
+	defer cancel()
+
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if isNoSuchKey(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if objectExpired(info, time.Now()) {
+		return nil, nil
+	}
+	return &info, nil
+}
+
+// StoreFile uploads the payload with an expiry of now + ttl.
+func (s *S3FileStore) StoreFile(downloadID string, data []byte, contentType string, ttl time.Duration) error {
+	if !validS3ID(downloadID) {
+		return fmt.Errorf("invalid download ID %q", downloadID)
+	}
+	log.Debug().Str("download_id", downloadID).Dur("ttl", ttl).Int("bytes", len(data)).Str("content_type", contentType).Msg("store: storing file")
+
+	if err := s.putObject(s.fileObject(downloadID), data, contentType, ttl, nil); err != nil {
+		return fmt.Errorf("store file %q: %w", downloadID, err)
+	}
+	return nil
+}
+
+// OpenFile streams the object from the bucket. Returns nil if the file has
+// expired or was never stored.
+func (s *S3FileStore) OpenFile(downloadID string) (*StoredFile, error) {
+	if !validS3ID(downloadID) {
+		return nil, nil
+	}
+	key := s.fileObject(downloadID)
+
+	info, err := s.statLive(key)
+	if err != nil {
+		return nil, fmt.Errorf("stat file %q: %w", downloadID, err)
+	}
+	if info == nil {
+		log.Debug().Str("download_id", downloadID).Msg("store: file not found or expired")
+		return nil, nil
+	}
+
+	// The object streams lazily; the context must outlive this call, so it is
+	// not bounded here. Closing the object releases the connection.
+	obj, err := s.client.GetObject(context.Background(), s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get file %q: %w", downloadID, err)
+	}
+
+	log.Debug().Str("download_id", downloadID).Int64("bytes", info.Size).Msg("store: file retrieved")
+	return &StoredFile{
+		Content:     obj,
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		ModTime:     info.LastModified,
+		ETag:        `"` + info.ETag + `"`,
+	}, nil
+}
+
+// DeleteFile removes the object holding the file stored under downloadID.
+func (s *S3FileStore) DeleteFile(downloadID string) error {
+	if !validS3ID(downloadID) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3OpTimeout)
+	defer cancel()
+
+	err := s.client.RemoveObject(ctx, s.bucket, s.fileObject(downloadID), minio.RemoveObjectOptions{})
+	if err != nil && !isNoSuchKey(err) {
+		return fmt.Errorf("delete file %q: %w", downloadID, err)
+	}
+	log.Debug().Str("download_id", downloadID).Msg("store: file deleted")
+	return nil
+}
+
+// PresignFile returns a presigned GET URL for the file. The URL never outlives
+// the file's own expiry and is capped at expiry.
+func (s *S3FileStore) PresignFile(downloadID string, expiry time.Duration) (*url.URL, error) {
+	if !validS3ID(downloadID) {
+		return nil, nil
+	}
+	key := s.fileObject(downloadID)
+
+	info, err := s.statLive(key)
+	if err != nil {
+		return nil, fmt.Errorf("stat file %q: %w", downloadID, err)
+	}
+	if info == nil {
+		return nil, nil
+	}
+	if expiresAt, err := time.Parse(time.RFC3339Nano, metaValue(info.UserMetadata, s3MetaExpiresAt)); err == nil {
+		if remaining := time.Until(expiresAt); remaining < expiry {
+			expiry = remaining
+		}
+	}
+	if expiry < time.Second {
+		expiry = time.Second
+	}
+
+	params := url.Values{}
+	params.Set("response-content-disposition", "attachment")
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3OpTimeout)
+	defer cancel()
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, params)
+	if err != nil {
+		return nil, fmt.Errorf("presign file %q: %w", downloadID, err)
+	}
+	return u, nil
+}
+
+// AddScanPage uploads the page as its own object. Keys start with the upload
+// time so listing returns pages in upload order.
+func (s *S3FileStore) AddScanPage(sessionID string, page ScanPageData, ttl time.Duration) error {
+	if !validS3ID(sessionID) {
+		return fmt.Errorf("invalid session ID %q", sessionID)
+	}
+	key := fmt.Sprintf("%s%020d-%s", s.pagesPrefix(sessionID), time.Now().UnixNano(), uuid.New().String()[:8])
+
+	err := s.putObject(key, page.Data, page.ContentType, ttl, map[string]string{
+		s3MetaDocumentIndex: strconv.Itoa(page.DocumentIndex),
+		s3MetaPageIndex:     strconv.Itoa(page.PageIndex),
+	})
+	if err != nil {
+		return fmt.Errorf("add scan page for session %q: %w", sessionID, err)
+	}
+
+	log.Debug().Str("session_id", sessionID).Int("document_index", page.DocumentIndex).Int("page_index", page.PageIndex).Msg("store: scan page added")
+	return nil
+}
+
+// listPages returns the keys of a session's live scan pages in upload order.
+func (s *S3FileStore) listPages(ctx context.Context, sessionID string) ([]string, error) {
+	now := time.Now()
+	var keys []string
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:       s.pagesPrefix(sessionID),
+		Recursive:    true,
+		WithMetadata: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if objectExpired(obj, now) {
+			continue
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+// GetScanPages downloads all accumulated scan pages for a session.
+func (s *S3FileStore) GetScanPages(sessionID string) ([]ScanPageData, error) {
+	if !validS3ID(sessionID) {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3OpTimeout)
+	defer cancel()
+
+	keys, err := s.listPages(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("list scan pages for session %q: %w", sessionID, err)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	pages := make([]ScanPageData, 0, len(keys))
+	for _, key := range keys {
+		page, err := s.readPage(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("read scan page %q: %w", path.Base(key), err)
+		}
+		if page != nil {
+			pages = append(pages, *page)
+		}
+	}
+	return pages, nil
+}
+
+// readPage downloads one scan page object. Returns nil if it expired meanwhile.
+func (s *S3FileStore) readPage(ctx context.Context, key string) (*ScanPageData, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	info, err := obj.Stat()
+	if isNoSuchKey(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	docIndex, _ := strconv.Atoi(metaValue(info.UserMetadata, s3MetaDocumentIndex))
+	pageIndex, _ := strconv.Atoi(metaValue(info.UserMetadata, s3MetaPageIndex))
+	return &ScanPageData{
+		DocumentIndex: docIndex,
+		PageIndex:     pageIndex,
+		Data:          data,
+		ContentType:   info.ContentType,
+	}, nil
+}
+
+// GetScanPageCount returns the number of accumulated scan pages for a session.
+func (s *S3FileStore) GetScanPageCount(sessionID string) (int, error) {
+	if !validS3ID(sessionID) {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3OpTimeout)
+	defer cancel()
+
+	keys, err := s.listPages(ctx, sessionID)
+	if err != nil {
+		return 0, fmt.Errorf("count scan pages for session %q: %w", sessionID, err)
+	}
+	return len(keys), nil
+}
+
+// ClearScanPages removes all accumulated scan pages for a session.
+func (s *S3FileStore) ClearScanPages(sessionID string) error {
+	if !validS3ID(sessionID) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3OpTimeout)
+	defer cancel()
+
+	if err := s.removePrefix(ctx, s.pagesPrefix(sessionID), nil); err != nil {
+		return fmt.Errorf("clear scan pages for session %q: %w", sessionID, err)
+	}
+	log.Debug().Str("session_id", sessionID).Msg("store: scan pages cleared")
+	return nil
+}
+
+// TouchScanPages rewrites the expiry metadata of every live scan page. S3
+// metadata is immutable, so each page is copied onto itself with new metadata.
+func (s *S3FileStore) TouchScanPages(sessionID string, ttl time.Duration) error {
+	if !validS3ID(sessionID) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3OpTimeout)
+	defer cancel()
+
+	keys, err := s.listPages(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("list scan pages for session %q: %w", sessionID, err)
+	}
+	expiresAt := time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)
+	for _, key := range keys {
+		info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+		if isNoSuchKey(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("stat scan page %q: %w", path.Base(key), err)
+		}
+		_, err = s.client.CopyObject(ctx, minio.CopyDestOptions{
+			Bucket: s.bucket,
+			Object: key,
+			UserMetadata: map[string]string{
+				s3MetaExpiresAt:     expiresAt,
+				s3MetaDocumentIndex: metaValue(info.UserMetadata, s3MetaDocumentIndex),
+				s3MetaPageIndex:     metaValue(info.UserMetadata, s3MetaPageIndex),
+			},
+			ReplaceMetadata: true,
+			ContentType:     info.ContentType,
+		}, minio.CopySrcOptions{Bucket: s.bucket, Object: key})
+		if err != nil {
+			return fmt.Errorf("touch scan page %q: %w", path.Base(key), err)
+		}
+	}
+	log.Debug().Str("session_id", sessionID).Int("pages", len(keys)).Dur("ttl", ttl).Msg("store: scan pages touched")
+	return nil
+}
+
+// removePrefix deletes every object under prefix for which keep returns false.
+// A nil keep deletes everything. Returns the first deletion error.
+func (s *S3FileStore) removePrefix(ctx context.Context, prefix string, keep func(minio.ObjectInfo) bool) error {
+	objects := make(chan minio.ObjectInfo)
+	listErr := make(chan error, 1)
+	go func() {
+		defer close(objects)
+		for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+			Prefix:       prefix,
+			Recursive:    true,
+			WithMetadata: keep != nil,
+		}) {
+			if obj.Err != nil {
+				listErr <- obj.Err
+				return
+			}
+			if keep != nil && keep(obj) {
+				continue
+			}
+			objects <- obj
+		}
+		listErr <- nil
+	}()
+
+	var firstErr error
+	for rerr := range s.client.RemoveObjects(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if firstErr == nil {
+			firstErr = rerr.Err
+		}
+	}
+	if err := <-listErr; err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// cleanupLoop deletes expired objects every minute until Close is called.
+func (s *S3FileStore) cleanupLoop() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
+
+// sweep deletes every expired file and scan page object. List results only
+// carry user metadata on MinIO; elsewhere each object is stat'ed individually.
+func (s *S3FileStore) sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupInterval)
+	defer cancel()
+
+	now := time.Now()
+	keep := func(obj minio.ObjectInfo) bool {
+		if metaValue(obj.UserMetadata, s3MetaExpiresAt) == "" {
+			info, err := s.client.StatObject(ctx, s.bucket, obj.Key, minio.StatObjectOptions{})
+			if err != nil {
+				return true
+			}
+			obj = info
+		}
+		return !objectExpired(obj, now)
+	}
+
+	for _, prefix := range []string{s.prefix + s3FilesPrefix, s.prefix + s3ScanPagesPrefix} {
+		if err := s.removePrefix(ctx, prefix, keep); err != nil {
+			log.Warn().Err(err).Str("prefix", prefix).Msg("store: s3 sweep failed")
+		}
+	}
+}
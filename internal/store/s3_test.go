@@ -0,0 +1,81 @@
+package store_test
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/mxcd/handoff/internal/store/storetest"
+)
+
+// The S3 tests run against the S3-compatible server at
+// HANDOFF_TEST_S3_ENDPOINT, e.g. a local MinIO started with
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//
+// and are skipped if it is not set. HANDOFF_TEST_S3_ACCESS_KEY and
+// HANDOFF_TEST_S3_SECRET_KEY default to MinIO's "minioadmin", and
+// HANDOFF_TEST_S3_BUCKET to "handoff-test". Every subtest works below its own
+// prefix, so the bucket can be shared.
+func newS3FileStore(t *testing.T) *store.S3FileStore {
+	t.Helper()
+	endpoint := os.Getenv("HANDOFF_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("HANDOFF_TEST_S3_ENDPOINT not set")
+	}
+	st, err := store.NewS3FileStore(store.S3Options{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    envOr("HANDOFF_TEST_S3_BUCKET", "handoff-test"),
+		AccessKey: envOr("HANDOFF_TEST_S3_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("HANDOFF_TEST_S3_SECRET_KEY", "minioadmin"),
+		Prefix:    "test/" + model.NewSessionID() + "/",
+	})
+	if err != nil {
+		t.Fatalf("NewS3FileStore: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func TestS3FileStore(t *testing.T) {
+	newS3FileStore(t)
+	storetest.TestFileStore(t, func(t *testing.T) store.FileStore { return newS3FileStore(t) })
+}
+
+func TestS3FileStorePresign(t *testing.T) {
+	st := newS3FileStore(t)
+	id := model.NewSessionID()
+	if err := st.StoreFile(id, []byte("presigned"), "text/plain", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+
+	u, err := st.PresignFile(id, time.Minute)
+	if err != nil || u == nil {
+		t.Fatalf("PresignFile: got (%v, %v), want a URL", u, err)
+	}
+	resp, err := http.Get(u.String())
+	if err != nil {
+		t.Fatalf("GET presigned URL: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "presigned" {
+		t.Errorf("GET presigned URL: got %d %q", resp.StatusCode, body)
+	}
+
+	if u, err := st.PresignFile(model.NewSessionID(), time.Minute); err != nil || u != nil {
+		t.Errorf("PresignFile(unknown): got (%v, %v), want (nil, nil)", u, err)
+	}
+}
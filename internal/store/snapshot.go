@@ -0,0 +1,320 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/rs/zerolog/log"
+)
+
+// snapshotVersion is bumped whenever a snapshot written by an older server
+// would be misread: a field is removed, renamed or changes meaning. Adding a
+// field does not bump it, since older snapshots then decode with the field's
+// zero value, which every addition so far (session metadata, status history,
+// idempotency records, webhook deliveries, event logs) treats as "none".
+// Snapshots written with any other version are rejected rather than
+// half-restored.
+const snapshotVersion = 1
+
+// ErrSnapshotVersion is returned by LoadSnapshot for snapshots written by an
+// incompatible version of the store.
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// memorySnapshot is the on-disk shape of a MemoryStore snapshot.
+type memorySnapshot struct {
+	Version    int                 `json:"version"`
+	TakenAt    time.Time           `json:"taken_at"`
+	Sessions   []json.RawMessage   `json:"sessions"`
+	Tombstones []snapshotTombstone `json:"tombstones"`
+	Files      []snapshotFile      `json:"files"`
+	ScanPages  []snapshotScanPages `json:"scan_pages"`
+
+	Idempotency []snapshotIdempotency `json:"idempotency,omitempty"`
+	Webhooks    []snapshotWebhook     `json:"webhooks,omitempty"`
+	Events      []snapshotEvents      `json:"events,omitempty"`
+}
+
+// snapshotTombstone records when the tombstone of an expired session lapses.
+type snapshotTombstone struct {
+	SessionID   string               `json:"session_id"`
+	ActionType  model.ActionType     `json:"action_type,omitempty"`
+	APIKeyID    string               `json:"api_key_id,omitempty"`
+	ExternalRef string               `json:"external_ref,omitempty"`
+	Metadata    map[string]string    `json:"metadata,omitempty"`
+	WebhookURL  string               `json:"webhook_url,omitempty"`
+	History     []model.StatusChange `json:"history,omitempty"`
+	ExpiresAt   time.Time            `json:"expires_at"`
+}
+
+// snapshotFile is a stored result file with its absolute expiry.
+type snapshotFile struct {
+	DownloadID  string    `json:"download_id"`
+	Data        []byte    `json:"data"`
+	ContentType string    `json:"content_type"`
+	ModTime     time.Time `json:"mod_time"`
+	ETag        string    `json:"etag"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// snapshotScanPages holds the accumulated scan pages of one session.
+type snapshotScanPages struct {
+	SessionID string         `json:"session_id"`
+	Pages     []ScanPageData `json:"pages"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// snapshotIdempotency is a finished idempotent request with its absolute expiry.
+type snapshotIdempotency struct {
+	Key       string            `json:"key"`
+	Record    IdempotencyRecord `json:"record"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// snapshotWebhook is a webhook delivery with its absolute expiry; queued
+// deliveries have none.
+type snapshotWebhook struct {
+	Delivery  model.WebhookDelivery `json:"delivery"`
+	ExpiresAt time.Time             `json:"expires_at"`
+}
+
+// snapshotEvents is the event log of one session with its absolute expiry.
+type snapshotEvents struct {
+	SessionID string                `json:"session_id"`
+	Events    []*model.SessionEvent `json:"events"`
+	ExpiresAt time.Time             `json:"expires_at"`
+}
+
+// expiryTime converts a go-cache expiration in Unix nanoseconds to a time.
+// Zero means the item never expires.
+func expiryTime(expiration int64) time.Time {
+	if expiration == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, expiration)
+}
+
+// remainingTTL returns the time left until expiresAt, or defaultExpiry for a
+// zero time. ok is false once expiresAt has passed.
+func remainingTTL(expiresAt time.Time) (ttl time.Duration, ok bool) {
+	if expiresAt.IsZero() {
+		return defaultExpiry, true
+	}
+	ttl = time.Until(expiresAt)
+	return ttl, ttl > 0
+}
+
+// SaveSnapshot writes every live session, tombstone, file, scan page, webhook
+// delivery and event log to path. The file is replaced atomically so a crash
+// mid-write leaves the previous snapshot intact.
+func (s *MemoryStore) SaveSnapshot(path string) error {
+	snap := memorySnapshot{
+		Version: snapshotVersion,
+		TakenAt: time.Now(),
+	}
+
+	for key, item := range s.sessions.Items() {
+		switch {
+		case strings.HasPrefix(key, sessionKey("")):
+			data, err := encodeSession(item.Object.(*model.Session))
+			if err != nil {
+				return err
+			}
+			snap.Sessions = append(snap.Sessions, data)
+		case strings.HasPrefix(key, tombstoneKey("")):
+			tombstone := item.Object.(*model.Session)
+			snap.Tombstones = append(snap.Tombstones, snapshotTombstone{
+				SessionID:   tombstone.ID,
+				ActionType:  tombstone.ActionType,
+				APIKeyID:    tombstone.APIKeyID,
+				ExternalRef: tombstone.ExternalRef,
+				Metadata:    tombstone.Metadata,
+				WebhookURL:  tombstone.WebhookURL,
+				History:     tombstone.History,
+				ExpiresAt:   expiryTime(item.Expiration),
+			})
+		case strings.HasPrefix(key, idempotencyKey("")):
+			// Requests still in flight die with the process; only finished
+			// ones can be replayed after a restart.
+			rec := item.Object.(*IdempotencyRecord)
+			if rec.Pending() {
+				continue
+			}
+			snap.Idempotency = append(snap.Idempotency, snapshotIdempotency{
+				Key:       strings.TrimPrefix(key, idempotencyKey("")),
+				Record:    *rec,
+				ExpiresAt: expiryTime(item.Expiration),
+			})
+		}
+	}
+
+	for key, item := range s.files.Items() {
+		mf := item.Object.(*memoryFile)
+		snap.Files = append(snap.Files, snapshotFile{
+			DownloadID:  strings.TrimPrefix(key, fileKey("")),
+			Data:        mf.Data,
+			ContentType: mf.ContentType,
+			ModTime:     mf.ModTime,
+			ETag:        mf.ETag,
+			ExpiresAt:   expiryTime(item.Expiration),
+		})
+	}
+
+	s.pagesMu.Lock()
+	for key, item := range s.scanPages.Items() {
+		snap.ScanPages = append(snap.ScanPages, snapshotScanPages{
+			SessionID: strings.TrimPrefix(key, scanPagesKey("")),
+			Pages:     item.Object.([]ScanPageData),
+			ExpiresAt: expiryTime(item.Expiration),
+		})
+	}
+	s.pagesMu.Unlock()
+
+	s.hooksMu.Lock()
+	for _, item := range s.webhooks.Items() {
+		snap.Webhooks = append(snap.Webhooks, snapshotWebhook{
+			Delivery:  *item.Object.(*model.WebhookDelivery),
+			ExpiresAt: expiryTime(item.Expiration),
+		})
+	}
+	s.hooksMu.Unlock()
+
+	s.eventsMu.Lock()
+	for key, item := range s.events.Items() {
+		snap.Events = append(snap.Events, snapshotEvents{
+			SessionID: strings.TrimPrefix(key, eventsKey("")),
+			Events:    item.Object.([]*model.SessionEvent),
+			ExpiresAt: expiryTime(item.Expiration),
+		})
+	}
+	s.eventsMu.Unlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	log.Info().Str("path", path).Int("sessions", len(snap.Sessions)).Int("files", len(snap.Files)).Msg("store: snapshot saved")
+	return nil
+}
+
+// LoadSnapshot restores a snapshot written by SaveSnapshot. Session TTLs are
+// recomputed from CreatedAt, so sessions that expired while the server was
+// down are dropped and only their tombstone survives; they are reported to the
+// OnSessionExpired functions like any other expiry, so subscribers and
+// webhooks still learn about them. A missing file is not an error. A corrupt
+// snapshot or one with a different version is rejected as a whole and the
+// store is left untouched.
+func (s *MemoryStore) LoadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Debug().Str("path", path).Msg("store: no snapshot to restore")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snap memorySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("%w: got %d, want %d", ErrSnapshotVersion, snap.Version, snapshotVersion)
+	}
+
+	sessions := make([]*model.Session, 0, len(snap.Sessions))
+	for _, raw := range snap.Sessions {
+		session, err := decodeSession(raw)
+		if err != nil {
+			return fmt.Errorf("decode snapshot: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	restored := 0
+	var expired []string
+	for _, session := range sessions {
+		remaining := time.Until(session.CreatedAt.Add(session.SessionTTL))
+		if remaining <= 0 {
+			if !session.Status.IsTerminal() {
+				expired = append(expired, session.ID)
+			}
+			continue
+		}
+		s.sessions.Set(sessionKey(session.ID), session, remaining)
+		restored++
+	}
+	for _, t := range snap.Tombstones {
+		if ttl, ok := remainingTTL(t.ExpiresAt); ok {
+			s.sessions.Set(tombstoneKey(t.SessionID), &model.Session{
+				ID:          t.SessionID,
+				ActionType:  t.ActionType,
+				Status:      model.SessionStatusExpired,
+				APIKeyID:    t.APIKeyID,
+				ExternalRef: t.ExternalRef,
+				Metadata:    t.Metadata,
+				WebhookURL:  t.WebhookURL,
+				History:     t.History,
+			}, ttl)
+		}
+	}
+	for _, r := range snap.Idempotency {
+		if ttl, ok := remainingTTL(r.ExpiresAt); ok {
+			rec := r.Record
+			s.sessions.Set(idempotencyKey(r.Key), &rec, ttl)
+		}
+	}
+	for _, f := range snap.Files {
+		if ttl, ok := remainingTTL(f.ExpiresAt); ok {
+			s.files.Set(fileKey(f.DownloadID), &memoryFile{
+				Data:        f.Data,
+				ContentType: f.ContentType,
+				ModTime:     f.ModTime,
+				ETag:        f.ETag,
+			}, ttl)
+		}
+	}
+	s.pagesMu.Lock()
+	for _, p := range snap.ScanPages {
+		if ttl, ok := remainingTTL(p.ExpiresAt); ok {
+			s.scanPages.Set(scanPagesKey(p.SessionID), p.Pages, ttl)
+		}
+	}
+	s.pagesMu.Unlock()
+	for _, w := range snap.Webhooks {
+		if ttl, ok := remainingTTL(w.ExpiresAt); ok {
+			d := w.Delivery
+			s.webhooks.Set(webhookKey(d.ID), &d, ttl)
+		}
+	}
+	for _, e := range snap.Events {
+		if ttl, ok := remainingTTL(e.ExpiresAt); ok {
+			s.events.Set(eventsKey(e.SessionID), e.Events, ttl)
+		}
+	}
+
+	s.expiryMu.Lock()
+	s.expiredOnLoad = append(s.expiredOnLoad, expired...)
+	fns := append([]func(sessionID string){}, s.expiryFns...)
+	s.expiryMu.Unlock()
+	if len(fns) > 0 && len(expired) > 0 {
+		go func() {
+			for _, id := range expired {
+				for _, fn := range fns {
+					fn(id)
+				}
+			}
+		}()
+	}
+
+	log.Info().Str("path", path).Time("taken_at", snap.TakenAt).Int("sessions", restored).Int("expired", len(expired)).Int("files", len(snap.Files)).Msg("store: snapshot restored")
+	return nil
+}
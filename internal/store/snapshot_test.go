@@ -0,0 +1,191 @@
+package store_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	saved := store.NewMemoryStore()
+
+	session := newSnapshotSession(time.Minute)
+	session.ExternalRef = "order-1234"
+	session.Metadata = map[string]string{"customer": "c-42"}
+	if err := saved.CreateSession(session); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := saved.MarkSessionOpened(session.ID); err != nil {
+		t.Fatalf("MarkSessionOpened: %v", err)
+	}
+	if err := saved.StoreFile("f1", []byte("result"), "application/pdf", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+	page := store.ScanPageData{DocumentIndex: 1, PageIndex: 2, Data: []byte("page"), ContentType: "image/jpeg"}
+	if err := saved.AddScanPage(session.ID, page, time.Minute); err != nil {
+		t.Fatalf("AddScanPage: %v", err)
+	}
+	if err := saved.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	loaded := store.NewMemoryStore()
+	if err := loaded.LoadSnapshot(path); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+
+	got, err := loaded.GetSession(session.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetSession: got (%v, %v), want the session", got, err)
+	}
+	if got.Status != model.SessionStatusOpened || !got.Opened || got.ExternalRef != "order-1234" || got.Metadata["customer"] != "c-42" || len(got.History) != 2 {
+		t.Errorf("restored session: got %+v", got)
+	}
+
+	f, err := loaded.OpenFile("f1")
+	if err != nil || f == nil {
+		t.Fatalf("OpenFile: got (%v, %v), want the file", f, err)
+	}
+	if data, err := f.ReadAll(); err != nil || string(data) != "result" || f.ContentType != "application/pdf" {
+		t.Errorf("restored file: got %q %q, %v", data, f.ContentType, err)
+	}
+
+	pages, err := loaded.GetScanPages(session.ID)
+	if err != nil || len(pages) != 1 || pages[0].DocumentIndex != 1 || pages[0].PageIndex != 2 || string(pages[0].Data) != "page" {
+		t.Errorf("restored scan pages: got (%+v, %v)", pages, err)
+	}
+}
+
+func TestSnapshotExpiredWhileDown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	saved := store.NewMemoryStore()
+
+	expiring := newSnapshotSession(100 * time.Millisecond)
+	completed := newSnapshotSession(100 * time.Millisecond)
+	for _, sess := range []*model.Session{expiring, completed} {
+		if err := saved.CreateSession(sess); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+	}
+	if _, err := saved.MarkCompleted(completed.ID, 0, model.ActionResult{}); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if err := saved.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// One function is registered before the snapshot is loaded, one after,
+	// as the server does once the store is set up.
+	before := make(chan string, 4)
+	after := make(chan string, 4)
+	loaded := store.NewMemoryStore()
+	loaded.OnSessionExpired(func(id string) { before <- id })
+	if err := loaded.LoadSnapshot(path); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	loaded.OnSessionExpired(func(id string) { after <- id })
+
+	for name, ch := range map[string]chan string{"registered before": before, "registered after": after} {
+		select {
+		case id := <-ch:
+			if id != expiring.ID {
+				t.Errorf("%s: got expiry of %q, want %q", name, id, expiring.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no expiry reported", name)
+		}
+		select {
+		case id := <-ch:
+			t.Errorf("%s: unexpected expiry of %q", name, id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	got, err := loaded.GetSession(expiring.ID)
+	if err != nil || got == nil || got.Status != model.SessionStatusExpired {
+		t.Errorf("GetSession: got (%+v, %v), want the expired tombstone", got, err)
+	}
+}
+
+func TestSnapshotRejected(t *testing.T) {
+	dir := t.TempDir()
+	if err := store.NewMemoryStore().LoadSnapshot(filepath.Join(dir, "missing.json")); err != nil {
+		t.Errorf("LoadSnapshot (missing file): %v", err)
+	}
+
+	// Each case spoils a snapshot holding a valid session, which must not be
+	// restored on its own.
+	path := filepath.Join(dir, "snapshot.json")
+	saved := store.NewMemoryStore()
+	session := newSnapshotSession(time.Minute)
+	if err := saved.CreateSession(session); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := saved.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	valid, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		spoil func(string) string
+		want  error
+	}{
+		"truncated": {spoil: func(s string) string { return s[:len(s)/2] }},
+		"corrupt entry": {spoil: func(s string) string {
+			return strings.Replace(s, `"sessions":[`, `"sessions":[{"status":7},`, 1)
+		}},
+		"old version": {
+			spoil: func(s string) string { return strings.Replace(s, `"version":1`, `"version":0`, 1) },
+			want:  store.ErrSnapshotVersion,
+		},
+		"newer version": {
+			spoil: func(s string) string { return strings.Replace(s, `"version":1`, `"version":2`, 1) },
+			want:  store.ErrSnapshotVersion,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			spoiled := tc.spoil(string(valid))
+			if spoiled == string(valid) {
+				t.Fatal("snapshot left unchanged")
+			}
+			if err := os.WriteFile(path, []byte(spoiled), 0o600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			s := store.NewMemoryStore()
+			err := s.LoadSnapshot(path)
+			if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+				t.Fatalf("LoadSnapshot: err = %v, want %v", err, tc.want)
+			}
+			if got, err := s.GetSession(session.ID); err != nil || got != nil {
+				t.Errorf("GetSession after rejected snapshot: got (%+v, %v), want (nil, nil)", got, err)
+			}
+		})
+	}
+}
+
+func newSnapshotSession(ttl time.Duration) *model.Session {
+	return &model.Session{
+		ID:           model.NewSessionID(),
+		ActionType:   model.ActionTypePhoto,
+		Status:       model.SessionStatusPending,
+		OutputFormat: model.OutputFormatJPG,
+		SessionTTL:   ttl,
+		ResultTTL:    time.Minute,
+		CreatedAt:    time.Now(),
+		History: []model.StatusChange{
+			{Status: model.SessionStatusPending, At: time.Now(), Actor: model.ActorAPI},
+		},
+	}
+}
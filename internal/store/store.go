@@ -1,266 +1,315 @@
 package store
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"time"
 
 	"github.com/mxcd/handoff/internal/model"
-	cache "github.com/patrickmn/go-cache"
-	"github.com/rs/zerolog/log"
 )
 
-const (
-	tombstoneTTL    = 24 * time.Hour
-	sessionKeyFmt   = "session:%s"
-	tombstoneKeyFmt = "tombstone:%s"
-	fileKeyFmt      = "file:%s"
-	scanPagesKeyFmt = "scanpages:%s"
-	defaultExpiry   = cache.NoExpiration
-	cleanupInterval = time.Minute
-)
-
-// Store is an in-memory session and file store backed by patrickmn/go-cache.
-// Sessions expire according to their SessionTTL. Tombstones linger for 24 hours
-// after session expiry so callers can distinguish "expired" from "never existed".
-// Result files expire independently according to the session's ResultTTL.
-// Scan pages accumulate until finalization or expiry.
-type Store struct {
-	sessions  *cache.Cache // keyed by "session:{id}"
-	files     *cache.Cache // keyed by "file:{downloadID}"
-	scanPages *cache.Cache // keyed by "scanpages:{sessionID}", stores []ScanPageData
-}
-
-// ScanPageData holds raw uploaded page data before finalization.
-type ScanPageData struct {
-	DocumentIndex int
-	PageIndex     int
-	Data          []byte
-	ContentType   string
-}
-
-// NewStore creates a new Store with separate caches for sessions, files, and scan pages.
-// The session cache retains tombstone entries for up to 24 hours.
-// The file cache uses a 5-minute default expiry.
-func NewStore() *Store {
-	return &Store{
-		// 24-hour default expiry covers the tombstone lifetime; cleanup runs every minute.
-		sessions: cache.New(tombstoneTTL, cleanupInterval),
-		// 5-minute default expiry for files; cleanup every minute.
-		files: cache.New(5*time.Minute, cleanupInterval),
-		// scan pages live as long as the session; cleanup every minute.
-		scanPages: cache.New(tombstoneTTL, cleanupInterval),
-	}
+// SessionStore persists sessions and the tombstones left behind once they expire.
+//
+// GetSession return semantics are shared by every backend:
+//   - (session, nil)  — live session found
+//   - (expiredSession, nil)  — session expired but tombstone present; Status == expired
+//   - (nil, nil)  — never existed or tombstone also gone; caller should 404
+type SessionStore interface {
+	// CreateSession stores a new session with its SessionTTL and a tombstone that outlives it.
+	CreateSession(session *model.Session) error
+	// GetSession retrieves a session by ID.
+	GetSession(id string) (*model.Session, error)
+	// UpdateSession replaces a session, keeping the remaining TTL derived from
+	// CreatedAt + SessionTTL, so changing SessionTTL extends or shortens it.
+	UpdateSession(session *model.Session) error
+	// DeleteSession removes a session, its tombstone and its event log.
+	DeleteSession(id string) error
+	// MarkSessionOpened sets the session status to "opened" and marks the Opened flag.
+	// The Mark methods record the change in the session's history and return an
+	// error wrapping model.ErrInvalidTransition if the session cannot move there.
+	MarkSessionOpened(id string) error
+	// MarkActionStarted sets the session status to "action_started" on behalf
+	// of the phone and returns the updated session.
+	MarkActionStarted(id string) (*model.Session, error)
+	// MarkSessionCancelled sets the session status to "cancelled" on behalf of
+	// the API and returns the updated session.
+	MarkSessionCancelled(id string) (*model.Session, error)
+	// UpdateSessionTTL applies change to the SessionTTL and ResultTTL of a
+	// live session and returns the updated session, moving its expiry along
+	// like UpdateSession. Returns an error wrapping model.ErrInvalidTransition
+	// if the session has already completed or been cancelled, and
+	// ErrTTLElapsed if the new SessionTTL would expire it.
+	UpdateSessionTTL(id string, change TTLChange) (*model.Session, error)
+	// UpdateDeliveryStatus records files as the push state of a completed
+	// session's delivery target, leaving the rest of the session as it is.
+	// Nothing is recorded once the session has expired.
+	UpdateDeliveryStatus(id string, files []model.FileDelivery) error
+	// MarkCompleted records r as the result of step, see model.Session.Complete,
+	// and returns the updated session. A session without steps is completed at
+	// step 0; a workflow session completes with its last step.
+	MarkCompleted(id string, step int, r model.ActionResult) (*model.Session, error)
+	// ListSessions returns one page of live sessions matching filter. Returns
+	// ErrInvalidCursor if filter.Cursor was not issued by this store.
+	ListSessions(filter SessionFilter) (*SessionList, error)
+	// ReserveIdempotencyKey stores record under key for ttl unless the key is
+	// already taken, in which case the existing record is returned instead.
+	// Returns (nil, nil) when the key was reserved.
+	ReserveIdempotencyKey(key string, record *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, error)
+	// SaveIdempotencyRecord replaces the record under key, resetting its TTL.
+	SaveIdempotencyRecord(key string, record *IdempotencyRecord, ttl time.Duration) error
+	// DeleteIdempotencyRecord releases key so it can be reserved again.
+	DeleteIdempotencyRecord(key string) error
+
+	EventLog
+	WebhookQueue
 }
 
-// sessionKey returns the cache key for the given session ID.
-func sessionKey(id string) string {
-	return fmt.Sprintf(sessionKeyFmt, id)
+// FileStore persists result files and the raw pages of in-progress scan sessions.
+type FileStore interface {
+	// StoreFile stores file data and its content type under downloadID for ttl.
+	StoreFile(downloadID string, data []byte, contentType string, ttl time.Duration) error
+	// OpenFile opens a stored file for streaming. Returns (nil, nil) if it expired
+	// or never existed. The caller must Close the returned file.
+	OpenFile(downloadID string) (*StoredFile, error)
+	// DeleteFile removes a stored file before it expires. Deleting a missing
+	// file is not an error.
+	DeleteFile(downloadID string) error
+	// AddScanPage appends a page to the accumulated scan pages for a session.
+	AddScanPage(sessionID string, page ScanPageData, ttl time.Duration) error
+	// GetScanPages returns all accumulated scan pages for a session in upload order.
+	GetScanPages(sessionID string) ([]ScanPageData, error)
+	// GetScanPageCount returns the number of accumulated scan pages for a session.
+	GetScanPageCount(sessionID string) (int, error)
+	// ClearScanPages removes all accumulated scan pages for a session.
+	ClearScanPages(sessionID string) error
+	// TouchScanPages resets the expiry of a session's accumulated scan pages to
+	// ttl from now, e.g. after the session's TTL changed. No-op without pages.
+	TouchScanPages(sessionID string, ttl time.Duration) error
 }
 
-// tombstoneKey returns the cache key for the expired-session tombstone of id.
-func tombstoneKey(id string) string {
-	return fmt.Sprintf(tombstoneKeyFmt, id)
+// Store is the complete storage backend used by the server.
+// Every implementation must pass the conformance suite in package storetest.
+type Store interface {
+	SessionStore
+	FileStore
 }
 
-// fileKey returns the cache key for the given download ID.
-func fileKey(downloadID string) string {
-	return fmt.Sprintf(fileKeyFmt, downloadID)
+// newTombstone returns the minimal record kept once session expires. It keeps
+// the caller's correlation fields so expired lookups can still echo them, the
+// owner and action type that account event streams filter on, the webhook to
+// notify of the expiry, and the status history, ending with the expiry unless
+// the session already reached a terminal status.
+func newTombstone(session *model.Session) *model.Session {
+	tombstone := &model.Session{
+		ID:          session.ID,
+		ActionType:  session.ActionType,
+		Status:      model.SessionStatusExpired,
+		APIKeyID:    session.APIKeyID,
+		ExternalRef: session.ExternalRef,
+		Metadata:    session.Metadata,
+		WebhookURL:  session.WebhookURL,
+	}
+	if len(session.History) > 0 {
+		tombstone.History = make([]model.StatusChange, len(session.History), len(session.History)+1)
+		copy(tombstone.History, session.History)
+		if !session.Status.IsTerminal() {
+			tombstone.History = append(tombstone.History, model.StatusChange{
+				Status: model.SessionStatusExpired,
+				At:     session.CreatedAt.Add(session.SessionTTL),
+				Actor:  model.ActorSystem,
+			})
+		}
+	}
+	return tombstone
 }
 
-// scanPagesKey returns the cache key for the accumulated scan pages of a session.
-func scanPagesKey(sessionID string) string {
-	return fmt.Sprintf(scanPagesKeyFmt, sessionID)
+// TTLChange is a change to the TTLs of a live session. It is applied to the
+// stored session, so an extension adds to the TTL current at that time rather
+// than the one the caller last read.
+type TTLChange struct {
+	// SessionTTL replaces the session TTL, unless it is zero.
+	SessionTTL time.Duration
+	// ExtendBy is then added to the session TTL; negative shortens it.
+	ExtendBy time.Duration
+	// ResultTTL replaces the result TTL, unless it is zero.
+	ResultTTL time.Duration
 }
 
-// CreateSession stores a new session in the cache with its SessionTTL.
-// It also stores a tombstone entry that persists for 24 hours so expired sessions
-// can be distinguished from sessions that never existed.
-func (s *Store) CreateSession(session *model.Session) error {
-	key := sessionKey(session.ID)
-	tombstone := tombstoneKey(session.ID)
+// ErrTTLElapsed is returned by UpdateSessionTTL when the new session TTL has
+// already elapsed.
+var ErrTTLElapsed = errors.New("session TTL would expire the session")
 
-	log.Debug().Str("session_id", session.ID).Dur("ttl", session.SessionTTL).Msg("store: creating session")
+// errSessionNotFound is returned by the stores' mutateSession when the live
+// session is gone.
+var errSessionNotFound = errors.New("session not found")
 
-	// Store the live session entry with the session's own TTL.
-	s.sessions.Set(key, session, session.SessionTTL)
+// errDeliveryNotRecorded tells UpdateDeliveryStatus that the session has no
+// delivery to record.
+var errDeliveryNotRecorded = errors.New("delivery not recorded")
 
-	// Pre-store a tombstone that outlives the session.
-	// The tombstone is a minimal Session snapshot indicating expiry.
-	expired := &model.Session{
-		ID:     session.ID,
-		Status: model.SessionStatusExpired,
+// setDeliveryStatus applies the change of UpdateDeliveryStatus to sess.
+func setDeliveryStatus(sess *model.Session, files []model.FileDelivery) error {
+	if sess.Status != model.SessionStatusCompleted || sess.Delivery == nil {
+		return errDeliveryNotRecorded
 	}
-	s.sessions.Set(tombstone, expired, tombstoneTTL)
-
+	sess.Delivery.Files = append([]model.FileDelivery(nil), files...)
 	return nil
 }
 
-// GetSession retrieves a session by ID.
-//
-// Return semantics:
-//   - (session, nil)  — live session found
-//   - (expiredSession, nil)  — session expired but tombstone present; Status == expired
-//   - (nil, nil)  — never existed or tombstone also gone; caller should 404
-func (s *Store) GetSession(id string) (*model.Session, error) {
-	// Try the live session entry first.
-	if v, found := s.sessions.Get(sessionKey(id)); found {
-		sess := v.(*model.Session)
-		log.Debug().Str("session_id", id).Str("status", string(sess.Status)).Msg("store: session found")
-		return sess, nil
+// setSessionTTLs applies the TTL change of UpdateSessionTTL to sess.
+func setSessionTTLs(sess *model.Session, change TTLChange) error {
+	if sess.Status.IsTerminal() {
+		return fmt.Errorf("%w: cannot change the TTL of a %s session", model.ErrInvalidTransition, sess.Status)
 	}
-
-	// Not found — check for a tombstone (expired session).
-	if v, found := s.sessions.Get(tombstoneKey(id)); found {
-		expired := v.(*model.Session)
-		log.Debug().Str("session_id", id).Msg("store: tombstone found — session expired")
-		return expired, nil
+	sessionTTL := sess.SessionTTL
+	if change.SessionTTL != 0 {
+		sessionTTL = change.SessionTTL
 	}
-
-	log.Debug().Str("session_id", id).Msg("store: session not found")
-	return nil, nil
-}
-
-// UpdateSession replaces a session in the cache, preserving a proportional TTL
-// calculated from CreatedAt + SessionTTL - now.
-func (s *Store) UpdateSession(session *model.Session) error {
-	remaining := time.Until(session.CreatedAt.Add(session.SessionTTL))
-	if remaining <= 0 {
-		// Session has already expired; nothing to update.
-		log.Debug().Str("session_id", session.ID).Msg("store: update skipped — session already expired")
-		return nil
+	sessionTTL += change.ExtendBy
+	if !time.Now().Before(sess.CreatedAt.Add(sessionTTL)) {
+		return fmt.Errorf("%w: %v for session %q", ErrTTLElapsed, sessionTTL, sess.ID)
+	}
+	sess.SessionTTL = sessionTTL
+	if change.ResultTTL != 0 {
+		sess.ResultTTL = change.ResultTTL
 	}
-
-	log.Debug().Str("session_id", session.ID).Dur("remaining_ttl", remaining).Msg("store: updating session")
-	s.sessions.Set(sessionKey(session.ID), session, remaining)
 	return nil
 }
 
-// DeleteSession removes a session (and its tombstone) from the store.
-func (s *Store) DeleteSession(id string) error {
-	log.Debug().Str("session_id", id).Msg("store: deleting session")
-	s.sessions.Delete(sessionKey(id))
-	s.sessions.Delete(tombstoneKey(id))
-	return nil
+// combinedStore pairs a SessionStore with an independent FileStore.
+type combinedStore struct {
+	SessionStore
+	FileStore
 }
 
-// MarkSessionOpened sets the session status to "opened" and marks the Opened flag.
-func (s *Store) MarkSessionOpened(id string) error {
-	sess, err := s.GetSession(id)
-	if err != nil {
-		return err
+// Combine returns a Store that keeps sessions in sessions and result files and
+// scan pages in files, e.g. sessions in Redis with payloads on local disk.
+func Combine(sessions SessionStore, files FileStore) Store {
+	if c, ok := sessions.(*combinedStore); ok {
+		sessions = c.SessionStore
 	}
-	if sess == nil {
-		return fmt.Errorf("session %q not found", id)
-	}
-
-	sess.Opened = true
-	sess.Status = model.SessionStatusOpened
+	return &combinedStore{SessionStore: sessions, FileStore: files}
+}
 
-	log.Debug().Str("session_id", id).Msg("store: marking session opened")
-	return s.UpdateSession(sess)
+// FilePresigner is implemented by file stores that can hand out short-lived
+// URLs from which clients download a file directly, bypassing the server.
+type FilePresigner interface {
+	// PresignFile returns a URL valid for at most expiry, or nil if the file
+	// has expired or was never stored.
+	PresignFile(downloadID string, expiry time.Duration) (*url.URL, error)
 }
 
-// MarkSessionCompleted sets the session status to "completed", records the
-// completion time and result items, and stores each result file with the
-// session's ResultTTL.
-func (s *Store) MarkSessionCompleted(id string, result []model.ResultItem) error {
-	sess, err := s.GetSession(id)
-	if err != nil {
-		return err
+// AsPresigner returns the FilePresigner behind st, looking through stores
+// created with Combine.
+func AsPresigner(st Store) (FilePresigner, bool) {
+	if c, ok := st.(*combinedStore); ok {
+		p, ok := c.FileStore.(FilePresigner)
+		return p, ok
 	}
-	if sess == nil {
-		return fmt.Errorf("session %q not found", id)
-	}
-
-	now := time.Now()
-	sess.Status = model.SessionStatusCompleted
-	sess.CompletedAt = &now
-	sess.Result = result
+	p, ok := st.(FilePresigner)
+	return p, ok
+}
 
-	log.Debug().Str("session_id", id).Int("result_items", len(result)).Msg("store: marking session completed")
-	return s.UpdateSession(sess)
+// ExpiryNotifier is implemented by session stores that report sessions whose
+// TTL elapsed before they reached a terminal status, so subscribers can be
+// told instead of waiting forever.
+type ExpiryNotifier interface {
+	// OnSessionExpired registers fn to be called with the ID of each session
+	// that expires. fn runs on a store-owned goroutine and must not block.
+	OnSessionExpired(fn func(sessionID string))
 }
 
-// MarkScanSessionCompleted sets the session status to "completed" with a scan result.
-func (s *Store) MarkScanSessionCompleted(id string, scanResult *model.ScanResult) error {
-	sess, err := s.GetSession(id)
-	if err != nil {
-		return err
-	}
-	if sess == nil {
-		return fmt.Errorf("session %q not found", id)
+// AsExpiryNotifier returns the ExpiryNotifier behind st, looking through stores
+// created with Combine.
+func AsExpiryNotifier(st Store) (ExpiryNotifier, bool) {
+	if c, ok := st.(*combinedStore); ok {
+		n, ok := c.SessionStore.(ExpiryNotifier)
+		return n, ok
 	}
+	n, ok := st.(ExpiryNotifier)
+	return n, ok
+}
 
-	now := time.Now()
-	sess.Status = model.SessionStatusCompleted
-	sess.CompletedAt = &now
-	sess.ScanResult = scanResult
+// Snapshotter is implemented by stores whose state lives only in the server
+// process and can be carried across a restart via a snapshot file.
+type Snapshotter interface {
+	// SaveSnapshot writes the store's contents to path.
+	SaveSnapshot(path string) error
+	// LoadSnapshot restores the contents previously written to path.
+	LoadSnapshot(path string) error
+}
 
-	log.Debug().Str("session_id", id).Int("documents", len(scanResult.Documents)).Msg("store: marking scan session completed")
-	return s.UpdateSession(sess)
+// AsSnapshotter returns the Snapshotter behind st, looking through stores
+// created with Combine. Only the session store is considered there; a
+// separate file store is expected to persist on its own.
+func AsSnapshotter(st Store) (Snapshotter, bool) {
+	if c, ok := st.(*combinedStore); ok {
+		sn, ok := c.SessionStore.(Snapshotter)
+		return sn, ok
+	}
+	sn, ok := st.(Snapshotter)
+	return sn, ok
 }
 
-// StoredFile holds binary file data together with its MIME content type.
+// StoredFile is an open handle to a stored result file. Content supports
+// seeking so downloads can be served with http.ServeContent (Range requests).
 type StoredFile struct {
-	Data        []byte
+	// Content streams the file payload. Closed by StoredFile.Close.
+	Content io.ReadSeekCloser
+	// ContentType is the MIME type recorded when the file was stored.
 	ContentType string
+	// Size is the payload length in bytes.
+	Size int64
+	// ModTime is when the file was stored; used for Last-Modified.
+	ModTime time.Time
+	// ETag is a quoted strong entity tag derived from the payload.
+	ETag string
 }
 
-// StoreFile stores binary file data and its content type under the given downloadID with a specific TTL.
-func (s *Store) StoreFile(downloadID string, data []byte, contentType string, ttl time.Duration) error {
-	log.Debug().Str("download_id", downloadID).Dur("ttl", ttl).Int("bytes", len(data)).Str("content_type", contentType).Msg("store: storing file")
-	s.files.Set(fileKey(downloadID), &StoredFile{Data: data, ContentType: contentType}, ttl)
-	return nil
+// Close releases the underlying content reader.
+func (f *StoredFile) Close() error {
+	return f.Content.Close()
 }
 
-// GetFile retrieves file data by downloadID. Returns nil if the file has
-// expired or was never stored.
-func (s *Store) GetFile(downloadID string) (*StoredFile, error) {
-	v, found := s.files.Get(fileKey(downloadID))
-	if !found {
-		log.Debug().Str("download_id", downloadID).Msg("store: file not found or expired")
-		return nil, nil
-	}
-
-	sf := v.(*StoredFile)
-	log.Debug().Str("download_id", downloadID).Int("bytes", len(sf.Data)).Msg("store: file retrieved")
-	return sf, nil
+// ReadAll reads the complete payload and closes the file.
+func (f *StoredFile) ReadAll() ([]byte, error) {
+	defer f.Close()
+	return io.ReadAll(f.Content)
 }
 
-// AddScanPage appends a page to the accumulated scan pages for a session.
-// The TTL is set to match the session's remaining TTL.
-func (s *Store) AddScanPage(sessionID string, page ScanPageData, ttl time.Duration) error {
-	key := scanPagesKey(sessionID)
-	var pages []ScanPageData
-	if v, found := s.scanPages.Get(key); found {
-		pages = v.([]ScanPageData)
-	}
-	pages = append(pages, page)
-	s.scanPages.Set(key, pages, ttl)
-	log.Debug().Str("session_id", sessionID).Int("document_index", page.DocumentIndex).Int("page_index", page.PageIndex).Int("total_pages", len(pages)).Msg("store: scan page added")
-	return nil
+// nopReadSeekCloser adds a no-op Close to an in-memory reader.
+type nopReadSeekCloser struct {
+	io.ReadSeeker
 }
 
-// GetScanPages returns all accumulated scan pages for a session.
-func (s *Store) GetScanPages(sessionID string) ([]ScanPageData, error) {
-	v, found := s.scanPages.Get(scanPagesKey(sessionID))
-	if !found {
-		return nil, nil
+func (nopReadSeekCloser) Close() error { return nil }
+
+// newBytesFile wraps an in-memory payload as a StoredFile.
+func newBytesFile(data []byte, contentType string, modTime time.Time, etag string) *StoredFile {
+	return &StoredFile{
+		Content:     nopReadSeekCloser{bytes.NewReader(data)},
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		ModTime:     modTime,
+		ETag:        etag,
 	}
-	return v.([]ScanPageData), nil
 }
 
-// GetScanPageCount returns the number of accumulated scan pages for a session.
-func (s *Store) GetScanPageCount(sessionID string) int {
-	v, found := s.scanPages.Get(scanPagesKey(sessionID))
-	if !found {
-		return 0
-	}
-	return len(v.([]ScanPageData))
+// fileETag returns a quoted strong ETag for the payload.
+func fileETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
 }
 
-// ClearScanPages removes all accumulated scan pages for a session.
-func (s *Store) ClearScanPages(sessionID string) {
-	s.scanPages.Delete(scanPagesKey(sessionID))
-	log.Debug().Str("session_id", sessionID).Msg("store: scan pages cleared")
+// ScanPageData holds raw uploaded page data before finalization.
+type ScanPageData struct {
+	DocumentIndex int
+	PageIndex     int
+	Data          []byte
+	ContentType   string
 }
@@ -0,0 +1,1373 @@
+// Package storetest provides a conformance suite that every store.Store backend
+// must pass. Backends call TestStore from their own tests:
+//
+//	func TestMemoryStore(t *testing.T) {
+//		storetest.TestStore(t, func(t *testing.T) store.Store {
+//			return store.NewMemoryStore()
+//		})
+//	}
+//
+// Backends that only hold files (or only sessions) run TestFileStore or
+// TestSessionStore instead. Backends faked in-process whose entries only
+// expire when their clock is advanced, such as miniredis, pass
+// WithFastForward.
+package storetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+)
+
+// Factory returns a fresh, empty store for a single subtest.
+type Factory func(t *testing.T) store.Store
+
+// SessionFactory returns a fresh, empty session store for a single subtest.
+type SessionFactory func(t *testing.T) store.SessionStore
+
+// FileFactory returns a fresh, empty file store for a single subtest.
+type FileFactory func(t *testing.T) store.FileStore
+
+// shortTTL is long enough for a round trip to an external backend and short
+// enough that expiry tests finish quickly.
+const shortTTL = 1500 * time.Millisecond
+
+// Option configures the conformance suite.
+type Option func(*options)
+
+type options struct {
+	fastForward func(d time.Duration)
+}
+
+// WithFastForward makes the expiry tests call fn with the time they waited,
+// so a fake backend can advance its clock by as much.
+func WithFastForward(fn func(d time.Duration)) Option {
+	return func(o *options) { o.fastForward = fn }
+}
+
+// elapser returns a function that waits for d and then fast-forwards the
+// backend by d, if it asked for that.
+func elapser(opts []Option) func(d time.Duration) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(d time.Duration) {
+		time.Sleep(d)
+		if o.fastForward != nil {
+			o.fastForward(d)
+		}
+	}
+}
+
+// TestStore runs the full conformance suite against the backend produced by newStore.
+func TestStore(t *testing.T, newStore Factory, opts ...Option) {
+	TestSessionStore(t, func(t *testing.T) store.SessionStore { return newStore(t) }, opts...)
+	TestFileStore(t, func(t *testing.T) store.FileStore { return newStore(t) }, opts...)
+}
+
+// TestSessionStore runs the session half of the conformance suite.
+func TestSessionStore(t *testing.T, newStore SessionFactory, opts ...Option) {
+	elapse := elapser(opts)
+	t.Run("SessionRoundTrip", func(t *testing.T) { testSessionRoundTrip(t, newStore(t)) })
+	t.Run("SessionNotFound", func(t *testing.T) { testSessionNotFound(t, newStore(t)) })
+	t.Run("SessionExpiryLeavesTombstone", func(t *testing.T) { testSessionExpiry(t, newStore(t), elapse) })
+	t.Run("UpdateSession", func(t *testing.T) { testUpdateSession(t, newStore(t)) })
+	t.Run("UpdateSessionTTL", func(t *testing.T) { testUpdateSessionTTL(t, newStore(t), elapse) })
+	t.Run("UpdateSessionTTLAtomic", func(t *testing.T) { testUpdateSessionTTLAtomic(t, newStore(t), elapse) })
+	t.Run("ExtendSessionTTL", func(t *testing.T) { testExtendSessionTTL(t, newStore(t)) })
+	t.Run("DeleteSession", func(t *testing.T) { testDeleteSession(t, newStore(t)) })
+	t.Run("GetSessionReturnsCopy", func(t *testing.T) { testGetSessionReturnsCopy(t, newStore(t)) })
+	t.Run("MarkSessionOpened", func(t *testing.T) { testMarkSessionOpened(t, newStore(t)) })
+	t.Run("MarkActionStarted", func(t *testing.T) { testMarkActionStarted(t, newStore(t)) })
+	t.Run("MarkSessionCancelled", func(t *testing.T) { testMarkSessionCancelled(t, newStore(t)) })
+	t.Run("MarkCompleted", func(t *testing.T) { testMarkCompleted(t, newStore(t)) })
+	t.Run("MarkCompletedScan", func(t *testing.T) { testMarkCompletedScan(t, newStore(t)) })
+	t.Run("MarkCompletedBarcode", func(t *testing.T) { testMarkCompletedBarcode(t, newStore(t)) })
+	t.Run("MarkCompletedSteps", func(t *testing.T) { testMarkCompletedSteps(t, newStore(t)) })
+	t.Run("UpdateDeliveryStatus", func(t *testing.T) { testUpdateDeliveryStatus(t, newStore(t)) })
+	t.Run("MarkUnknownSession", func(t *testing.T) { testMarkUnknownSession(t, newStore(t)) })
+	t.Run("ConcurrentTransitions", func(t *testing.T) { testConcurrentTransitions(t, newStore(t)) })
+	t.Run("ExpiryNotification", func(t *testing.T) { testExpiryNotification(t, newStore(t), elapse) })
+	t.Run("ListSessions", func(t *testing.T) { testListSessions(t, newStore(t)) })
+	t.Run("IdempotencyKeys", func(t *testing.T) { testIdempotencyKeys(t, newStore(t), elapse) })
+	t.Run("EventLog", func(t *testing.T) { testEventLog(t, newStore(t)) })
+	t.Run("WebhookQueue", func(t *testing.T) { testWebhookQueue(t, newStore(t)) })
+}
+
+// TestFileStore runs the file and scan page half of the conformance suite.
+func TestFileStore(t *testing.T, newStore FileFactory, opts ...Option) {
+	elapse := elapser(opts)
+	t.Run("FileRoundTrip", func(t *testing.T) { testFileRoundTrip(t, newStore(t)) })
+	t.Run("FileRange", func(t *testing.T) { testFileRange(t, newStore(t)) })
+	t.Run("FileOverwrite", func(t *testing.T) { testFileOverwrite(t, newStore(t)) })
+	t.Run("FileExpiry", func(t *testing.T) { testFileExpiry(t, newStore(t), elapse) })
+	t.Run("DeleteFile", func(t *testing.T) { testDeleteFile(t, newStore(t)) })
+	t.Run("ScanPages", func(t *testing.T) { testScanPages(t, newStore(t)) })
+	t.Run("TouchScanPages", func(t *testing.T) { testTouchScanPages(t, newStore(t), elapse) })
+	t.Run("ConcurrentScanPages", func(t *testing.T) { testConcurrentScanPages(t, newStore(t)) })
+}
+
+// newTestSession returns a pending photo session with the given TTL.
+func newTestSession(ttl time.Duration) *model.Session {
+	return &model.Session{
+		ID:           model.NewSessionID(),
+		ActionType:   model.ActionTypePhoto,
+		Status:       model.SessionStatusPending,
+		IntroText:    "Take a photo",
+		OutputFormat: model.OutputFormatJPG,
+		SessionTTL:   ttl,
+		ResultTTL:    time.Minute,
+		URL:          "http://localhost/s/test",
+		CreatedAt:    time.Now(),
+		ExternalRef:  "order-1234",
+		Metadata:     map[string]string{"customer": "c-42"},
+		History: []model.StatusChange{
+			{Status: model.SessionStatusPending, At: time.Now(), Actor: model.ActorAPI},
+		},
+	}
+}
+
+// checkHistory fails the test unless history records the given statuses in order.
+func checkHistory(t *testing.T, history []model.StatusChange, want ...model.SessionStatus) {
+	t.Helper()
+	if len(history) != len(want) {
+		t.Fatalf("history = %+v, want statuses %v", history, want)
+	}
+	for i, change := range history {
+		if change.Status != want[i] || change.At.IsZero() || change.Actor == "" {
+			t.Fatalf("history[%d] = %+v, want status %q with time and actor", i, change, want[i])
+		}
+	}
+}
+
+// mustGetSession fetches a session and fails the test on error or absence.
+func mustGetSession(t *testing.T, s store.SessionStore, id string) *model.Session {
+	t.Helper()
+	sess, err := s.GetSession(id)
+	if err != nil {
+		t.Fatalf("GetSession(%q): unexpected error: %v", id, err)
+	}
+	if sess == nil {
+		t.Fatalf("GetSession(%q): session not found", id)
+	}
+	return sess
+}
+
+func testSessionRoundTrip(t *testing.T, s store.SessionStore) {
+	want := newTestSession(time.Minute)
+	if err := s.CreateSession(want); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	got := mustGetSession(t, s, want.ID)
+	if got.ID != want.ID || got.ActionType != want.ActionType || got.Status != want.Status {
+		t.Errorf("GetSession: got %+v, want %+v", got, want)
+	}
+	if got.IntroText != want.IntroText || got.OutputFormat != want.OutputFormat || got.URL != want.URL {
+		t.Errorf("GetSession: fields not preserved: got %+v, want %+v", got, want)
+	}
+	if got.ExternalRef != want.ExternalRef || fmt.Sprint(got.Metadata) != fmt.Sprint(want.Metadata) {
+		t.Errorf("GetSession: correlation fields not preserved: got %q/%v, want %q/%v", got.ExternalRef, got.Metadata, want.ExternalRef, want.Metadata)
+	}
+	if got.SessionTTL != want.SessionTTL || got.ResultTTL != want.ResultTTL {
+		t.Errorf("GetSession: TTLs not preserved: got %v/%v, want %v/%v", got.SessionTTL, got.ResultTTL, want.SessionTTL, want.ResultTTL)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("GetSession: CreatedAt = %v, want %v", got.CreatedAt, want.CreatedAt)
+	}
+
+	// Delivery headers are hidden from API responses but must be kept.
+	want = newTestSession(time.Minute)
+	want.Delivery = &model.DeliveryTarget{
+		Method:  model.DeliveryMethodPut,
+		URL:     "https://uploads.example.com/{filename}",
+		Headers: map[string]string{"Authorization": "Bearer t"},
+	}
+	if err := s.CreateSession(want); err != nil {
+		t.Fatalf("CreateSession with delivery: %v", err)
+	}
+	got = mustGetSession(t, s, want.ID)
+	if got.Delivery == nil || got.Delivery.URL != want.Delivery.URL || got.Delivery.Headers["Authorization"] != "Bearer t" {
+		t.Errorf("GetSession: delivery not preserved: got %+v, want %+v", got.Delivery, want.Delivery)
+	}
+}
+
+func testSessionNotFound(t *testing.T, s store.SessionStore) {
+	sess, err := s.GetSession(model.NewSessionID())
+	if err != nil {
+		t.Fatalf("GetSession: unexpected error: %v", err)
+	}
+	if sess != nil {
+		t.Fatalf("GetSession: expected nil for unknown session, got %+v", sess)
+	}
+}
+
+// testExpiryNotification checks that stores implementing store.ExpiryNotifier
+// report sessions that expire while pending, but not completed or deleted ones.
+func testExpiryNotification(t *testing.T, s store.SessionStore, elapse func(time.Duration)) {
+	notifier, ok := s.(store.ExpiryNotifier)
+	if !ok {
+		t.Skip("store does not implement store.ExpiryNotifier")
+	}
+
+	expired := make(chan string, 8)
+	notifier.OnSessionExpired(func(id string) { expired <- id })
+
+	pending := newTestSession(shortTTL)
+	completed := newTestSession(shortTTL)
+	deleted := newTestSession(shortTTL)
+	for _, sess := range []*model.Session{pending, completed, deleted} {
+		if err := s.CreateSession(sess); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+	}
+	if _, err := s.MarkCompleted(completed.ID, 0, model.ActionResult{}); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if err := s.DeleteSession(deleted.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+
+	elapse(shortTTL)
+	select {
+	case id := <-expired:
+		if id != pending.ID {
+			t.Fatalf("expiry notification for %q, want %q", id, pending.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no expiry notification for pending session")
+	}
+
+	select {
+	case id := <-expired:
+		t.Fatalf("unexpected second expiry notification for %q", id)
+	case <-time.After(2 * time.Second):
+	}
+}
+
+func testListSessions(t *testing.T, s store.SessionStore) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var all []*model.Session
+	for i := 0; i < 7; i++ {
+		sess := newTestSession(time.Minute)
+		sess.CreatedAt = base.Add(time.Duration(i) * time.Second)
+		sess.SessionTTL = time.Hour + time.Minute
+		if i%2 == 1 {
+			sess.ActionType = model.ActionTypeSignature
+		}
+		if i < 3 {
+			sess.APIKeyID = "key-a"
+		} else {
+			sess.APIKeyID = "key-b"
+		}
+		sess.ExternalRef = fmt.Sprintf("order-%d", i)
+		sess.Metadata = map[string]string{"tenant": sess.APIKeyID, "parity": fmt.Sprint(i % 2)}
+		if err := s.CreateSession(sess); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		all = append(all, sess)
+	}
+	if err := s.MarkSessionOpened(all[4].ID); err != nil {
+		t.Fatalf("MarkSessionOpened: %v", err)
+	}
+
+	ids := func(list *store.SessionList) []string {
+		out := make([]string, len(list.Sessions))
+		for i, sess := range list.Sessions {
+			out[i] = sess.ID
+		}
+		return out
+	}
+	expect := func(name string, got []string, want ...*model.Session) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d sessions, want %d", name, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i].ID {
+				t.Fatalf("%s: session %d = %q, want %q", name, i, got[i], want[i].ID)
+			}
+		}
+	}
+	list := func(f store.SessionFilter) *store.SessionList {
+		t.Helper()
+		res, err := s.ListSessions(f)
+		if err != nil {
+			t.Fatalf("ListSessions(%+v): %v", f, err)
+		}
+		return res
+	}
+
+	expect("default order", ids(list(store.SessionFilter{})), all[6], all[5], all[4], all[3], all[2], all[1], all[0])
+	expect("ascending", ids(list(store.SessionFilter{Order: store.SortOrderAsc})), all...)
+	expect("status", ids(list(store.SessionFilter{Status: []model.SessionStatus{model.SessionStatusOpened}})), all[4])
+	expect("action type", ids(list(store.SessionFilter{
+		ActionType: []model.ActionType{model.ActionTypeSignature},
+		Order:      store.SortOrderAsc,
+	})), all[1], all[3], all[5])
+	expect("api key", ids(list(store.SessionFilter{APIKeyID: "key-a", Order: store.SortOrderAsc})), all[0], all[1], all[2])
+	expect("external ref", ids(list(store.SessionFilter{ExternalRef: "order-3"})), all[3])
+	expect("metadata", ids(list(store.SessionFilter{
+		Metadata: map[string]string{"tenant": "key-b", "parity": "0"},
+		Order:    store.SortOrderAsc,
+	})), all[4], all[6])
+	expect("created range", ids(list(store.SessionFilter{
+		CreatedAfter:  all[1].CreatedAt,
+		CreatedBefore: all[5].CreatedAt,
+		Order:         store.SortOrderAsc,
+	})), all[2], all[3], all[4])
+
+	for _, order := range []store.SortOrder{store.SortOrderAsc, store.SortOrderDesc} {
+		var got []string
+		f := store.SessionFilter{Order: order, Limit: 3}
+		for pages := 0; ; pages++ {
+			if pages > len(all) {
+				t.Fatalf("pagination (%s): cursor never ran out", order)
+			}
+			page := list(f)
+			got = append(got, ids(page)...)
+			if page.NextCursor == "" {
+				break
+			}
+			f.Cursor = page.NextCursor
+		}
+		want := append([]*model.Session(nil), all...)
+		if order == store.SortOrderDesc {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		expect(fmt.Sprintf("pagination (%s)", order), got, want...)
+	}
+
+	if _, err := s.ListSessions(store.SessionFilter{Cursor: "not a cursor"}); err != store.ErrInvalidCursor {
+		t.Errorf("ListSessions with bad cursor: err = %v, want %v", err, store.ErrInvalidCursor)
+	}
+}
+
+func testSessionExpiry(t *testing.T, s store.SessionStore, elapse func(time.Duration)) {
+	sess := newTestSession(shortTTL)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	elapse(shortTTL + 500*time.Millisecond)
+
+	got := mustGetSession(t, s, sess.ID)
+	if got.Status != model.SessionStatusExpired {
+		t.Fatalf("GetSession after TTL: status = %q, want %q", got.Status, model.SessionStatusExpired)
+	}
+	if got.ID != sess.ID {
+		t.Fatalf("GetSession after TTL: tombstone ID = %q, want %q", got.ID, sess.ID)
+	}
+	if got.ExternalRef != sess.ExternalRef {
+		t.Errorf("GetSession after TTL: tombstone external_ref = %q, want %q", got.ExternalRef, sess.ExternalRef)
+	}
+	if got.ActionType != sess.ActionType {
+		t.Errorf("GetSession after TTL: tombstone action_type = %q, want %q", got.ActionType, sess.ActionType)
+	}
+	checkHistory(t, got.History, model.SessionStatusPending, model.SessionStatusExpired)
+
+	// A completed session's history ends with its completion.
+	completed := newTestSession(shortTTL)
+	if err := s.CreateSession(completed); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if _, err := s.MarkCompleted(completed.ID, 0, model.ActionResult{}); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	elapse(shortTTL + 500*time.Millisecond)
+	got = mustGetSession(t, s, completed.ID)
+	if got.Status != model.SessionStatusExpired {
+		t.Fatalf("GetSession after TTL: status = %q, want %q", got.Status, model.SessionStatusExpired)
+	}
+	checkHistory(t, got.History, model.SessionStatusPending, model.SessionStatusCompleted)
+}
+
+func testUpdateSession(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	updated := mustGetSession(t, s, sess.ID)
+	updated.Status = model.SessionStatusActionStarted
+	if err := s.UpdateSession(updated); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+
+	if got := mustGetSession(t, s, sess.ID); got.Status != model.SessionStatusActionStarted {
+		t.Fatalf("GetSession after update: status = %q, want %q", got.Status, model.SessionStatusActionStarted)
+	}
+
+	// Updating an already-expired session is a silent no-op.
+	stale := newTestSession(time.Minute)
+	stale.CreatedAt = time.Now().Add(-2 * time.Minute)
+	if err := s.UpdateSession(stale); err != nil {
+		t.Fatalf("UpdateSession on expired session: unexpected error: %v", err)
+	}
+	if got, err := s.GetSession(stale.ID); err != nil || got != nil {
+		t.Fatalf("GetSession after stale update: got (%+v, %v), want (nil, nil)", got, err)
+	}
+}
+
+func testUpdateSessionTTL(t *testing.T, s store.SessionStore, elapse func(time.Duration)) {
+	extended := newTestSession(shortTTL)
+	shortened := newTestSession(time.Minute)
+	for _, sess := range []*model.Session{extended, shortened} {
+		if err := s.CreateSession(sess); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+	}
+
+	extended.SessionTTL = time.Minute
+	if err := s.UpdateSession(extended); err != nil {
+		t.Fatalf("UpdateSession (extend): %v", err)
+	}
+	shortened.SessionTTL = time.Since(shortened.CreatedAt) + shortTTL
+	if err := s.UpdateSession(shortened); err != nil {
+		t.Fatalf("UpdateSession (shorten): %v", err)
+	}
+
+	elapse(shortTTL + 500*time.Millisecond)
+
+	if got := mustGetSession(t, s, extended.ID); got.Status != model.SessionStatusPending || got.SessionTTL != time.Minute {
+		t.Errorf("extended session: got status %q ttl %v, want %q %v", got.Status, got.SessionTTL, model.SessionStatusPending, time.Minute)
+	}
+	if got := mustGetSession(t, s, shortened.ID); got.Status != model.SessionStatusExpired {
+		t.Errorf("shortened session: status = %q, want %q", got.Status, model.SessionStatusExpired)
+	}
+}
+
+func testUpdateSessionTTLAtomic(t *testing.T, s store.SessionStore, elapse func(time.Duration)) {
+	extended := newTestSession(shortTTL)
+	shortened := newTestSession(time.Minute)
+	completed := newTestSession(time.Minute)
+	for _, sess := range []*model.Session{extended, shortened, completed} {
+		if err := s.CreateSession(sess); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+	}
+
+	// The TTL change must not undo a status change made after the caller read
+	// the session.
+	if err := s.MarkSessionOpened(extended.ID); err != nil {
+		t.Fatalf("MarkSessionOpened: %v", err)
+	}
+	updated, err := s.UpdateSessionTTL(extended.ID, store.TTLChange{SessionTTL: time.Minute, ResultTTL: 2 * time.Minute})
+	if err != nil {
+		t.Fatalf("UpdateSessionTTL (extend): %v", err)
+	}
+	if updated.Status != model.SessionStatusOpened || updated.SessionTTL != time.Minute || updated.ResultTTL != 2*time.Minute {
+		t.Errorf("UpdateSessionTTL returned status %q ttl %v result ttl %v", updated.Status, updated.SessionTTL, updated.ResultTTL)
+	}
+	if _, err := s.UpdateSessionTTL(shortened.ID, store.TTLChange{SessionTTL: time.Since(shortened.CreatedAt) + shortTTL}); err != nil {
+		t.Fatalf("UpdateSessionTTL (shorten): %v", err)
+	}
+	if _, err := s.UpdateSessionTTL(shortened.ID, store.TTLChange{SessionTTL: time.Since(shortened.CreatedAt) - time.Second}); !errors.Is(err, store.ErrTTLElapsed) {
+		t.Errorf("UpdateSessionTTL with an elapsed TTL: err = %v, want ErrTTLElapsed", err)
+	}
+	if _, err := s.UpdateSessionTTL(shortened.ID, store.TTLChange{ExtendBy: -time.Hour}); !errors.Is(err, store.ErrTTLElapsed) {
+		t.Errorf("UpdateSessionTTL shortening past now: err = %v, want ErrTTLElapsed", err)
+	}
+
+	if _, err := s.MarkCompleted(completed.ID, 0, model.ActionResult{}); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if _, err := s.UpdateSessionTTL(completed.ID, store.TTLChange{SessionTTL: 2 * time.Minute}); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Errorf("UpdateSessionTTL on completed session: err = %v, want ErrInvalidTransition", err)
+	}
+
+	elapse(shortTTL + 500*time.Millisecond)
+
+	got := mustGetSession(t, s, extended.ID)
+	if got.Status != model.SessionStatusOpened || got.SessionTTL != time.Minute || got.ResultTTL != 2*time.Minute {
+		t.Errorf("extended session: got status %q ttl %v result ttl %v", got.Status, got.SessionTTL, got.ResultTTL)
+	}
+	if got := mustGetSession(t, s, shortened.ID); got.Status != model.SessionStatusExpired {
+		t.Errorf("shortened session: status = %q, want %q", got.Status, model.SessionStatusExpired)
+	}
+	if got := mustGetSession(t, s, completed.ID); got.SessionTTL != time.Minute {
+		t.Errorf("completed session: ttl = %v, want %v", got.SessionTTL, time.Minute)
+	}
+}
+
+func testExtendSessionTTL(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	sess.ResultTTL = 5 * time.Minute
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// Concurrent extensions add up: each applies to the TTL stored at the
+	// time, not to the one its caller read.
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.UpdateSessionTTL(sess.ID, store.TTLChange{ExtendBy: time.Minute}); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("UpdateSessionTTL (extend): %v", err)
+	}
+
+	got := mustGetSession(t, s, sess.ID)
+	if got.SessionTTL != (n+1)*time.Minute || got.ResultTTL != 5*time.Minute {
+		t.Errorf("after %d extensions: got ttl %v result ttl %v, want %v %v", n, got.SessionTTL, got.ResultTTL, (n+1)*time.Minute, 5*time.Minute)
+	}
+
+	updated, err := s.UpdateSessionTTL(sess.ID, store.TTLChange{SessionTTL: 2 * time.Minute, ExtendBy: -30 * time.Second, ResultTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("UpdateSessionTTL (set and shorten): %v", err)
+	}
+	if updated.SessionTTL != 90*time.Second || updated.ResultTTL != time.Minute {
+		t.Errorf("UpdateSessionTTL (set and shorten): got ttl %v result ttl %v, want %v %v", updated.SessionTTL, updated.ResultTTL, 90*time.Second, time.Minute)
+	}
+}
+
+func testDeleteSession(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := s.DeleteSession(sess.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+
+	got, err := s.GetSession(sess.ID)
+	if err != nil {
+		t.Fatalf("GetSession after delete: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("GetSession after delete: expected nil (no tombstone), got %+v", got)
+	}
+}
+
+func testGetSessionReturnsCopy(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// Changes to a fetched session, and to the one passed to CreateSession,
+	// stay out of the store until they are written back.
+	got := mustGetSession(t, s, sess.ID)
+	if err := got.Transition(model.SessionStatusCancelled, model.ActorAPI); err != nil {
+		t.Fatalf("Transition: %v", err)
+	}
+	got.Metadata["customer"] = "changed"
+	sess.Status = model.SessionStatusCompleted
+
+	again := mustGetSession(t, s, sess.ID)
+	if again.Status != model.SessionStatusPending || again.Metadata["customer"] != "c-42" {
+		t.Fatalf("stored session changed without an update: status %q, metadata %v", again.Status, again.Metadata)
+	}
+	checkHistory(t, again.History, model.SessionStatusPending)
+}
+
+func testMarkSessionOpened(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := s.MarkSessionOpened(sess.ID); err != nil {
+		t.Fatalf("MarkSessionOpened: %v", err)
+	}
+
+	got := mustGetSession(t, s, sess.ID)
+	if got.Status != model.SessionStatusOpened {
+		t.Errorf("status = %q, want %q", got.Status, model.SessionStatusOpened)
+	}
+	if !got.Opened {
+		t.Errorf("Opened flag not persisted")
+	}
+	checkHistory(t, got.History, model.SessionStatusPending, model.SessionStatusOpened)
+
+	// A session never moves backward.
+	if err := s.MarkSessionOpened(sess.ID); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("MarkSessionOpened twice: got %v, want ErrInvalidTransition", err)
+	}
+}
+
+func testMarkActionStarted(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := s.MarkSessionOpened(sess.ID); err != nil {
+		t.Fatalf("MarkSessionOpened: %v", err)
+	}
+
+	started, err := s.MarkActionStarted(sess.ID)
+	if err != nil {
+		t.Fatalf("MarkActionStarted: %v", err)
+	}
+	if started.Status != model.SessionStatusActionStarted || !started.Opened {
+		t.Errorf("MarkActionStarted: got status %q opened %v, want %q opened", started.Status, started.Opened, model.SessionStatusActionStarted)
+	}
+
+	got := mustGetSession(t, s, sess.ID)
+	if got.Status != model.SessionStatusActionStarted || !got.Opened {
+		t.Errorf("GetSession: got status %q opened %v, want %q opened", got.Status, got.Opened, model.SessionStatusActionStarted)
+	}
+	checkHistory(t, got.History, model.SessionStatusPending, model.SessionStatusOpened, model.SessionStatusActionStarted)
+
+	if _, err := s.MarkActionStarted(sess.ID); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("MarkActionStarted twice: got %v, want ErrInvalidTransition", err)
+	}
+}
+
+func testMarkSessionCancelled(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	cancelled, err := s.MarkSessionCancelled(sess.ID)
+	if err != nil {
+		t.Fatalf("MarkSessionCancelled: %v", err)
+	}
+	if cancelled.Status != model.SessionStatusCancelled {
+		t.Errorf("MarkSessionCancelled: status = %q, want %q", cancelled.Status, model.SessionStatusCancelled)
+	}
+	got := mustGetSession(t, s, sess.ID)
+	checkHistory(t, got.History, model.SessionStatusPending, model.SessionStatusCancelled)
+	if got.History[1].Actor != model.ActorAPI {
+		t.Errorf("cancellation actor = %q, want %q", got.History[1].Actor, model.ActorAPI)
+	}
+
+	if _, err := s.MarkSessionCancelled(sess.ID); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("MarkSessionCancelled twice: got %v, want ErrInvalidTransition", err)
+	}
+	if _, err := s.MarkCompleted(sess.ID, 0, model.ActionResult{}); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("MarkCompleted after cancellation: got %v, want ErrInvalidTransition", err)
+	}
+}
+
+func testMarkCompleted(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	items := []model.ResultItem{
+		{DownloadID: model.NewSessionID(), ContentType: "image/jpeg", Filename: "photo.jpg"},
+	}
+	if _, err := s.MarkCompleted(sess.ID, 1, model.ActionResult{Result: items}); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("MarkCompleted(1) without steps: got %v, want ErrInvalidTransition", err)
+	}
+	completed, err := s.MarkCompleted(sess.ID, 0, model.ActionResult{Result: items})
+	if err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if completed.Status != model.SessionStatusCompleted || len(completed.Result) != 1 {
+		t.Errorf("MarkCompleted returned status %q with result %+v", completed.Status, completed.Result)
+	}
+
+	got := mustGetSession(t, s, sess.ID)
+	if got.Status != model.SessionStatusCompleted {
+		t.Errorf("status = %q, want %q", got.Status, model.SessionStatusCompleted)
+	}
+	if got.CompletedAt == nil {
+		t.Errorf("CompletedAt not set")
+	}
+	if len(got.Result) != 1 || got.Result[0] != items[0] {
+		t.Errorf("Result = %+v, want %+v", got.Result, items)
+	}
+	checkHistory(t, got.History, model.SessionStatusPending, model.SessionStatusCompleted)
+
+	if err := s.MarkSessionOpened(sess.ID); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("MarkSessionOpened after completion: got %v, want ErrInvalidTransition", err)
+	}
+	if got := mustGetSession(t, s, sess.ID); got.Status != model.SessionStatusCompleted {
+		t.Errorf("status after rejected transition = %q, want %q", got.Status, model.SessionStatusCompleted)
+	}
+}
+
+func testMarkCompletedScan(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	sess.ActionType = model.ActionTypeScan
+	sess.OutputFormat = ""
+	sess.ScanDocumentMode = model.ScanDocumentModeMulti
+	sess.ScanOutputFormat = model.ScanOutputFormatPDF
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	result := &model.ScanResult{Documents: []model.ScanDocument{
+		{PDFURL: "/api/v1/downloads/a"},
+		{PDFURL: "/api/v1/downloads/b"},
+	}}
+	if _, err := s.MarkCompleted(sess.ID, 0, model.ActionResult{ScanResult: result}); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+
+	got := mustGetSession(t, s, sess.ID)
+	if got.Status != model.SessionStatusCompleted {
+		t.Errorf("status = %q, want %q", got.Status, model.SessionStatusCompleted)
+	}
+	if got.ScanResult == nil || len(got.ScanResult.Documents) != 2 {
+		t.Fatalf("ScanResult = %+v, want 2 documents", got.ScanResult)
+	}
+	if got.ScanDocumentMode != model.ScanDocumentModeMulti || got.ScanOutputFormat != model.ScanOutputFormatPDF {
+		t.Errorf("scan settings not preserved: %q/%q", got.ScanDocumentMode, got.ScanOutputFormat)
+	}
+}
+
+func testMarkCompletedBarcode(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	sess.ActionType = model.ActionTypeBarcode
+	sess.OutputFormat = ""
+	sess.BarcodeSymbologies = []model.BarcodeSymbology{model.BarcodeSymbologyCode128}
+	sess.BarcodePattern = `SN-\d+`
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	result := &model.BarcodeResult{Symbology: model.BarcodeSymbologyCode128, Text: "SN-1234"}
+	if _, err := s.MarkCompleted(sess.ID, 0, model.ActionResult{BarcodeResult: result}); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+
+	got := mustGetSession(t, s, sess.ID)
+	if got.Status != model.SessionStatusCompleted || got.CompletedAt == nil {
+		t.Errorf("status = %q, completed at = %v, want %q", got.Status, got.CompletedAt, model.SessionStatusCompleted)
+	}
+	if got.BarcodeResult == nil || *got.BarcodeResult != *result {
+		t.Fatalf("BarcodeResult = %+v, want %+v", got.BarcodeResult, result)
+	}
+	if len(got.BarcodeSymbologies) != 1 || got.BarcodePattern != sess.BarcodePattern {
+		t.Errorf("barcode settings not preserved: %v/%q", got.BarcodeSymbologies, got.BarcodePattern)
+	}
+
+	if _, err := s.MarkCompleted(sess.ID, 0, model.ActionResult{BarcodeResult: result}); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Errorf("MarkCompleted twice: got %v, want ErrInvalidTransition", err)
+	}
+}
+
+func testMarkCompletedSteps(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	sess.ActionType = model.ActionTypeWorkflow
+	sess.OutputFormat = ""
+	sess.Steps = []model.Step{
+		{ActionType: model.ActionTypePhoto, OutputFormat: model.OutputFormatJPG, IntroText: "Front of your ID"},
+		{ActionType: model.ActionTypeScan, ScanDocumentMode: model.ScanDocumentModeSingle, ScanOutputFormat: model.ScanOutputFormatPDF},
+	}
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	items := []model.ResultItem{
+		{DownloadID: model.NewSessionID(), ContentType: "image/jpeg", Filename: "front.jpg"},
+	}
+	updated, err := s.MarkCompleted(sess.ID, 0, model.ActionResult{Result: items})
+	if err != nil {
+		t.Fatalf("MarkCompleted(0): %v", err)
+	}
+	if updated.CurrentStep != 1 || len(updated.Steps) != 2 || updated.Steps[0].CompletedAt == nil {
+		t.Errorf("MarkCompleted(0) returned current step %d with steps %+v", updated.CurrentStep, updated.Steps)
+	}
+	got := mustGetSession(t, s, sess.ID)
+	if got.Status != model.SessionStatusPending || got.CurrentStep != 1 || got.CompletedAt != nil {
+		t.Errorf("after first step: status = %q, current step = %d, completed at = %v", got.Status, got.CurrentStep, got.CompletedAt)
+	}
+	if got.Steps[0].CompletedAt == nil || len(got.Steps[0].Result) != 1 || got.Steps[0].Result[0] != items[0] {
+		t.Errorf("first step = %+v, want result %+v", got.Steps[0], items)
+	}
+	if got.Steps[0].IntroText != "Front of your ID" {
+		t.Errorf("step settings not preserved: %+v", got.Steps[0])
+	}
+
+	if _, err := s.MarkCompleted(sess.ID, 0, model.ActionResult{Result: items}); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("MarkCompleted(0) twice: got %v, want ErrInvalidTransition", err)
+	}
+
+	result := &model.ScanResult{Documents: []model.ScanDocument{{PDFURL: "/api/v1/downloads/a"}}}
+	if _, err := s.MarkCompleted(sess.ID, 1, model.ActionResult{ScanResult: result}); err != nil {
+		t.Fatalf("MarkCompleted(1): %v", err)
+	}
+	got = mustGetSession(t, s, sess.ID)
+	if got.Status != model.SessionStatusCompleted || got.CompletedAt == nil || got.CurrentStep != 2 {
+		t.Errorf("after last step: status = %q, current step = %d, completed at = %v", got.Status, got.CurrentStep, got.CompletedAt)
+	}
+	if got.Steps[1].ScanResult == nil || len(got.Steps[1].ScanResult.Documents) != 1 {
+		t.Errorf("last step ScanResult = %+v, want 1 document", got.Steps[1].ScanResult)
+	}
+	checkHistory(t, got.History, model.SessionStatusPending, model.SessionStatusCompleted)
+
+	if _, err := s.MarkCompleted(sess.ID, 2, model.ActionResult{}); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("MarkCompleted after completion: got %v, want ErrInvalidTransition", err)
+	}
+}
+
+func testUpdateDeliveryStatus(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	sess.Delivery = &model.DeliveryTarget{
+		Method:  model.DeliveryMethodPut,
+		URL:     "https://example.com/{filename}",
+		Headers: map[string]string{"Authorization": "Bearer t"},
+	}
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	files := []model.FileDelivery{
+		{DownloadID: model.NewSessionID(), Filename: "photo.jpg", ContentType: "image/jpeg", State: model.DeliveryStatePending},
+	}
+	if err := s.UpdateDeliveryStatus(sess.ID, files); err != nil {
+		t.Fatalf("UpdateDeliveryStatus before completion: %v", err)
+	}
+	if got := mustGetSession(t, s, sess.ID); len(got.Delivery.Files) != 0 {
+		t.Errorf("files recorded before completion: %+v", got.Delivery.Files)
+	}
+
+	if _, err := s.MarkCompleted(sess.ID, 0, model.ActionResult{}); err != nil {
+		t.Fatalf("MarkCompleted: %v", err)
+	}
+	if err := s.UpdateDeliveryStatus(sess.ID, files); err != nil {
+		t.Fatalf("UpdateDeliveryStatus: %v", err)
+	}
+	files[0].State = model.DeliveryStateDelivered
+
+	got := mustGetSession(t, s, sess.ID)
+	if got.Status != model.SessionStatusCompleted || got.CompletedAt == nil {
+		t.Errorf("status = %q, completed at %v; want the completion kept", got.Status, got.CompletedAt)
+	}
+	if got.Delivery.URL != sess.Delivery.URL || got.Delivery.Headers["Authorization"] != "Bearer t" {
+		t.Errorf("delivery target = %+v, want it kept", got.Delivery)
+	}
+	if len(got.Delivery.Files) != 1 || got.Delivery.Files[0].State != model.DeliveryStatePending {
+		t.Errorf("files = %+v, want the pending file as recorded", got.Delivery.Files)
+	}
+
+	if err := s.UpdateDeliveryStatus(model.NewSessionID(), files); err != nil {
+		t.Errorf("UpdateDeliveryStatus on unknown session: %v", err)
+	}
+}
+
+func testMarkUnknownSession(t *testing.T, s store.SessionStore) {
+	id := model.NewSessionID()
+	if err := s.MarkSessionOpened(id); err == nil {
+		t.Errorf("MarkSessionOpened on unknown session: expected error")
+	}
+	if _, err := s.MarkCompleted(id, 0, model.ActionResult{}); err == nil {
+		t.Errorf("MarkCompleted on unknown session: expected error")
+	}
+	if _, err := s.MarkActionStarted(id); err == nil {
+		t.Errorf("MarkActionStarted on unknown session: expected error")
+	}
+	if _, err := s.MarkSessionCancelled(id); err == nil {
+		t.Errorf("MarkSessionCancelled on unknown session: expected error")
+	}
+}
+
+func testConcurrentTransitions(t *testing.T, s store.SessionStore) {
+	const racers = 10
+	sess := newTestSession(time.Minute)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// Completions race cancellations; exactly one of them may win.
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		won []string
+	)
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			name := "completed"
+			if i%2 == 0 {
+				_, err = s.MarkCompleted(sess.ID, 0, model.ActionResult{})
+			} else {
+				name = "cancelled"
+				_, err = s.MarkSessionCancelled(sess.ID)
+			}
+			switch {
+			case err == nil:
+				mu.Lock()
+				won = append(won, name)
+				mu.Unlock()
+			case !errors.Is(err, model.ErrInvalidTransition):
+				t.Errorf("concurrent %s: %v", name, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(won) != 1 {
+		t.Fatalf("concurrent transitions: %d succeeded (%v), want 1", len(won), won)
+	}
+	got := mustGetSession(t, s, sess.ID)
+	if string(got.Status) != won[0] {
+		t.Errorf("status = %q, want %q", got.Status, won[0])
+	}
+	checkHistory(t, got.History, model.SessionStatusPending, got.Status)
+}
+
+func testIdempotencyKeys(t *testing.T, s store.SessionStore, elapse func(time.Duration)) {
+	key := "key-" + model.NewSessionID()
+	pending := &store.IdempotencyRecord{RequestHash: "hash-1"}
+
+	existing, err := s.ReserveIdempotencyKey(key, pending, shortTTL)
+	if err != nil {
+		t.Fatalf("ReserveIdempotencyKey: %v", err)
+	}
+	if existing != nil {
+		t.Fatalf("ReserveIdempotencyKey on fresh key: got %+v, want nil", existing)
+	}
+
+	existing, err = s.ReserveIdempotencyKey(key, &store.IdempotencyRecord{RequestHash: "hash-2"}, shortTTL)
+	if err != nil {
+		t.Fatalf("ReserveIdempotencyKey again: %v", err)
+	}
+	if existing == nil || existing.RequestHash != "hash-1" || !existing.Pending() {
+		t.Fatalf("ReserveIdempotencyKey again: got %+v, want pending record with hash-1", existing)
+	}
+
+	done := &store.IdempotencyRecord{RequestHash: "hash-1", StatusCode: 201, Body: []byte(`{"id":"x"}`)}
+	if err := s.SaveIdempotencyRecord(key, done, shortTTL); err != nil {
+		t.Fatalf("SaveIdempotencyRecord: %v", err)
+	}
+	existing, err = s.ReserveIdempotencyKey(key, pending, shortTTL)
+	if err != nil {
+		t.Fatalf("ReserveIdempotencyKey after save: %v", err)
+	}
+	if existing == nil || existing.Pending() || existing.StatusCode != 201 || !bytes.Equal(existing.Body, done.Body) {
+		t.Fatalf("ReserveIdempotencyKey after save: got %+v, want %+v", existing, done)
+	}
+
+	if err := s.DeleteIdempotencyRecord(key); err != nil {
+		t.Fatalf("DeleteIdempotencyRecord: %v", err)
+	}
+	if existing, err := s.ReserveIdempotencyKey(key, pending, shortTTL); err != nil || existing != nil {
+		t.Fatalf("ReserveIdempotencyKey after delete: got (%+v, %v), want (nil, nil)", existing, err)
+	}
+
+	elapse(shortTTL + 500*time.Millisecond)
+	if existing, err := s.ReserveIdempotencyKey(key, pending, shortTTL); err != nil || existing != nil {
+		t.Fatalf("ReserveIdempotencyKey after TTL: got (%+v, %v), want (nil, nil)", existing, err)
+	}
+}
+
+func newTestDelivery(apiKeyID string, due time.Time) *model.WebhookDelivery {
+	return &model.WebhookDelivery{
+		ID:            model.NewSessionID(),
+		SessionID:     model.NewSessionID(),
+		APIKeyID:      apiKeyID,
+		Event:         "completed",
+		URL:           "https://example.com/hook",
+		Payload:       []byte(`{"event":"completed"}`),
+		State:         model.WebhookStateQueued,
+		CreatedAt:     time.Now(),
+		NextAttemptAt: due,
+	}
+}
+
+// claimIDs claims every due delivery and returns their IDs.
+func claimIDs(t *testing.T, s store.SessionStore, now time.Time) []string {
+	t.Helper()
+	claimed, err := s.ClaimWebhooks(now, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimWebhooks: %v", err)
+	}
+	ids := make([]string, len(claimed))
+	for i, d := range claimed {
+		ids[i] = d.ID
+	}
+	return ids
+}
+
+func testEventLog(t *testing.T, s store.SessionStore) {
+	sess := newTestSession(time.Minute)
+	if err := s.CreateSession(sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if events, err := s.ListEvents(sess.ID, 0); err != nil || len(events) != 0 {
+		t.Fatalf("ListEvents before any append: got (%v, %v), want none", events, err)
+	}
+
+	sessionEnd := sess.CreatedAt.Add(sess.SessionTTL)
+	statuses := []model.SessionStatus{model.SessionStatusOpened, model.SessionStatusActionStarted, model.SessionStatusCompleted}
+	for i, status := range statuses {
+		event := &model.SessionEvent{
+			Type:      "status_update",
+			Status:    status,
+			Data:      json.RawMessage(`{"n":` + strconv.Itoa(i) + `}`),
+			Timestamp: time.Now(),
+		}
+		if err := s.AppendEvent(sess.ID, event, sessionEnd); err != nil {
+			t.Fatalf("AppendEvent: %v", err)
+		}
+		if event.Seq != uint64(i+1) {
+			t.Fatalf("AppendEvent: seq = %d, want %d", event.Seq, i+1)
+		}
+	}
+
+	events, err := s.ListEvents(sess.ID, 0)
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(events) != len(statuses) {
+		t.Fatalf("ListEvents: got %d events, want %d", len(events), len(statuses))
+	}
+	for i, event := range events {
+		if event.Seq != uint64(i+1) || event.Status != statuses[i] || string(event.Data) != `{"n":`+strconv.Itoa(i)+`}` {
+			t.Errorf("ListEvents[%d] = %+v, want seq %d with status %q", i, event, i+1, statuses[i])
+		}
+	}
+
+	events, err = s.ListEvents(sess.ID, 2)
+	if err != nil {
+		t.Fatalf("ListEvents since 2: %v", err)
+	}
+	if len(events) != 1 || events[0].Seq != 3 {
+		t.Fatalf("ListEvents since 2: got %+v, want only seq 3", events)
+	}
+	if events, err := s.ListEvents(sess.ID, 3); err != nil || len(events) != 0 {
+		t.Fatalf("ListEvents since last: got (%v, %v), want none", events, err)
+	}
+
+	// Logs are per session.
+	other := newTestSession(time.Minute)
+	event := &model.SessionEvent{Type: "status_update", Status: model.SessionStatusOpened, Timestamp: time.Now()}
+	if err := s.AppendEvent(other.ID, event, sessionEnd); err != nil || event.Seq != 1 {
+		t.Fatalf("AppendEvent to other session: got (seq %d, %v), want (1, nil)", event.Seq, err)
+	}
+
+	if err := s.DeleteSession(sess.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if events, err := s.ListEvents(sess.ID, 0); err != nil || len(events) != 0 {
+		t.Fatalf("ListEvents after DeleteSession: got (%v, %v), want none", events, err)
+	}
+}
+
+func testWebhookQueue(t *testing.T, s store.SessionStore) {
+	now := time.Now()
+	due := newTestDelivery("key-a", now.Add(-time.Second))
+	later := newTestDelivery("key-a", now.Add(time.Hour))
+	for _, d := range []*model.WebhookDelivery{due, later} {
+		if queued, err := s.EnqueueWebhook(d); err != nil || !queued {
+			t.Fatalf("EnqueueWebhook: got (%v, %v), want (true, nil)", queued, err)
+		}
+	}
+	if queued, err := s.EnqueueWebhook(due); err != nil || queued {
+		t.Fatalf("EnqueueWebhook with known ID: got (%v, %v), want (false, nil)", queued, err)
+	}
+
+	claimed, err := s.ClaimWebhooks(now, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimWebhooks: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != due.ID || string(claimed[0].Payload) != string(due.Payload) {
+		t.Fatalf("ClaimWebhooks: got %+v, want only %s", claimed, due.ID)
+	}
+	if ids := claimIDs(t, s, now); len(ids) != 0 {
+		t.Fatalf("ClaimWebhooks while leased: got %v, want none", ids)
+	}
+
+	// A failed attempt reschedules the delivery.
+	retry := *claimed[0]
+	attemptAt := time.Now()
+	retry.Attempts = 1
+	retry.LastAttemptAt = &attemptAt
+	retry.LastError = "HTTP 500"
+	retry.NextAttemptAt = attemptAt.Add(-time.Millisecond)
+	if err := s.UpdateWebhook(&retry, time.Hour); err != nil {
+		t.Fatalf("UpdateWebhook: %v", err)
+	}
+	claimed, err = s.ClaimWebhooks(time.Now(), 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimWebhooks after reschedule: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].Attempts != 1 || claimed[0].LastError != "HTTP 500" {
+		t.Fatalf("ClaimWebhooks after reschedule: got %+v, want %s with one attempt", claimed, due.ID)
+	}
+
+	// Giving up moves it to the dead-letter list of its API key.
+	dead := *claimed[0]
+	dead.State = model.WebhookStateDead
+	if err := s.UpdateWebhook(&dead, time.Hour); err != nil {
+		t.Fatalf("UpdateWebhook dead: %v", err)
+	}
+	if ids := claimIDs(t, s, time.Now().Add(2*time.Minute)); len(ids) != 0 {
+		t.Fatalf("ClaimWebhooks after dead letter: got %v, want none", ids)
+	}
+	list, err := s.ListDeadWebhooks("key-a")
+	if err != nil {
+		t.Fatalf("ListDeadWebhooks: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != due.ID || list[0].State != model.WebhookStateDead {
+		t.Fatalf("ListDeadWebhooks: got %+v, want only %s", list, due.ID)
+	}
+	if list, err := s.ListDeadWebhooks("key-b"); err != nil || len(list) != 0 {
+		t.Fatalf("ListDeadWebhooks for another key: got (%+v, %v), want none", list, err)
+	}
+	if queued, err := s.EnqueueWebhook(due); err != nil || queued {
+		t.Fatalf("EnqueueWebhook after dead letter: got (%v, %v), want (false, nil)", queued, err)
+	}
+
+	// Delivered ones leave the queue without becoming dead letters.
+	delivered := *later
+	delivered.State = model.WebhookStateDelivered
+	if err := s.UpdateWebhook(&delivered, time.Hour); err != nil {
+		t.Fatalf("UpdateWebhook delivered: %v", err)
+	}
+	if ids := claimIDs(t, s, now.Add(2*time.Hour)); len(ids) != 0 {
+		t.Fatalf("ClaimWebhooks after delivery: got %v, want none", ids)
+	}
+	if list, err := s.ListDeadWebhooks("key-a"); err != nil || len(list) != 1 {
+		t.Fatalf("ListDeadWebhooks after delivery: got (%+v, %v), want one", list, err)
+	}
+}
+
+func testFileRoundTrip(t *testing.T, s store.FileStore) {
+	id := model.NewSessionID()
+	data := []byte("\x89PNG\r\n\x1a\nnot really a png")
+	if err := s.StoreFile(id, data, "image/png", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+
+	got := mustOpenFile(t, s, id)
+	if got.ContentType != "image/png" {
+		t.Errorf("OpenFile: content type = %q, want %q", got.ContentType, "image/png")
+	}
+	if got.Size != int64(len(data)) {
+		t.Errorf("OpenFile: size = %d, want %d", got.Size, len(data))
+	}
+	if got.ETag == "" {
+		t.Errorf("OpenFile: empty ETag")
+	}
+	if got.ModTime.IsZero() {
+		t.Errorf("OpenFile: zero ModTime")
+	}
+	read, err := got.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("OpenFile: data mismatch")
+	}
+
+	missing, err := s.OpenFile(model.NewSessionID())
+	if err != nil || missing != nil {
+		t.Errorf("OpenFile(unknown): got (%+v, %v), want (nil, nil)", missing, err)
+	}
+}
+
+// mustOpenFile opens a stored file and fails the test on error or absence.
+func mustOpenFile(t *testing.T, s store.FileStore, downloadID string) *store.StoredFile {
+	t.Helper()
+	f, err := s.OpenFile(downloadID)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): unexpected error: %v", downloadID, err)
+	}
+	if f == nil {
+		t.Fatalf("OpenFile(%q): file not found", downloadID)
+	}
+	return f
+}
+
+func testFileRange(t *testing.T, s store.FileStore) {
+	id := model.NewSessionID()
+	data := []byte("0123456789abcdef")
+	if err := s.StoreFile(id, data, "application/octet-stream", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+
+	f := mustOpenFile(t, s, id)
+	defer f.Close()
+
+	if _, err := f.Content.Seek(10, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(f.Content, buf); err != nil {
+		t.Fatalf("ReadFull after seek: %v", err)
+	}
+	if string(buf) != "abcd" {
+		t.Errorf("read after seek = %q, want %q", buf, "abcd")
+	}
+}
+
+func testFileOverwrite(t *testing.T, s store.FileStore) {
+	id := model.NewSessionID()
+	if err := s.StoreFile(id, []byte("first version"), "text/plain", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+	first := mustOpenFile(t, s, id)
+	first.Close()
+
+	if err := s.StoreFile(id, []byte("{\"second\":true}"), "application/json", time.Minute); err != nil {
+		t.Fatalf("StoreFile (overwrite): %v", err)
+	}
+	got := mustOpenFile(t, s, id)
+	read, err := got.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(read) != `{"second":true}` || got.ContentType != "application/json" || got.Size != int64(len(read)) {
+		t.Errorf("after overwrite: got %q (%s, %d bytes)", read, got.ContentType, got.Size)
+	}
+	if got.ETag == first.ETag {
+		t.Errorf("after overwrite: ETag %s unchanged", got.ETag)
+	}
+}
+
+func testFileExpiry(t *testing.T, s store.FileStore, elapse func(time.Duration)) {
+	id := model.NewSessionID()
+	if err := s.StoreFile(id, []byte("data"), "text/plain", shortTTL); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+
+	elapse(shortTTL + 500*time.Millisecond)
+
+	got, err := s.OpenFile(id)
+	if err != nil {
+		t.Fatalf("OpenFile after TTL: %v", err)
+	}
+	if got != nil {
+		got.Close()
+		t.Fatalf("OpenFile after TTL: expected nil, got file")
+	}
+}
+
+func testDeleteFile(t *testing.T, s store.FileStore) {
+	id := model.NewSessionID()
+	if err := s.StoreFile(id, []byte("data"), "text/plain", time.Minute); err != nil {
+		t.Fatalf("StoreFile: %v", err)
+	}
+	if err := s.DeleteFile(id); err != nil {
+		t.Fatalf("DeleteFile: %v", err)
+	}
+
+	got, err := s.OpenFile(id)
+	if err != nil {
+		t.Fatalf("OpenFile after DeleteFile: %v", err)
+	}
+	if got != nil {
+		got.Close()
+		t.Fatalf("OpenFile after DeleteFile: expected nil, got file")
+	}
+
+	// Deleting again, or a file that never existed, is a no-op.
+	if err := s.DeleteFile(id); err != nil {
+		t.Fatalf("DeleteFile twice: %v", err)
+	}
+	if err := s.DeleteFile(model.NewSessionID()); err != nil {
+		t.Fatalf("DeleteFile unknown: %v", err)
+	}
+}
+
+func testScanPages(t *testing.T, s store.FileStore) {
+	sessionID := model.NewSessionID()
+
+	if n, err := s.GetScanPageCount(sessionID); err != nil || n != 0 {
+		t.Fatalf("GetScanPageCount on empty session: got (%d, %v), want (0, nil)", n, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		page := store.ScanPageData{
+			DocumentIndex: i / 2,
+			PageIndex:     i % 2,
+			Data:          []byte(fmt.Sprintf("page-%d", i)),
+			ContentType:   "image/jpeg",
+		}
+		if err := s.AddScanPage(sessionID, page, time.Minute); err != nil {
+			t.Fatalf("AddScanPage(%d): %v", i, err)
+		}
+	}
+
+	if n, err := s.GetScanPageCount(sessionID); err != nil || n != 3 {
+		t.Fatalf("GetScanPageCount: got (%d, %v), want (3, nil)", n, err)
+	}
+
+	pages, err := s.GetScanPages(sessionID)
+	if err != nil {
+		t.Fatalf("GetScanPages: %v", err)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("GetScanPages: got %d pages, want 3", len(pages))
+	}
+	for i, p := range pages {
+		if string(p.Data) != fmt.Sprintf("page-%d", i) || p.DocumentIndex != i/2 || p.PageIndex != i%2 || p.ContentType != "image/jpeg" {
+			t.Errorf("page %d: got %+v", i, p)
+		}
+	}
+
+	if err := s.ClearScanPages(sessionID); err != nil {
+		t.Fatalf("ClearScanPages: %v", err)
+	}
+	if pages, err := s.GetScanPages(sessionID); err != nil || len(pages) != 0 {
+		t.Fatalf("GetScanPages after clear: got (%d pages, %v), want (0, nil)", len(pages), err)
+	}
+}
+
+func testTouchScanPages(t *testing.T, s store.FileStore, elapse func(time.Duration)) {
+	sessionID := model.NewSessionID()
+	if err := s.TouchScanPages(sessionID, time.Minute); err != nil {
+		t.Fatalf("TouchScanPages without pages: %v", err)
+	}
+
+	page := store.ScanPageData{Data: []byte("page"), ContentType: "image/jpeg"}
+	if err := s.AddScanPage(sessionID, page, shortTTL); err != nil {
+		t.Fatalf("AddScanPage: %v", err)
+	}
+	if err := s.TouchScanPages(sessionID, time.Minute); err != nil {
+		t.Fatalf("TouchScanPages: %v", err)
+	}
+
+	elapse(shortTTL + 500*time.Millisecond)
+
+	pages, err := s.GetScanPages(sessionID)
+	if err != nil {
+		t.Fatalf("GetScanPages after original TTL: %v", err)
+	}
+	if len(pages) != 1 || string(pages[0].Data) != "page" || pages[0].ContentType != "image/jpeg" {
+		t.Fatalf("GetScanPages after original TTL: got %+v, want the touched page", pages)
+	}
+}
+
+func testConcurrentScanPages(t *testing.T, s store.FileStore) {
+	const uploads = 20
+	sessionID := model.NewSessionID()
+
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			page := store.ScanPageData{PageIndex: i, Data: []byte{byte(i)}, ContentType: "image/png"}
+			if err := s.AddScanPage(sessionID, page, time.Minute); err != nil {
+				t.Errorf("AddScanPage(%d): %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n, err := s.GetScanPageCount(sessionID); err != nil || n != uploads {
+		t.Fatalf("GetScanPageCount after concurrent uploads: got (%d, %v), want (%d, nil)", n, err, uploads)
+	}
+}
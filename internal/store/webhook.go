@@ -0,0 +1,43 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+)
+
+const webhookKeyFmt = "webhook:%s"
+
+// WebhookQueue persists webhook deliveries until they succeed or are given up
+// on, so retries survive restarts and are shared between replicas.
+type WebhookQueue interface {
+	// EnqueueWebhook queues delivery for its NextAttemptAt. A delivery whose
+	// ID is already known is ignored, so an event reported by several
+	// replicas is sent once. Returns whether the delivery was queued.
+	EnqueueWebhook(delivery *model.WebhookDelivery) (bool, error)
+	// ClaimWebhooks returns up to limit queued deliveries due at now, oldest
+	// first, and pushes their NextAttemptAt to now+lease so no other claimer
+	// picks them up while they are being sent.
+	ClaimWebhooks(now time.Time, limit int, lease time.Duration) ([]*model.WebhookDelivery, error)
+	// UpdateWebhook saves delivery after an attempt. Queued deliveries are
+	// rescheduled for their NextAttemptAt; delivered and dead ones leave the
+	// queue and are kept for retention.
+	UpdateWebhook(delivery *model.WebhookDelivery, retention time.Duration) error
+	// ListDeadWebhooks returns the dead deliveries of sessions created with
+	// apiKeyID, most recently failed first.
+	ListDeadWebhooks(apiKeyID string) ([]*model.WebhookDelivery, error)
+}
+
+// webhookKey returns the store key for the delivery with the given ID.
+func webhookKey(id string) string {
+	return fmt.Sprintf(webhookKeyFmt, id)
+}
+
+// lastAttempt returns when delivery was last attempted, or its creation time.
+func lastAttempt(delivery *model.WebhookDelivery) time.Time {
+	if delivery.LastAttemptAt != nil {
+		return *delivery.LastAttemptAt
+	}
+	return delivery.CreatedAt
+}
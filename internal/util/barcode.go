@@ -0,0 +1,71 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/aztec"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// ErrNoBarcode is returned by DecodeBarcode if the image holds no readable
+// barcode of the requested symbologies.
+var ErrNoBarcode = errors.New("no barcode found")
+
+// barcodeReaders lists the symbologies DecodeBarcode can read, named as in
+// the browser's Barcode Detection API, in the order they are tried. UPC-A
+// comes before EAN-13, which would also read it with a leading zero.
+var barcodeReaders = []struct {
+	symbology string
+	newReader func() gozxing.Reader
+}{
+	{"qr_code", qrcode.NewQRCodeReader},
+	{"data_matrix", func() gozxing.Reader { return datamatrix.NewDataMatrixReader() }},
+	{"aztec", func() gozxing.Reader { return aztec.NewAztecReader() }},
+	{"upc_a", oned.NewUPCAReader},
+	{"upc_e", oned.NewUPCEReader},
+	{"ean_13", oned.NewEAN13Reader},
+	{"ean_8", oned.NewEAN8Reader},
+	{"code_128", oned.NewCode128Reader},
+	{"code_39", oned.NewCode39Reader},
+	{"code_93", oned.NewCode93Reader},
+	{"itf", oned.NewITFReader},
+	{"codabar", oned.NewCodaBarReader},
+}
+
+// DecodeBarcode reads a barcode of one of symbologies from a JPEG or PNG
+// image and returns its symbology and text. It returns ErrNoBarcode if none
+// is found, and another error if data is not an image.
+func DecodeBarcode(data []byte, symbologies []string) (string, string, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to prepare image: %w", err)
+	}
+
+	allowed := make(map[string]bool, len(symbologies))
+	for _, s := range symbologies {
+		allowed[s] = true
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	for _, r := range barcodeReaders {
+		if !allowed[r.symbology] {
+			continue
+		}
+		result, err := r.newReader().Decode(bitmap, hints)
+		if err == nil {
+			return r.symbology, result.GetText(), nil
+		}
+	}
+	return "", "", ErrNoBarcode
+}
@@ -14,6 +14,9 @@ func InitConfig() error {
 		config.Bool("DEV").Default(false),
 		config.Int("PORT").Default(8080),
 
+		// interval of WebSocket pings; clients missing two pongs are disconnected
+		config.String("WS_PING_INTERVAL").Default("30s"),
+
 		// API key auth (required — server refuses to start without at least one key)
 		config.StringArray("API_KEYS").NotEmpty(),
 
@@ -21,12 +24,69 @@ func InitConfig() error {
 		config.String("SESSION_TTL").Default("30m"),
 		config.String("RESULT_TTL").Default("5m"),
 
+		// how long an Idempotency-Key on session creation is remembered
+		config.String("IDEMPOTENCY_TTL").Default("24h"),
+
+		// webhook delivery of session events; enabled when WEBHOOK_SECRET is set
+		config.String("WEBHOOK_SECRET").Default("").Sensitive(),
+		// default webhook URL per API key: "apikeyid=url" entries, apikeyid being the session's api_key_id
+		config.StringArray("WEBHOOK_URLS").Default([]string{}),
+		config.Int("WEBHOOK_MAX_ATTEMPTS").Default(8),
+		config.String("WEBHOOK_TIMEOUT").Default("10s"),
+		config.String("WEBHOOK_RETRY_BACKOFF").Default("30s"),
+		config.String("WEBHOOK_DEAD_LETTER_TTL").Default("168h"),
+
+		// pushing result files to a session's delivery target
+		config.Int("DELIVERY_MAX_ATTEMPTS").Default(5),
+		config.String("DELIVERY_TIMEOUT").Default("60s"),
+		config.String("DELIVERY_RETRY_BACKOFF").Default("5s"),
+
 		// base URL for generating session URLs (required)
 		config.String("BASE_URL").NotEmpty(),
 
 		// scan upload limits
 		config.Int("SCAN_UPLOAD_MAX_BYTES").Default(20971520), // 20 MB (20 * 1024 * 1024)
 		config.Int("SCAN_MAX_PAGES").Default(50),
+
+		// video recording: default and longest max duration, default resolution, size limit of a recording
+		config.String("VIDEO_MAX_DURATION").Default("60s"),
+		config.String("VIDEO_RESOLUTION").Default("720p"),
+		config.Int("VIDEO_MAX_BYTES").Default(104857600), // 100 MB (100 * 1024 * 1024)
+
+		// audio recording: longest recording the phone user can make
+		config.String("AUDIO_MAX_DURATION").Default("120s"),
+
+		// file uploads: default allowed types, and default and highest file count and per-file size
+		config.StringArray("UPLOAD_ALLOWED_TYPES").Default([]string{"application/pdf", "image/*"}),
+		config.Int("UPLOAD_MAX_FILES").Default(10),
+		config.Int("UPLOAD_MAX_FILE_BYTES").Default(20971520), // 20 MB (20 * 1024 * 1024)
+
+		// storage backend: "memory" (default, single instance) or "redis" (shared across replicas)
+		config.String("STORE_BACKEND").NotEmpty().Default("memory"),
+		config.String("REDIS_URL").Default("").Sensitive(),
+
+		// result file storage: empty uses STORE_BACKEND; "disk" keeps payloads under FILE_STORE_DIR
+		config.String("FILE_STORE_BACKEND").Default(""),
+		config.String("FILE_STORE_DIR").Default(""),
+
+		// S3-compatible object storage for result files (FILE_STORE_BACKEND=s3)
+		config.String("S3_ENDPOINT").Default(""),
+		config.String("S3_REGION").Default(""),
+		config.String("S3_BUCKET").Default("handoff"),
+		config.String("S3_ACCESS_KEY").Default(""),
+		config.String("S3_SECRET_KEY").Default("").Sensitive(),
+		config.Bool("S3_USE_SSL").Default(true),
+		config.String("S3_PREFIX").Default(""),
+		// answer downloads with a 302 to a presigned URL instead of proxying bytes
+		config.Bool("S3_PRESIGNED_DOWNLOADS").Default(false),
+		config.String("S3_PRESIGN_TTL").Default("1m"),
+
+		// envelope encryption of result files and scan pages: "id:base64key" entries, 32-byte keys
+		config.StringArray("ENCRYPTION_KEYS").Default([]string{}).Sensitive(),
+		config.String("ENCRYPTION_KEY_ID").Default(""),
+
+		// in-memory store persistence: snapshot on graceful shutdown, restore on startup
+		config.String("SNAPSHOT_PATH").Default(""),
 	})
 	return err
 }
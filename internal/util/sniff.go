@@ -0,0 +1,26 @@
+package util
+
+import (
+	"net/http"
+	"strings"
+)
+
+// heicBrands are the ISO base media file brands of HEIC/HEIF images, which
+// http.DetectContentType does not know.
+var heicBrands = []string{"heic", "heix", "hevc", "hevx", "mif1", "msf1"}
+
+// SniffContentType detects the content type of data from its leading bytes,
+// ignoring whatever type the uploader declared. It returns the bare MIME type
+// without parameters, e.g. "text/plain", and "application/octet-stream" if
+// the type is unknown.
+func SniffContentType(data []byte) string {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		for _, brand := range heicBrands {
+			if string(data[8:12]) == brand {
+				return "image/heic"
+			}
+		}
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	return contentType
+}
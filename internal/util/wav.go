@@ -0,0 +1,39 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// WAVDuration reads the length of a RIFF/WAVE file from its fmt and data
+// chunks. It returns an error if data is not a WAV file.
+func WAVDuration(data []byte) (time.Duration, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return 0, fmt.Errorf("not a WAV file")
+	}
+
+	var byteRate uint32
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8:]
+		switch id {
+		case "fmt ":
+			if size < 16 || len(body) < 16 {
+				return 0, fmt.Errorf("short fmt chunk")
+			}
+			byteRate = binary.LittleEndian.Uint32(body[8:12])
+		case "data":
+			if byteRate == 0 {
+				return 0, fmt.Errorf("data chunk before fmt chunk or zero byte rate")
+			}
+			// Streaming writers leave the size unset; count what is there.
+			size = min(size, len(body))
+			return time.Duration(float64(size) / float64(byteRate) * float64(time.Second)), nil
+		}
+		// Chunks are padded to an even size.
+		pos += 8 + size + size%2
+	}
+	return 0, fmt.Errorf("missing data chunk")
+}
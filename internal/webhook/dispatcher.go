@@ -0,0 +1,286 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mxcd/handoff/internal/model"
+	"github.com/mxcd/handoff/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// pollInterval is how often the queue is checked for due deliveries.
+	pollInterval = time.Second
+	// claimBatch is how many deliveries are claimed and sent at once.
+	claimBatch = 16
+	// leaseMargin is added to the attempt timeout when claiming, so a
+	// delivery only becomes due again once its attempt is surely over.
+	leaseMargin = 30 * time.Second
+	// maxBackoff caps the delay between two attempts.
+	maxBackoff = time.Hour
+	// deliveredRetention is how long a delivered event is remembered, so
+	// that replicas reporting it late do not send it again.
+	deliveredRetention = 24 * time.Hour
+	// maxResponseBody is how much of a receiver's response is read.
+	maxResponseBody = 64 << 10
+)
+
+// Options configures a Dispatcher.
+type Options struct {
+	// Secret keys the HMAC signature of every request. Required.
+	Secret string
+	// DefaultURLs maps API key fingerprints to the webhook URL of sessions
+	// created with that key that do not set their own.
+	DefaultURLs map[string]string
+	// MaxAttempts is how often a delivery is tried before it becomes a dead letter.
+	MaxAttempts int
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+	// RetryBackoff is the delay after the first failed attempt; it doubles
+	// with every further failure.
+	RetryBackoff time.Duration
+	// DeadLetterTTL is how long dead letters stay listed.
+	DeadLetterTTL time.Duration
+}
+
+// Dispatcher queues session events and delivers them in the background.
+// Several replicas may run a Dispatcher on the same store; each delivery is
+// claimed by one of them at a time.
+type Dispatcher struct {
+	queue   store.WebhookQueue
+	options Options
+	client  *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewDispatcher creates a Dispatcher that persists deliveries in queue.
+// Call Start to begin delivering.
+func NewDispatcher(queue store.WebhookQueue, options Options) (*Dispatcher, error) {
+	if options.Secret == "" {
+		return nil, fmt.Errorf("webhook secret cannot be empty")
+	}
+	if options.MaxAttempts < 1 {
+		return nil, fmt.Errorf("webhook max attempts must be at least 1")
+	}
+	if options.Timeout <= 0 || options.RetryBackoff <= 0 || options.DeadLetterTTL <= 0 {
+		return nil, fmt.Errorf("webhook timeout, retry backoff and dead letter TTL must be positive")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		queue:   queue,
+		options: options,
+		client: &http.Client{
+			Timeout: options.Timeout,
+			// A redirected POST would arrive as a GET; treat it as a failure instead.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// DefaultURL returns the webhook URL configured for sessions created with the
+// API key whose fingerprint is apiKeyID, or "".
+func (d *Dispatcher) DefaultURL(apiKeyID string) string {
+	return d.options.DefaultURLs[apiKeyID]
+}
+
+// Enqueue queues event for delivery to session's webhook URL, if it has one.
+// data is the result payload for "completed" events.
+func (d *Dispatcher) Enqueue(session *model.Session, event string, data interface{}) error {
+	if session.WebhookURL == "" {
+		return nil
+	}
+
+	now := time.Now()
+	id := deliveryID(session.ID, event)
+	payload, err := json.Marshal(Payload{
+		ID:          id,
+		Event:       event,
+		SessionID:   session.ID,
+		Status:      string(session.Status),
+		Data:        data,
+		Timestamp:   now,
+		ExternalRef: session.ExternalRef,
+		Metadata:    session.Metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("encode webhook payload: %w", err)
+	}
+
+	queued, err := d.queue.EnqueueWebhook(&model.WebhookDelivery{
+		ID:            id,
+		SessionID:     session.ID,
+		APIKeyID:      session.APIKeyID,
+		Event:         event,
+		URL:           session.WebhookURL,
+		Payload:       payload,
+		State:         model.WebhookStateQueued,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	})
+	if err != nil {
+		return err
+	}
+	if queued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Start begins delivering queued events in the background.
+func (d *Dispatcher) Start() {
+	go d.run()
+}
+
+// Stop stops delivering and waits for attempts in flight to be abandoned.
+// Abandoned deliveries are retried once their claim lapses.
+func (d *Dispatcher) Stop() {
+	d.once.Do(d.cancel)
+	<-d.done
+}
+
+// run delivers due events every pollInterval and whenever one is queued.
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue()
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue claims and sends due deliveries until none are left.
+func (d *Dispatcher) deliverDue() {
+	for d.ctx.Err() == nil {
+		batch, err := d.queue.ClaimWebhooks(time.Now(), claimBatch, d.options.Timeout+leaseMargin)
+		if err != nil {
+			log.Warn().Err(err).Msg("webhook: failed to claim deliveries")
+			return
+		}
+
+		// Sessions are delivered in parallel, each session's events in the
+		// order they occurred.
+		bySession := make(map[string][]*model.WebhookDelivery)
+		for _, delivery := range batch {
+			bySession[delivery.SessionID] = append(bySession[delivery.SessionID], delivery)
+		}
+		var wg sync.WaitGroup
+		for _, deliveries := range bySession {
+			sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt) })
+			wg.Add(1)
+			go func(deliveries []*model.WebhookDelivery) {
+				defer wg.Done()
+				for _, delivery := range deliveries {
+					d.deliver(delivery)
+				}
+			}(deliveries)
+		}
+		wg.Wait()
+
+		if len(batch) < claimBatch {
+			return
+		}
+	}
+}
+
+// deliver makes one attempt and records its outcome: delivered, rescheduled
+// with backoff, or dead once MaxAttempts is reached.
+func (d *Dispatcher) deliver(delivery *model.WebhookDelivery) {
+	now := time.Now()
+	status, err := d.post(delivery, now)
+	if d.ctx.Err() != nil {
+		// Shutting down; the attempt does not count.
+		return
+	}
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.LastStatusCode = status
+	delivery.LastError = ""
+	retention := deliveredRetention
+
+	logger := log.With().Str("delivery_id", delivery.ID).Str("session_id", delivery.SessionID).Str("event", delivery.Event).Int("attempts", delivery.Attempts).Logger()
+	switch {
+	case err == nil:
+		delivery.State = model.WebhookStateDelivered
+		logger.Info().Int("status", status).Msg("webhook: delivered")
+	case delivery.Attempts >= d.options.MaxAttempts:
+		delivery.State = model.WebhookStateDead
+		delivery.LastError = err.Error()
+		retention = d.options.DeadLetterTTL
+		logger.Warn().Err(err).Msg("webhook: giving up, moved to dead letters")
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+		logger.Debug().Err(err).Time("next_attempt_at", delivery.NextAttemptAt).Msg("webhook: attempt failed")
+	}
+
+	if err := d.queue.UpdateWebhook(delivery, retention); err != nil {
+		logger.Error().Err(err).Msg("webhook: failed to record attempt")
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.options.RetryBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// post sends the signed payload. Any response other than 2xx is an error;
+// the status code is returned alongside it when there was a response.
+func (d *Dispatcher) post(delivery *model.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "handoff-webhook")
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(d.options.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,88 @@
+// Package webhook delivers session events to caller-supplied URLs. Events are
+// queued in the store and POSTed by a Dispatcher, signed with HMAC-SHA256 and
+// retried with exponential backoff until they succeed or become dead letters.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mxcd/handoff/internal/model"
+)
+
+// Request headers sent with every delivery.
+const (
+	// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of
+	// the timestamp, a dot and the request body, keyed with the secret.
+	SignatureHeader = "X-Handoff-Signature"
+	// TimestampHeader carries the Unix time of the attempt in seconds.
+	TimestampHeader = "X-Handoff-Timestamp"
+	// DeliveryHeader carries the delivery ID, the same on every retry.
+	DeliveryHeader = "X-Handoff-Delivery"
+	// EventHeader carries the event name.
+	EventHeader = "X-Handoff-Event"
+)
+
+// Events sent to webhooks, named after the status the session entered.
+const (
+	EventOpened        = "opened"
+	EventActionStarted = "action_started"
+	EventCompleted     = "completed"
+	EventExpired       = "expired"
+	EventCancelled     = "cancelled"
+)
+
+// Payload is the JSON body POSTed for an event.
+type Payload struct {
+	ID        string      `json:"id"`    // delivery ID
+	Event     string      `json:"event"` // one of the Event constants
+	SessionID string      `json:"session_id"`
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"` // result metadata on completion
+	Timestamp time.Time   `json:"timestamp"`      // when the event occurred
+
+	ExternalRef string            `json:"external_ref,omitempty"` // caller's reference, echoed from the session
+	Metadata    map[string]string `json:"metadata,omitempty"`     // caller's metadata, echoed from the session
+}
+
+// deliveryNamespace seeds the name-based UUIDs used as delivery IDs.
+var deliveryNamespace = uuid.MustParse("4a6f0c1e-8a3b-4d52-9c1f-6f3e2b7d9a10")
+
+// deliveryID returns the ID of the delivery of event for sessionID. A session
+// enters each status at most once, so the ID is stable across replicas that
+// report the same event.
+func deliveryID(sessionID, event string) string {
+	return uuid.NewSHA1(deliveryNamespace, []byte(sessionID+"/"+event)).String()
+}
+
+// Sign returns the SignatureHeader value for body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseDefaultURLs parses "apikeyid=url" entries into a map from API key
+// fingerprint to webhook URL.
+func ParseDefaultURLs(entries []string) (map[string]string, error) {
+	urls := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		id, url, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("entry %q must have the form apikeyid=url", entry)
+		}
+		if err := model.ValidateWebhookURL(url); err != nil {
+			return nil, fmt.Errorf("entry for %q: %w", id, err)
+		}
+		urls[id] = url
+	}
+	return urls, nil
+}
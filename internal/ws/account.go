@@ -0,0 +1,110 @@
+package ws
+
+import "github.com/rs/zerolog/log"
+
+// AccountFilter narrows an account subscription to some of the API key's
+// sessions. The zero value matches every session.
+type AccountFilter struct {
+	// ActionTypes matches sessions of any of these action types.
+	ActionTypes []string
+	// Metadata matches sessions whose metadata has all of these values.
+	Metadata map[string]string
+}
+
+// Match reports whether msg belongs to a session the filter selects.
+func (f AccountFilter) Match(msg WSMessage) bool {
+	if len(f.ActionTypes) > 0 {
+		found := false
+		for _, t := range f.ActionTypes {
+			if t == msg.ActionType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range f.Metadata {
+		if msg.Metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// accountSubscription is a subscriber to all sessions of an API key.
+type accountSubscription struct {
+	sub    Subscriber
+	filter AccountFilter
+}
+
+// SubscribeAccount adds sub to the subscribers of every session created with
+// the API key whose fingerprint is apiKeyID, including sessions created later.
+// Account subscribers are not replayed missed messages.
+func (h *Hub) SubscribeAccount(apiKeyID string, sub Subscriber, filter AccountFilter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.accounts[apiKeyID] = append(h.accounts[apiKeyID], &accountSubscription{sub: sub, filter: filter})
+	log.Debug().
+		Str("api_key_id", apiKeyID).
+		Int("total_subscribers", len(h.accounts[apiKeyID])).
+		Msg("ws: account client subscribed")
+}
+
+// UnsubscribeAccount removes sub from the account subscribers of apiKeyID.
+func (h *Hub) UnsubscribeAccount(apiKeyID string, sub Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unsubscribeAccountLocked(apiKeyID, sub)
+}
+
+// unsubscribeAccountLocked removes sub; caller must hold h.mu (write lock).
+func (h *Hub) unsubscribeAccountLocked(apiKeyID string, sub Subscriber) {
+	subs := h.accounts[apiKeyID]
+	filtered := subs[:0]
+	for _, as := range subs {
+		if as.sub != sub {
+			filtered = append(filtered, as)
+		}
+	}
+	if len(filtered) == 0 {
+		delete(h.accounts, apiKeyID)
+	} else {
+		h.accounts[apiKeyID] = filtered
+	}
+	log.Debug().
+		Str("api_key_id", apiKeyID).
+		Int("remaining_subscribers", len(filtered)).
+		Msg("ws: account client unsubscribed")
+}
+
+// broadcastAccount sends msg to the local account subscribers of apiKeyID
+// whose filter matches it. Subscribers that fail to receive the message are
+// unsubscribed and closed.
+func (h *Hub) broadcastAccount(apiKeyID string, msg WSMessage) {
+	if apiKeyID == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var failed []Subscriber
+	for _, as := range h.accounts[apiKeyID] {
+		if !as.filter.Match(msg) {
+			continue
+		}
+		if err := as.sub.Send(msg); err != nil {
+			log.Debug().Err(err).Str("api_key_id", apiKeyID).Msg("ws: write failed, dropping account subscriber")
+			failed = append(failed, as.sub)
+		}
+	}
+
+	for _, sub := range failed {
+		h.unsubscribeAccountLocked(apiKeyID, sub)
+		sub.Close()
+	}
+	h.dropped.Add(uint64(len(failed)))
+}
@@ -0,0 +1,72 @@
+package ws
+
+import "sync"
+
+// BusMessage is what a Hub publishes on its Bus: either a message for the
+// subscribers of a session and of its API key, or the instruction to
+// disconnect a session's subscribers.
+type BusMessage struct {
+	// SessionID is the session the message belongs to.
+	SessionID string `json:"session_id"`
+	// APIKeyID is the fingerprint of the session's API key, selecting the
+	// account subscribers; empty reaches none.
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// Message is delivered to the subscribers unless Close is set.
+	Message WSMessage `json:"message"`
+	// Close disconnects the session's subscribers.
+	Close bool `json:"close,omitempty"`
+}
+
+// Bus carries Hub traffic to the Hubs of every replica, including the one
+// that published it, so subscribers receive a session's messages no matter
+// which replica they are connected to.
+type Bus interface {
+	// Publish sends msg to every Hub subscribed to the bus. Messages from one
+	// publisher are delivered in the order they were published.
+	Publish(msg BusMessage) error
+	// Subscribe registers fn to be called with every published message. fn
+	// must not block.
+	Subscribe(fn func(BusMessage)) error
+	// Close stops delivering messages.
+	Close() error
+}
+
+// LocalBus is an in-process Bus. It reaches the Hubs sharing it, e.g. a
+// single replica's, but no other process.
+type LocalBus struct {
+	mu  sync.RWMutex
+	fns []func(BusMessage)
+}
+
+var _ Bus = (*LocalBus)(nil)
+
+// NewLocalBus creates and returns an empty LocalBus.
+func NewLocalBus() *LocalBus {
+	return &LocalBus{}
+}
+
+// Publish calls every subscriber synchronously.
+func (b *LocalBus) Publish(msg BusMessage) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.fns {
+		fn(msg)
+	}
+	return nil
+}
+
+// Subscribe registers fn.
+func (b *LocalBus) Subscribe(fn func(BusMessage)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fns = append(b.fns, fn)
+	return nil
+}
+
+// Close removes all subscribers.
+func (b *LocalBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fns = nil
+	return nil
+}
@@ -0,0 +1,100 @@
+package ws_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mxcd/handoff/internal/ws"
+)
+
+// quiet is how long a subscriber must stay silent to count as having received
+// no further messages.
+const quiet = 200 * time.Millisecond
+
+func TestLocalBus(t *testing.T) {
+	bus := ws.NewLocalBus()
+	testHubsShareBus(t, bus, bus)
+}
+
+func TestRedisBus(t *testing.T) {
+	mr := miniredis.RunT(t)
+	newBus := func() ws.Bus {
+		bus, err := ws.NewRedisBusFromURL("redis://" + mr.Addr())
+		if err != nil {
+			t.Fatalf("NewRedisBusFromURL: %v", err)
+		}
+		t.Cleanup(func() { bus.Close() })
+		return bus
+	}
+	// Each replica has its own connection to Redis.
+	testHubsShareBus(t, newBus(), newBus())
+}
+
+// testHubsShareBus checks that what hub A publishes reaches the subscribers of
+// hub B, which stands in for another replica, exactly once.
+func testHubsShareBus(t *testing.T, busA, busB ws.Bus) {
+	hubA, err := ws.NewHub(busA)
+	if err != nil {
+		t.Fatalf("NewHub (A): %v", err)
+	}
+	hubB, err := ws.NewHub(busB)
+	if err != nil {
+		t.Fatalf("NewHub (B): %v", err)
+	}
+
+	session := ws.NewStreamSubscriber(8)
+	hubB.Subscribe("s1", session)
+	account := ws.NewStreamSubscriber(8)
+	hubB.SubscribeAccount("key1", account, ws.AccountFilter{})
+	other := ws.NewStreamSubscriber(8)
+	hubB.Subscribe("s2", other)
+
+	hubA.Publish("key1", ws.WSMessage{Type: "status_update", SessionID: "s1", Seq: 1, Status: "opened"})
+
+	for name, sub := range map[string]*ws.StreamSubscriber{"session": session, "account": account} {
+		msg := receive(t, name, sub)
+		if msg.SessionID != "s1" || msg.Seq != 1 || msg.Status != "opened" {
+			t.Errorf("%s subscriber: got %+v", name, msg)
+		}
+	}
+	for name, sub := range map[string]*ws.StreamSubscriber{"session": session, "account": account, "other session": other} {
+		expectNone(t, name, sub)
+	}
+
+	hubA.CloseSession("s1")
+	select {
+	case msg, ok := <-session.Events():
+		if ok {
+			t.Fatalf("session subscriber: got %+v after CloseSession, want closed stream", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session subscriber: not closed by CloseSession on the other hub")
+	}
+	if stats := hubB.Stats(); stats.SessionSubscribers != 1 || stats.AccountSubscribers != 1 {
+		t.Errorf("hub B stats after CloseSession: %+v", stats)
+	}
+}
+
+func receive(t *testing.T, name string, sub *ws.StreamSubscriber) ws.WSMessage {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("%s subscriber: stream closed", name)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s subscriber: no message received", name)
+	}
+	return ws.WSMessage{}
+}
+
+func expectNone(t *testing.T, name string, sub *ws.StreamSubscriber) {
+	t.Helper()
+	select {
+	case msg := <-sub.Events():
+		t.Errorf("%s subscriber: unexpected message %+v", name, msg)
+	case <-time.After(quiet):
+	}
+}
@@ -1,64 +1,127 @@
 package ws
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
 )
 
-const writeDeadline = 10 * time.Second
-
-// WSMessage is the envelope sent to all WebSocket subscribers of a session.
+// WSMessage is the envelope sent to all subscribers of a session.
 type WSMessage struct {
-	Type      string      `json:"type"`             // "status_update" or "completed"
+	Type      string      `json:"type"`             // "status_update", "completed", "cancelled" or "expired"
 	SessionID string      `json:"session_id"`
+	Seq       uint64      `json:"seq,omitempty"`    // position in the session's event log; 0 for status snapshots
 	Status    string      `json:"status"`
 	Data      interface{} `json:"data,omitempty"`   // result metadata on completion
 	Timestamp time.Time   `json:"timestamp"`
+
+	ActionType  string            `json:"action_type,omitempty"`  // session's action type, for account streams
+	ExternalRef string            `json:"external_ref,omitempty"` // caller's reference, echoed from the session
+	Metadata    map[string]string `json:"metadata,omitempty"`     // caller's metadata, echoed from the session
 }
 
-// Hub manages per-session WebSocket subscriber lists.
+// Hub manages per-session subscriber lists for WebSocket and Server-Sent
+// Events clients, and per-account lists of clients following all sessions of
+// an API key. Messages travel over a Bus, so that the Hubs of all replicas
+// deliver them to the clients connected to them.
 // All methods are safe for concurrent use.
 type Hub struct {
+	bus         Bus
 	mu          sync.RWMutex
-	subscribers map[string][]*websocket.Conn // session ID -> list of WS connections
+	subscribers map[string][]Subscriber           // session ID -> list of subscribers
+	accounts    map[string][]*accountSubscription // API key ID -> subscribers to all its sessions
+	dropped     atomic.Uint64                     // subscribers dropped because Send failed
+}
+
+// HubStats is a point-in-time view of the Hub's subscribers.
+type HubStats struct {
+	// Sessions is the number of sessions with at least one subscriber.
+	Sessions int
+	// SessionSubscribers is the number of subscribers to single sessions.
+	SessionSubscribers int
+	// AccountSubscribers is the number of subscribers to all sessions of an API key.
+	AccountSubscribers int
+	// Dropped counts the subscribers dropped since startup because they fell
+	// behind or their connection failed.
+	Dropped uint64
+}
+
+// NewHub creates a Hub and subscribes it to bus. A nil bus uses a LocalBus,
+// which only reaches this Hub's subscribers.
+func NewHub(bus Bus) (*Hub, error) {
+	if bus == nil {
+		bus = NewLocalBus()
+	}
+	h := &Hub{
+		bus:         bus,
+		subscribers: make(map[string][]Subscriber),
+		accounts:    make(map[string][]*accountSubscription),
+	}
+	if err := bus.Subscribe(h.deliver); err != nil {
+		return nil, fmt.Errorf("subscribe hub to bus: %w", err)
+	}
+	return h, nil
+}
+
+// Publish sends msg to the subscribers of its session and to the account
+// subscribers of apiKeyID, on every replica. If the bus fails, msg still
+// reaches the subscribers of this Hub.
+func (h *Hub) Publish(apiKeyID string, msg WSMessage) {
+	h.publish(BusMessage{SessionID: msg.SessionID, APIKeyID: apiKeyID, Message: msg})
+}
+
+// CloseSession disconnects the subscribers of a session on every replica.
+func (h *Hub) CloseSession(sessionID string) {
+	h.publish(BusMessage{SessionID: sessionID, Close: true})
+}
+
+// publish sends msg over the bus, delivering it locally if that fails.
+func (h *Hub) publish(msg BusMessage) {
+	if err := h.bus.Publish(msg); err != nil {
+		log.Warn().Err(err).Str("session_id", msg.SessionID).Msg("ws: failed to publish to bus, delivering locally")
+		h.deliver(msg)
+	}
 }
 
-// NewHub creates and returns an initialised Hub.
-func NewHub() *Hub {
-	return &Hub{
-		subscribers: make(map[string][]*websocket.Conn),
+// deliver hands a message received from the bus to this Hub's subscribers.
+func (h *Hub) deliver(msg BusMessage) {
+	if msg.Close {
+		h.closeSession(msg.SessionID)
+		return
 	}
+	h.broadcast(msg.SessionID, msg.Message)
+	h.broadcastAccount(msg.APIKeyID, msg.Message)
 }
 
-// Subscribe adds conn to the subscriber list for the given session.
-func (h *Hub) Subscribe(sessionID string, conn *websocket.Conn) {
+// Subscribe adds sub to the subscriber list for the given session.
+func (h *Hub) Subscribe(sessionID string, sub Subscriber) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.subscribers[sessionID] = append(h.subscribers[sessionID], conn)
+	h.subscribers[sessionID] = append(h.subscribers[sessionID], sub)
 	log.Debug().
 		Str("session_id", sessionID).
 		Int("total_subscribers", len(h.subscribers[sessionID])).
 		Msg("ws: client subscribed")
 }
 
-// Unsubscribe removes conn from the subscriber list for the given session.
+// Unsubscribe removes sub from the subscriber list for the given session.
 // If the list becomes empty the map entry is deleted.
-func (h *Hub) Unsubscribe(sessionID string, conn *websocket.Conn) {
+func (h *Hub) Unsubscribe(sessionID string, sub Subscriber) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.unsubscribeLocked(sessionID, conn)
+	h.unsubscribeLocked(sessionID, sub)
 }
 
-// unsubscribeLocked removes conn; caller must hold h.mu (write lock).
-func (h *Hub) unsubscribeLocked(sessionID string, conn *websocket.Conn) {
-	conns := h.subscribers[sessionID]
-	filtered := conns[:0]
-	for _, c := range conns {
-		if c != conn {
-			filtered = append(filtered, c)
+// unsubscribeLocked removes sub; caller must hold h.mu (write lock).
+func (h *Hub) unsubscribeLocked(sessionID string, sub Subscriber) {
+	subs := h.subscribers[sessionID]
+	filtered := subs[:0]
+	for _, s := range subs {
+		if s != sub {
+			filtered = append(filtered, s)
 		}
 	}
 	if len(filtered) == 0 {
@@ -72,73 +135,88 @@ func (h *Hub) unsubscribeLocked(sessionID string, conn *websocket.Conn) {
 		Msg("ws: client unsubscribed")
 }
 
-// Broadcast sends msg as JSON to all subscribers of the session.
-// Connections that fail to receive the message are unsubscribed and closed.
-func (h *Hub) Broadcast(sessionID string, msg WSMessage) {
+// broadcast sends msg to all local subscribers of the session. Subscribers
+// that fail to receive the message are unsubscribed and closed.
+func (h *Hub) broadcast(sessionID string, msg WSMessage) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	conns := h.subscribers[sessionID]
-	if len(conns) == 0 {
+	subs := h.subscribers[sessionID]
+	if len(subs) == 0 {
 		return
 	}
 
 	log.Debug().
 		Str("session_id", sessionID).
 		Str("type", msg.Type).
-		Int("subscribers", len(conns)).
+		Uint64("seq", msg.Seq).
+		Int("subscribers", len(subs)).
 		Msg("ws: broadcasting message")
 
-	var failed []*websocket.Conn
-	for _, conn := range conns {
-		if err := conn.SetWriteDeadline(time.Now().Add(writeDeadline)); err != nil {
-			failed = append(failed, conn)
-			continue
-		}
-		if err := conn.WriteJSON(msg); err != nil {
+	var failed []Subscriber
+	for _, sub := range subs {
+		if err := sub.Send(msg); err != nil {
 			log.Debug().Err(err).Str("session_id", sessionID).Msg("ws: write failed, dropping subscriber")
-			failed = append(failed, conn)
+			failed = append(failed, sub)
 		}
 	}
 
-	for _, conn := range failed {
-		h.unsubscribeLocked(sessionID, conn)
-		conn.Close()
+	for _, sub := range failed {
+		h.unsubscribeLocked(sessionID, sub)
+		sub.Close()
 	}
+	h.dropped.Add(uint64(len(failed)))
 }
 
-// BroadcastStatusUpdate is a convenience method that sends a "status_update" message
-// to all subscribers of the session.
-func (h *Hub) BroadcastStatusUpdate(sessionID string, status string) {
-	h.Broadcast(sessionID, WSMessage{
-		Type:      "status_update",
-		SessionID: sessionID,
-		Status:    status,
-		Timestamp: time.Now(),
-	})
-}
+// Stats returns the current subscriber counts.
+func (h *Hub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 
-// BroadcastCompletion sends a "completed" message that includes full result metadata
-// to all subscribers of the session.
-func (h *Hub) BroadcastCompletion(sessionID string, status string, resultItems interface{}) {
-	h.Broadcast(sessionID, WSMessage{
-		Type:      "completed",
-		SessionID: sessionID,
-		Status:    status,
-		Data:      resultItems,
-		Timestamp: time.Now(),
-	})
+	stats := HubStats{
+		Sessions: len(h.subscribers),
+		Dropped:  h.dropped.Load(),
+	}
+	for _, subs := range h.subscribers {
+		stats.SessionSubscribers += len(subs)
+	}
+	for _, subs := range h.accounts {
+		stats.AccountSubscribers += len(subs)
+	}
+	return stats
 }
 
-// CloseSession closes all connections for a session and removes the map entry.
-func (h *Hub) CloseSession(sessionID string) {
+// closeSession closes all local subscribers of a session and removes the map
+// entry.
+func (h *Hub) closeSession(sessionID string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	conns := h.subscribers[sessionID]
-	for _, conn := range conns {
-		conn.Close()
+	subs := h.subscribers[sessionID]
+	for _, sub := range subs {
+		sub.Close()
 	}
 	delete(h.subscribers, sessionID)
-	log.Debug().Str("session_id", sessionID).Int("closed", len(conns)).Msg("ws: session closed, all subscribers disconnected")
+	log.Debug().Str("session_id", sessionID).Int("closed", len(subs)).Msg("ws: session closed, all subscribers disconnected")
+}
+
+// CloseAll closes the local subscribers of every session and account, e.g. on
+// shutdown, so that open event streams do not hold up a graceful server stop.
+func (h *Hub) CloseAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sessionID, subs := range h.subscribers {
+		for _, sub := range subs {
+			sub.Close()
+		}
+		delete(h.subscribers, sessionID)
+	}
+	for apiKeyID, subs := range h.accounts {
+		for _, as := range subs {
+			as.sub.Close()
+		}
+		delete(h.accounts, apiKeyID)
+	}
+	log.Debug().Msg("ws: all subscribers disconnected")
 }
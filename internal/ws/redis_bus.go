@@ -0,0 +1,133 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRedisBusChannel = "handoff:events"
+	redisBusTimeout        = 5 * time.Second
+)
+
+// RedisBus is a Bus over Redis pub/sub, shared by every replica connected to
+// the same Redis server. Messages published while a replica's connection to
+// Redis is down do not reach its subscribers; they can catch up from the
+// session event log.
+type RedisBus struct {
+	client      redis.UniversalClient
+	channel     string
+	closeClient bool
+
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+	fns    []func(BusMessage)
+}
+
+var _ Bus = (*RedisBus)(nil)
+
+// NewRedisBus creates a RedisBus publishing on channel; an empty channel
+// defaults to "handoff:events". The bus does not close client.
+func NewRedisBus(client redis.UniversalClient, channel string) *RedisBus {
+	if channel == "" {
+		channel = defaultRedisBusChannel
+	}
+	return &RedisBus{client: client, channel: channel}
+}
+
+// NewRedisBusFromURL connects to the Redis server at url (redis:// or
+// rediss://) and verifies the connection with a PING before returning a bus
+// on the default channel. Closing the bus closes the connection.
+func NewRedisBusFromURL(url string) (*RedisBus, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisBusTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("ping redis: %w", err)
+	}
+
+	bus := NewRedisBus(client, "")
+	bus.closeClient = true
+	return bus, nil
+}
+
+// Publish sends msg to the channel as JSON.
+func (b *RedisBus) Publish(msg BusMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisBusTimeout)
+	defer cancel()
+	return b.client.Publish(ctx, b.channel, data).Err()
+}
+
+// Subscribe registers fn. The first call subscribes to the channel and
+// returns once Redis has confirmed the subscription.
+func (b *RedisBus) Subscribe(fn func(BusMessage)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pubsub == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), redisBusTimeout)
+		defer cancel()
+		pubsub := b.client.Subscribe(ctx, b.channel)
+		if _, err := pubsub.Receive(ctx); err != nil {
+			pubsub.Close()
+			return fmt.Errorf("subscribe to %s: %w", b.channel, err)
+		}
+		b.pubsub = pubsub
+		go b.deliver(pubsub.Channel())
+	}
+	b.fns = append(b.fns, fn)
+	return nil
+}
+
+// deliver passes the messages received on ch to the subscribers until the
+// subscription is closed.
+func (b *RedisBus) deliver(ch <-chan *redis.Message) {
+	for m := range ch {
+		var msg BusMessage
+		if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+			log.Warn().Err(err).Str("channel", b.channel).Msg("ws: dropping malformed bus message")
+			continue
+		}
+		b.mu.Lock()
+		fns := b.fns
+		b.mu.Unlock()
+		for _, fn := range fns {
+			fn(msg)
+		}
+	}
+}
+
+// Close unsubscribes from the channel, and closes the client if the bus
+// created it.
+func (b *RedisBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var err error
+	if b.pubsub != nil {
+		err = b.pubsub.Close()
+		b.pubsub = nil
+		b.fns = nil
+	}
+	if b.closeClient {
+		if cerr := b.client.Close(); err == nil {
+			err = cerr
+		}
+		b.closeClient = false
+	}
+	return err
+}
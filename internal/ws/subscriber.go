@@ -0,0 +1,57 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrSubscriberFull is returned by StreamSubscriber.Send when the reader has
+// fallen too far behind.
+var ErrSubscriberFull = errors.New("subscriber buffer full")
+
+// Subscriber receives the messages broadcast to a session. Send is called
+// with the Hub's lock held, so it must not block.
+type Subscriber interface {
+	// Send delivers msg. An error drops the subscriber.
+	Send(msg WSMessage) error
+	// Close disconnects the subscriber once it has been dropped or its
+	// session has ended.
+	Close()
+}
+
+// StreamSubscriber buffers messages for a reader in another goroutine, such
+// as a handler that first replays missed events and then writes live ones.
+// A reader that falls behind by more than the buffer is dropped instead of
+// holding up the other subscribers.
+type StreamSubscriber struct {
+	events chan WSMessage
+	once   sync.Once
+}
+
+// NewStreamSubscriber returns a StreamSubscriber that holds up to buffer
+// unread messages before it is dropped.
+func NewStreamSubscriber(buffer int) *StreamSubscriber {
+	return &StreamSubscriber{events: make(chan WSMessage, buffer)}
+}
+
+// Events returns the channel of received messages. It is closed after the
+// subscriber is closed and every buffered message has been read.
+func (s *StreamSubscriber) Events() <-chan WSMessage {
+	return s.events
+}
+
+// Send queues msg without blocking.
+func (s *StreamSubscriber) Send(msg WSMessage) error {
+	select {
+	case s.events <- msg:
+		return nil
+	default:
+		return ErrSubscriberFull
+	}
+}
+
+// Close ends the stream after the messages already queued. The Hub never
+// calls Send after Close.
+func (s *StreamSubscriber) Close() {
+	s.once.Do(func() { close(s.events) })
+}
@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // Client is the main entry point for interacting with a Handoff server.
@@ -19,25 +21,73 @@ type Client struct {
 	baseURL    string
 	apiKey     string
 	httpClient *http.Client
+	transport  Transport
+}
+
+// Transport selects how sessions receive real-time events from the server.
+type Transport string
+
+const (
+	// TransportWebSocket receives events over a WebSocket. This is the default.
+	TransportWebSocket Transport = "websocket"
+	// TransportSSE receives events as Server-Sent Events over plain HTTP, for
+	// networks whose proxies do not pass WebSocket upgrades.
+	TransportSSE Transport = "sse"
+)
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithTransport sets the transport sessions use for real-time events. Either
+// way, a session falls back to polling if it cannot stay connected.
+func WithTransport(transport Transport) ClientOption {
+	return func(c *Client) {
+		c.transport = transport
+	}
 }
 
 // NewClient creates a new Client for the given Handoff server URL and API key.
 // baseURL should be the root URL of the server, e.g., "https://handoff.example.com".
-func NewClient(baseURL, apiKey string) *Client {
-	return &Client{
+func NewClient(baseURL, apiKey string, opts ...ClientOption) *Client {
+	c := &Client{
 		baseURL: strings.TrimRight(baseURL, "/"),
 		apiKey:  apiKey,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		transport: TransportWebSocket,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
+// idempotencyKeyHeader carries the key that lets the server deduplicate retried session creations.
+const idempotencyKeyHeader = "Idempotency-Key"
+
 // doRequest performs an HTTP request with retry logic.
 // Retries up to 3 times with exponential backoff on 5xx responses or network errors.
 func (c *Client) doRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	return c.doRequestWithHeaders(ctx, method, path, body, nil)
+}
+
+// doRequestWithHeaders is doRequest with extra request headers. The body is
+// buffered so that every attempt sends it in full. Requests carrying an
+// Idempotency-Key are also retried on 409, which the server returns while the
+// original attempt is still being processed.
+func (c *Client) doRequestWithHeaders(ctx context.Context, method, path string, body io.Reader, headers map[string]string) (*http.Response, error) {
 	url := c.baseURL + path
 	delays := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}
+	retryConflict := headers[idempotencyKeyHeader] != ""
+
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, fmt.Errorf("handoff: failed to read request body: %w", err)
+		}
+	}
 
 	var lastErr error
 	for attempt := 0; attempt <= 3; attempt++ {
@@ -52,13 +102,20 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body io.Rea
 			}
 		}
 
-		// Create a fresh request (body may have been read)
-		req, err := http.NewRequestWithContext(ctx, method, url, body)
+		// Create a fresh request with a fresh body reader
+		var reqBody io.Reader
+		if payload != nil {
+			reqBody = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 		if err != nil {
 			return nil, fmt.Errorf("handoff: failed to create request: %w", err)
 		}
 		req.Header.Set("X-API-Key", c.apiKey)
 		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
@@ -83,6 +140,11 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body io.Rea
 			lastErr = fmt.Errorf("handoff: server error %d", resp.StatusCode)
 			continue
 		}
+		if retryConflict && resp.StatusCode == http.StatusConflict && attempt < 3 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("handoff: idempotent request still in progress")
+			continue
+		}
 
 		// Non-2xx responses after retries exhausted → APIError
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -116,6 +178,22 @@ type SessionInfo struct {
 	DocumentMode ScanDocumentMode
 	// ScanOutputFormat is the scan output format for scan sessions.
 	ScanOutputFormat ScanOutputFormat
+	// VideoMaxDuration is the longest recording of video sessions.
+	VideoMaxDuration time.Duration
+	// VideoResolution is the resolution of video sessions.
+	VideoResolution VideoResolution
+	// AudioWAV reports whether audio sessions return a WAV copy of the recording.
+	AudioWAV bool
+	// UploadAllowedTypes lists the content types upload sessions accept.
+	UploadAllowedTypes []string
+	// UploadMaxFiles is the most files of upload sessions.
+	UploadMaxFiles int
+	// UploadMaxFileBytes is the size limit of each file of upload sessions.
+	UploadMaxFileBytes int64
+	// BarcodeSymbologies lists the formats barcode sessions accept.
+	BarcodeSymbologies []BarcodeSymbology
+	// BarcodePattern is the regular expression the value of barcode sessions must match, if any.
+	BarcodePattern string
 	// URL is the URL for the user to open on their phone.
 	URL string
 	// CreatedAt is when the session was created.
@@ -126,6 +204,38 @@ type SessionInfo struct {
 	Result []ResultItem
 	// ScanResult contains the scan result if the session is a completed scan session.
 	ScanResult *ScanResult
+	// BarcodeResult contains the value read if the session is a completed barcode session.
+	BarcodeResult *BarcodeResult
+	// APIKeyID is a fingerprint of the API key that created the session.
+	APIKeyID string
+	// ExternalRef is the caller-defined reference set at creation, if any.
+	ExternalRef string
+	// Metadata is the caller-defined metadata set at creation, if any.
+	Metadata map[string]string
+	// History records every status the session entered, oldest first.
+	History []StatusChange
+	// WebhookURL receives the session's events, if webhooks are enabled.
+	WebhookURL string
+	// Delivery reports where the result files are pushed and how each push
+	// went, if the session has a delivery target.
+	Delivery *DeliveryStatus
+	// Steps contains the steps of a workflow session, with the results of
+	// those completed so far.
+	Steps []StepResult
+	// CurrentStep is the index of the step the user is at in a workflow
+	// session, counting from 0. It equals len(Steps) once all are completed.
+	CurrentStep int
+}
+
+// StatusReachedAt returns when the session entered status, e.g. to measure the
+// time from creation to SessionStatusOpened. ok is false if it never did.
+func (i *SessionInfo) StatusReachedAt(status SessionStatus) (at time.Time, ok bool) {
+	for _, change := range i.History {
+		if change.Status == status {
+			return change.At, true
+		}
+	}
+	return time.Time{}, false
 }
 
 // GetSession retrieves the current state of a session by ID.
@@ -175,8 +285,22 @@ type SessionBuilder struct {
 	outputFormat    OutputFormat
 	documentMode    ScanDocumentMode
 	scanOutputFormat ScanOutputFormat
+	videoMaxDuration string
+	videoResolution VideoResolution
+	audioWAV        bool
+	uploadAllowedTypes []string
+	uploadMaxFiles  int
+	uploadMaxFileBytes int64
+	barcodeSymbologies []BarcodeSymbology
+	barcodePattern  string
 	sessionTTL      string
 	resultTTL       string
+	externalRef     string
+	metadata        map[string]string
+	webhookURL      string
+	delivery        *DeliveryTarget
+	steps           []Step
+	idempotencyKey  string
 }
 
 // WithAction sets the action type for the session. This is required.
@@ -185,6 +309,15 @@ func (b *SessionBuilder) WithAction(actionType ActionType) *SessionBuilder {
 	return b
 }
 
+// AddStep appends a step to a workflow session, which walks the user through
+// its steps in order with a single link. A session with steps needs no
+// WithAction, WithIntro, WithOutputFormat or scan options; they are set per
+// step instead. Use Session.WaitForSteps to receive the results.
+func (b *SessionBuilder) AddStep(step Step) *SessionBuilder {
+	b.steps = append(b.steps, step)
+	return b
+}
+
 // WithIntro sets the introductory text shown to the user.
 func (b *SessionBuilder) WithIntro(text string) *SessionBuilder {
 	b.introText = text
@@ -211,6 +344,69 @@ func (b *SessionBuilder) WithScanOutputFormat(format ScanOutputFormat) *SessionB
 	return b
 }
 
+// WithVideoMaxDuration sets the longest recording of video sessions as a
+// duration string, e.g., "30s". It defaults to, and cannot exceed, the
+// server's limit. Only meaningful when action type is ActionTypeVideo.
+func (b *SessionBuilder) WithVideoMaxDuration(duration string) *SessionBuilder {
+	b.videoMaxDuration = duration
+	return b
+}
+
+// WithVideoResolution sets the resolution of video sessions. Only meaningful
+// when action type is ActionTypeVideo.
+func (b *SessionBuilder) WithVideoResolution(resolution VideoResolution) *SessionBuilder {
+	b.videoResolution = resolution
+	return b
+}
+
+// WithAudioWAV requests a WAV copy of the recording of audio sessions, next
+// to the WebM or Ogg file. Only meaningful when action type is ActionTypeAudio.
+func (b *SessionBuilder) WithAudioWAV() *SessionBuilder {
+	b.audioWAV = true
+	return b
+}
+
+// WithUploadAllowedTypes sets the content types upload sessions accept, e.g.
+// "application/pdf" or "image/*". The server detects the type of each file
+// from its content. Only meaningful when action type is ActionTypeUpload.
+func (b *SessionBuilder) WithUploadAllowedTypes(types ...string) *SessionBuilder {
+	b.uploadAllowedTypes = types
+	return b
+}
+
+// WithUploadMaxFiles sets the most files of upload sessions. It defaults to,
+// and cannot exceed, the server's limit. Only meaningful when action type is
+// ActionTypeUpload.
+func (b *SessionBuilder) WithUploadMaxFiles(n int) *SessionBuilder {
+	b.uploadMaxFiles = n
+	return b
+}
+
+// WithUploadMaxFileBytes sets the size limit of each file of upload sessions.
+// It defaults to, and cannot exceed, the server's limit. Only meaningful when
+// action type is ActionTypeUpload.
+func (b *SessionBuilder) WithUploadMaxFileBytes(n int64) *SessionBuilder {
+	b.uploadMaxFileBytes = n
+	return b
+}
+
+// WithBarcodeSymbologies sets the barcode formats barcode sessions accept. It
+// defaults to every format the server reads. Only meaningful when action type
+// is ActionTypeBarcode.
+func (b *SessionBuilder) WithBarcodeSymbologies(symbologies ...BarcodeSymbology) *SessionBuilder {
+	b.barcodeSymbologies = symbologies
+	return b
+}
+
+// WithBarcodePattern sets a regular expression in Go syntax that the whole
+// value of barcode sessions must match, e.g. `SN-\d{8}`. The phone user is
+// asked for another barcode until one matches. Only meaningful when action
+// type is ActionTypeBarcode.
+func (b *SessionBuilder) WithBarcodePattern(pattern string) *SessionBuilder {
+	b.barcodePattern = pattern
+	return b
+}
+
 // WithSessionTTL sets the session time-to-live as a duration string, e.g., "30m".
 func (b *SessionBuilder) WithSessionTTL(ttl string) *SessionBuilder {
 	b.sessionTTL = ttl
@@ -223,15 +419,75 @@ func (b *SessionBuilder) WithResultTTL(ttl string) *SessionBuilder {
 	return b
 }
 
+// WithExternalRef sets a caller-defined reference, such as an order ID, that the
+// server echoes in every response and event for the session.
+func (b *SessionBuilder) WithExternalRef(ref string) *SessionBuilder {
+	b.externalRef = ref
+	return b
+}
+
+// WithMetadata sets caller-defined key/value pairs that the server echoes like
+// the external reference. Sessions can be listed by metadata value.
+func (b *SessionBuilder) WithMetadata(metadata map[string]string) *SessionBuilder {
+	b.metadata = metadata
+	return b
+}
+
+// WithWebhookURL sets the URL that receives a signed POST for every status
+// change of the session, overriding the API key's default. Verify requests
+// with VerifyWebhook.
+func (b *SessionBuilder) WithWebhookURL(url string) *SessionBuilder {
+	b.webhookURL = url
+	return b
+}
+
+// WithDelivery sets the target the server pushes the result files to once the
+// session completes. Track the pushes with Client.GetSession.
+func (b *SessionBuilder) WithDelivery(target DeliveryTarget) *SessionBuilder {
+	b.delivery = &target
+	return b
+}
+
+// WithIdempotencyKey sets the Idempotency-Key sent when creating the session.
+// Invoke generates a random key when none is set, which already makes its own
+// retries safe; set one explicitly to also deduplicate across separate Invoke
+// calls, e.g. after a process restart.
+func (b *SessionBuilder) WithIdempotencyKey(key string) *SessionBuilder {
+	b.idempotencyKey = key
+	return b
+}
+
 // Invoke validates the builder, creates the session on the server, and returns a
 // connected Session object ready to receive events.
 func (b *SessionBuilder) Invoke(ctx context.Context) (*Session, error) {
-	if b.actionType == "" {
+	if b.actionType == "" && len(b.steps) == 0 {
 		return nil, fmt.Errorf("handoff: action type is required (use WithAction)")
 	}
 
 	var reqBody CreateSessionRequest
-	if b.actionType == ActionTypeScan {
+	if len(b.steps) > 0 {
+		steps := make([]Step, len(b.steps))
+		for i, step := range b.steps {
+			if step.ActionType == ActionTypeScan && step.ScanOutputFormat != "" {
+				// As for scan sessions, output_format carries the scan-specific format.
+				step.OutputFormat = OutputFormat(step.ScanOutputFormat)
+			} else if step.ActionType != ActionTypeScan && step.ActionType != ActionTypeUpload &&
+				step.ActionType != ActionTypeBarcode && step.OutputFormat == "" {
+				return nil, fmt.Errorf("handoff: output format is required for step %d", i+1)
+			}
+			steps[i] = step
+		}
+		reqBody = CreateSessionRequest{
+			ActionType:  b.actionType,
+			Steps:       steps,
+			SessionTTL:  b.sessionTTL,
+			ResultTTL:   b.resultTTL,
+			ExternalRef: b.externalRef,
+			Metadata:    b.metadata,
+			WebhookURL:  b.webhookURL,
+			Delivery:    b.delivery,
+		}
+	} else if b.actionType == ActionTypeScan {
 		// For scan sessions, output_format carries the scan-specific format.
 		// output_format is not required — defaults to "pdf".
 		scanFmt := b.scanOutputFormat
@@ -245,17 +501,35 @@ func (b *SessionBuilder) Invoke(ctx context.Context) (*Session, error) {
 			DocumentMode: b.documentMode,
 			SessionTTL:   b.sessionTTL,
 			ResultTTL:    b.resultTTL,
+			ExternalRef:  b.externalRef,
+			Metadata:     b.metadata,
+			WebhookURL:   b.webhookURL,
+			Delivery:     b.delivery,
 		}
 	} else {
-		if b.outputFormat == "" {
+		// Upload sessions keep the type of each file, and barcode sessions
+		// return the value read.
+		if b.outputFormat == "" && b.actionType != ActionTypeUpload && b.actionType != ActionTypeBarcode {
 			return nil, fmt.Errorf("handoff: output format is required (use WithOutputFormat)")
 		}
 		reqBody = CreateSessionRequest{
-			ActionType:   b.actionType,
-			IntroText:    b.introText,
-			OutputFormat: b.outputFormat,
-			SessionTTL:   b.sessionTTL,
-			ResultTTL:    b.resultTTL,
+			ActionType:         b.actionType,
+			IntroText:          b.introText,
+			OutputFormat:       b.outputFormat,
+			SessionTTL:         b.sessionTTL,
+			ResultTTL:          b.resultTTL,
+			VideoMaxDuration:   b.videoMaxDuration,
+			VideoResolution:    b.videoResolution,
+			AudioWAV:           b.audioWAV,
+			UploadAllowedTypes: b.uploadAllowedTypes,
+			UploadMaxFiles:     b.uploadMaxFiles,
+			UploadMaxFileBytes: b.uploadMaxFileBytes,
+			BarcodeSymbologies: b.barcodeSymbologies,
+			BarcodePattern:     b.barcodePattern,
+			ExternalRef:        b.externalRef,
+			Metadata:           b.metadata,
+			WebhookURL:         b.webhookURL,
+			Delivery:           b.delivery,
 		}
 	}
 
@@ -264,7 +538,15 @@ func (b *SessionBuilder) Invoke(ctx context.Context) (*Session, error) {
 		return nil, fmt.Errorf("handoff: failed to marshal request: %w", err)
 	}
 
-	resp, err := b.client.doRequest(ctx, http.MethodPost, "/api/v1/sessions", bytes.NewReader(bodyBytes))
+	// The key makes the retries in doRequest safe: a retried POST whose first
+	// attempt reached the server returns that attempt's session.
+	idempotencyKey := b.idempotencyKey
+	if idempotencyKey == "" {
+		idempotencyKey = uuid.New().String()
+	}
+	resp, err := b.client.doRequestWithHeaders(ctx, http.MethodPost, "/api/v1/sessions", bytes.NewReader(bodyBytes), map[string]string{
+		idempotencyKeyHeader: idempotencyKey,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -276,24 +558,45 @@ func (b *SessionBuilder) Invoke(ctx context.Context) (*Session, error) {
 	}
 
 	session := newSession(b.client, &sr)
-	go session.runWebSocket()
+	if b.client.transport == TransportSSE {
+		go session.runSSE()
+	} else {
+		go session.runWebSocket()
+	}
 	return session, nil
 }
 
 // sessionResponseToInfo converts an internal sessionResponse to a public SessionInfo.
 func sessionResponseToInfo(sr *sessionResponse) *SessionInfo {
 	return &SessionInfo{
-		ID:               sr.ID,
-		ActionType:       sr.ActionType,
-		Status:           sr.Status,
-		IntroText:        sr.IntroText,
-		OutputFormat:     sr.OutputFormat,
-		DocumentMode:     sr.DocumentMode,
-		ScanOutputFormat: sr.ScanOutputFormat,
-		URL:              sr.URL,
-		CreatedAt:        sr.CreatedAt,
-		CompletedAt:      sr.CompletedAt,
-		Result:           sr.Result,
-		ScanResult:       sr.ScanResult,
+		ID:                 sr.ID,
+		ActionType:         sr.ActionType,
+		Status:             sr.Status,
+		IntroText:          sr.IntroText,
+		OutputFormat:       sr.OutputFormat,
+		DocumentMode:       sr.DocumentMode,
+		ScanOutputFormat:   sr.ScanOutputFormat,
+		VideoMaxDuration:   time.Duration(sr.VideoMaxDuration),
+		VideoResolution:    sr.VideoResolution,
+		AudioWAV:           sr.AudioWAV,
+		UploadAllowedTypes: sr.UploadAllowedTypes,
+		UploadMaxFiles:     sr.UploadMaxFiles,
+		UploadMaxFileBytes: sr.UploadMaxFileBytes,
+		BarcodeSymbologies: sr.BarcodeSymbologies,
+		BarcodePattern:     sr.BarcodePattern,
+		URL:                sr.URL,
+		CreatedAt:          sr.CreatedAt,
+		CompletedAt:        sr.CompletedAt,
+		Result:             sr.Result,
+		ScanResult:         sr.ScanResult,
+		BarcodeResult:      sr.BarcodeResult,
+		APIKeyID:           sr.APIKeyID,
+		ExternalRef:        sr.ExternalRef,
+		Metadata:           sr.Metadata,
+		History:            sr.History,
+		WebhookURL:         sr.WebhookURL,
+		Delivery:           sr.Delivery,
+		Steps:              sr.Steps,
+		CurrentStep:        sr.CurrentStep,
 	}
 }
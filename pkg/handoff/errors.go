@@ -8,7 +8,8 @@ import (
 
 // Sentinel errors for common API error conditions.
 var (
-	// ErrSessionExpired is returned when the server responds with 410 Gone.
+	// ErrSessionExpired is returned when the server responds with 410 Gone, and
+	// by WaitForResult and WaitForScanResult when the session TTL runs out.
 	ErrSessionExpired = errors.New("handoff: session expired")
 	// ErrNotFound is returned when the server responds with 404 Not Found.
 	ErrNotFound = errors.New("handoff: session not found")
@@ -16,6 +17,12 @@ var (
 	ErrUnauthorized = errors.New("handoff: unauthorized")
 	// ErrConflict is returned when the server responds with 409 Conflict.
 	ErrConflict = errors.New("handoff: conflict")
+	// ErrSessionCancelled is returned by WaitForResult and WaitForScanResult
+	// when the session is cancelled before it completes.
+	ErrSessionCancelled = errors.New("handoff: session cancelled")
+	// ErrInvalidWebhook is returned by VerifyWebhook for requests whose
+	// signature or timestamp does not check out.
+	ErrInvalidWebhook = errors.New("handoff: invalid webhook")
 )
 
 // APIError represents an error returned by the Handoff API.
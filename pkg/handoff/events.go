@@ -0,0 +1,112 @@
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxEventsReconnectDelay caps the delay between reconnects of Client.Events.
+const maxEventsReconnectDelay = 30 * time.Second
+
+// EventsOption filters the sessions whose events Client.Events receives.
+type EventsOption func(url.Values)
+
+// WithEventActionTypes only receives events of sessions with one of the given action types.
+func WithEventActionTypes(types ...ActionType) EventsOption {
+	return func(q url.Values) {
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = string(t)
+		}
+		q.Set("action_type", strings.Join(names, ","))
+	}
+}
+
+// WithEventMetadata only receives events of sessions whose metadata has key
+// set to value. Use it several times to require several values.
+func WithEventMetadata(key, value string) EventsOption {
+	return func(q url.Values) {
+		q.Set("metadata."+key, value)
+	}
+}
+
+// wsBaseURL converts the HTTP base URL to a WebSocket URL.
+func (c *Client) wsBaseURL() string {
+	base := c.baseURL
+	switch {
+	case strings.HasPrefix(base, "https://"):
+		base = "wss://" + strings.TrimPrefix(base, "https://")
+	case strings.HasPrefix(base, "http://"):
+		base = "ws://" + strings.TrimPrefix(base, "http://")
+	}
+	return base
+}
+
+// Events streams the events of every session created with this client's API
+// key over a single WebSocket, including sessions created later, until ctx is
+// done; then the channel is closed. It reconnects with backoff when the
+// connection drops. Events that occur while disconnected are not delivered,
+// so reconcile with GetSession or ListSessions if you cannot miss any.
+//
+// Read from the channel promptly: the server drops connections that fall
+// behind.
+func (c *Client) Events(ctx context.Context, opts ...EventsOption) <-chan Event {
+	q := url.Values{"api_key": {c.apiKey}}
+	for _, opt := range opts {
+		opt(q)
+	}
+	streamURL := c.wsBaseURL() + "/api/v1/events/ws?" + q.Encode()
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		delay := time.Second
+		for {
+			if c.readEvents(ctx, streamURL, events) {
+				delay = time.Second
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxEventsReconnectDelay)
+		}
+	}()
+	return events
+}
+
+// readEvents connects to the account event stream and forwards its events
+// until the connection drops or ctx is done. Returns true if it connected.
+func (c *Client) readEvents(ctx context.Context, streamURL string, events chan<- Event) bool {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, streamURL, nil)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	// Unblock ReadMessage when ctx is done.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		_, msgBytes, err := conn.ReadMessage()
+		if err != nil {
+			return true
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(msgBytes, &msg); err != nil {
+			continue
+		}
+		select {
+		case events <- msg.event():
+		case <-ctx.Done():
+			return true
+		}
+	}
+}
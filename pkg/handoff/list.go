@@ -0,0 +1,184 @@
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SortOrder controls the order in which ListSessions returns sessions.
+type SortOrder string
+
+const (
+	// SortOrderDesc lists the newest sessions first. This is the default.
+	SortOrderDesc SortOrder = "desc"
+	// SortOrderAsc lists the oldest sessions first.
+	SortOrderAsc SortOrder = "asc"
+)
+
+// ListSessionsOptions filters the sessions returned by ListSessions.
+// Zero-valued fields match everything.
+type ListSessionsOptions struct {
+	// Status matches sessions in any of the given statuses.
+	Status []SessionStatus
+	// ActionType matches sessions of any of the given action types.
+	ActionType []ActionType
+	// CreatedAfter only matches sessions created after this time.
+	CreatedAfter time.Time
+	// CreatedBefore only matches sessions created before this time.
+	CreatedBefore time.Time
+	// Mine only matches sessions created with this client's API key.
+	Mine bool
+	// ExternalRef only matches sessions with exactly this external reference.
+	ExternalRef string
+	// Metadata only matches sessions carrying every given key/value pair.
+	Metadata map[string]string
+	// Order sorts by creation time. Defaults to SortOrderDesc.
+	Order SortOrder
+	// PageSize is the number of sessions fetched per request (server default 50, max 200).
+	PageSize int
+}
+
+// listSessionsResponse is the response from GET /api/v1/sessions.
+type listSessionsResponse struct {
+	Sessions   []sessionResponse `json:"sessions"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// SessionIterator walks the results of ListSessions, fetching pages lazily.
+//
+//	it := client.ListSessions(ctx, &handoff.ListSessionsOptions{Mine: true})
+//	for it.Next() {
+//		fmt.Println(it.Session().ID)
+//	}
+//	if err := it.Err(); err != nil {
+//		// handle error
+//	}
+type SessionIterator struct {
+	ctx     context.Context
+	client  *Client
+	query   url.Values
+	page    []sessionResponse
+	current *SessionInfo
+	cursor  string
+	last    bool
+	err     error
+}
+
+// ListSessions returns an iterator over the live sessions matching opts.
+// opts may be nil to list every session.
+func (c *Client) ListSessions(ctx context.Context, opts *ListSessionsOptions) *SessionIterator {
+	if opts == nil {
+		opts = &ListSessionsOptions{}
+	}
+
+	query := url.Values{}
+	if len(opts.Status) > 0 {
+		values := make([]string, len(opts.Status))
+		for i, s := range opts.Status {
+			values[i] = string(s)
+		}
+		query.Set("status", strings.Join(values, ","))
+	}
+	if len(opts.ActionType) > 0 {
+		values := make([]string, len(opts.ActionType))
+		for i, a := range opts.ActionType {
+			values[i] = string(a)
+		}
+		query.Set("action_type", strings.Join(values, ","))
+	}
+	if !opts.CreatedAfter.IsZero() {
+		query.Set("created_after", opts.CreatedAfter.Format(time.RFC3339Nano))
+	}
+	if !opts.CreatedBefore.IsZero() {
+		query.Set("created_before", opts.CreatedBefore.Format(time.RFC3339Nano))
+	}
+	if opts.Mine {
+		query.Set("mine", "true")
+	}
+	if opts.ExternalRef != "" {
+		query.Set("external_ref", opts.ExternalRef)
+	}
+	for k, v := range opts.Metadata {
+		query.Set("metadata."+k, v)
+	}
+	if opts.Order != "" {
+		query.Set("order", string(opts.Order))
+	}
+	if opts.PageSize > 0 {
+		query.Set("limit", strconv.Itoa(opts.PageSize))
+	}
+
+	return &SessionIterator{ctx: ctx, client: c, query: query}
+}
+
+// Next advances to the next session, fetching another page if needed.
+// It returns false when there are no more sessions or an error occurred.
+func (it *SessionIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for len(it.page) == 0 {
+		if it.last {
+			it.current = nil
+			return false
+		}
+		if err := it.fetch(); err != nil {
+			it.err = err
+			it.current = nil
+			return false
+		}
+	}
+	it.current = sessionResponseToInfo(&it.page[0])
+	it.page = it.page[1:]
+	return true
+}
+
+// Session returns the session at the current position. Only valid after
+// Next returned true.
+func (it *SessionIterator) Session() *SessionInfo {
+	return it.current
+}
+
+// Err returns the first error encountered while fetching pages.
+func (it *SessionIterator) Err() error {
+	return it.err
+}
+
+// fetch loads the next page of sessions.
+func (it *SessionIterator) fetch() error {
+	query := it.query
+	if it.cursor != "" {
+		query = url.Values{}
+		for k, v := range it.query {
+			query[k] = v
+		}
+		query.Set("cursor", it.cursor)
+	}
+
+	path := "/api/v1/sessions"
+	if encoded := query.Encode(); encoded != "" {
+		path += "?" + encoded
+	}
+
+	resp, err := it.client.doRequest(it.ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var lr listSessionsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil {
+		return fmt.Errorf("handoff: failed to decode session list: %w", err)
+	}
+
+	it.page = lr.Sessions
+	it.cursor = lr.NextCursor
+	it.last = lr.NextCursor == ""
+	return nil
+}
@@ -1,13 +1,15 @@
 package handoff
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
@@ -28,24 +30,74 @@ type Session struct {
 	ActionType ActionType
 	// OutputFormat is the requested output format.
 	OutputFormat OutputFormat
-
-	client     *Client
-	done       chan struct{}
-	resultCh   chan []ResultItem
-	mu         sync.Mutex
-	callbacks  []func(Event)
-	wsConn     *websocket.Conn
-	closed     bool
-	scanResult *ScanResult
+	// ExternalRef is the external reference the session was created with.
+	ExternalRef string
+	// Metadata is the metadata the session was created with.
+	Metadata map[string]string
+
+	client        *Client
+	done          chan struct{}
+	resultCh      chan []ResultItem
+	errCh         chan error
+	mu            sync.Mutex
+	callbacks     []func(Event)
+	wsConn        *websocket.Conn
+	closed        bool
+	scanResult    *ScanResult
+	barcodeResult *BarcodeResult
+	steps         []StepResult
+	lastSeq       uint64 // sequence number of the last logged event received
 }
 
-// wsMessage is the incoming WebSocket message shape from the server.
+// wsMessage is the incoming WebSocket and Server-Sent Events message shape from the server.
 type wsMessage struct {
 	Type      string          `json:"type"`
 	SessionID string          `json:"session_id"`
+	Seq       uint64          `json:"seq,omitempty"`
 	Status    string          `json:"status"`
 	Data      json.RawMessage `json:"data,omitempty"`
 	Timestamp time.Time       `json:"timestamp"`
+
+	ActionType  string            `json:"action_type,omitempty"`
+	ExternalRef string            `json:"external_ref,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// event converts the message into an Event, decoding its data.
+func (msg wsMessage) event() Event {
+	evt := Event{
+		Type:       msg.Type,
+		SessionID:  msg.SessionID,
+		Seq:        msg.Seq,
+		Status:     SessionStatus(msg.Status),
+		ActionType: ActionType(msg.ActionType),
+		Timestamp:  msg.Timestamp,
+
+		ExternalRef: msg.ExternalRef,
+		Metadata:    msg.Metadata,
+	}
+
+	switch {
+	case msg.Type == "completed" && len(msg.Data) > 0:
+		evt.Result, evt.ScanResult, evt.BarcodeResult, evt.Steps = decodeResultData(msg.Data)
+	case msg.Type == "step_completed" && len(msg.Data) > 0:
+		var step struct {
+			Index int `json:"index"`
+			StepResult
+		}
+		if err := json.Unmarshal(msg.Data, &step); err == nil {
+			evt.Step = &step.StepResult
+			evt.StepIndex = step.Index
+		}
+	case msg.Type == "ttl_updated" && len(msg.Data) > 0:
+		var update struct {
+			ExpiresAt time.Time `json:"expires_at"`
+		}
+		if err := json.Unmarshal(msg.Data, &update); err == nil {
+			evt.ExpiresAt = update.ExpiresAt
+		}
+	}
+	return evt
 }
 
 // newSession creates a Session from a server response without starting any goroutines.
@@ -56,9 +108,12 @@ func newSession(client *Client, sr *sessionResponse) *Session {
 		Status:       sr.Status,
 		ActionType:   sr.ActionType,
 		OutputFormat: sr.OutputFormat,
+		ExternalRef:  sr.ExternalRef,
+		Metadata:     sr.Metadata,
 		client:       client,
 		done:         make(chan struct{}),
 		resultCh:     make(chan []ResultItem, 1),
+		errCh:        make(chan error, 1),
 	}
 }
 
@@ -83,11 +138,14 @@ func (s *Session) OnEvent(callback func(Event)) {
 }
 
 // WaitForResult blocks until the session is completed or the context is cancelled.
-// Returns the result items on completion.
+// Returns the result items on completion, ErrSessionCancelled if the session
+// is cancelled first, or ErrSessionExpired if its TTL runs out.
 func (s *Session) WaitForResult(ctx context.Context) ([]ResultItem, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case err := <-s.errCh:
+		return nil, err
 	case items, ok := <-s.resultCh:
 		if !ok {
 			return nil, fmt.Errorf("handoff: session closed before completion")
@@ -98,10 +156,13 @@ func (s *Session) WaitForResult(ctx context.Context) ([]ResultItem, error) {
 
 // WaitForScanResult blocks until the session is completed or the context is cancelled.
 // Returns the scan result on completion. Use this for scan sessions instead of WaitForResult.
+// Cancellation and expiry are reported as for WaitForResult.
 func (s *Session) WaitForScanResult(ctx context.Context) (*ScanResult, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case err := <-s.errCh:
+		return nil, err
 	case _, ok := <-s.resultCh:
 		if !ok {
 			return nil, fmt.Errorf("handoff: session closed before completion")
@@ -116,7 +177,96 @@ func (s *Session) WaitForScanResult(ctx context.Context) (*ScanResult, error) {
 	}
 }
 
-// Close stops the WebSocket connection and any background goroutines.
+// WaitForBarcode blocks until the session is completed or the context is cancelled.
+// Returns the value read on completion. Use this for barcode sessions instead of
+// WaitForResult. Cancellation and expiry are reported as for WaitForResult.
+func (s *Session) WaitForBarcode(ctx context.Context) (*BarcodeResult, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err := <-s.errCh:
+		return nil, err
+	case _, ok := <-s.resultCh:
+		if !ok {
+			return nil, fmt.Errorf("handoff: session closed before completion")
+		}
+		s.mu.Lock()
+		br := s.barcodeResult
+		s.mu.Unlock()
+		if br == nil {
+			return nil, fmt.Errorf("handoff: no barcode result available")
+		}
+		return br, nil
+	}
+}
+
+// WaitForSteps blocks until the session is completed or the context is cancelled.
+// Returns the steps with their results on completion. Use this for workflow
+// sessions instead of WaitForResult. Cancellation and expiry are reported as
+// for WaitForResult.
+func (s *Session) WaitForSteps(ctx context.Context) ([]StepResult, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err := <-s.errCh:
+		return nil, err
+	case _, ok := <-s.resultCh:
+		if !ok {
+			return nil, fmt.Errorf("handoff: session closed before completion")
+		}
+		s.mu.Lock()
+		steps := s.steps
+		s.mu.Unlock()
+		if steps == nil {
+			return nil, fmt.Errorf("handoff: no step results available")
+		}
+		return steps, nil
+	}
+}
+
+// Cancel cancels the session on the server. The phone link stops working, any
+// pending WaitForResult call returns ErrSessionCancelled, and subscribers
+// receive a final "cancelled" event. Cancelling twice is not an error.
+func (s *Session) Cancel(ctx context.Context) error {
+	resp, err := s.client.doRequest(ctx, http.MethodDelete, "/api/v1/sessions/"+s.ID, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	s.mu.Lock()
+	s.Status = SessionStatusCancelled
+	s.mu.Unlock()
+	s.fail(ErrSessionCancelled)
+	return nil
+}
+
+// Extend changes the session's lifetime by d, measured against its current
+// expiry; a negative d shortens it. Uploaded scan pages are kept for the new
+// lifetime too, and subscribers receive a "ttl_updated" event. Returns
+// ErrConflict once the session has completed or been cancelled.
+func (s *Session) Extend(ctx context.Context, d time.Duration) error {
+	body, err := json.Marshal(map[string]string{"extend_by": d.String()})
+	if err != nil {
+		return fmt.Errorf("handoff: failed to marshal request: %w", err)
+	}
+	resp, err := s.client.doRequest(ctx, http.MethodPatch, "/api/v1/sessions/"+s.ID, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// fail hands err to a waiting WaitForResult call. Only the first error is kept.
+func (s *Session) fail(err error) {
+	select {
+	case s.errCh <- err:
+	default:
+	}
+}
+
+// Close stops the WebSocket or event stream connection and any background goroutines.
 // It is idempotent — calling Close multiple times is safe.
 func (s *Session) Close() error {
 	s.mu.Lock()
@@ -157,16 +307,21 @@ func (s *Session) isClosed() bool {
 	return s.closed
 }
 
-// wsURL converts the HTTP base URL to a WebSocket URL and appends the session path.
-func (s *Session) wsURL() string {
-	base := s.client.baseURL
-	switch {
-	case strings.HasPrefix(base, "https://"):
-		base = "wss://" + strings.TrimPrefix(base, "https://")
-	case strings.HasPrefix(base, "http://"):
-		base = "ws://" + strings.TrimPrefix(base, "http://")
+// wsURL returns the WebSocket URL of the session's event stream. When
+// resuming, the stream starts with the events after the last one received.
+func (s *Session) wsURL(resume bool) string {
+	u := fmt.Sprintf("%s/api/v1/sessions/%s/ws?api_key=%s", s.client.wsBaseURL(), s.ID, s.client.apiKey)
+	if resume {
+		u += "&since=" + strconv.FormatUint(s.seenSeq(), 10)
 	}
-	return fmt.Sprintf("%s/api/v1/sessions/%s/ws?api_key=%s", base, s.ID, s.client.apiKey)
+	return u
+}
+
+// seenSeq returns the sequence number of the last logged event received.
+func (s *Session) seenSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeq
 }
 
 // runWebSocket is the main WebSocket goroutine. It attempts to connect and read messages,
@@ -189,7 +344,8 @@ func (s *Session) runWebSocket() {
 			}
 		}
 
-		conn, _, err := websocket.DefaultDialer.Dial(s.wsURL(), nil)
+		// Reconnects replay the events missed while disconnected.
+		conn, _, err := websocket.DefaultDialer.Dial(s.wsURL(attempt > 0), nil)
 		if err != nil {
 			continue
 		}
@@ -215,7 +371,7 @@ func (s *Session) runWebSocket() {
 }
 
 // readWebSocketMessages reads messages from the WebSocket connection until it closes.
-// Returns true if the session completed, false if the connection was lost.
+// Returns true if the session completed, was cancelled or expired, false if the connection was lost.
 func (s *Session) readWebSocketMessages(conn *websocket.Conn) bool {
 	for {
 		select {
@@ -233,39 +389,90 @@ func (s *Session) readWebSocketMessages(conn *websocket.Conn) bool {
 		if err := json.Unmarshal(msgBytes, &msg); err != nil {
 			continue
 		}
+		if s.handleMessage(msg) {
+			return true
+		}
+	}
+}
 
-		evt := Event{
-			Type:      msg.Type,
-			SessionID: msg.SessionID,
-			Status:    SessionStatus(msg.Status),
-			Timestamp: msg.Timestamp,
+// handleMessage dispatches a message received over WebSocket, Server-Sent
+// Events or polling, skipping logged events that were already dispatched.
+// Returns true if the session completed, was cancelled or expired.
+func (s *Session) handleMessage(msg wsMessage) bool {
+	if msg.Seq != 0 {
+		// Events replayed after a reconnect may overlap with those already seen.
+		s.mu.Lock()
+		seen := msg.Seq <= s.lastSeq
+		if !seen {
+			s.lastSeq = msg.Seq
 		}
+		s.mu.Unlock()
+		if seen {
+			return false
+		}
+	}
+	evt := msg.event()
 
-		if msg.Type == "completed" && len(msg.Data) > 0 {
-			// Try standard result items first (photo/signature sessions).
-			var items []ResultItem
-			if err := json.Unmarshal(msg.Data, &items); err == nil && len(items) > 0 {
-				evt.Result = items
-			} else {
-				// Try scan result (scan sessions).
-				var scanResult ScanResult
-				if err := json.Unmarshal(msg.Data, &scanResult); err == nil && len(scanResult.Documents) > 0 {
-					s.mu.Lock()
-					s.scanResult = &scanResult
-					s.mu.Unlock()
-					evt.ScanResult = &scanResult
-				}
-			}
-			s.dispatchEvent(evt)
-			select {
-			case s.resultCh <- evt.Result:
-			default:
-			}
-			return true
+	if msg.Type == "completed" && len(msg.Data) > 0 {
+		s.mu.Lock()
+		if evt.ScanResult != nil {
+			s.scanResult = evt.ScanResult
+		}
+		if evt.BarcodeResult != nil {
+			s.barcodeResult = evt.BarcodeResult
+		}
+		if evt.Steps != nil {
+			s.steps = evt.Steps
+		}
+		s.mu.Unlock()
+		s.dispatchEvent(evt)
+		select {
+		case s.resultCh <- evt.Result:
+		default:
 		}
+		return true
+	}
+
+	if msg.Type == "cancelled" {
+		s.dispatchEvent(evt)
+		s.fail(ErrSessionCancelled)
+		return true
+	}
 
+	if msg.Type == "expired" {
 		s.dispatchEvent(evt)
+		s.fail(ErrSessionExpired)
+		return true
+	}
+
+	s.dispatchEvent(evt)
+	return false
+}
+
+// decodeResultData parses the data of a "completed" event: result items for
+// photo, signature, video, audio and upload sessions, a scan result for scan
+// sessions, a barcode result for barcode sessions, or the steps of workflow
+// sessions.
+func decodeResultData(data json.RawMessage) ([]ResultItem, *ScanResult, *BarcodeResult, []StepResult) {
+	var items []ResultItem
+	if err := json.Unmarshal(data, &items); err == nil && len(items) > 0 {
+		return items, nil, nil, nil
+	}
+	var workflow struct {
+		Steps []StepResult `json:"steps"`
+	}
+	if err := json.Unmarshal(data, &workflow); err == nil && len(workflow.Steps) > 0 {
+		return nil, nil, nil, workflow.Steps
+	}
+	var scanResult ScanResult
+	if err := json.Unmarshal(data, &scanResult); err == nil && len(scanResult.Documents) > 0 {
+		return nil, &scanResult, nil, nil
 	}
+	var barcodeResult BarcodeResult
+	if err := json.Unmarshal(data, &barcodeResult); err == nil && barcodeResult.Symbology != "" {
+		return nil, nil, &barcodeResult, nil
+	}
+	return nil, nil, nil, nil
 }
 
 // runPolling polls the result endpoint every 2 seconds as a fallback when WebSocket fails.
@@ -278,7 +485,27 @@ func (s *Session) runPolling() {
 		case <-s.done:
 			return
 		case <-ticker.C:
-			items, done, err := s.pollResult()
+			items, events, done, err := s.pollResult()
+			// Logged events since the last one received come first; the
+			// events synthesised below only cover a log that is unavailable.
+			for _, msg := range events {
+				if s.handleMessage(msg) {
+					return
+				}
+			}
+			if status, ok := terminalStatus(err); ok {
+				s.dispatchEvent(Event{
+					Type:      string(status),
+					SessionID: s.ID,
+					Status:    status,
+					Timestamp: time.Now().UTC(),
+
+					ExternalRef: s.ExternalRef,
+					Metadata:    s.Metadata,
+				})
+				s.fail(err)
+				return
+			}
 			if err != nil {
 				continue
 			}
@@ -289,9 +516,14 @@ func (s *Session) runPolling() {
 					Status:    SessionStatusCompleted,
 					Result:    items,
 					Timestamp: time.Now().UTC(),
+
+					ExternalRef: s.ExternalRef,
+					Metadata:    s.Metadata,
 				}
 				s.mu.Lock()
 				evt.ScanResult = s.scanResult
+				evt.BarcodeResult = s.barcodeResult
+				evt.Steps = s.steps
 				s.mu.Unlock()
 				s.dispatchEvent(evt)
 				select {
@@ -304,40 +536,66 @@ func (s *Session) runPolling() {
 	}
 }
 
-// pollResult queries the result endpoint once and reports whether the session is complete.
-func (s *Session) pollResult() ([]ResultItem, bool, error) {
+// terminalStatus maps the errors pollResult reports for sessions that ended
+// without a result to the corresponding status.
+func terminalStatus(err error) (SessionStatus, bool) {
+	switch {
+	case errors.Is(err, ErrSessionCancelled):
+		return SessionStatusCancelled, true
+	case errors.Is(err, ErrSessionExpired):
+		return SessionStatusExpired, true
+	default:
+		return "", false
+	}
+}
+
+// pollResult queries the result endpoint once and reports whether the session is complete,
+// along with the logged events after the last one received.
+// Returns ErrSessionCancelled if the session was cancelled and ErrSessionExpired
+// (via the 410 APIError) if it expired.
+func (s *Session) pollResult() ([]ResultItem, []wsMessage, bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	resp, err := s.client.doRequest(ctx, http.MethodGet, "/api/v1/sessions/"+s.ID+"/result", nil)
+	path := "/api/v1/sessions/" + s.ID + "/result?since=" + strconv.FormatUint(s.seenSeq(), 10)
+	resp, err := s.client.doRequest(ctx, http.MethodGet, path, nil)
 	if err != nil {
-		return nil, false, err
+		return nil, nil, false, err
 	}
 	defer resp.Body.Close()
 
-	// 202 Accepted means still pending
-	if resp.StatusCode == http.StatusAccepted {
-		return nil, false, nil
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, false, fmt.Errorf("handoff: failed to read poll response: %w", err)
+		return nil, nil, false, fmt.Errorf("handoff: failed to read poll response: %w", err)
 	}
 
 	var pollResp resultPollResponse
 	if err := json.Unmarshal(body, &pollResp); err != nil {
-		return nil, false, fmt.Errorf("handoff: failed to decode poll response: %w", err)
+		return nil, nil, false, fmt.Errorf("handoff: failed to decode poll response: %w", err)
+	}
+
+	// 202 Accepted means still pending
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, pollResp.Events, false, nil
 	}
 
 	if SessionStatus(pollResp.Status) == SessionStatusCompleted {
+		s.mu.Lock()
 		if pollResp.ScanResult != nil {
-			s.mu.Lock()
 			s.scanResult = pollResp.ScanResult
-			s.mu.Unlock()
 		}
-		return pollResp.Items, true, nil
+		if pollResp.BarcodeResult != nil {
+			s.barcodeResult = pollResp.BarcodeResult
+		}
+		if pollResp.Steps != nil {
+			s.steps = pollResp.Steps
+		}
+		s.mu.Unlock()
+		return pollResp.Items, pollResp.Events, true, nil
+	}
+	if SessionStatus(pollResp.Status) == SessionStatusCancelled {
+		return nil, pollResp.Events, false, ErrSessionCancelled
 	}
 
-	return nil, false, nil
+	return nil, pollResp.Events, false, nil
 }
@@ -0,0 +1,121 @@
+package handoff
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSSELine bounds a single line of the event stream; completed events carry
+// the result metadata.
+const maxSSELine = 1 << 20
+
+// sseURL returns the URL of the session's event stream.
+func (s *Session) sseURL() string {
+	return fmt.Sprintf("%s/api/v1/sessions/%s/events", s.client.baseURL, s.ID)
+}
+
+// runSSE is the Server-Sent Events counterpart of runWebSocket. It reconnects
+// up to 3 times with backoff, resuming from the last event received, and falls
+// back to polling after that.
+func (s *Session) runSSE() {
+	const maxReconnects = 3
+	reconnectDelays := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+
+	// Closing the session aborts the request in flight.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// The stream stays open for the whole session, so no client timeout applies.
+	httpClient := &http.Client{Transport: s.client.httpClient.Transport}
+
+	for attempt := 0; attempt < maxReconnects; attempt++ {
+		if s.isClosed() {
+			return
+		}
+
+		if attempt > 0 {
+			delay := reconnectDelays[attempt-1]
+			select {
+			case <-s.done:
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		// Reconnects replay the events missed while disconnected.
+		completed, _ := s.readSSE(ctx, httpClient, attempt > 0)
+		if completed || s.isClosed() {
+			return
+		}
+	}
+
+	// All reconnect attempts exhausted — fall back to polling
+	s.runPolling()
+}
+
+// readSSE opens the event stream and handles its events until it ends. When
+// resuming, the stream starts with the events after the last one received.
+// Returns true if the session completed, was cancelled or expired.
+func (s *Session) readSSE(ctx context.Context, httpClient *http.Client, resume bool) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.sseURL(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-API-Key", s.client.apiKey)
+	req.Header.Set("Accept", "text/event-stream")
+	if resume {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(s.seenSeq(), 10))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("handoff: event stream returned status %d", resp.StatusCode)
+	}
+
+	// Each message carries its sequence number, so only data fields matter.
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxSSELine)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line ends the event.
+			if data.Len() > 0 {
+				var msg wsMessage
+				if err := json.Unmarshal([]byte(data.String()), &msg); err == nil && s.handleMessage(msg) {
+					return true, nil
+				}
+			}
+			data.Reset()
+		case strings.HasPrefix(line, ":"):
+			// Comment, e.g. a keepalive.
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			if field == "data" {
+				if data.Len() > 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(strings.TrimPrefix(value, " "))
+			}
+		}
+	}
+	return false, scanner.Err()
+}
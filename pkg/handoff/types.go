@@ -15,6 +15,18 @@ const (
 	ActionTypeSignature ActionType = "signature"
 	// ActionTypeScan requests the user to scan a document.
 	ActionTypeScan ActionType = "scan"
+	// ActionTypeVideo requests the user to record a short video.
+	ActionTypeVideo ActionType = "video"
+	// ActionTypeAudio requests the user to record a short audio clip, e.g. a
+	// spoken consent statement read from the intro text.
+	ActionTypeAudio ActionType = "audio"
+	// ActionTypeUpload requests the user to upload files already on their phone.
+	ActionTypeUpload ActionType = "upload"
+	// ActionTypeBarcode requests the user to scan a barcode or QR code. The
+	// result is the decoded value, not a file.
+	ActionTypeBarcode ActionType = "barcode"
+	// ActionTypeWorkflow marks a session made of several steps; see SessionBuilder.AddStep.
+	ActionTypeWorkflow ActionType = "workflow"
 )
 
 // ScanDocumentMode specifies whether a scan session captures a single document or multiple.
@@ -59,6 +71,32 @@ type ScanResult struct {
 	Documents []ScanDocumentResult `json:"documents"`
 }
 
+// BarcodeSymbology is a barcode format a barcode session can read.
+type BarcodeSymbology string
+
+const (
+	BarcodeSymbologyQRCode     BarcodeSymbology = "qr_code"
+	BarcodeSymbologyDataMatrix BarcodeSymbology = "data_matrix"
+	BarcodeSymbologyAztec      BarcodeSymbology = "aztec"
+	BarcodeSymbologyEAN13      BarcodeSymbology = "ean_13"
+	BarcodeSymbologyEAN8       BarcodeSymbology = "ean_8"
+	BarcodeSymbologyUPCA       BarcodeSymbology = "upc_a"
+	BarcodeSymbologyUPCE       BarcodeSymbology = "upc_e"
+	BarcodeSymbologyCode128    BarcodeSymbology = "code_128"
+	BarcodeSymbologyCode39     BarcodeSymbology = "code_39"
+	BarcodeSymbologyCode93     BarcodeSymbology = "code_93"
+	BarcodeSymbologyITF        BarcodeSymbology = "itf"
+	BarcodeSymbologyCodabar    BarcodeSymbology = "codabar"
+)
+
+// BarcodeResult is the value read by a barcode session.
+type BarcodeResult struct {
+	// Symbology is the format of the barcode that was read.
+	Symbology BarcodeSymbology `json:"symbology"`
+	// Text is the raw text encoded in the barcode.
+	Text string `json:"text"`
+}
+
 // OutputFormat represents the desired output format for a session result.
 type OutputFormat string
 
@@ -71,6 +109,24 @@ const (
 	OutputFormatPDF OutputFormat = "pdf"
 	// OutputFormatSVG requests SVG output.
 	OutputFormatSVG OutputFormat = "svg"
+	// OutputFormatMP4 requests MP4 video output.
+	OutputFormatMP4 OutputFormat = "mp4"
+	// OutputFormatWebM requests WebM video or audio output.
+	OutputFormatWebM OutputFormat = "webm"
+	// OutputFormatOGG requests Ogg audio output.
+	OutputFormatOGG OutputFormat = "ogg"
+)
+
+// VideoResolution is the resolution a video session records at.
+type VideoResolution string
+
+const (
+	// VideoResolution480p records at 854x480.
+	VideoResolution480p VideoResolution = "480p"
+	// VideoResolution720p records at 1280x720.
+	VideoResolution720p VideoResolution = "720p"
+	// VideoResolution1080p records at 1920x1080.
+	VideoResolution1080p VideoResolution = "1080p"
 )
 
 // SessionStatus represents the current status of a session.
@@ -87,6 +143,8 @@ const (
 	SessionStatusCompleted SessionStatus = "completed"
 	// SessionStatusExpired means the session has expired.
 	SessionStatusExpired SessionStatus = "expired"
+	// SessionStatusCancelled means the session was cancelled via Session.Cancel or the API.
+	SessionStatusCancelled SessionStatus = "cancelled"
 )
 
 // ResultItem represents a single result file from a completed session.
@@ -97,22 +155,210 @@ type ResultItem struct {
 	ContentType string `json:"content_type"`
 	// Filename is the suggested filename for the file.
 	Filename string `json:"filename"`
+	// Duration is the length of an audio recording.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// Step is one action of a workflow session, which walks the user through its
+// steps in order with a single link.
+type Step struct {
+	// ActionType is the type of action requested in this step (required).
+	ActionType ActionType `json:"action_type"`
+	// IntroText is optional introductory text shown before the step.
+	IntroText string `json:"intro_text,omitempty"`
+	// OutputFormat is the desired output format (required for all but scan, upload and barcode steps).
+	OutputFormat OutputFormat `json:"output_format,omitempty"`
+	// DocumentMode is the document mode of scan steps (optional, defaults to "single").
+	DocumentMode ScanDocumentMode `json:"document_mode,omitempty"`
+	// ScanOutputFormat is the output format of scan steps (optional, defaults to "pdf").
+	ScanOutputFormat ScanOutputFormat `json:"-"`
+	// VideoMaxDuration is the longest recording of video steps as a duration
+	// string, e.g. "30s" (optional, defaults to the server's limit).
+	VideoMaxDuration string `json:"video_max_duration,omitempty"`
+	// VideoResolution is the resolution of video steps (optional).
+	VideoResolution VideoResolution `json:"video_resolution,omitempty"`
+	// AudioWAV requests a WAV copy of the recording of audio steps (optional).
+	AudioWAV bool `json:"audio_wav,omitempty"`
+	// UploadAllowedTypes lists the content types upload steps accept, e.g.
+	// "application/pdf" or "image/*" (optional, defaults to the server's).
+	UploadAllowedTypes []string `json:"upload_allowed_types,omitempty"`
+	// UploadMaxFiles is the most files of upload steps (optional).
+	UploadMaxFiles int `json:"upload_max_files,omitempty"`
+	// UploadMaxFileBytes is the size limit of each file of upload steps (optional).
+	UploadMaxFileBytes int64 `json:"upload_max_file_bytes,omitempty"`
+	// BarcodeSymbologies lists the formats barcode steps accept (optional,
+	// defaults to every format the server reads).
+	BarcodeSymbologies []BarcodeSymbology `json:"barcode_symbologies,omitempty"`
+	// BarcodePattern is a regular expression in Go syntax the whole value of
+	// barcode steps must match (optional).
+	BarcodePattern string `json:"barcode_pattern,omitempty"`
+}
+
+// StepResult is a step of a workflow session together with its result once completed.
+type StepResult struct {
+	// ActionType is the type of action requested in this step.
+	ActionType ActionType `json:"action_type"`
+	// IntroText is the introductory text shown before the step, if any.
+	IntroText string `json:"intro_text,omitempty"`
+	// OutputFormat is the output format of photo, signature, video and audio steps.
+	OutputFormat OutputFormat `json:"output_format,omitempty"`
+	// DocumentMode is the document mode of scan steps.
+	DocumentMode ScanDocumentMode `json:"document_mode,omitempty"`
+	// ScanOutputFormat is the output format of scan steps.
+	ScanOutputFormat ScanOutputFormat `json:"scan_output_format,omitempty"`
+	// VideoMaxDuration is the longest recording of video steps.
+	VideoMaxDuration time.Duration `json:"video_max_duration,omitempty"`
+	// VideoResolution is the resolution of video steps.
+	VideoResolution VideoResolution `json:"video_resolution,omitempty"`
+	// AudioWAV reports whether audio steps return a WAV copy of the recording.
+	AudioWAV bool `json:"audio_wav,omitempty"`
+	// UploadAllowedTypes lists the content types upload steps accept.
+	UploadAllowedTypes []string `json:"upload_allowed_types,omitempty"`
+	// UploadMaxFiles is the most files of upload steps.
+	UploadMaxFiles int `json:"upload_max_files,omitempty"`
+	// UploadMaxFileBytes is the size limit of each file of upload steps.
+	UploadMaxFileBytes int64 `json:"upload_max_file_bytes,omitempty"`
+	// BarcodeSymbologies lists the formats barcode steps accept.
+	BarcodeSymbologies []BarcodeSymbology `json:"barcode_symbologies,omitempty"`
+	// BarcodePattern is the regular expression the value of barcode steps must match, if any.
+	BarcodePattern string `json:"barcode_pattern,omitempty"`
+	// CompletedAt is when the user completed the step (nil if not completed).
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	// Result contains the result items of a completed photo, signature, video, audio or upload step.
+	Result []ResultItem `json:"result,omitempty"`
+	// ScanResult contains the scan result of a completed scan step.
+	ScanResult *ScanResult `json:"scan_result,omitempty"`
+	// BarcodeResult contains the value read by a completed barcode step.
+	BarcodeResult *BarcodeResult `json:"barcode_result,omitempty"`
+}
+
+// Actors recorded in a session's status history.
+const (
+	// ActorAPI is the backend application, acting through the REST API.
+	ActorAPI = "api"
+	// ActorPhone is the end user, acting through the phone UI.
+	ActorPhone = "phone"
+	// ActorSystem is the server itself, e.g. when a session's TTL elapses.
+	ActorSystem = "system"
+)
+
+// StatusChange is one entry in a session's status history.
+type StatusChange struct {
+	// Status is the status the session entered.
+	Status SessionStatus `json:"status"`
+	// At is when the session entered Status.
+	At time.Time `json:"at"`
+	// Actor is who caused the change: ActorAPI, ActorPhone or ActorSystem.
+	Actor string `json:"actor"`
 }
 
 // Event represents a state change event received from the server.
 type Event struct {
-	// Type is the event type: "status_update" or "completed".
+	// Type is the event type: "status_update", "step_completed", "completed",
+	// "ttl_updated", "cancelled" or "expired". Only workflow sessions send
+	// "step_completed".
 	Type string
 	// SessionID is the ID of the session this event belongs to.
 	SessionID string
+	// Seq is the event's position in the session's event log, counting from
+	// 1. It is 0 for the status snapshot sent when a stream starts.
+	Seq uint64
 	// Status is the current status of the session.
 	Status SessionStatus
+	// ActionType is the session's action type.
+	ActionType ActionType
 	// Result contains the result items when Type is "completed".
 	Result []ResultItem
 	// ScanResult contains the scan result when Type is "completed" and the session is a scan session.
 	ScanResult *ScanResult
+	// BarcodeResult contains the value read when Type is "completed" and the
+	// session is a barcode session.
+	BarcodeResult *BarcodeResult
+	// Steps contains the result grouped by step when Type is "completed" and
+	// the session is a workflow session.
+	Steps []StepResult
+	// Step is the completed step with its result when Type is "step_completed".
+	Step *StepResult
+	// StepIndex is the index of Step in the session's steps, counting from 0.
+	StepIndex int
+	// ExpiresAt is the session's new expiry when Type is "ttl_updated".
+	ExpiresAt time.Time
 	// Timestamp is when the event occurred.
 	Timestamp time.Time
+	// ExternalRef is the session's external reference, if one was set.
+	ExternalRef string
+	// Metadata is the session's metadata, if any was set.
+	Metadata map[string]string
+}
+
+// DeliveryMethod is how result files are pushed to a DeliveryTarget.
+type DeliveryMethod string
+
+const (
+	// DeliveryMethodPut sends each file as the body of its own PUT request.
+	DeliveryMethodPut DeliveryMethod = "put"
+	// DeliveryMethodPost sends each file as a multipart/form-data POST.
+	DeliveryMethodPost DeliveryMethod = "post"
+)
+
+// DeliveryTarget is an endpoint the server pushes the result files to once the
+// session completes. The files stay downloadable unless Purge is set and every
+// file was delivered.
+type DeliveryTarget struct {
+	// Method is how each file is sent (required).
+	Method DeliveryMethod `json:"method"`
+	// URL receives the files (required). For PUT, "{filename}" in the URL is
+	// replaced with the name of each file.
+	URL string `json:"url"`
+	// Headers are sent with every request, e.g. for authorisation. The server
+	// never returns them.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Field is the form field of POSTed files; the server defaults it to "file".
+	Field string `json:"field,omitempty"`
+	// Purge deletes the files from the server once every one was delivered.
+	Purge bool `json:"purge,omitempty"`
+}
+
+// DeliveryState is the push state of one result file.
+type DeliveryState string
+
+const (
+	// DeliveryStatePending means the file waits for its first or next attempt.
+	DeliveryStatePending DeliveryState = "pending"
+	// DeliveryStateDelivered means the target acknowledged the file.
+	DeliveryStateDelivered DeliveryState = "delivered"
+	// DeliveryStateFailed means every attempt failed; the file can still be downloaded.
+	DeliveryStateFailed DeliveryState = "failed"
+)
+
+// DeliveryStatus reports a session's DeliveryTarget and, once the session
+// completed, the push of each result file.
+type DeliveryStatus struct {
+	Method DeliveryMethod `json:"method"`
+	URL    string         `json:"url"`
+	Field  string         `json:"field,omitempty"`
+	Purge  bool           `json:"purge,omitempty"`
+	Files  []FileDelivery `json:"files,omitempty"`
+}
+
+// FileDelivery is the push of one result file.
+type FileDelivery struct {
+	// DownloadID identifies the file on the download endpoint.
+	DownloadID string `json:"download_id"`
+	// Filename is the name the file is sent under.
+	Filename string `json:"filename"`
+	// ContentType is the MIME type of the file.
+	ContentType string `json:"content_type"`
+	// State is the push state.
+	State DeliveryState `json:"state"`
+	// Attempts counts the attempts made so far.
+	Attempts int `json:"attempts"`
+	// DeliveredAt is when the target acknowledged the file.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// LastStatusCode is the HTTP status of the most recent attempt, if any.
+	LastStatusCode int `json:"last_status_code,omitempty"`
+	// LastError describes why the most recent attempt failed.
+	LastError string `json:"last_error,omitempty"`
 }
 
 // CreateSessionRequest is used to create a new session.
@@ -121,7 +367,7 @@ type CreateSessionRequest struct {
 	ActionType ActionType `json:"action_type"`
 	// IntroText is optional introductory text shown to the user.
 	IntroText string `json:"intro_text,omitempty"`
-	// OutputFormat is the desired output format for the result (required for photo/signature; carries ScanOutputFormat value for scan sessions).
+	// OutputFormat is the desired output format for the result (required for photo/signature/video/audio; carries ScanOutputFormat value for scan sessions).
 	OutputFormat OutputFormat `json:"output_format"`
 	// DocumentMode is the document mode for scan sessions (optional, defaults to "single").
 	DocumentMode ScanDocumentMode `json:"document_mode,omitempty"`
@@ -129,6 +375,40 @@ type CreateSessionRequest struct {
 	SessionTTL string `json:"session_ttl,omitempty"`
 	// ResultTTL is the result time-to-live as a duration string, e.g., "5m".
 	ResultTTL string `json:"result_ttl,omitempty"`
+	// VideoMaxDuration is the longest recording of video sessions as a
+	// duration string, e.g. "30s" (optional, defaults to the server's limit).
+	VideoMaxDuration string `json:"video_max_duration,omitempty"`
+	// VideoResolution is the resolution of video sessions (optional).
+	VideoResolution VideoResolution `json:"video_resolution,omitempty"`
+	// AudioWAV requests a WAV copy of the recording of audio sessions (optional).
+	AudioWAV bool `json:"audio_wav,omitempty"`
+	// UploadAllowedTypes lists the content types upload sessions accept (optional).
+	UploadAllowedTypes []string `json:"upload_allowed_types,omitempty"`
+	// UploadMaxFiles is the most files of upload sessions (optional).
+	UploadMaxFiles int `json:"upload_max_files,omitempty"`
+	// UploadMaxFileBytes is the size limit of each file of upload sessions (optional).
+	UploadMaxFileBytes int64 `json:"upload_max_file_bytes,omitempty"`
+	// BarcodeSymbologies lists the formats barcode sessions accept (optional).
+	BarcodeSymbologies []BarcodeSymbology `json:"barcode_symbologies,omitempty"`
+	// BarcodePattern is a regular expression the whole value of barcode
+	// sessions must match (optional).
+	BarcodePattern string `json:"barcode_pattern,omitempty"`
+	// ExternalRef is an optional caller-defined reference (at most 256 bytes),
+	// e.g. an order ID, echoed in every response and event.
+	ExternalRef string `json:"external_ref,omitempty"`
+	// Metadata holds optional caller-defined key/value pairs (at most 20 keys),
+	// echoed like ExternalRef.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// WebhookURL optionally receives the session's events, overriding the
+	// API key's default webhook URL.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Delivery optionally receives the result files once the session completes.
+	Delivery *DeliveryTarget `json:"delivery,omitempty"`
+	// Steps makes a workflow session of several actions. ActionType must then
+	// be ActionTypeWorkflow or empty, and IntroText, OutputFormat,
+	// DocumentMode and the video, audio, upload and barcode settings are set
+	// per step.
+	Steps []Step `json:"steps,omitempty"`
 }
 
 // sessionResponse is the internal representation of the server's session JSON response.
@@ -142,17 +422,37 @@ type sessionResponse struct {
 	ScanOutputFormat ScanOutputFormat `json:"scan_output_format,omitempty"`
 	SessionTTL      int64            `json:"session_ttl"`
 	ResultTTL       int64            `json:"result_ttl"`
+	VideoMaxDuration int64           `json:"video_max_duration,omitempty"`
+	VideoResolution VideoResolution  `json:"video_resolution,omitempty"`
+	AudioWAV        bool             `json:"audio_wav,omitempty"`
+	UploadAllowedTypes []string      `json:"upload_allowed_types,omitempty"`
+	UploadMaxFiles  int              `json:"upload_max_files,omitempty"`
+	UploadMaxFileBytes int64         `json:"upload_max_file_bytes,omitempty"`
+	BarcodeSymbologies []BarcodeSymbology `json:"barcode_symbologies,omitempty"`
+	BarcodePattern  string           `json:"barcode_pattern,omitempty"`
 	URL             string           `json:"url"`
 	CreatedAt       time.Time        `json:"created_at"`
 	CompletedAt     *time.Time       `json:"completed_at,omitempty"`
 	Result          []ResultItem     `json:"result,omitempty"`
 	ScanResult      *ScanResult      `json:"scan_result,omitempty"`
+	BarcodeResult   *BarcodeResult   `json:"barcode_result,omitempty"`
+	APIKeyID        string           `json:"api_key_id,omitempty"`
+	ExternalRef     string           `json:"external_ref,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	History         []StatusChange   `json:"history,omitempty"`
+	WebhookURL      string           `json:"webhook_url,omitempty"`
+	Delivery        *DeliveryStatus  `json:"delivery,omitempty"`
+	Steps           []StepResult     `json:"steps,omitempty"`
+	CurrentStep     int              `json:"current_step,omitempty"`
 }
 
 // resultPollResponse is the response from GET /api/v1/sessions/:id/result.
 type resultPollResponse struct {
-	Status      string       `json:"status"`
-	CompletedAt *time.Time   `json:"completed_at,omitempty"`
-	Items       []ResultItem `json:"items"`
-	ScanResult  *ScanResult  `json:"scan_result,omitempty"`
+	Status        string         `json:"status"`
+	CompletedAt   *time.Time     `json:"completed_at,omitempty"`
+	Items         []ResultItem   `json:"items"`
+	ScanResult    *ScanResult    `json:"scan_result,omitempty"`
+	BarcodeResult *BarcodeResult `json:"barcode_result,omitempty"`
+	Events        []wsMessage    `json:"events,omitempty"`
+	Steps         []StepResult   `json:"steps,omitempty"`
 }
@@ -0,0 +1,191 @@
+package handoff
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers sent with every webhook request.
+const (
+	// WebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+	// timestamp, a dot and the request body, keyed with the webhook secret.
+	WebhookSignatureHeader = "X-Handoff-Signature"
+	// WebhookTimestampHeader carries the Unix time of the attempt in seconds.
+	WebhookTimestampHeader = "X-Handoff-Timestamp"
+	// WebhookDeliveryHeader carries the delivery ID, the same on every retry.
+	WebhookDeliveryHeader = "X-Handoff-Delivery"
+	// WebhookEventHeader carries the event name.
+	WebhookEventHeader = "X-Handoff-Event"
+)
+
+// Webhook event names.
+const (
+	WebhookEventOpened        = "opened"
+	WebhookEventActionStarted = "action_started"
+	WebhookEventCompleted     = "completed"
+	WebhookEventExpired       = "expired"
+	WebhookEventCancelled     = "cancelled"
+)
+
+// WebhookTolerance is how far a webhook's timestamp may be from the local
+// clock before VerifyWebhook rejects it as a possible replay.
+const WebhookTolerance = 5 * time.Minute
+
+// maxWebhookBody bounds the request body VerifyWebhook reads.
+const maxWebhookBody = 1 << 20
+
+// WebhookEvent is a session event received on a webhook URL.
+type WebhookEvent struct {
+	// DeliveryID identifies the delivery. Retries carry the same ID, so use it
+	// to ignore events that were already handled.
+	DeliveryID string
+	// Event is one of the WebhookEvent constants.
+	Event string
+	// SessionID is the ID of the session this event belongs to.
+	SessionID string
+	// Status is the session's status after the event.
+	Status SessionStatus
+	// Result contains the result items when Event is "completed".
+	Result []ResultItem
+	// ScanResult contains the scan result when Event is "completed" and the session is a scan session.
+	ScanResult *ScanResult
+	// BarcodeResult contains the value read when Event is "completed" and the
+	// session is a barcode session.
+	BarcodeResult *BarcodeResult
+	// Steps contains the result grouped by step when Event is "completed" and
+	// the session is a workflow session.
+	Steps []StepResult
+	// Timestamp is when the event occurred.
+	Timestamp time.Time
+	// ExternalRef is the session's external reference, if one was set.
+	ExternalRef string
+	// Metadata is the session's metadata, if any was set.
+	Metadata map[string]string
+}
+
+// webhookPayload is the JSON body of a webhook request.
+type webhookPayload struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	SessionID string          `json:"session_id"`
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+
+	ExternalRef string            `json:"external_ref,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// VerifyWebhook checks that r was sent by the Handoff server configured with
+// secret and returns the event it carries. It returns ErrInvalidWebhook if
+// the signature does not match or the timestamp is outside WebhookTolerance.
+// The request body is consumed and replaced, so it can still be read.
+//
+//	http.HandleFunc("/handoff", func(w http.ResponseWriter, r *http.Request) {
+//		evt, err := handoff.VerifyWebhook(r, secret)
+//		if err != nil {
+//			http.Error(w, "invalid webhook", http.StatusUnauthorized)
+//			return
+//		}
+//		// handle evt
+//	})
+func VerifyWebhook(r *http.Request, secret string) (*WebhookEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		return nil, fmt.Errorf("handoff: failed to read webhook body: %w", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing or malformed timestamp", ErrInvalidWebhook)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > WebhookTolerance || age < -WebhookTolerance {
+		return nil, fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidWebhook)
+	}
+	if !hmac.Equal([]byte(r.Header.Get(WebhookSignatureHeader)), []byte(signWebhook(secret, timestamp, body))) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidWebhook)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("handoff: failed to decode webhook payload: %w", err)
+	}
+	evt := &WebhookEvent{
+		DeliveryID:  payload.ID,
+		Event:       payload.Event,
+		SessionID:   payload.SessionID,
+		Status:      SessionStatus(payload.Status),
+		Timestamp:   payload.Timestamp,
+		ExternalRef: payload.ExternalRef,
+		Metadata:    payload.Metadata,
+	}
+	if payload.Event == WebhookEventCompleted && len(payload.Data) > 0 {
+		evt.Result, evt.ScanResult, evt.BarcodeResult, evt.Steps = decodeResultData(payload.Data)
+	}
+	return evt, nil
+}
+
+// signWebhook returns the WebhookSignatureHeader value for body sent at timestamp.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDelivery is a webhook event the server gave up delivering.
+type WebhookDelivery struct {
+	// ID is the delivery ID sent in WebhookDeliveryHeader.
+	ID string `json:"id"`
+	// SessionID is the session the event belongs to.
+	SessionID string `json:"session_id"`
+	// Event is the event name.
+	Event string `json:"event"`
+	// URL is the webhook URL the event was sent to.
+	URL string `json:"url"`
+	// Payload is the JSON body that was sent.
+	Payload json.RawMessage `json:"payload"`
+	// Attempts is how many times delivery was tried.
+	Attempts int `json:"attempts"`
+	// CreatedAt is when the event occurred.
+	CreatedAt time.Time `json:"created_at"`
+	// LastAttemptAt is when the final attempt was made.
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	// LastStatusCode is the HTTP status of the final attempt, if there was a response.
+	LastStatusCode int `json:"last_status_code,omitempty"`
+	// LastError describes why the final attempt failed.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// deadWebhooksResponse is the response from GET /api/v1/webhooks/dead-letters.
+type deadWebhooksResponse struct {
+	DeadLetters []WebhookDelivery `json:"dead_letters"`
+}
+
+// ListDeadWebhooks returns the webhook deliveries for this API key's sessions
+// that failed every attempt, most recent first.
+func (c *Client) ListDeadWebhooks(ctx context.Context) ([]WebhookDelivery, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/api/v1/webhooks/dead-letters", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var dr deadWebhooksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&dr); err != nil {
+		return nil, fmt.Errorf("handoff: failed to decode dead letters response: %w", err)
+	}
+	return dr.DeadLetters, nil
+}
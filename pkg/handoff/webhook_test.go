@@ -0,0 +1,100 @@
+package handoff_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mxcd/handoff/internal/webhook"
+	"github.com/mxcd/handoff/pkg/handoff"
+)
+
+const webhookSecret = "whsec-test"
+
+// webhookRequest builds a webhook request the way the server's dispatcher
+// signs it.
+func webhookRequest(t *testing.T, secret string, at time.Time, body []byte) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/handoff", bytes.NewReader(body))
+	r.Header.Set(webhook.TimestampHeader, strconv.FormatInt(at.Unix(), 10))
+	r.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, at.Unix(), body))
+	return r
+}
+
+func webhookBody(t *testing.T) []byte {
+	t.Helper()
+	body, err := json.Marshal(webhook.Payload{
+		ID:          "d1",
+		Event:       webhook.EventOpened,
+		SessionID:   "s1",
+		Status:      "opened",
+		Timestamp:   time.Now().UTC(),
+		ExternalRef: "order-1",
+		Metadata:    map[string]string{"k": "v"},
+	})
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	return body
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := webhookBody(t)
+	r := webhookRequest(t, webhookSecret, time.Now(), body)
+
+	evt, err := handoff.VerifyWebhook(r, webhookSecret)
+	if err != nil {
+		t.Fatalf("VerifyWebhook: %v", err)
+	}
+	if evt.DeliveryID != "d1" || evt.Event != handoff.WebhookEventOpened || evt.SessionID != "s1" ||
+		evt.Status != handoff.SessionStatusOpened || evt.ExternalRef != "order-1" || evt.Metadata["k"] != "v" {
+		t.Errorf("VerifyWebhook: got %+v", evt)
+	}
+	if rest, _ := io.ReadAll(r.Body); !bytes.Equal(rest, body) {
+		t.Error("request body not restored after VerifyWebhook")
+	}
+}
+
+func TestVerifyWebhookRejected(t *testing.T) {
+	body := webhookBody(t)
+	for name, r := range map[string]*http.Request{
+		"wrong secret": webhookRequest(t, "other-secret", time.Now(), body),
+		"bad signature": func() *http.Request {
+			r := webhookRequest(t, webhookSecret, time.Now(), body)
+			r.Header.Set(webhook.SignatureHeader, "sha256=00")
+			return r
+		}(),
+		"missing signature": func() *http.Request {
+			r := webhookRequest(t, webhookSecret, time.Now(), body)
+			r.Header.Del(webhook.SignatureHeader)
+			return r
+		}(),
+		"modified body": func() *http.Request {
+			r := webhookRequest(t, webhookSecret, time.Now(), body)
+			r.Body = io.NopCloser(bytes.NewReader(bytes.Replace(body, []byte("order-1"), []byte("order-2"), 1)))
+			return r
+		}(),
+		"timestamp changed": func() *http.Request {
+			r := webhookRequest(t, webhookSecret, time.Now(), body)
+			r.Header.Set(webhook.TimestampHeader, strconv.FormatInt(time.Now().Unix()+1, 10))
+			return r
+		}(),
+		"stale timestamp":  webhookRequest(t, webhookSecret, time.Now().Add(-handoff.WebhookTolerance-time.Minute), body),
+		"future timestamp": webhookRequest(t, webhookSecret, time.Now().Add(handoff.WebhookTolerance+time.Minute), body),
+		"missing timestamp": func() *http.Request {
+			r := webhookRequest(t, webhookSecret, time.Now(), body)
+			r.Header.Del(webhook.TimestampHeader)
+			return r
+		}(),
+	} {
+		if _, err := handoff.VerifyWebhook(r, webhookSecret); !errors.Is(err, handoff.ErrInvalidWebhook) {
+			t.Errorf("%s: got %v, want ErrInvalidWebhook", name, err)
+		}
+	}
+}